@@ -22,4 +22,8 @@ func (c *Client) Help(path string) (*Help, error) {
 type Help struct {
 	Help    string   `json:"help"`
 	SeeAlso []string `json:"see_also"`
+
+	// Schema describes the operations and fields of the path. It is not
+	// set for the help of a mount.
+	Schema map[string]interface{} `json:"schema"`
 }
@@ -196,9 +196,19 @@ func (c *Client) SSHHelperWithMountPoint(mountPoint string) *SSHHelper {
 // an echo response message is returned. This feature is used by ssh-helper to verify if
 // its configured correctly.
 func (c *SSHHelper) Verify(otp string) (*SSHVerifyResponse, error) {
+	return c.VerifyClient(otp, "")
+}
+
+// VerifyClient is like Verify, but also reports the source address of the SSH
+// connection the OTP is presented for. This is required for OTPs of roles which
+// bind them to the client which requested them.
+func (c *SSHHelper) VerifyClient(otp, clientIP string) (*SSHVerifyResponse, error) {
 	data := map[string]interface{}{
 		"otp": otp,
 	}
+	if clientIP != "" {
+		data["client_ip"] = clientIP
+	}
 	verifyPath := fmt.Sprintf("/v1/%s/verify", c.MountPoint)
 	r := c.c.NewRequest("PUT", verifyPath)
 	if err := r.SetJSONBody(data); err != nil {
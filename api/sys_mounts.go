@@ -119,6 +119,26 @@ func (c *Sys) MountConfig(path string) (*MountConfigOutput, error) {
 	return &result, err
 }
 
+// RollbackMount rolls back the mount at the path now, rather than at the next
+// periodic rollback, and reports the WAL entries processed by its backend.
+func (c *Sys) RollbackMount(path string) (*RollbackOutput, error) {
+	r := c.c.NewRequest("POST", fmt.Sprintf("/v1/sys/rollback/%s", path))
+
+	resp, err := c.c.RawRequest(r)
+	if err != nil {
+		return nil, err
+	}
+	defer resp.Body.Close()
+
+	var result RollbackOutput
+	err = resp.DecodeJSON(&result)
+	if err != nil {
+		return nil, err
+	}
+
+	return &result, err
+}
+
 type MountInput struct {
 	Type        string           `json:"type" structs:"type"`
 	Description string           `json:"description" structs:"description"`
@@ -131,6 +151,9 @@ type MountConfigInput struct {
 	MaxLeaseTTL     string `json:"max_lease_ttl" structs:"max_lease_ttl" mapstructure:"max_lease_ttl"`
 	ForceNoCache    bool   `json:"force_no_cache" structs:"force_no_cache" mapstructure:"force_no_cache"`
 	PluginName      string `json:"plugin_name,omitempty" structs:"plugin_name,omitempty" mapstructure:"plugin_name"`
+
+	// WALRollbackMinAge is only used when tuning mounts
+	WALRollbackMinAge string `json:"wal_rollback_min_age,omitempty" structs:"wal_rollback_min_age,omitempty" mapstructure:"wal_rollback_min_age"`
 }
 
 type MountOutput struct {
@@ -142,8 +165,15 @@ type MountOutput struct {
 }
 
 type MountConfigOutput struct {
-	DefaultLeaseTTL int    `json:"default_lease_ttl" structs:"default_lease_ttl" mapstructure:"default_lease_ttl"`
-	MaxLeaseTTL     int    `json:"max_lease_ttl" structs:"max_lease_ttl" mapstructure:"max_lease_ttl"`
-	ForceNoCache    bool   `json:"force_no_cache" structs:"force_no_cache" mapstructure:"force_no_cache"`
-	PluginName      string `json:"plugin_name,omitempty" structs:"plugin_name,omitempty" mapstructure:"plugin_name"`
+	DefaultLeaseTTL   int    `json:"default_lease_ttl" structs:"default_lease_ttl" mapstructure:"default_lease_ttl"`
+	MaxLeaseTTL       int    `json:"max_lease_ttl" structs:"max_lease_ttl" mapstructure:"max_lease_ttl"`
+	ForceNoCache      bool   `json:"force_no_cache" structs:"force_no_cache" mapstructure:"force_no_cache"`
+	PluginName        string `json:"plugin_name,omitempty" structs:"plugin_name,omitempty" mapstructure:"plugin_name"`
+	WALRollbackMinAge int    `json:"wal_rollback_min_age,omitempty" structs:"wal_rollback_min_age,omitempty" mapstructure:"wal_rollback_min_age"`
+}
+
+type RollbackOutput struct {
+	Processed int      `json:"processed" structs:"processed" mapstructure:"processed"`
+	Failed    int      `json:"failed" structs:"failed" mapstructure:"failed"`
+	Errors    []string `json:"errors,omitempty" structs:"errors,omitempty" mapstructure:"errors"`
 }
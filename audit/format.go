@@ -13,6 +13,10 @@ import (
 	"github.com/mitchellh/copystructure"
 )
 
+// RedactedValue replaces the values of response data listed in
+// logical.Response.AuditRedact in audit log entries.
+const RedactedValue = "[redacted]"
+
 type AuditFormatWriter interface {
 	WriteRequest(io.Writer, *AuditRequestEntry) error
 	WriteResponse(io.Writer, *AuditResponseEntry) error
@@ -175,6 +179,13 @@ func (f *AuditFormatter) FormatResponse(
 		return errwrap.Wrapf("error fetching salt: {{err}}", err)
 	}
 
+	// Record the status an error is responded to with, before the
+	// response is hashed
+	statusCode, _ := logical.RespondErrorCommon(req, resp, inErr)
+	if inErr != nil {
+		logical.AdjustErrorStatusCode(&statusCode, inErr)
+	}
+
 	if !config.Raw {
 		// Before we copy the structure we must nil out some data
 		// otherwise we will cause reflection to panic and die
@@ -306,8 +317,9 @@ func (f *AuditFormatter) FormatResponse(
 	}
 
 	respEntry := &AuditResponseEntry{
-		Type:  "response",
-		Error: errString,
+		Type:       "response",
+		Error:      errString,
+		StatusCode: statusCode,
 		Auth: AuditAuth{
 			ClientToken:   auth.ClientToken,
 			Accessor:      auth.Accessor,
@@ -332,9 +344,10 @@ func (f *AuditFormatter) FormatResponse(
 		Response: AuditResponse{
 			Auth:     respAuth,
 			Secret:   respSecret,
-			Data:     resp.Data,
+			Data:     redactData(resp.Data, resp.AuditRedact),
 			Redirect: resp.Redirect,
 			WrapInfo: respWrapInfo,
+			Warnings: resp.Warnings,
 		},
 	}
 
@@ -349,6 +362,26 @@ func (f *AuditFormatter) FormatResponse(
 	return f.AuditFormatWriter.WriteResponse(w, respEntry)
 }
 
+// redactData returns the data with the values of the given keys replaced by
+// RedactedValue. The data is copied rather than modified in place, since
+// the response is not copied when logging raw.
+func redactData(data map[string]interface{}, keys []string) map[string]interface{} {
+	if len(keys) == 0 || data == nil {
+		return data
+	}
+
+	redacted := make(map[string]interface{}, len(data))
+	for k, v := range data {
+		redacted[k] = v
+	}
+	for _, k := range keys {
+		if _, ok := redacted[k]; ok {
+			redacted[k] = RedactedValue
+		}
+	}
+	return redacted
+}
+
 // AuditRequest is the structure of a request audit log entry in Audit.
 type AuditRequestEntry struct {
 	Time    string       `json:"time,omitempty"`
@@ -360,12 +393,13 @@ type AuditRequestEntry struct {
 
 // AuditResponseEntry is the structure of a response audit log entry in Audit.
 type AuditResponseEntry struct {
-	Time     string        `json:"time,omitempty"`
-	Type     string        `json:"type"`
-	Auth     AuditAuth     `json:"auth"`
-	Request  AuditRequest  `json:"request"`
-	Response AuditResponse `json:"response"`
-	Error    string        `json:"error"`
+	Time       string        `json:"time,omitempty"`
+	Type       string        `json:"type"`
+	Auth       AuditAuth     `json:"auth"`
+	Request    AuditRequest  `json:"request"`
+	Response   AuditResponse `json:"response"`
+	Error      string        `json:"error"`
+	StatusCode int           `json:"status_code,omitempty"`
 }
 
 type AuditRequest struct {
@@ -387,6 +421,7 @@ type AuditResponse struct {
 	Data     map[string]interface{} `json:"data,omitempty"`
 	Redirect string                 `json:"redirect,omitempty"`
 	WrapInfo *AuditResponseWrapInfo `json:"wrap_info,omitempty"`
+	Warnings []string               `json:"warnings,omitempty"`
 }
 
 type AuditAuth struct {
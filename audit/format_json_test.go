@@ -2,6 +2,9 @@ package audit
 
 import (
 	"bytes"
+	"crypto/tls"
+	"crypto/x509"
+	"encoding/base64"
 	"encoding/json"
 	"strings"
 	"testing"
@@ -120,3 +123,140 @@ func TestFormatJSON_formatRequest(t *testing.T) {
 
 const testFormatJSONReqBasicStrFmt = `{"time":"2015-08-05T13:45:46Z","type":"request","auth":{"client_token":"%s","accessor":"bar","display_name":"testtoken","policies":["root"],"metadata":null},"request":{"operation":"update","path":"/foo","data":null,"wrap_ttl":60,"remote_address":"127.0.0.1","headers":{"foo":["bar"]}},"error":"this is an error"}
 `
+
+func TestFormatJSON_formatResponseWarnings(t *testing.T) {
+	salter, err := salt.NewSalt(nil, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	saltFunc := func() (*salt.Salt, error) {
+		return salter, nil
+	}
+
+	var buf bytes.Buffer
+	formatter := AuditFormatter{
+		AuditFormatWriter: &JSONFormatWriter{
+			SaltFunc: saltFunc,
+		},
+	}
+	resp := &logical.Response{
+		Data:     map[string]interface{}{"foo": "bar"},
+		Warnings: []string{"be careful"},
+	}
+	if err := formatter.FormatResponse(&buf, FormatterConfig{}, nil, &logical.Request{Path: "/foo"}, resp, nil); err != nil {
+		t.Fatal(err)
+	}
+
+	var actualjson = new(AuditResponseEntry)
+	if err := jsonutil.DecodeJSON(buf.Bytes(), &actualjson); err != nil {
+		t.Fatalf("bad json: %s", err)
+	}
+	if len(actualjson.Response.Warnings) != 1 || actualjson.Response.Warnings[0] != "be careful" {
+		t.Fatalf("bad: %s", buf.String())
+	}
+}
+
+func TestFormatJSON_formatRequestConnState(t *testing.T) {
+	salter, err := salt.NewSalt(nil, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	saltFunc := func() (*salt.Salt, error) {
+		return salter, nil
+	}
+
+	formatter := AuditFormatter{
+		AuditFormatWriter: &JSONFormatWriter{
+			SaltFunc: saltFunc,
+		},
+	}
+	certRaw := []byte("client certificate material")
+	connState := &tls.ConnectionState{
+		PeerCertificates: []*x509.Certificate{&x509.Certificate{Raw: certRaw}},
+	}
+	req := &logical.Request{
+		Operation: logical.UpdateOperation,
+		Path:      "/foo",
+		Connection: &logical.Connection{
+			RemoteAddr: "127.0.0.1",
+			ConnState:  connState,
+		},
+	}
+
+	for _, raw := range []bool{false, true} {
+		var buf bytes.Buffer
+		if err := formatter.FormatRequest(&buf, FormatterConfig{Raw: raw}, nil, req, nil); err != nil {
+			t.Fatal(err)
+		}
+
+		// The address is logged, but not the certificates
+		if !strings.Contains(buf.String(), `"remote_address":"127.0.0.1"`) {
+			t.Fatalf("bad: raw: %v: %s", raw, buf.String())
+		}
+		if strings.Contains(buf.String(), base64.StdEncoding.EncodeToString(certRaw)) {
+			t.Fatalf("bad: raw: %v: certificate logged: %s", raw, buf.String())
+		}
+
+		// The connection state is left to the backends
+		if req.Connection.ConnState != connState {
+			t.Fatalf("bad: raw: %v: connection state not restored", raw)
+		}
+	}
+}
+
+func TestFormatJSON_formatResponseRedacted(t *testing.T) {
+	salter, err := salt.NewSalt(nil, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	saltFunc := func() (*salt.Salt, error) {
+		return salter, nil
+	}
+
+	formatter := AuditFormatter{
+		AuditFormatWriter: &JSONFormatWriter{
+			SaltFunc: saltFunc,
+		},
+	}
+	resp := &logical.Response{
+		Data: map[string]interface{}{
+			"key":      "private key material",
+			"username": "ubuntu",
+		},
+		AuditRedact: []string{"key", "missing"},
+	}
+
+	for _, raw := range []bool{false, true} {
+		var buf bytes.Buffer
+		if err := formatter.FormatResponse(&buf, FormatterConfig{Raw: raw}, nil, &logical.Request{Path: "/foo"}, resp, nil); err != nil {
+			t.Fatal(err)
+		}
+		if strings.Contains(buf.String(), "private key material") ||
+			strings.Contains(buf.String(), salter.GetIdentifiedHMAC("private key material")) {
+			t.Fatalf("bad: raw: %v: redacted value logged: %s", raw, buf.String())
+		}
+
+		var actualjson = new(AuditResponseEntry)
+		if err := jsonutil.DecodeJSON(buf.Bytes(), &actualjson); err != nil {
+			t.Fatalf("bad json: %s", err)
+		}
+		if actualjson.Response.Data["key"] != RedactedValue {
+			t.Fatalf("bad: raw: %v: %s", raw, buf.String())
+		}
+		if _, ok := actualjson.Response.Data["missing"]; ok {
+			t.Fatalf("bad: raw: %v: %s", raw, buf.String())
+		}
+		username := "ubuntu"
+		if !raw {
+			username = salter.GetIdentifiedHMAC(username)
+		}
+		if actualjson.Response.Data["username"] != username {
+			t.Fatalf("bad: raw: %v: %s", raw, buf.String())
+		}
+
+		// The response sent down to the user is left untouched
+		if resp.Data["key"] != "private key material" {
+			t.Fatalf("bad: raw: %v: response modified: %#v", raw, resp.Data)
+		}
+	}
+}
@@ -3,7 +3,11 @@ package ssh
 import (
 	"strings"
 	"sync"
+	"sync/atomic"
+	"time"
 
+	"github.com/hashicorp/go-multierror"
+	"github.com/hashicorp/vault/helper/locksutil"
 	"github.com/hashicorp/vault/helper/salt"
 	"github.com/hashicorp/vault/logical"
 	"github.com/hashicorp/vault/logical/framework"
@@ -14,6 +18,38 @@ type backend struct {
 	view      logical.Storage
 	salt      *salt.Salt
 	saltMutex sync.RWMutex
+
+	// revocationLock serializes the processing of pending revocations
+	revocationLock sync.Mutex
+
+	// keyLock serializes changes to the versions of shared keys
+	keyLock sync.Mutex
+
+	// installedLock serializes changes to the records of installed keys
+	installedLock sync.Mutex
+
+	// otpLocks serialize the operations on each OTP entry so that an OTP
+	// can only be verified once
+	otpLocks []*locksutil.LockEntry
+
+	// tidyOTPCASGuard prevents concurrent tidy operations on OTP entries
+	tidyOTPCASGuard uint32
+
+	// nextOTPTidyTime is the time at which the periodic function tidies
+	// the OTP entries next
+	nextOTPTidyTime time.Time
+
+	// mountPoint holds the mount path of the backend, as seen in the last
+	// request, which namespaces its metrics
+	mountPoint atomic.Value
+
+	// credsLimiter limits the rate of credential issuance of the roles
+	// which configure a limit
+	credsLimiter *credsRateLimiter
+
+	// installPublicKeyFunc installs or uninstalls dynamic keys in targets.
+	// It is replaced in tests to avoid connecting to remote hosts.
+	installPublicKeyFunc func(adminUser, username, ip string, port int, hostKey *sshHostKey, connConfig *connectionConfig, bastion *bastionHost, dynamicPublicKey, installScript, sudoCommand, authorizedKeysPath string, install bool) error
 }
 
 func Factory(conf *logical.BackendConfig) (logical.Backend, error) {
@@ -30,6 +66,9 @@ func Factory(conf *logical.BackendConfig) (logical.Backend, error) {
 func Backend(conf *logical.BackendConfig) (*backend, error) {
 	var b backend
 	b.view = conf.StorageView
+	b.otpLocks = locksutil.CreateLocks()
+	b.credsLimiter = newCredsRateLimiter()
+	b.installPublicKeyFunc = b.installPublicKeyInTarget
 	b.Backend = &framework.Backend{
 		Help: strings.TrimSpace(backendHelp),
 
@@ -44,25 +83,48 @@ func Backend(conf *logical.BackendConfig) (*backend, error) {
 			},
 		},
 
+		RejectUnknownFields: true,
+
 		Paths: []*framework.Path{
 			pathConfigZeroAddress(&b),
+			pathConfigRevocation(&b),
+			pathConfigInstallScript(&b),
+			pathConfigConnection(&b),
+			pathListRevocations(&b),
+			pathRevocations(&b),
+			pathListInstalled(&b),
+			pathInstalledCleanup(&b),
+			pathInstalled(&b),
+			pathListKeys(&b),
 			pathKeys(&b),
+			pathKeyVersions(&b),
+			pathKeyPrune(&b),
 			pathListRoles(&b),
 			pathRoles(&b),
+			pathRoleValidateScript(&b),
 			pathCredsCreate(&b),
 			pathLookup(&b),
 			pathVerify(&b),
+			pathOTP(&b),
 			pathConfigCA(&b),
 			pathSign(&b),
 			pathFetchPublicKey(&b),
+			pathTidy(&b),
 		},
 
 		Secrets: []*framework.Secret{
 			secretDynamicKey(&b),
 			secretOTP(&b),
+			secretSignedKey(&b),
 		},
 
-		Invalidate:  b.invalidate,
+		PeriodicFunc:          b.periodicFunc,
+		WALRollback:           b.walRollback,
+		WALRollbackMinAgeFunc: b.walRollbackMinAge,
+		InvalidateKeys: map[string]framework.InvalidateFunc{
+			salt.DefaultLocation: b.invalidateSalt,
+		},
+		Clean:       b.cleanup,
 		BackendType: logical.TypeLogical,
 	}
 	return &b, nil
@@ -91,13 +153,42 @@ func (b *backend) Salt() (*salt.Salt, error) {
 	return salt, nil
 }
 
-func (b *backend) invalidate(key string) {
-	switch key {
-	case salt.DefaultLocation:
-		b.saltMutex.Lock()
-		defer b.saltMutex.Unlock()
-		b.salt = nil
+// periodicFunc is invoked by the RollbackManager once a minute. It retries
+// the revocation of dynamic keys that could not be removed from their targets
+// and, once every otpTidyInterval, deletes the entries of expired OTPs.
+func (b *backend) periodicFunc(req *logical.Request) error {
+	var result error
+	if err := b.retryPendingRevocations(req.Storage); err != nil {
+		result = multierror.Append(result, err)
 	}
+
+	if b.nextOTPTidyTime.IsZero() || !time.Now().Before(b.nextOTPTidyTime) {
+		if _, _, err := b.tidyOTPs(req.Storage); err != nil {
+			result = multierror.Append(result, err)
+		}
+		b.nextOTPTidyTime = time.Now().Add(otpTidyInterval)
+	}
+
+	return result
+}
+
+// cleanup releases the state held in memory when the backend is unloaded.
+// Revocations which are still pending are kept in storage and retried by
+// the backend of the next mount.
+func (b *backend) cleanup() {
+	b.saltMutex.Lock()
+	b.salt = nil
+	b.saltMutex.Unlock()
+
+	b.credsLimiter.resetAll()
+}
+
+// invalidateSalt drops the cached salt, so that it is read from storage
+// again when next used.
+func (b *backend) invalidateSalt(string) {
+	b.saltMutex.Lock()
+	defer b.saltMutex.Unlock()
+	b.salt = nil
 }
 
 const backendHelp = `
@@ -10,6 +10,7 @@ import (
 	"net"
 	"os"
 	"path/filepath"
+	"time"
 
 	log "github.com/mgutz/logxi/v1"
 
@@ -40,6 +41,11 @@ type SSHCommConfig struct {
 	// DisableAgent, if true, will not forward the SSH agent.
 	DisableAgent bool
 
+	// Timeout, if set, bounds the lifetime of a connection, including the
+	// handshake and all sessions run over it, so that an unresponsive
+	// host cannot hold up the caller indefinitely.
+	Timeout time.Duration
+
 	// Logger for output
 	Logger log.Logger
 }
@@ -128,6 +134,13 @@ func (c *comm) reconnect() error {
 		return err
 	}
 
+	if c.config.Timeout > 0 {
+		if err := c.conn.SetDeadline(time.Now().Add(c.config.Timeout)); err != nil {
+			c.Close()
+			return err
+		}
+	}
+
 	sshConn, sshChan, req, err := ssh.NewClientConn(c.conn, c.address, c.config.SSHConfig)
 	if err != nil {
 		c.config.Logger.Error("handshake error", "error", err)
@@ -0,0 +1,84 @@
+package ssh
+
+import (
+	"bytes"
+	"fmt"
+	"regexp"
+	"strings"
+	"text/template"
+
+	"github.com/hashicorp/vault/logical"
+)
+
+// Usernames rendered from templates must be valid POSIX account names, as
+// accepted by useradd by default.
+const maxUsernameLength = 32
+
+var posixUsernameRegex = regexp.MustCompile(`^[a-z_][a-z0-9_-]*\$?$`)
+
+// Structure holding the values available to default username templates,
+// e.g. '{{.DisplayName | lowercase}}' or '{{.Metadata.username}}'.
+type defaultUserTemplateData struct {
+	DisplayName string
+	Metadata    map[string]string
+}
+
+var defaultUserTemplateFuncs = template.FuncMap{
+	"lowercase": strings.ToLower,
+	"uppercase": strings.ToUpper,
+	"replace": func(old, new, s string) string {
+		return strings.Replace(s, old, new, -1)
+	},
+}
+
+func parseDefaultUserTemplate(userTemplate string) (*template.Template, error) {
+	return template.New("default_user_template").Option("missingkey=zero").Funcs(defaultUserTemplateFuncs).Parse(userTemplate)
+}
+
+// validateDefaultUserTemplate checks that the template parses and can be
+// rendered. Missing metadata renders as empty.
+func validateDefaultUserTemplate(userTemplate string) error {
+	tmpl, err := parseDefaultUserTemplate(userTemplate)
+	if err != nil {
+		return err
+	}
+	return tmpl.Execute(&bytes.Buffer{}, &defaultUserTemplateData{
+		DisplayName: "token",
+		Metadata:    map[string]string{},
+	})
+}
+
+// renderDefaultUser renders the default username template of the role with
+// the display name and metadata of the token of the request. Surrounding
+// whitespace is removed, so an empty string is returned if the template
+// renders only whitespace.
+func renderDefaultUser(userTemplate string, req *logical.Request) (string, error) {
+	tmpl, err := parseDefaultUserTemplate(userTemplate)
+	if err != nil {
+		return "", err
+	}
+	metadata := req.TokenMetadata
+	if metadata == nil {
+		metadata = map[string]string{}
+	}
+
+	var rendered bytes.Buffer
+	if err := tmpl.Execute(&rendered, &defaultUserTemplateData{
+		DisplayName: req.DisplayName,
+		Metadata:    metadata,
+	}); err != nil {
+		return "", err
+	}
+	return strings.TrimSpace(rendered.String()), nil
+}
+
+// validatePOSIXUsername checks that the username is a valid account name.
+func validatePOSIXUsername(username string) error {
+	if len(username) > maxUsernameLength {
+		return fmt.Errorf("username %q is longer than %d characters", username, maxUsernameLength)
+	}
+	if !posixUsernameRegex.MatchString(username) {
+		return fmt.Errorf("username %q is not a valid account name", username)
+	}
+	return nil
+}
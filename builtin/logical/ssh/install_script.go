@@ -0,0 +1,146 @@
+package ssh
+
+import (
+	"bytes"
+	"fmt"
+	"strings"
+	"text/template"
+	"text/template/parse"
+)
+
+// Structure holding the values available to install scripts written as
+// templates. The field names are the placeholders scripts can reference,
+// e.g. '{{.PublicKeyFile}}'.
+type installScriptData struct {
+	PublicKeyFile      string
+	Username           string
+	AuthorizedKeysPath string
+	Install            bool
+	Uninstall          bool
+}
+
+var installScriptFields = []string{"PublicKeyFile", "Username", "AuthorizedKeysPath", "Install", "Uninstall"}
+
+// isInstallScriptTemplate reports whether the install script contains
+// template placeholders. Scripts without any are run with positional
+// arguments instead.
+func isInstallScriptTemplate(installScript string) bool {
+	return strings.Contains(installScript, "{{")
+}
+
+// parseInstallScriptTemplate parses the install script as a template and
+// checks that it only references the fields of installScriptData.
+func parseInstallScriptTemplate(installScript string) (*template.Template, error) {
+	tmpl, err := template.New("install_script").Option("missingkey=error").Parse(installScript)
+	if err != nil {
+		return nil, err
+	}
+	if err := checkTemplateFields(tmpl.Tree.Root); err != nil {
+		return nil, err
+	}
+	return tmpl, nil
+}
+
+// checkTemplateFields walks the parse tree and returns an error for the first
+// field reference which is not a field of installScriptData.
+func checkTemplateFields(node parse.Node) error {
+	switch n := node.(type) {
+	case *parse.ListNode:
+		if n == nil {
+			return nil
+		}
+		for _, child := range n.Nodes {
+			if err := checkTemplateFields(child); err != nil {
+				return err
+			}
+		}
+	case *parse.ActionNode:
+		return checkTemplateFields(n.Pipe)
+	case *parse.IfNode:
+		return checkBranchFields(&n.BranchNode)
+	case *parse.RangeNode:
+		return checkBranchFields(&n.BranchNode)
+	case *parse.WithNode:
+		return checkBranchFields(&n.BranchNode)
+	case *parse.TemplateNode:
+		return checkTemplateFields(n.Pipe)
+	case *parse.PipeNode:
+		if n == nil {
+			return nil
+		}
+		for _, cmd := range n.Cmds {
+			if err := checkTemplateFields(cmd); err != nil {
+				return err
+			}
+		}
+	case *parse.CommandNode:
+		for _, arg := range n.Args {
+			if err := checkTemplateFields(arg); err != nil {
+				return err
+			}
+		}
+	case *parse.ChainNode:
+		return checkTemplateFields(n.Node)
+	case *parse.FieldNode:
+		name := strings.Join(n.Ident, ".")
+		for _, field := range installScriptFields {
+			if name == field {
+				return nil
+			}
+		}
+		return fmt.Errorf("unknown placeholder {{.%s}}; must be one of .%s", name, strings.Join(installScriptFields, ", ."))
+	}
+	return nil
+}
+
+func checkBranchFields(n *parse.BranchNode) error {
+	if err := checkTemplateFields(n.Pipe); err != nil {
+		return err
+	}
+	if err := checkTemplateFields(n.List); err != nil {
+		return err
+	}
+	return checkTemplateFields(n.ElseList)
+}
+
+// checkInstallScript checks that an install script is not larger than
+// maxSize, starts with an interpreter line and, if it is written as a
+// template, only references known placeholders. The interpreter line must
+// come first, as the target only runs the script with it then.
+func checkInstallScript(installScript string, maxSize int) error {
+	if len(installScript) > maxSize {
+		return fmt.Errorf("script must not be larger than %d bytes", maxSize)
+	}
+	if !strings.HasPrefix(installScript, "#!") {
+		return fmt.Errorf("script must start with an interpreter line such as '#!/bin/sh'")
+	}
+	return validateInstallScript(installScript)
+}
+
+// validateInstallScript checks that an install script written as a template
+// parses and only references known placeholders. Other scripts are accepted
+// as they are.
+func validateInstallScript(installScript string) error {
+	if !isInstallScriptTemplate(installScript) {
+		return nil
+	}
+	_, err := parseInstallScriptTemplate(installScript)
+	return err
+}
+
+// renderInstallScript fills in the placeholders of an install script written
+// as a template. Other scripts are returned unchanged.
+func renderInstallScript(installScript string, data *installScriptData) (string, error) {
+	if !isInstallScriptTemplate(installScript) {
+		return installScript, nil
+	}
+	tmpl, err := parseInstallScriptTemplate(installScript)
+	if err != nil {
+		return "", fmt.Errorf("error parsing install script: %v", err)
+	}
+	var buf bytes.Buffer
+	if err := tmpl.Execute(&buf, data); err != nil {
+		return "", fmt.Errorf("error rendering install script: %v", err)
+	}
+	return buf.String(), nil
+}
@@ -0,0 +1,175 @@
+package ssh
+
+import (
+	"bytes"
+	"crypto/hmac"
+	"crypto/sha1"
+	"encoding/base64"
+	"fmt"
+	"net"
+	"strings"
+
+	"golang.org/x/crypto/ssh"
+)
+
+// knownHost is an entry of a known_hosts blob: the host key that the hosts
+// matching the patterns present, or must not present if it is revoked.
+type knownHost struct {
+	patterns []string
+	key      ssh.PublicKey
+	revoked  bool
+}
+
+// parseKnownHosts parses a blob in the format of OpenSSH's known_hosts files.
+// Host patterns may use wildcards, negations and hashed host names.
+// Certificate authorities are not supported.
+func parseKnownHosts(blob string) ([]*knownHost, error) {
+	var hosts []*knownHost
+	rest := []byte(blob)
+	for len(bytes.TrimSpace(rest)) != 0 {
+		var marker string
+		var patterns []string
+		var key ssh.PublicKey
+		var err error
+		marker, patterns, key, _, rest, err = ssh.ParseKnownHosts(rest)
+		if err != nil {
+			return nil, err
+		}
+		switch marker {
+		case "", "revoked":
+		default:
+			return nil, fmt.Errorf("@%s entries are not supported", marker)
+		}
+		for _, pattern := range patterns {
+			if strings.HasPrefix(pattern, "|") {
+				if _, _, err := decodeHashedHost(pattern); err != nil {
+					return nil, fmt.Errorf("invalid hashed host %q: %v", pattern, err)
+				}
+			}
+		}
+		hosts = append(hosts, &knownHost{
+			patterns: patterns,
+			key:      key,
+			revoked:  marker == "revoked",
+		})
+	}
+	if len(hosts) == 0 {
+		return nil, fmt.Errorf("no host keys found")
+	}
+	return hosts, nil
+}
+
+// knownHostsCallback returns a callback accepting the host keys which the
+// known hosts list for the host that is connected to. Hosts without an entry
+// are rejected, as are revoked keys.
+func knownHostsCallback(hosts []*knownHost) ssh.HostKeyCallback {
+	return func(address string, remote net.Addr, key ssh.PublicKey) error {
+		host := knownHostsName(address)
+		presented := key.Marshal()
+		for _, known := range hosts {
+			if !bytes.Equal(known.key.Marshal(), presented) || !matchHostPatterns(known.patterns, host) {
+				continue
+			}
+			if known.revoked {
+				return fmt.Errorf("host key verification failed for %s: presented %s key %s is revoked", address, key.Type(), ssh.FingerprintSHA256(key))
+			}
+			return nil
+		}
+		return fmt.Errorf("host key verification failed for %s: presented %s key %s is not in known_hosts", address, key.Type(), ssh.FingerprintSHA256(key))
+	}
+}
+
+// knownHostsName returns the name of the address in known_hosts files: the
+// host for the default port and '[host]:port' for others.
+func knownHostsName(address string) string {
+	host, port, err := net.SplitHostPort(address)
+	if err != nil {
+		return address
+	}
+	if port == "22" {
+		return host
+	}
+	return "[" + host + "]:" + port
+}
+
+// matchHostPatterns reports whether the host matches one of the patterns and
+// none of the negated ones.
+func matchHostPatterns(patterns []string, host string) bool {
+	matched := false
+	for _, pattern := range patterns {
+		negated := strings.HasPrefix(pattern, "!")
+		if negated {
+			pattern = pattern[1:]
+		}
+
+		var ok bool
+		if strings.HasPrefix(pattern, "|") {
+			ok = matchHashedHost(pattern, host)
+		} else {
+			ok = wildcardMatch(pattern, host)
+		}
+		if !ok {
+			continue
+		}
+		if negated {
+			return false
+		}
+		matched = true
+	}
+	return matched
+}
+
+// decodeHashedHost decodes a host name hashed by 'ssh-keygen -H', which has
+// the form '|1|<salt>|<hash>'.
+func decodeHashedHost(pattern string) ([]byte, []byte, error) {
+	parts := strings.Split(pattern, "|")
+	if len(parts) != 4 || parts[0] != "" || parts[1] != "1" {
+		return nil, nil, fmt.Errorf("unsupported hash format")
+	}
+	salt, err := base64.StdEncoding.DecodeString(parts[2])
+	if err != nil {
+		return nil, nil, err
+	}
+	hash, err := base64.StdEncoding.DecodeString(parts[3])
+	if err != nil {
+		return nil, nil, err
+	}
+	return salt, hash, nil
+}
+
+func matchHashedHost(pattern, host string) bool {
+	salt, hash, err := decodeHashedHost(pattern)
+	if err != nil {
+		return false
+	}
+	mac := hmac.New(sha1.New, salt)
+	mac.Write([]byte(host))
+	return hmac.Equal(mac.Sum(nil), hash)
+}
+
+// wildcardMatch matches the host against a pattern in which '*' matches any
+// number of characters and '?' exactly one.
+func wildcardMatch(pattern, host string) bool {
+	for len(pattern) != 0 {
+		switch pattern[0] {
+		case '*':
+			for i := len(host); i >= 0; i-- {
+				if wildcardMatch(pattern[1:], host[i:]) {
+					return true
+				}
+			}
+			return false
+		case '?':
+			if len(host) == 0 {
+				return false
+			}
+		default:
+			if len(host) == 0 || pattern[0] != host[0] {
+				return false
+			}
+		}
+		pattern = pattern[1:]
+		host = host[1:]
+	}
+	return len(host) == 0
+}
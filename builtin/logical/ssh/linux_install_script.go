@@ -3,8 +3,7 @@ package ssh
 const (
 	// This is a constant representing a script to install and uninstall public
 	// key in remote hosts.
-	DefaultPublicKeyInstallScript = `
-#!/bin/bash
+	DefaultPublicKeyInstallScript = `#!/bin/bash
 #
 # This is a default script which installs or uninstalls an RSA public key to/from
 # authorized_keys file in a typical linux machine.
@@ -38,7 +37,10 @@ AUTH_KEYS_FILE=$3
 # Delete the public key file and the temporary file
 function cleanup
 {
-	rm -f "$PUBLIC_KEY_FILE" temp_$PUBLIC_KEY_FILE
+	rm -f "$PUBLIC_KEY_FILE"
+	if [ -n "$TEMP_FILE" ]; then
+		sudo rm -f "$TEMP_FILE"
+	fi
 }
 
 # 'cleanup' will be called if the script ends or if any command fails.
@@ -54,16 +56,24 @@ SSH_DIR=$(dirname $AUTH_KEYS_FILE)
 sudo mkdir -p "$SSH_DIR"
 sudo touch "$AUTH_KEYS_FILE"
 
+# The authorized_keys file is rewritten into a temporary file next to it,
+# which then replaces it, so that it is never seen partially written.
+TEMP_FILE=$(sudo mktemp "$AUTH_KEYS_FILE.XXXXXX")
+
 # Remove the key from authorized_keys file if it is already present.
-# This step is common for both install and uninstall.  Note that grep's
-# return code is ignored, thus if grep fails all keys will be removed
-# rather than none and it fails secure
-sudo grep -vFf "$PUBLIC_KEY_FILE" "$AUTH_KEYS_FILE" > temp_$PUBLIC_KEY_FILE || true
-cat temp_$PUBLIC_KEY_FILE | sudo tee "$AUTH_KEYS_FILE"
+# This step is common for both install and uninstall. Only lines which are
+# exactly equal to the key are removed, so that other keys containing it
+# are kept. Note that grep's return code is ignored, thus if grep fails all
+# keys will be removed rather than none and it fails secure
+sudo grep -vxFf "$PUBLIC_KEY_FILE" "$AUTH_KEYS_FILE" | sudo tee "$TEMP_FILE" > /dev/null || true
 
-# Append the new public key to authorized_keys file
+# Append the new public key to authorized_keys file, on a line of its own
 if [ "$INSTALL_OPTION" == "install" ]; then
-	cat "$PUBLIC_KEY_FILE" | sudo tee --append "$AUTH_KEYS_FILE"
+	echo "$(cat "$PUBLIC_KEY_FILE")" | sudo tee --append "$TEMP_FILE" > /dev/null
 fi
+
+sudo chmod --reference="$AUTH_KEYS_FILE" "$TEMP_FILE"
+sudo chown --reference="$AUTH_KEYS_FILE" "$TEMP_FILE"
+sudo mv -f "$TEMP_FILE" "$AUTH_KEYS_FILE"
 `
 )
@@ -0,0 +1,52 @@
+package ssh
+
+import (
+	"strings"
+	"time"
+
+	"github.com/armon/go-metrics"
+	"github.com/hashicorp/vault/logical"
+)
+
+// HandleRequest records the mount path of the backend, which namespaces its
+// metrics, before handling the request.
+func (b *backend) HandleRequest(req *logical.Request) (*logical.Response, error) {
+	if req.MountPoint != "" {
+		b.mountPoint.Store(req.MountPoint)
+	}
+	return b.Backend.HandleRequest(req)
+}
+
+// metricKey returns the key of a metric of the backend. Keys start with the
+// mount path, with slashes replaced by dashes, so that the metrics of several
+// mounts can be told apart, e.g. 'ssh.ssh-prod.creds.otp'.
+func (b *backend) metricKey(name ...string) []string {
+	key := []string{"ssh"}
+	if mountPoint, _ := b.mountPoint.Load().(string); mountPoint != "" {
+		key = append(key, strings.Replace(strings.Trim(mountPoint, "/"), "/", "-", -1))
+	}
+	return append(key, name...)
+}
+
+// measureSince records the time elapsed since start under the key of the
+// named metric.
+func (b *backend) measureSince(start time.Time, name ...string) {
+	metrics.MeasureSince(b.metricKey(name...), start)
+}
+
+// recordOperation records the duration of an operation and counts its
+// outcome under the key of the operation followed by the outcome, e.g.
+// 'ssh.ssh.creds.otp.issued'.
+func (b *backend) recordOperation(start time.Time, outcome string, name ...string) {
+	b.measureSince(start, name...)
+	metrics.IncrCounter(b.metricKey(append(append([]string{}, name...), outcome)...), 1)
+}
+
+// operationOutcome returns the success outcome unless the operation returned
+// an error or an error response.
+func operationOutcome(resp *logical.Response, err error, success string) string {
+	if err != nil || (resp != nil && resp.IsError()) {
+		return "failed"
+	}
+	return success
+}
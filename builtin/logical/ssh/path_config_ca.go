@@ -1,6 +1,7 @@
 package ssh
 
 import (
+	"bytes"
 	"crypto/rand"
 	"crypto/rsa"
 	"crypto/x509"
@@ -43,19 +44,33 @@ func pathConfigCA(b *backend) *framework.Path {
 				Description: `Generate SSH key pair internally rather than use the private_key and public_key fields.`,
 				Default:     true,
 			},
+			"force": &framework.FieldSchema{
+				Type:        framework.TypeBool,
+				Description: `Overwrite the CA keys if they are already configured.`,
+			},
 		},
 
-		Callbacks: map[logical.Operation]framework.OperationFunc{
-			logical.UpdateOperation: b.pathConfigCAUpdate,
-			logical.DeleteOperation: b.pathConfigCADelete,
-			logical.ReadOperation:   b.pathConfigCARead,
+		Operations: map[logical.Operation]*framework.PathOperation{
+			logical.UpdateOperation: &framework.PathOperation{
+				Callback: b.pathConfigCAUpdate,
+				Summary:  "Configure the CA key pair used for signing, generating one if none is given.",
+			},
+			logical.DeleteOperation: &framework.PathOperation{
+				Callback: b.pathConfigCADelete,
+				Summary:  "Remove the CA key pair.",
+			},
+			logical.ReadOperation: &framework.PathOperation{
+				Callback: b.pathConfigCARead,
+				Summary:  "Read the public key of the CA.",
+			},
 		},
 
 		HelpSynopsis: `Set the SSH private key used for signing certificates.`,
 		HelpDescription: `This sets the CA information used for certificates generated by this
 by this mount. The fields must be in the standard private and public SSH format.
 
-For security reasons, the private key cannot be retrieved later.
+For security reasons, the private key cannot be retrieved later. Existing keys
+are not replaced unless 'force' is set to true.
 
 Read operations will return the public key, if already stored/generated.`,
 	}
@@ -63,6 +78,10 @@ Read operations will return the public key, if already stored/generated.`,
 
 func (b *backend) pathConfigCARead(
 	req *logical.Request, data *framework.FieldData) (*logical.Response, error) {
+	if err := checkFieldTypes(data); err != nil {
+		return nil, err
+	}
+
 	publicKeyEntry, err := caKey(req.Storage, caPublicKey)
 	if err != nil {
 		return nil, fmt.Errorf("failed to read CA public key: %v", err)
@@ -83,6 +102,10 @@ func (b *backend) pathConfigCARead(
 
 func (b *backend) pathConfigCADelete(
 	req *logical.Request, data *framework.FieldData) (*logical.Response, error) {
+	if err := checkFieldTypes(data); err != nil {
+		return nil, err
+	}
+
 	if err := req.Storage.Delete(caPrivateKeyStoragePath); err != nil {
 		return nil, err
 	}
@@ -145,6 +168,10 @@ func caKey(storage logical.Storage, keyType string) (*keyStorageEntry, error) {
 }
 
 func (b *backend) pathConfigCAUpdate(req *logical.Request, data *framework.FieldData) (*logical.Response, error) {
+	if err := checkFieldTypes(data); err != nil {
+		return nil, err
+	}
+
 	var err error
 	publicKey := data.Get("public_key").(string)
 	privateKey := data.Get("private_key").(string)
@@ -171,16 +198,29 @@ func (b *backend) pathConfigCAUpdate(req *logical.Request, data *framework.Field
 			return logical.ErrorResponse("missing private_key"), nil
 		}
 
-		_, err := ssh.ParsePrivateKey([]byte(privateKey))
+		rawPrivateKey, err := ssh.ParseRawPrivateKey([]byte(privateKey))
 		if err != nil {
 			return logical.ErrorResponse(fmt.Sprintf("Unable to parse private_key as an SSH private key: %v", err)), nil
 		}
 
-		_, err = parsePublicSSHKey(publicKey)
+		if _, ok := rawPrivateKey.(*rsa.PrivateKey); !ok {
+			return logical.ErrorResponse(fmt.Sprintf("private_key must be an RSA key, got %T", rawPrivateKey)), nil
+		}
+
+		signer, err := ssh.NewSignerFromKey(rawPrivateKey)
+		if err != nil {
+			return logical.ErrorResponse(fmt.Sprintf("Unable to parse private_key as an SSH private key: %v", err)), nil
+		}
+
+		parsedPublicKey, err := parsePublicSSHKey(publicKey)
 		if err != nil {
 			return logical.ErrorResponse(fmt.Sprintf("Unable to parse public_key as an SSH public key: %v", err)), nil
 		}
 
+		if !bytes.Equal(parsedPublicKey.Marshal(), signer.PublicKey().Marshal()) {
+			return logical.ErrorResponse("public_key does not match private_key"), nil
+		}
+
 	// not set and no public/private key provided so generate
 	case publicKey == "" && privateKey == "":
 		generateSigningKey = true
@@ -211,8 +251,9 @@ func (b *backend) pathConfigCAUpdate(req *logical.Request, data *framework.Field
 		return nil, fmt.Errorf("failed to read CA private key: %v", err)
 	}
 
-	if (publicKeyEntry != nil && publicKeyEntry.Key != "") || (privateKeyEntry != nil && privateKeyEntry.Key != "") {
-		return nil, fmt.Errorf("keys are already configured; delete them before reconfiguring")
+	if !data.Get("force").(bool) &&
+		((publicKeyEntry != nil && publicKeyEntry.Key != "") || (privateKeyEntry != nil && privateKeyEntry.Key != "")) {
+		return nil, fmt.Errorf("keys are already configured; delete them or set 'force' before reconfiguring")
 	}
 
 	entry, err := logical.StorageEntryJSON(caPublicKeyStoragePath, &keyStorageEntry{
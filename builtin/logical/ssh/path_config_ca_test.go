@@ -1,9 +1,15 @@
 package ssh
 
 import (
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/x509"
+	"encoding/pem"
 	"testing"
 
 	"github.com/hashicorp/vault/logical"
+	"golang.org/x/crypto/ssh"
 )
 
 func TestSSH_ConfigCAStorageUpgrade(t *testing.T) {
@@ -161,3 +167,90 @@ func TestSSH_ConfigCAUpdateDelete(t *testing.T) {
 		t.Fatalf("bad: err: %v, resp:%v", err, resp)
 	}
 }
+
+func TestSSH_ConfigCAForceAndValidation(t *testing.T) {
+	config := logical.TestBackendConfig()
+	config.StorageView = &logical.InmemStorage{}
+
+	b, err := Factory(config)
+	if err != nil {
+		t.Fatalf("Cannot create backend: %s", err)
+	}
+
+	caReq := &logical.Request{
+		Path:      "config/ca",
+		Operation: logical.UpdateOperation,
+		Storage:   config.StorageView,
+	}
+
+	// A non-RSA private key should be rejected
+	ecKey, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	if err != nil {
+		t.Fatal(err)
+	}
+	ecDER, err := x509.MarshalECPrivateKey(ecKey)
+	if err != nil {
+		t.Fatal(err)
+	}
+	ecPublicKey, err := ssh.NewPublicKey(&ecKey.PublicKey)
+	if err != nil {
+		t.Fatal(err)
+	}
+	caReq.Data = map[string]interface{}{
+		"public_key":  string(ssh.MarshalAuthorizedKey(ecPublicKey)),
+		"private_key": string(pem.EncodeToMemory(&pem.Block{Type: "EC PRIVATE KEY", Bytes: ecDER})),
+	}
+	resp, err := b.HandleRequest(caReq)
+	if err != nil || resp == nil || !resp.IsError() {
+		t.Fatalf("expected an error response: err: %v, resp: %#v", err, resp)
+	}
+
+	// A public key that is not the pair of the private key should be rejected
+	otherPublicKey, _, err := generateRSAKeys(1024)
+	if err != nil {
+		t.Fatal(err)
+	}
+	caReq.Data = map[string]interface{}{
+		"public_key":  otherPublicKey,
+		"private_key": privateKey,
+	}
+	resp, err = b.HandleRequest(caReq)
+	if err != nil || resp == nil || !resp.IsError() {
+		t.Fatalf("expected an error response: err: %v, resp: %#v", err, resp)
+	}
+
+	caReq.Data = map[string]interface{}{
+		"public_key":  publicKey,
+		"private_key": privateKey,
+	}
+	resp, err = b.HandleRequest(caReq)
+	if err != nil || (resp != nil && resp.IsError()) {
+		t.Fatalf("bad: err: %v, resp: %#v", err, resp)
+	}
+
+	// Overwriting with force should replace the configured keys
+	caReq.Data = map[string]interface{}{
+		"generate_signing_key": true,
+		"force":                true,
+	}
+	resp, err = b.HandleRequest(caReq)
+	if err != nil || resp == nil || resp.IsError() {
+		t.Fatalf("bad: err: %v, resp: %#v", err, resp)
+	}
+	if resp.Data["public_key"] == publicKey {
+		t.Fatalf("expected the CA public key to be replaced")
+	}
+
+	caReq.Operation = logical.ReadOperation
+	caReq.Data = nil
+	readResp, err := b.HandleRequest(caReq)
+	if err != nil || readResp == nil || readResp.IsError() {
+		t.Fatalf("bad: err: %v, resp: %#v", err, readResp)
+	}
+	if readResp.Data["public_key"] != resp.Data["public_key"] {
+		t.Fatalf("bad: expected %q, got %q", resp.Data["public_key"], readResp.Data["public_key"])
+	}
+	if _, ok := readResp.Data["private_key"]; ok {
+		t.Fatalf("private key must never be returned")
+	}
+}
@@ -0,0 +1,189 @@
+package ssh
+
+import (
+	"fmt"
+	"time"
+
+	"github.com/hashicorp/vault/logical"
+	"github.com/hashicorp/vault/logical/framework"
+	"golang.org/x/crypto/ssh"
+)
+
+const (
+	// Connections to targets time out after this long unless configured
+	// otherwise.
+	defaultConnectionTimeout = 15 * time.Second
+
+	// Failed connection attempts are retried after this interval, growing
+	// linearly with every attempt.
+	connectionRetryInterval = 500 * time.Millisecond
+
+	// Upper bound for the number of times failed connections are retried
+	maxConnectionRetries = 10
+)
+
+// Structure to hold the settings for connecting to the targets of dynamic
+// keys. Roles can override these.
+type connectionConfig struct {
+	Timeout time.Duration `json:"connection_timeout" mapstructure:"connection_timeout"`
+	Retries int           `json:"connection_retries" mapstructure:"connection_retries"`
+
+	// The host keys pinned by the role, if any. These are not stored with
+	// the configuration.
+	knownHosts []*knownHost
+}
+
+// hostKeyCallback returns the callback verifying the host keys of the targets
+// and bastions. Their host keys are only verified if the role pins them.
+func (c *connectionConfig) hostKeyCallback() ssh.HostKeyCallback {
+	if c == nil || len(c.knownHosts) == 0 {
+		return ssh.InsecureIgnoreHostKey()
+	}
+	return knownHostsCallback(c.knownHosts)
+}
+
+func pathConfigConnection(b *backend) *framework.Path {
+	return &framework.Path{
+		Pattern: "config/connection",
+		Fields: map[string]*framework.FieldSchema{
+			"connection_timeout": &framework.FieldSchema{
+				Type: framework.TypeDurationSecond,
+				Description: `Duration after which an attempt to connect to the target
+				of a dynamic key is abandoned. Defaults to 15 seconds.`,
+			},
+			"connection_retries": &framework.FieldSchema{
+				Type: framework.TypeInt,
+				Description: `Number of times a failed attempt to connect to the
+				target of a dynamic key is retried. Defaults to 0.`,
+			},
+		},
+		Operations: map[logical.Operation]*framework.PathOperation{
+			logical.UpdateOperation: &framework.PathOperation{
+				Callback: b.pathConfigConnectionWrite,
+				Summary:  "Configure how the targets of dynamic keys are connected to.",
+			},
+			logical.ReadOperation: &framework.PathOperation{
+				Callback: b.pathConfigConnectionRead,
+				Summary:  "Read the connection settings.",
+			},
+		},
+		HelpSynopsis:    pathConfigConnectionSyn,
+		HelpDescription: pathConfigConnectionDesc,
+	}
+}
+
+func (b *backend) pathConfigConnectionRead(req *logical.Request, d *framework.FieldData) (*logical.Response, error) {
+	if err := checkFieldTypes(d); err != nil {
+		return nil, err
+	}
+
+	config, err := b.getConnectionConfig(req.Storage)
+	if err != nil {
+		return nil, err
+	}
+
+	return &logical.Response{
+		Data: map[string]interface{}{
+			"connection_timeout": int64(config.Timeout.Seconds()),
+			"connection_retries": config.Retries,
+		},
+	}, nil
+}
+
+func (b *backend) pathConfigConnectionWrite(req *logical.Request, d *framework.FieldData) (*logical.Response, error) {
+	if err := checkFieldTypes(d); err != nil {
+		return nil, err
+	}
+
+	timeout := time.Duration(d.Get("connection_timeout").(int)) * time.Second
+	retries := d.Get("connection_retries").(int)
+	if errResp := validateConnectionSettings(timeout, retries); errResp != nil {
+		return errResp, nil
+	}
+	if timeout == 0 {
+		timeout = defaultConnectionTimeout
+	}
+
+	entry, err := logical.StorageEntryJSON("config/connection", &connectionConfig{
+		Timeout: timeout,
+		Retries: retries,
+	})
+	if err != nil {
+		return nil, err
+	}
+	if err := req.Storage.Put(entry); err != nil {
+		return nil, err
+	}
+
+	return nil, nil
+}
+
+// Retrieves the connection settings, falling back to the defaults if they
+// were never configured.
+func (b *backend) getConnectionConfig(s logical.Storage) (*connectionConfig, error) {
+	result := &connectionConfig{
+		Timeout: defaultConnectionTimeout,
+	}
+
+	entry, err := s.Get("config/connection")
+	if err != nil {
+		return nil, err
+	}
+	if entry == nil {
+		return result, nil
+	}
+
+	if err := entry.DecodeJSON(result); err != nil {
+		return nil, err
+	}
+
+	return result, nil
+}
+
+// roleConnectionConfig returns the connection settings for the targets of the
+// role. Settings which the role does not define are taken from
+// 'config/connection'. A nil role yields the configured settings.
+func (b *backend) roleConnectionConfig(s logical.Storage, role *sshRole) (*connectionConfig, error) {
+	config, err := b.getConnectionConfig(s)
+	if err != nil {
+		return nil, fmt.Errorf("error retrieving connection configuration: %v", err)
+	}
+	if role == nil {
+		return config, nil
+	}
+	if role.ConnectionTimeout > 0 {
+		config.Timeout = role.ConnectionTimeout
+	}
+	if role.ConnectionRetries > 0 {
+		config.Retries = role.ConnectionRetries
+	}
+	if role.KnownHosts != "" {
+		config.knownHosts, err = parseKnownHosts(role.KnownHosts)
+		if err != nil {
+			return nil, fmt.Errorf("invalid known_hosts of role: %v", err)
+		}
+	}
+	return config, nil
+}
+
+func validateConnectionSettings(timeout time.Duration, retries int) *logical.Response {
+	if timeout < 0 {
+		return logical.ErrorResponse("connection_timeout must not be negative")
+	}
+	if retries < 0 || retries > maxConnectionRetries {
+		return logical.ErrorResponse(fmt.Sprintf("connection_retries must be between 0 and %d", maxConnectionRetries))
+	}
+	return nil
+}
+
+const pathConfigConnectionSyn = `
+Configure how the targets of dynamic keys are connected to.
+`
+
+const pathConfigConnectionDesc = `
+Installing and removing dynamic keys requires Vault to connect to the target.
+The 'connection_timeout' parameter bounds each attempt to connect, and failed
+attempts are retried 'connection_retries' times with a short backoff in
+between. Roles can override both settings. If every attempt times out, the
+request fails with an error naming the target and the time spent.
+`
@@ -0,0 +1,222 @@
+package ssh
+
+import (
+	"fmt"
+	"strings"
+
+	"github.com/hashicorp/vault/logical"
+	"github.com/hashicorp/vault/logical/framework"
+)
+
+const (
+	// Install scripts are limited to this size unless configured otherwise
+	defaultMaxInstallScriptSize = 32 * 1024
+
+	// Upper bound for the configurable size limit of install scripts
+	maxInstallScriptSizeLimit = 1024 * 1024
+)
+
+// Structure to hold the install script shared by dynamic roles that do not
+// define their own, and the size limit of install scripts. An empty script
+// stands for the built-in one.
+type installScriptConfig struct {
+	InstallScript string `json:"install_script" mapstructure:"install_script"`
+	MaxSize       int    `json:"max_size" mapstructure:"max_size"`
+}
+
+// maxSize returns the size limit of install scripts.
+func (c *installScriptConfig) maxSize() int {
+	if c == nil || c.MaxSize == 0 {
+		return defaultMaxInstallScriptSize
+	}
+	return c.MaxSize
+}
+
+func pathConfigInstallScript(b *backend) *framework.Path {
+	return &framework.Path{
+		Pattern: "config/install_script",
+		Fields: map[string]*framework.FieldSchema{
+			"install_script": &framework.FieldSchema{
+				Type: framework.TypeString,
+				Description: `[Required] Script used to install and uninstall dynamic
+				keys for the roles which do not define their own 'install_script'.
+				It is run with the arguments 'install' or 'uninstall', the name of
+				the file containing the public key and the path of the
+				authorized_keys file, which it must reference as $1, $2 and $3.
+				Alternatively, it can use the placeholders '{{.Install}}',
+				'{{.Uninstall}}', '{{.PublicKeyFile}}', '{{.AuthorizedKeysPath}}'
+				and '{{.Username}}', in which case it is run without arguments.
+				Can be omitted when only updating 'max_size'.`,
+			},
+			"max_size": &framework.FieldSchema{
+				Type: framework.TypeInt,
+				Description: `Maximum size in bytes of the install scripts of the
+				roles and of the shared script, checked when they are written.
+				Defaults to 32768.`,
+			},
+		},
+		Operations: map[logical.Operation]*framework.PathOperation{
+			logical.UpdateOperation: &framework.PathOperation{
+				Callback: b.pathConfigInstallScriptWrite,
+				Summary:  "Configure the install script shared by dynamic roles.",
+			},
+			logical.ReadOperation: &framework.PathOperation{
+				Callback: b.pathConfigInstallScriptRead,
+				Summary:  "Read the shared install script.",
+			},
+			logical.DeleteOperation: &framework.PathOperation{
+				Callback: b.pathConfigInstallScriptDelete,
+				Summary:  "Remove the shared install script.",
+			},
+		},
+		HelpSynopsis:    pathConfigInstallScriptSyn,
+		HelpDescription: pathConfigInstallScriptDesc,
+	}
+}
+
+func (b *backend) pathConfigInstallScriptWrite(req *logical.Request, d *framework.FieldData) (*logical.Response, error) {
+	if err := checkFieldTypes(d); err != nil {
+		return nil, err
+	}
+
+	config, err := b.getInstallScriptConfig(req.Storage)
+	if err != nil {
+		return nil, err
+	}
+	if config == nil {
+		config = &installScriptConfig{}
+	}
+
+	maxSizeRaw, setMaxSize := d.GetOk("max_size")
+	if setMaxSize {
+		maxSize := maxSizeRaw.(int)
+		if maxSize <= 0 || maxSize > maxInstallScriptSizeLimit {
+			return logical.ErrorResponse(fmt.Sprintf("max_size must be between 1 and %d", maxInstallScriptSizeLimit)), nil
+		}
+		if len(config.InstallScript) > maxSize {
+			return logical.ErrorResponse("the configured install_script is larger than max_size"), nil
+		}
+		config.MaxSize = maxSize
+	}
+
+	installScript := d.Get("install_script").(string)
+	switch {
+	case strings.TrimSpace(installScript) != "":
+		if err := checkInstallScript(installScript, config.maxSize()); err != nil {
+			return logical.ErrorResponse(fmt.Sprintf("invalid install_script: %v", err)), nil
+		}
+		// Templates get the values filled in instead of passed as arguments
+		if !isInstallScriptTemplate(installScript) {
+			for _, arg := range []string{"1", "2", "3"} {
+				if !strings.Contains(installScript, "$"+arg) && !strings.Contains(installScript, "${"+arg+"}") {
+					return logical.ErrorResponse(fmt.Sprintf("install_script does not reference argument $%s", arg)), nil
+				}
+			}
+		}
+		config.InstallScript = installScript
+	case !setMaxSize:
+		return logical.ErrorResponse("Missing install_script"), nil
+	}
+
+	entry, err := logical.StorageEntryJSON("config/install_script", config)
+	if err != nil {
+		return nil, err
+	}
+	if err := req.Storage.Put(entry); err != nil {
+		return nil, err
+	}
+
+	return nil, nil
+}
+
+func (b *backend) pathConfigInstallScriptRead(req *logical.Request, d *framework.FieldData) (*logical.Response, error) {
+	if err := checkFieldTypes(d); err != nil {
+		return nil, err
+	}
+
+	config, err := b.getInstallScriptConfig(req.Storage)
+	if err != nil {
+		return nil, err
+	}
+
+	installScript := DefaultPublicKeyInstallScript
+	if config != nil && config.InstallScript != "" {
+		installScript = config.InstallScript
+	}
+
+	return &logical.Response{
+		Data: map[string]interface{}{
+			"install_script": installScript,
+			"built_in":       config == nil || config.InstallScript == "",
+			"max_size":       config.maxSize(),
+		},
+	}, nil
+}
+
+func (b *backend) pathConfigInstallScriptDelete(req *logical.Request, d *framework.FieldData) (*logical.Response, error) {
+	if err := checkFieldTypes(d); err != nil {
+		return nil, err
+	}
+
+	if err := req.Storage.Delete("config/install_script"); err != nil {
+		return nil, err
+	}
+	return nil, nil
+}
+
+func (b *backend) getInstallScriptConfig(s logical.Storage) (*installScriptConfig, error) {
+	entry, err := s.Get("config/install_script")
+	if err != nil {
+		return nil, err
+	}
+	if entry == nil {
+		return nil, nil
+	}
+
+	var result installScriptConfig
+	if err := entry.DecodeJSON(&result); err != nil {
+		return nil, err
+	}
+
+	return &result, nil
+}
+
+// sharedInstallScript returns the install script configured at
+// config/install_script, or the built-in script if none is configured.
+func (b *backend) sharedInstallScript(s logical.Storage) (string, error) {
+	config, err := b.getInstallScriptConfig(s)
+	if err != nil {
+		return "", fmt.Errorf("error retrieving shared install script: %v", err)
+	}
+	if config == nil || config.InstallScript == "" {
+		return DefaultPublicKeyInstallScript, nil
+	}
+	return config.InstallScript, nil
+}
+
+// maxInstallScriptSize returns the configured size limit of install scripts.
+func (b *backend) maxInstallScriptSize(s logical.Storage) (int, error) {
+	config, err := b.getInstallScriptConfig(s)
+	if err != nil {
+		return 0, fmt.Errorf("error retrieving install script configuration: %v", err)
+	}
+	return config.maxSize(), nil
+}
+
+const pathConfigInstallScriptSyn = `
+Configure the install script shared by dynamic roles.
+`
+
+const pathConfigInstallScriptDesc = `
+Dynamic roles without an 'install_script' of their own, including roles which
+were created with a copy of the built-in script, use the script configured
+here to install and uninstall dynamic keys. The script is looked up every time
+a key is installed or uninstalled, so updating it takes effect for all these
+roles at once.
+
+Install scripts, including those of roles, must start with an interpreter
+line such as '#!/bin/sh' and must not be larger than 'max_size' bytes, as they
+are uploaded to the target for every key that is installed or uninstalled.
+The limit is checked when scripts are written. Deleting the configuration
+reverts to the built-in script and the default limit.
+`
@@ -0,0 +1,121 @@
+package ssh
+
+import (
+	"time"
+
+	"github.com/hashicorp/vault/logical"
+	"github.com/hashicorp/vault/logical/framework"
+)
+
+// Revocations that could not be completed are retried for this long unless
+// configured otherwise.
+const defaultRevocationMaxAge = 7 * 24 * time.Hour
+
+// Structure to hold the settings for retrying failed revocations.
+type revocationConfig struct {
+	MaxAge time.Duration `json:"max_age" mapstructure:"max_age"`
+}
+
+func pathConfigRevocation(b *backend) *framework.Path {
+	return &framework.Path{
+		Pattern: "config/revocation",
+		Fields: map[string]*framework.FieldSchema{
+			"max_age": &framework.FieldSchema{
+				Type:    framework.TypeDurationSecond,
+				Default: int(defaultRevocationMaxAge / time.Second),
+				Description: `Duration for which the removal of a dynamic key from
+				an unreachable target is retried. After this, the pending revocation
+				is marked as failed and kept for inspection. Defaults to 7 days.`,
+			},
+		},
+		Operations: map[logical.Operation]*framework.PathOperation{
+			logical.UpdateOperation: &framework.PathOperation{
+				Callback: b.pathConfigRevocationWrite,
+				Summary:  "Configure how failed removals of dynamic keys are retried.",
+			},
+			logical.ReadOperation: &framework.PathOperation{
+				Callback: b.pathConfigRevocationRead,
+				Summary:  "Read the revocation settings.",
+			},
+		},
+		HelpSynopsis:    pathConfigRevocationSyn,
+		HelpDescription: pathConfigRevocationDesc,
+	}
+}
+
+func (b *backend) pathConfigRevocationRead(req *logical.Request, d *framework.FieldData) (*logical.Response, error) {
+	if err := checkFieldTypes(d); err != nil {
+		return nil, err
+	}
+
+	config, err := b.getRevocationConfig(req.Storage)
+	if err != nil {
+		return nil, err
+	}
+
+	return &logical.Response{
+		Data: map[string]interface{}{
+			"max_age": int64(config.MaxAge.Seconds()),
+		},
+	}, nil
+}
+
+func (b *backend) pathConfigRevocationWrite(req *logical.Request, d *framework.FieldData) (*logical.Response, error) {
+	if err := checkFieldTypes(d); err != nil {
+		return nil, err
+	}
+
+	maxAge := time.Duration(d.Get("max_age").(int)) * time.Second
+	if maxAge < 0 {
+		return logical.ErrorResponse("max_age must not be negative"), nil
+	}
+	if maxAge == 0 {
+		maxAge = defaultRevocationMaxAge
+	}
+
+	entry, err := logical.StorageEntryJSON("config/revocation", &revocationConfig{
+		MaxAge: maxAge,
+	})
+	if err != nil {
+		return nil, err
+	}
+	if err := req.Storage.Put(entry); err != nil {
+		return nil, err
+	}
+
+	return nil, nil
+}
+
+// Retrieves the revocation settings, falling back to the defaults if they
+// were never configured.
+func (b *backend) getRevocationConfig(s logical.Storage) (*revocationConfig, error) {
+	result := &revocationConfig{
+		MaxAge: defaultRevocationMaxAge,
+	}
+
+	entry, err := s.Get("config/revocation")
+	if err != nil {
+		return nil, err
+	}
+	if entry == nil {
+		return result, nil
+	}
+
+	if err := entry.DecodeJSON(result); err != nil {
+		return nil, err
+	}
+
+	return result, nil
+}
+
+const pathConfigRevocationSyn = `
+Configure how failed removals of dynamic keys are retried.
+`
+
+const pathConfigRevocationDesc = `
+When a dynamic key lease is revoked while its target is unreachable, the key
+cannot be removed from the authorized_keys file of the target. Such revocations
+are queued and retried with exponential backoff. The 'max_age' parameter
+controls for how long they are retried before being marked as failed. Queued
+revocations can be listed using the 'revocations/' endpoint.
+`
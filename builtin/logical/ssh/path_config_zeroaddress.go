@@ -4,6 +4,7 @@ import (
 	"fmt"
 	"strings"
 
+	"github.com/hashicorp/vault/helper/strutil"
 	"github.com/hashicorp/vault/logical"
 	"github.com/hashicorp/vault/logical/framework"
 )
@@ -24,10 +25,19 @@ func pathConfigZeroAddress(b *backend) *framework.Path {
 				previously registered under these roles will be ignored.`,
 			},
 		},
-		Callbacks: map[logical.Operation]framework.OperationFunc{
-			logical.UpdateOperation: b.pathConfigZeroAddressWrite,
-			logical.ReadOperation:   b.pathConfigZeroAddressRead,
-			logical.DeleteOperation: b.pathConfigZeroAddressDelete,
+		Operations: map[logical.Operation]*framework.PathOperation{
+			logical.UpdateOperation: &framework.PathOperation{
+				Callback: b.pathConfigZeroAddressWrite,
+				Summary:  "Set the roles using the zero address as their default CIDR block.",
+			},
+			logical.ReadOperation: &framework.PathOperation{
+				Callback: b.pathConfigZeroAddressRead,
+				Summary:  "Read the roles using the zero address as their default CIDR block.",
+			},
+			logical.DeleteOperation: &framework.PathOperation{
+				Callback: b.pathConfigZeroAddressDelete,
+				Summary:  "Remove the zero address roles.",
+			},
 		},
 		HelpSynopsis:    pathConfigZeroAddressSyn,
 		HelpDescription: pathConfigZeroAddressDesc,
@@ -35,6 +45,10 @@ func pathConfigZeroAddress(b *backend) *framework.Path {
 }
 
 func (b *backend) pathConfigZeroAddressDelete(req *logical.Request, d *framework.FieldData) (*logical.Response, error) {
+	if err := checkFieldTypes(d); err != nil {
+		return nil, err
+	}
+
 	err := req.Storage.Delete("config/zeroaddress")
 	if err != nil {
 		return nil, err
@@ -43,6 +57,10 @@ func (b *backend) pathConfigZeroAddressDelete(req *logical.Request, d *framework
 }
 
 func (b *backend) pathConfigZeroAddressRead(req *logical.Request, d *framework.FieldData) (*logical.Response, error) {
+	if err := checkFieldTypes(d); err != nil {
+		return nil, err
+	}
+
 	entry, err := b.getZeroAddressRoles(req.Storage)
 	if err != nil {
 		return nil, err
@@ -59,6 +77,10 @@ func (b *backend) pathConfigZeroAddressRead(req *logical.Request, d *framework.F
 }
 
 func (b *backend) pathConfigZeroAddressWrite(req *logical.Request, d *framework.FieldData) (*logical.Response, error) {
+	if err := checkFieldTypes(d); err != nil {
+		return nil, err
+	}
+
 	roleNames := d.Get("roles").(string)
 	if roleNames == "" {
 		return logical.ErrorResponse("Missing roles"), nil
@@ -116,6 +138,18 @@ func (b *backend) getZeroAddressRoles(s logical.Storage) (*zeroAddressRoles, err
 	return &result, nil
 }
 
+// Checks whether the role is registered in config/zeroaddress
+func (b *backend) isZeroAddressRole(s logical.Storage, roleName string) (bool, error) {
+	zeroAddressEntry, err := b.getZeroAddressRoles(s)
+	if err != nil {
+		return false, fmt.Errorf("error retrieving zero-address roles: %v", err)
+	}
+	if zeroAddressEntry == nil {
+		return false, nil
+	}
+	return strutil.StrListContains(zeroAddressEntry.Roles, roleName), nil
+}
+
 // Removes a role from the list of roles present in config/zeroaddress path
 func (b *backend) removeZeroAddressRole(s logical.Storage, roleName string) error {
 	zeroAddressEntry, err := b.getZeroAddressRoles(s)
@@ -136,17 +170,18 @@ func (b *backend) removeZeroAddressRole(s logical.Storage, roleName string) erro
 
 // Removes a given role from the comma separated string
 func (r *zeroAddressRoles) remove(roleName string) error {
-	var index int
+	index := -1
 	for i, role := range r.Roles {
 		if role == roleName {
 			index = i
 			break
 		}
 	}
-	length := len(r.Roles)
-	if index >= length || index < 0 {
-		return fmt.Errorf("invalid index [%d]", index)
+	// Nothing to remove if the role is not in the list
+	if index < 0 {
+		return nil
 	}
+	length := len(r.Roles)
 	// If slice has zero or one item, remove the item by setting slice to nil.
 	if length < 2 {
 		r.Roles = nil
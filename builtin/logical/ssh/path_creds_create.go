@@ -3,17 +3,48 @@ package ssh
 import (
 	"fmt"
 	"net"
+	"net/http"
+	"strconv"
 	"strings"
+	"sync"
+	"time"
 
 	"github.com/hashicorp/go-uuid"
+	"github.com/hashicorp/vault/helper/strutil"
 	"github.com/hashicorp/vault/logical"
 	"github.com/hashicorp/vault/logical/framework"
+	"golang.org/x/crypto/ssh"
 )
 
 type sshOTP struct {
 	Username string `json:"username" structs:"username" mapstructure:"username"`
 	IP       string `json:"ip" structs:"ip" mapstructure:"ip"`
 	RoleName string `json:"role_name" structs:"role_name" mapstructure:"role_name"`
+
+	// Issuance time and lease duration of the OTP. These are not set for
+	// OTPs created before they were recorded.
+	CreatedAt time.Time     `json:"created_at" structs:"created_at" mapstructure:"created_at"`
+	TTL       time.Duration `json:"ttl" structs:"ttl" mapstructure:"ttl"`
+
+	// Display name of the token the OTP was issued to. Not set for OTPs
+	// created before it was recorded.
+	DisplayName string `json:"display_name" structs:"display_name" mapstructure:"display_name"`
+
+	// Set if the role binds OTPs to the address which requested them. The
+	// OTP can only be verified for SSH connections from that address or
+	// from the allowed redeemer CIDR blocks.
+	ClientIP             string   `json:"client_ip,omitempty" structs:"client_ip" mapstructure:"client_ip"`
+	AllowedRedeemerCIDRs []string `json:"allowed_redeemer_cidrs,omitempty" structs:"allowed_redeemer_cidrs" mapstructure:"allowed_redeemer_cidrs"`
+
+	// Number of times the OTP can be verified, and how many of these are
+	// left. OTPs created before uses were counted have neither and are
+	// deleted when first verified.
+	MaxUses       int `json:"max_uses,omitempty" structs:"max_uses" mapstructure:"max_uses"`
+	RemainingUses int `json:"remaining_uses,omitempty" structs:"remaining_uses" mapstructure:"remaining_uses"`
+
+	// Time after which the OTP can no longer be verified, if the role limits
+	// this to less than the lease.
+	ExpiresAt time.Time `json:"expires_at" structs:"expires_at" mapstructure:"expires_at"`
 }
 
 func pathCredsCreate(b *backend) *framework.Path {
@@ -21,7 +52,7 @@ func pathCredsCreate(b *backend) *framework.Path {
 		Pattern: "creds/" + framework.GenericNameRegex("role"),
 		Fields: map[string]*framework.FieldSchema{
 			"role": &framework.FieldSchema{
-				Type:        framework.TypeString,
+				Type:        framework.TypeNameString,
 				Description: "[Required] Name of the role",
 			},
 			"username": &framework.FieldSchema{
@@ -30,11 +61,48 @@ func pathCredsCreate(b *backend) *framework.Path {
 			},
 			"ip": &framework.FieldSchema{
 				Type:        framework.TypeString,
-				Description: "[Required] IP of the remote host",
+				Description: "[Required unless hostname is set] IP of the remote host. For dynamic roles, this can be a comma separated list of IPs to install the same key on",
+			},
+			"all_or_nothing": &framework.FieldSchema{
+				Type:        framework.TypeBool,
+				Description: "[Optional for Dynamic type] If set and the key could not be installed on some of the IPs, it is removed from the others and the request fails",
+			},
+			"private_key_format": &framework.FieldSchema{
+				Type:        framework.TypeString,
+				Description: "[Optional for Dynamic type] Encoding of the private key, 'pem' or 'openssh'. Defaults to the private_key_format of the role",
+			},
+			"key_passphrase": &framework.FieldSchema{
+				Type:        framework.TypeString,
+				Description: "[Optional for Dynamic type] Passphrase to encrypt the private key with. Only supported for the 'pem' private_key_format",
+			},
+			"generate_passphrase": &framework.FieldSchema{
+				Type:        framework.TypeBool,
+				Description: "[Optional for Dynamic type] If set, the private key is encrypted with a generated passphrase, which is returned as 'key_passphrase'",
+			},
+			"hostname": &framework.FieldSchema{
+				Type:        framework.TypeString,
+				Description: "[Optional] Hostname of the remote host. Only allowed if the role has resolve_hostnames set",
+			},
+			"port": &framework.FieldSchema{
+				Type:        framework.TypeInt,
+				Description: "[Optional] Port of the remote host. Must be the port of the role or be in its allowed_ports. Defaults to the port of the role",
+			},
+			"ttl": &framework.FieldSchema{
+				Type:        framework.TypeDurationSecond,
+				Aliases:     []string{"lease"},
+				Description: "[Optional] Requested lease duration of the credentials. Capped by the max_ttl of the role and the backend maximum. Defaults to the ttl of the role",
+			},
+			"dry_run": &framework.FieldSchema{
+				Type:        framework.TypeBool,
+				Description: "[Optional] If set, the request is only validated and the parameters of the credentials are returned, without issuing them",
 			},
 		},
-		Callbacks: map[logical.Operation]framework.OperationFunc{
-			logical.UpdateOperation: b.pathCredsCreateWrite,
+		Operations: map[logical.Operation]*framework.PathOperation{
+			logical.UpdateOperation: &framework.PathOperation{
+				Callback: b.pathCredsCreateWrite,
+				Summary:  "Create a credential for the given host.",
+				Response: credsResponse,
+			},
 		},
 		HelpSynopsis:    pathCredsCreateHelpSyn,
 		HelpDescription: pathCredsCreateHelpDesc,
@@ -42,15 +110,23 @@ func pathCredsCreate(b *backend) *framework.Path {
 }
 
 func (b *backend) pathCredsCreateWrite(
-	req *logical.Request, d *framework.FieldData) (*logical.Response, error) {
+	req *logical.Request, d *framework.FieldData) (resp *logical.Response, retErr error) {
+	if err := checkFieldTypes(d); err != nil {
+		return nil, err
+	}
+
 	roleName := d.Get("role").(string)
 	if roleName == "" {
 		return logical.ErrorResponse("Missing role"), nil
 	}
 
 	ipRaw := d.Get("ip").(string)
-	if ipRaw == "" {
-		return logical.ErrorResponse("Missing ip"), nil
+	hostname := d.Get("hostname").(string)
+	if ipRaw == "" && hostname == "" {
+		return logical.ErrorResponse("Missing ip or hostname"), nil
+	}
+	if ipRaw != "" && hostname != "" {
+		return logical.ErrorResponse("Only one of ip or hostname can be specified"), nil
 	}
 
 	role, err := b.getRole(req.Storage, roleName)
@@ -58,13 +134,43 @@ func (b *backend) pathCredsCreateWrite(
 		return nil, fmt.Errorf("error retrieving role: %v", err)
 	}
 	if role == nil {
-		return logical.ErrorResponse(fmt.Sprintf("Role %q not found", roleName)), nil
+		return nil, logical.CodedError(http.StatusNotFound, fmt.Sprintf("Role %q not found", roleName))
+	}
+
+	// Dry runs go through the same checks as requests issuing credentials
+	// but stop short of writing or installing anything. They do not count
+	// towards the rate limit of the role.
+	dryRun := d.Get("dry_run").(bool)
+	successOutcome := "issued"
+	if dryRun {
+		successOutcome = "dry_run"
+	}
+
+	defer func(start time.Time) {
+		b.recordOperation(start, operationOutcome(resp, retErr, successOutcome), "creds", role.KeyType)
+	}(time.Now())
+
+	if !dryRun && role.MaxCredsPerMinute > 0 && !b.credsLimiter.allow(roleName, role.MaxCredsPerMinute, role.MaxCredsBurst) {
+		return nil, logical.CodedError(http.StatusTooManyRequests, fmt.Sprintf("Rate limit of role %q exceeded: at most %d credentials can be issued per minute", roleName, role.MaxCredsPerMinute))
 	}
 
 	// username is an optional parameter.
 	username := d.Get("username").(string)
 
-	// Set the default username
+	// Set the default username, rendering it from the token of the request
+	// if the role has a template
+	if username == "" && role.DefaultUserTemplate != "" {
+		rendered, err := renderDefaultUser(role.DefaultUserTemplate, req)
+		if err != nil {
+			return logical.ErrorResponse(fmt.Sprintf("failed to render the default_user_template of role %q: %v", roleName, err)), nil
+		}
+		if rendered != "" {
+			if err := validatePOSIXUsername(rendered); err != nil {
+				return logical.ErrorResponse(fmt.Sprintf("default_user_template of role %q rendered an invalid username: %v", roleName, err)), nil
+			}
+			username = rendered
+		}
+	}
 	if username == "" {
 		if role.DefaultUser == "" {
 			return logical.ErrorResponse("No default username registered. Use 'username' option"), nil
@@ -80,21 +186,25 @@ func (b *backend) pathCredsCreateWrite(
 		// is the default username in the role. If neither is true, then
 		// that username is not allowed to generate a credential.
 		if err != nil && username != role.DefaultUser {
-			return logical.ErrorResponse("Username is not present is allowed users list"), nil
+			return logical.ErrorResponse(fmt.Sprintf("Username %q is neither the default_user nor present in the allowed_users list of role %q", username, roleName)), nil
 		}
 	} else if username != role.DefaultUser {
-		return logical.ErrorResponse("Username has to be either in allowed users list or has to be a default username"), nil
+		return logical.ErrorResponse(fmt.Sprintf("Username %q is not the default_user of role %q and the role has no allowed_users list", username, roleName)), nil
 	}
 
-	// Validate the IP address
-	ipAddr := net.ParseIP(ipRaw)
-	if ipAddr == nil {
-		return logical.ErrorResponse(fmt.Sprintf("Invalid IP %q", ipRaw)), nil
+	// The username of dynamic keys ends up in the authorized_keys path and
+	// the install script run on the target, so it has to be a plain account
+	// name, whatever allowed_users permits
+	if role.KeyType == KeyTypeDynamic {
+		if err := validatePOSIXUsername(username); err != nil {
+			return logical.ErrorResponse(fmt.Sprintf("Invalid username for role %q: %v", roleName, err)), nil
+		}
+		path := expandAuthorizedKeysPath(role.AuthorizedKeysPath, username)
+		if err := validateExpandedAuthorizedKeysPath(path); err != nil {
+			return logical.ErrorResponse(fmt.Sprintf("Invalid authorized_keys path %q for username %q: %v", path, username, err)), nil
+		}
 	}
 
-	// Check if the IP belongs to the registered list of CIDR blocks under the role
-	ip := ipAddr.String()
-
 	zeroAddressEntry, err := b.getZeroAddressRoles(req.Storage)
 	if err != nil {
 		return nil, fmt.Errorf("error retrieving zero-address roles: %v", err)
@@ -104,19 +214,156 @@ func (b *backend) pathCredsCreateWrite(
 		zeroAddressRoles = zeroAddressEntry.Roles
 	}
 
-	err = validateIP(ip, roleName, role.CIDRList, role.ExcludeCIDRList, zeroAddressRoles)
+	var ips []string
+	if hostname != "" {
+		// Resolve the hostname and use the first address it resolves to.
+		// Every address must be allowed by the role.
+		ip, err := resolveHostname(hostname, roleName, role, zeroAddressRoles)
+		if err != nil {
+			return logical.ErrorResponse(err.Error()), nil
+		}
+		ips = []string{ip}
+	} else {
+		// Every IP is validated before anything is installed
+		for _, ipRaw := range strutil.RemoveDuplicates(strings.Split(ipRaw, ","), false) {
+			// Validate the IP address
+			ip, ok := canonicalIP(ipRaw)
+			if !ok {
+				return logical.ErrorResponse(fmt.Sprintf("Invalid IP %q", ipRaw)), nil
+			}
+
+			// Check if the IP belongs to the registered list of CIDR blocks under the role
+
+			err = validateIP(ip, roleName, role.CIDRList, role.ExcludeCIDRList, zeroAddressRoles)
+			if err != nil {
+				return logical.ErrorResponse(fmt.Sprintf("Error validating IP: %v", err)), nil
+			}
+			if !strutil.StrListContains(ips, ip) {
+				ips = append(ips, ip)
+			}
+		}
+		if len(ips) == 0 {
+			return logical.ErrorResponse("Missing ip or hostname"), nil
+		}
+	}
+	if len(ips) > 1 && role.KeyType != KeyTypeDynamic {
+		return logical.ErrorResponse("Multiple IPs are only supported for dynamic roles"), nil
+	}
+	if len(ips) > maxHostsPerRequest {
+		return logical.ErrorResponse(fmt.Sprintf("At most %d IPs can be specified", maxHostsPerRequest)), nil
+	}
+	ip := ips[0]
+
+	// Hosts of a role can listen on different ports. The port is only
+	// overridden within the bounds set by the role.
+	port := role.Port
+	if portRaw, ok := d.GetOk("port"); ok {
+		port = portRaw.(int)
+		if port < minPort || port > maxPort {
+			return logical.ErrorResponse(fmt.Sprintf("port must be between %d and %d", minPort, maxPort)), nil
+		}
+		allowed, err := role.portAllowed(port)
+		if err != nil {
+			return nil, fmt.Errorf("error parsing allowed_ports of role %q: %v", roleName, err)
+		}
+		if !allowed {
+			return logical.ErrorResponse(fmt.Sprintf("Port %d is not allowed by role %q; allowed ports: %s", port, roleName, role.allowedPortsList())), nil
+		}
+	}
+
+	// Roles can shorten the lease of the credentials below the mount values.
+	ttl, maxTTL, err := parseRoleTTLs(role.TTL, role.MaxTTL)
 	if err != nil {
-		return logical.ErrorResponse(fmt.Sprintf("Error validating IP: %v", err)), nil
+		return nil, err
+	}
+	if ttl == 0 && maxTTL > 0 {
+		ttl = b.System().DefaultLeaseTTL()
+	}
+	// Clients can ask for a shorter lease, for instance to copy a single
+	// file, or a longer one within the limits of the role and the mount.
+	if ttlRaw, ok := d.GetOk("ttl"); ok {
+		ttl = time.Duration(ttlRaw.(int)) * time.Second
+		if ttl <= 0 {
+			return logical.ErrorResponse("ttl must be positive"), nil
+		}
+	}
+	if maxTTL > 0 && ttl > maxTTL {
+		ttl = maxTTL
+	}
+
+	// The lease the credentials are granted, which is returned to the client
+	// since it can be shorter than requested.
+	leaseTTL := ttl
+	if leaseTTL == 0 {
+		leaseTTL = b.System().DefaultLeaseTTL()
+	}
+	if maxSystemTTL := b.System().MaxLeaseTTL(); leaseTTL > maxSystemTTL {
+		leaseTTL = maxSystemTTL
+	}
+
+	for _, field := range []string{"private_key_format", "key_passphrase", "generate_passphrase", "all_or_nothing"} {
+		if _, ok := d.GetOk(field); ok && role.KeyType != KeyTypeDynamic {
+			return logical.ErrorResponse(fmt.Sprintf("%s is only applicable for dynamic roles", field)), nil
+		}
 	}
 
+	dryRunResponse := func() *logical.Response {
+		resp := &logical.Response{
+			Data: map[string]interface{}{
+				"dry_run":  true,
+				"key_type": role.KeyType,
+				"username": username,
+				"ip":       ip,
+				"port":     port,
+				"ttl":      int64(leaseTTL.Seconds()),
+			},
+		}
+		if len(ips) > 1 {
+			resp.Data["ips"] = ips
+		}
+		if hostname != "" {
+			resp.Data["hostname"] = hostname
+		}
+		return resp
+	}
+
+	// The times returned to the client are those of the lease actually
+	// granted, so that the client does not depend on its own clock.
+	issueTime := time.Now().UTC()
+
 	var result *logical.Response
 	if role.KeyType == KeyTypeOTP {
-		// Generate an OTP
-		otp, err := b.GenerateOTPCredential(req, &sshOTP{
-			Username: username,
-			IP:       ip,
-			RoleName: roleName,
-		})
+		// OTPs bound to the client can only be issued if the address of
+		// the client is known.
+		var clientIP string
+		if role.BindToClientIP {
+			var ok bool
+			if req.Connection != nil {
+				clientIP, ok = canonicalIP(req.Connection.RemoteAddr)
+			}
+			if !ok {
+				return logical.ErrorResponse(fmt.Sprintf("Role %q binds OTPs to the client address, which is not known for this request", roleName)), nil
+			}
+		}
+		if dryRun {
+			return dryRunResponse(), nil
+		}
+
+		// Generate an OTP. The lease duration is recorded so that entries
+		// of OTPs which were neither used nor revoked can be tidied up.
+		otpEntry := &sshOTP{
+			Username:             username,
+			IP:                   ip,
+			RoleName:             roleName,
+			CreatedAt:            issueTime,
+			TTL:                  leaseTTL,
+			DisplayName:          req.DisplayName,
+			ClientIP:             clientIP,
+			AllowedRedeemerCIDRs: role.AllowedRedeemerCIDRs.list(),
+			MaxUses:              role.otpMaxUses(),
+			RemainingUses:        role.otpMaxUses(),
+		}
+		otp, err := b.GenerateOTPCredential(req, role, otpEntry)
 		if err != nil {
 			return nil, err
 		}
@@ -126,84 +373,382 @@ func (b *backend) pathCredsCreateWrite(
 		// In this case, saving just the OTP is sufficient since there is
 		// no need to establish connection with the remote host.
 		result = b.Secret(SecretOTPType).Response(map[string]interface{}{
-			"key_type": role.KeyType,
-			"key":      otp,
-			"username": username,
-			"ip":       ip,
-			"port":     role.Port,
+			"key_type":          role.KeyType,
+			"key":               otp,
+			"username":          username,
+			"ip":                ip,
+			"port":              port,
+			"connection_string": sshCommand("", username, ip, hostname, port),
 		}, map[string]interface{}{
-			"otp": otp,
+			"otp":       otp,
+			"role_name": roleName,
 		})
+		if !otpEntry.ExpiresAt.IsZero() {
+			result.Data["otp_expiration_time"] = otpEntry.ExpiresAt.Format(time.RFC3339)
+		}
 	} else if role.KeyType == KeyTypeDynamic {
-		// Generate an RSA key pair. This also installs the newly generated
-		// public key in the remote host.
-		dynamicPublicKey, dynamicPrivateKey, err := b.GenerateDynamicCredential(req, role, username, ip)
+		privateKeyFormat := strings.ToLower(d.Get("private_key_format").(string))
+		if privateKeyFormat == "" {
+			privateKeyFormat = role.privateKeyFormat()
+		}
+		if errResp := validatePrivateKeyFormat(role.keyAlgorithm(), privateKeyFormat); errResp != nil {
+			return errResp, nil
+		}
+
+		// The passphrase is only returned to the client. It must not be
+		// stored in the internal data of the secret.
+		passphrase := d.Get("key_passphrase").(string)
+		generatePassphrase := d.Get("generate_passphrase").(bool)
+		if passphrase != "" || generatePassphrase {
+			if passphrase != "" && generatePassphrase {
+				return logical.ErrorResponse("only one of key_passphrase or generate_passphrase can be specified"), nil
+			}
+			if privateKeyFormat != PrivateKeyFormatPEM {
+				return logical.ErrorResponse(fmt.Sprintf("encrypted private keys are only supported for the %q private_key_format", PrivateKeyFormatPEM)), nil
+			}
+			if generatePassphrase {
+				passphrase, err = randomString(passphraseAlphabet, generatedPassphraseLength)
+				if err != nil {
+					return nil, fmt.Errorf("error generating passphrase: %v", err)
+				}
+			} else if len(passphrase) < minPassphraseLength {
+				return logical.ErrorResponse(fmt.Sprintf("key_passphrase must be at least %d characters long", minPassphraseLength)), nil
+			}
+		}
+
+		// Generate a key pair. This also installs the newly generated
+		// public key in the remote hosts.
+		// Fetch the host keys to be used for dynamic key installation. The
+		// key and version each target accepted are recorded so that the key
+		// is uninstalled with the same ones even if the host key is rotated
+		// in the meantime.
+		var hostKeys []*namedHostKey
+		for _, keyName := range role.hostKeyNames() {
+			hostKey, err := b.getKey(req.Storage, keyName)
+			if err != nil {
+				return nil, fmt.Errorf("key %q not found. err: %v", keyName, err)
+			}
+			if hostKey == nil {
+				return nil, fmt.Errorf("key %q not found", keyName)
+			}
+			hostKeys = append(hostKeys, &namedHostKey{name: keyName, sshHostKey: hostKey})
+		}
+		if dryRun {
+			return dryRunResponse(), nil
+		}
+
+		dynamicPublicKey, dynamicPrivateKey, walID, installedWith, failures, err := b.GenerateDynamicCredential(req, roleName, role, hostKeys, username, ips, port, privateKeyFormat, passphrase)
+		if err != nil {
+			return nil, err
+		}
+
+		// From here on the key is either recorded or removed, so the WAL
+		// entry is no longer needed
+		defer func() {
+			if err := framework.DeleteWAL(req.Storage, walID); err != nil {
+				b.Logger().Error("ssh: failed to delete WAL entry of dynamic key", "id", walID, "error", err)
+			}
+		}()
+
+		var installedIPs []string
+		failedIPs := map[string]interface{}{}
+		for _, ip := range ips {
+			if failures[ip] == nil {
+				installedIPs = append(installedIPs, ip)
+			} else {
+				failedIPs[ip] = failures[ip].Error()
+			}
+		}
+
+		var firstHostKey hostKeyRef
+		if len(installedIPs) != 0 {
+			firstHostKey = installedWith[installedIPs[0]]
+		}
+		installation := &dynamicKeyInstallation{
+			AdminUser:          role.AdminUser,
+			Username:           username,
+			IP:                 ip,
+			HostKeyName:        firstHostKey.Name,
+			HostKeyVersion:     firstHostKey.Version,
+			HostKeyNames:       role.hostKeyNames(),
+			HostKeys:           installedWith,
+			DynamicPublicKey:   dynamicPublicKey,
+			InstallScript:      role.customInstallScript(),
+			Port:               port,
+			RoleName:           roleName,
+			BastionHost:        role.BastionHost,
+			BastionPort:        role.BastionPort,
+			BastionKeyName:     role.BastionKeyName,
+			SudoCommand:        role.sudoCommand(),
+			IPs:                installedIPs,
+			AuthorizedKeysLine: strings.TrimSuffix(dynamicPublicKey, "\n"),
+			AuthorizedKeysPath: expandAuthorizedKeysPath(role.AuthorizedKeysPath, username),
+		}
+
+		switch {
+		case len(installedIPs) == 0 && len(ips) == 1:
+			// Unreachable targets are reported as such, so that clients
+			// can retry
+			err := failures[ip]
+			if isUnreachableError(err) {
+				return nil, logical.CodedError(http.StatusServiceUnavailable, err.Error())
+			}
+			return nil, err
+		case len(installedIPs) == 0:
+			msg := fmt.Sprintf("Failed to install the key on any of the IPs: %s", formatHostErrors(failures))
+			for _, err := range failures {
+				if !isUnreachableError(err) {
+					return logical.ErrorResponse(msg), nil
+				}
+			}
+			return nil, logical.CodedError(http.StatusServiceUnavailable, msg)
+		case len(failures) > 0 && d.Get("all_or_nothing").(bool):
+			// The key is not handed out, so it must not stay on the
+			// hosts it was installed on.
+			if err := b.removeDynamicKey(req.Storage, installation); err != nil {
+				b.Logger().Error("ssh: failed to remove partially installed dynamic key", "error", err)
+			}
+			return logical.ErrorResponse(fmt.Sprintf("Failed to install the key on some of the IPs, removed it from the others: %s", formatHostErrors(failures))), nil
+		}
+		ip = installedIPs[0]
+		installation.IP = ip
+
+		// The installation is recorded independently of the lease, so that
+		// the key can be found and removed even if the lease is lost.
+		installationID, err := uuid.GenerateUUID()
 		if err != nil {
 			return nil, err
 		}
+		installation.InstallationID = installationID
+
+		// The fingerprints let the key in the authorized_keys file and the
+		// logs of the target be correlated with the lease.
+		publicKey, _, _, _, err := ssh.ParseAuthorizedKey([]byte(dynamicPublicKey))
+		if err != nil {
+			return nil, fmt.Errorf("error parsing dynamic public key: %v", err)
+		}
+		fingerprintSHA256 := ssh.FingerprintSHA256(publicKey)
+		installation.FingerprintSHA256 = fingerprintSHA256
+		if err := b.putInstalledKey(req.Storage, installation); err != nil {
+			if rErr := b.removeDynamicKey(req.Storage, installation); rErr != nil {
+				b.Logger().Error("ssh: failed to remove dynamic key which could not be recorded", "error", rErr)
+			}
+			return nil, fmt.Errorf("error recording installed key: %v", err)
+		}
 
 		// Return the information relevant to user of dynamic type and save
 		// information required for later use in internal section of secret.
 		result = b.Secret(SecretDynamicKeyType).Response(map[string]interface{}{
-			"key":      dynamicPrivateKey,
-			"key_type": role.KeyType,
-			"username": username,
-			"ip":       ip,
-			"port":     role.Port,
-		}, map[string]interface{}{
-			"admin_user":         role.AdminUser,
+			"key":                dynamicPrivateKey,
+			"key_type":           role.KeyType,
+			"algorithm":          role.keyAlgorithm(),
+			"private_key_type":   privateKeyFormat,
 			"username":           username,
 			"ip":                 ip,
-			"host_key_name":      role.KeyName,
-			"dynamic_public_key": dynamicPublicKey,
-			"port":               role.Port,
-			"install_script":     role.InstallScript,
+			"port":               port,
+			"fingerprint_md5":    ssh.FingerprintLegacyMD5(publicKey),
+			"fingerprint_sha256": fingerprintSHA256,
+			"connection_string":  sshCommand(privateKeyFilePlaceholder, username, ip, hostname, port),
+		}, map[string]interface{}{
+			"admin_user":           role.AdminUser,
+			"username":             username,
+			"ip":                   ip,
+			"ips":                  installedIPs,
+			"host_key_name":        installation.HostKeyName,
+			"host_key_version":     installation.HostKeyVersion,
+			"host_key_names":       installation.HostKeyNames,
+			"host_keys":            installation.HostKeys,
+			"dynamic_public_key":   dynamicPublicKey,
+			"port":                 port,
+			"install_script":       role.customInstallScript(),
+			"role_name":            roleName,
+			"bastion_host":         role.BastionHost,
+			"bastion_port":         role.BastionPort,
+			"bastion_key_name":     role.BastionKeyName,
+			"sudo_command":         role.sudoCommand(),
+			"fingerprint_sha256":   fingerprintSHA256,
+			"installation_id":      installationID,
+			"authorized_keys_line": installation.AuthorizedKeysLine,
+			"authorized_keys_path": installation.AuthorizedKeysPath,
 		})
+		// The private key must not reach the audit logs, not even hashed
+		result.AuditRedact = []string{"key"}
+		if generatePassphrase {
+			result.Data["key_passphrase"] = passphrase
+			result.AuditRedact = append(result.AuditRedact, "key_passphrase")
+		}
+		if len(ips) > 1 {
+			result.Data["ips"] = installedIPs
+			result.Data["failed_ips"] = failedIPs
+		}
 	} else {
 		return nil, fmt.Errorf("key type unknown")
 	}
 
+	if hostname != "" {
+		result.Data["hostname"] = hostname
+	}
+	if ttl > 0 {
+		result.Secret.TTL = leaseTTL
+	}
+	result.Data["ttl"] = int64(leaseTTL.Seconds())
+	result.Data["issue_time"] = issueTime.Format(time.RFC3339)
+	result.Data["expiration_time"] = issueTime.Add(leaseTTL).Format(time.RFC3339)
+	result.Secret.InternalData["issue_time"] = issueTime.Format(time.RFC3339)
+
 	return result, nil
 }
 
-// Generates a RSA key pair and installs it in the remote target
-func (b *backend) GenerateDynamicCredential(req *logical.Request, role *sshRole, username, ip string) (string, string, error) {
-	// Fetch the host key to be used for dynamic key installation
-	keyEntry, err := req.Storage.Get(fmt.Sprintf("keys/%s", role.KeyName))
-	if err != nil {
-		return "", "", fmt.Errorf("key %q not found. err: %v", role.KeyName, err)
+// Stands for the file the client saves the private key of a dynamic key in
+const privateKeyFilePlaceholder = "<private_key_file>"
+
+// sshCommand returns the ssh invocation connecting to the host with the
+// issued credentials. The hostname is used if the client gave one, since
+// that is what the client resolved and its host key is known under. The port
+// is only included if it is not the default one.
+func sshCommand(identityFile, username, ip, hostname string, port int) string {
+	host := ip
+	if hostname != "" {
+		host = hostname
 	}
 
-	if keyEntry == nil {
-		return "", "", fmt.Errorf("key %q not found", role.KeyName)
+	args := []string{"ssh"}
+	if identityFile != "" {
+		args = append(args, "-i", identityFile)
 	}
+	if port != 0 && port != 22 {
+		args = append(args, "-p", strconv.Itoa(port))
+	}
+	return strings.Join(append(args, username+"@"+host), " ")
+}
 
-	var hostKey sshHostKey
-	if err := keyEntry.DecodeJSON(&hostKey); err != nil {
-		return "", "", fmt.Errorf("error reading the host key: %v", err)
+// Generates a key pair of the role's algorithm, with the private key encoded in
+// the given format and encrypted with the passphrase if one is given, and
+// installs it in the remote targets listening on the given port using the first
+// of the host keys each target accepts. The targets are installed concurrently;
+// the host keys they were installed with and the errors of the targets the key
+// could not be installed on are returned by IP.
+//
+// Before the targets are touched, a WAL entry is written so that the key is
+// removed from them if Vault stops before the installation is recorded. Its
+// ID is returned, and the caller must delete it once the installation is
+// recorded or removed.
+func (b *backend) GenerateDynamicCredential(req *logical.Request, roleName string, role *sshRole, hostKeys []*namedHostKey, username string, ips []string, port int, privateKeyFormat, passphrase string) (string, string, string, map[string]hostKeyRef, map[string]error, error) {
+	var err error
+	var dynamicPublicKey, dynamicPrivateKey string
+	switch role.keyAlgorithm() {
+	case KeyAlgorithmECDSA:
+		dynamicPublicKey, dynamicPrivateKey, err = generateECDSAKeys(role.Curve)
+	case KeyAlgorithmED25519:
+		dynamicPublicKey, dynamicPrivateKey, err = generateED25519Keys()
+	default:
+		// Generate a new RSA key pair with the given key length.
+		dynamicPublicKey, dynamicPrivateKey, err = generateRSAKeys(role.KeyBits)
+	}
+	if err != nil {
+		return "", "", "", nil, nil, fmt.Errorf("error generating key: %v", err)
 	}
 
-	// Generate a new RSA key pair with the given key length.
-	dynamicPublicKey, dynamicPrivateKey, err := generateRSAKeys(role.KeyBits)
+	dynamicPrivateKey, err = formatPrivateKey(dynamicPrivateKey, privateKeyFormat)
 	if err != nil {
-		return "", "", fmt.Errorf("error generating key: %v", err)
+		return "", "", "", nil, nil, fmt.Errorf("error encoding private key: %v", err)
+	}
+
+	if passphrase != "" {
+		dynamicPrivateKey, err = encryptPrivateKey(dynamicPrivateKey, passphrase)
+		if err != nil {
+			return "", "", "", nil, nil, fmt.Errorf("error encrypting private key: %v", err)
+		}
 	}
 
 	if len(role.KeyOptionSpecs) != 0 {
 		dynamicPublicKey = fmt.Sprintf("%s %s", role.KeyOptionSpecs, dynamicPublicKey)
 	}
 
-	// Add the public key to authorized_keys file in target machine
-	err = b.installPublicKeyInTarget(role.AdminUser, username, ip, role.Port, hostKey.Key, dynamicPublicKey, role.InstallScript, true)
+	installScript, err := b.installScript(req.Storage, role)
+	if err != nil {
+		return "", "", "", nil, nil, err
+	}
+	authorizedKeysPath := expandAuthorizedKeysPath(role.AuthorizedKeysPath, username)
+
+	connConfig, err := b.roleConnectionConfig(req.Storage, role)
 	if err != nil {
-		return "", "", fmt.Errorf("failed to add public key to authorized_keys file in target: %v", err)
+		return "", "", "", nil, nil, err
 	}
-	return dynamicPublicKey, dynamicPrivateKey, nil
+
+	bastion, err := b.getBastion(req.Storage, role.BastionHost, role.BastionPort, role.BastionKeyName, role.AdminUser)
+	if err != nil {
+		return "", "", "", nil, nil, err
+	}
+
+	walID, err := framework.PutWAL(req.Storage, dynamicKeyWALKind, &dynamicKeyInstallation{
+		AdminUser:          role.AdminUser,
+		Username:           username,
+		IP:                 ips[0],
+		IPs:                ips,
+		HostKeyName:        hostKeys[0].name,
+		HostKeyNames:       role.hostKeyNames(),
+		DynamicPublicKey:   dynamicPublicKey,
+		InstallScript:      role.customInstallScript(),
+		Port:               port,
+		RoleName:           roleName,
+		BastionHost:        role.BastionHost,
+		BastionPort:        role.BastionPort,
+		BastionKeyName:     role.BastionKeyName,
+		SudoCommand:        role.sudoCommand(),
+		AuthorizedKeysLine: strings.TrimSuffix(dynamicPublicKey, "\n"),
+		AuthorizedKeysPath: authorizedKeysPath,
+	})
+	if err != nil {
+		return "", "", "", nil, nil, fmt.Errorf("error writing WAL entry: %v", err)
+	}
+
+	// Add the public key to authorized_keys file in target machines
+	installStart := time.Now()
+	defer b.measureSince(installStart, "dynamic", "install")
+	var lock sync.Mutex
+	installedWith := map[string]hostKeyRef{}
+	failures := forEachHost(ips, func(ip string) error {
+		// Targets rejecting a host key, for instance because they predate
+		// its rollout, are tried with the next one. Other errors are not,
+		// so that outages are not masked.
+		var err error
+		for _, hostKey := range hostKeys {
+			err = b.installPublicKeyFunc(role.AdminUser, username, ip, port, hostKey.sshHostKey, connConfig, bastion, dynamicPublicKey, installScript, role.sudoCommand(), authorizedKeysPath, true)
+			if err == nil {
+				lock.Lock()
+				installedWith[ip] = hostKeyRef{Name: hostKey.name, Version: hostKey.version()}
+				lock.Unlock()
+				return nil
+			}
+			if !isAuthenticationError(err) {
+				break
+			}
+		}
+
+		// Unreachable targets are returned as is so that they can be
+		// reported to the client.
+		if isUnreachableError(err) {
+			return err
+		}
+		return fmt.Errorf("failed to add public key to authorized_keys file in target: %v", err)
+	})
+	return dynamicPublicKey, dynamicPrivateKey, walID, installedWith, failures, nil
 }
 
-// Generates a UUID OTP and its salted value based on the salt of the backend.
-func (b *backend) GenerateSaltedOTP() (string, string, error) {
-	str, err := uuid.GenerateUUID()
+// Generates an OTP of the given format and length and its salted value based
+// on the salt of the backend. The length is ignored for the UUID format.
+func (b *backend) GenerateSaltedOTP(format string, length int) (string, string, error) {
+	var str string
+	var err error
+	switch format {
+	case OTPFormatBase32:
+		str, err = randomString(otpBase32Alphabet, length)
+	case OTPFormatDigits:
+		str, err = randomString(otpDigitsAlphabet, length)
+	default:
+		str, err = uuid.GenerateUUID()
+	}
 	if err != nil {
 		return "", "", err
 	}
@@ -215,9 +760,9 @@ func (b *backend) GenerateSaltedOTP() (string, string, error) {
 	return str, salt.SaltID(str), nil
 }
 
-// Generates an UUID OTP and creates an entry for the same in storage backend with its salted string.
-func (b *backend) GenerateOTPCredential(req *logical.Request, sshOTPEntry *sshOTP) (string, error) {
-	otp, otpSalted, err := b.GenerateSaltedOTP()
+// Generates an OTP in the format of the role and creates an entry for the same in storage backend with its salted string.
+func (b *backend) GenerateOTPCredential(req *logical.Request, role *sshRole, sshOTPEntry *sshOTP) (string, error) {
+	otp, otpSalted, err := b.GenerateSaltedOTP(role.otpFormat(), role.OTPLength)
 	if err != nil {
 		return "", err
 	}
@@ -230,7 +775,7 @@ func (b *backend) GenerateOTPCredential(req *logical.Request, sshOTPEntry *sshOT
 	// OTP is generated. It is very unlikely that this is the case and this
 	// code is just for safety.
 	for err == nil && entry != nil {
-		otp, otpSalted, err = b.GenerateSaltedOTP()
+		otp, otpSalted, err = b.GenerateSaltedOTP(role.otpFormat(), role.OTPLength)
 		if err != nil {
 			return "", err
 		}
@@ -240,6 +785,16 @@ func (b *backend) GenerateOTPCredential(req *logical.Request, sshOTPEntry *sshOT
 		}
 	}
 
+	// Roles can require OTPs to be used sooner than their lease expires.
+	// The entry is still only deleted once it is used up or revoked.
+	if role.OTPTTL > 0 {
+		createdAt := sshOTPEntry.CreatedAt
+		if createdAt.IsZero() {
+			createdAt = time.Now().UTC()
+		}
+		sshOTPEntry.ExpiresAt = createdAt.Add(role.OTPTTL)
+	}
+
 	// Store an entry for the salt of OTP.
 	newEntry, err := logical.StorageEntryJSON("otp/"+otpSalted, sshOTPEntry)
 	if err != nil {
@@ -258,7 +813,7 @@ func (b *backend) GenerateOTPCredential(req *logical.Request, sshOTPEntry *sshOT
 // excluded CIDR blocks and if IP is found there as well, an error is returned.
 // IP is valid only if it is encompassed by allowed CIDR blocks and not by
 // excluded CIDR blocks.
-func validateIP(ip, roleName, cidrList, excludeCidrList string, zeroAddressRoles []string) error {
+func validateIP(ip, roleName string, cidrList, excludeCidrList, zeroAddressRoles []string) error {
 	// Search IP in the zero-address list
 	for _, role := range zeroAddressRoles {
 		if roleName == role {
@@ -266,13 +821,23 @@ func validateIP(ip, roleName, cidrList, excludeCidrList string, zeroAddressRoles
 		}
 	}
 
+	// Zero-address blocks only take effect for roles registered above, so
+	// that removing a role from config/zeroaddress enforces its CIDR list
+	// again.
+	var cidrs []string
+	for _, item := range cidrList {
+		if item != "" && !isZeroAddressCIDR(item) {
+			cidrs = append(cidrs, item)
+		}
+	}
+
 	// Search IP in allowed CIDR blocks
-	ipMatched, err := cidrListContainsIP(ip, cidrList)
+	ipMatched, err := cidrListContainsIP(ip, cidrs)
 	if err != nil {
 		return err
 	}
 	if !ipMatched {
-		return fmt.Errorf("IP does not belong to role")
+		return fmt.Errorf("IP %s does not belong to role", ip)
 	}
 
 	if len(excludeCidrList) == 0 {
@@ -280,17 +845,69 @@ func validateIP(ip, roleName, cidrList, excludeCidrList string, zeroAddressRoles
 	}
 
 	// Search IP in exclude list
-	ipMatched, err = cidrListContainsIP(ip, excludeCidrList)
+	excludedBy, err := cidrListMatch(ip, excludeCidrList)
 	if err != nil {
 		return err
 	}
-	if ipMatched {
-		return fmt.Errorf("IP does not belong to role")
+	if excludedBy != "" {
+		return fmt.Errorf("IP %s is excluded by %s", ip, excludedBy)
 	}
 
 	return nil
 }
 
+const (
+	// Passphrases for encrypting private keys, both given and generated
+	minPassphraseLength       = 12
+	generatedPassphraseLength = 32
+	passphraseAlphabet        = "ABCDEFGHIJKLMNOPQRSTUVWXYZabcdefghijklmnopqrstuvwxyz0123456789"
+)
+
+// lookupIP resolves hostnames for the creds endpoint. It is a variable so
+// that tests can substitute a fixed resolver.
+var lookupIP = net.LookupIP
+
+// resolveHostname checks the hostname against the allowed domains of the
+// role, resolves it and validates every address it resolves to against the
+// CIDR blocks of the role. The first address is returned for use when
+// connecting to the host. The returned errors are meant for the client.
+func resolveHostname(hostname, roleName string, role *sshRole, zeroAddressRoles []string) (string, error) {
+	if !role.ResolveHostnames {
+		return "", fmt.Errorf("Role %q does not allow hostnames; use 'ip' or set resolve_hostnames on the role", roleName)
+	}
+
+	if role.AllowedDomains != "" {
+		name := strings.ToLower(strings.TrimSuffix(hostname, "."))
+		allowed := false
+		for _, domain := range strings.Split(role.AllowedDomains, ",") {
+			if strutil.GlobbedStringsMatch(strings.ToLower(strings.TrimSpace(domain)), name) {
+				allowed = true
+				break
+			}
+		}
+		if !allowed {
+			return "", fmt.Errorf("Hostname %q is not permitted by the allowed_domains of role %q", hostname, roleName)
+		}
+	}
+
+	ips, err := lookupIP(hostname)
+	if err != nil {
+		return "", fmt.Errorf("Failed to resolve hostname %q: %v", hostname, err)
+	}
+	if len(ips) == 0 {
+		return "", fmt.Errorf("Hostname %q did not resolve to any IP address", hostname)
+	}
+
+	for _, ipAddr := range ips {
+		err := validateIP(ipAddr.String(), roleName, role.CIDRList, role.ExcludeCIDRList, zeroAddressRoles)
+		if err != nil {
+			return "", fmt.Errorf("Hostname %q resolves to %d address(es) and %s is not allowed by role %q: %v", hostname, len(ips), ipAddr.String(), roleName, err)
+		}
+	}
+
+	return ips[0].String(), nil
+}
+
 // Checks if the username supplied by the user is present in the list of
 // allowed users registered which creation of role.
 func validateUsername(username, allowedUsers string) error {
@@ -313,6 +930,87 @@ func validateUsername(username, allowedUsers string) error {
 	return fmt.Errorf("username not in allowed users list")
 }
 
+// credsResponse is the schema of the fields of issued credentials, which
+// depend on the key type of the role.
+var credsResponse = map[string]*framework.FieldSchema{
+	"key_type": &framework.FieldSchema{
+		Type:        framework.TypeString,
+		Description: `Type of the credentials: otp or dynamic.`,
+	},
+	"key": &framework.FieldSchema{
+		Type:        framework.TypeString,
+		Description: `The OTP, or the dynamic private key.`,
+	},
+	"username": &framework.FieldSchema{
+		Type:        framework.TypeString,
+		Description: `User the credentials are for.`,
+	},
+	"ip": &framework.FieldSchema{
+		Type:        framework.TypeString,
+		Description: `IP address of the host.`,
+	},
+	"ips": &framework.FieldSchema{
+		Type:        framework.TypeStringSlice,
+		Description: `IP addresses of the host when credentials are issued for all of them.`,
+	},
+	"failed_ips": &framework.FieldSchema{
+		Type:        framework.TypeMap,
+		Description: `IP addresses the dynamic key could not be installed on, with the reason.`,
+	},
+	"hostname": &framework.FieldSchema{
+		Type:        framework.TypeString,
+		Description: `Hostname of the host, if one was requested.`,
+	},
+	"port": &framework.FieldSchema{
+		Type:        framework.TypeInt,
+		Description: `SSH port of the host.`,
+	},
+	"connection_string": &framework.FieldSchema{
+		Type:        framework.TypeString,
+		Description: `Command connecting to the host with the credentials.`,
+	},
+	"otp_expiration_time": &framework.FieldSchema{
+		Type:        framework.TypeString,
+		Description: `Time the OTP expires at, in RFC 3339 format.`,
+	},
+	"algorithm": &framework.FieldSchema{
+		Type:        framework.TypeString,
+		Description: `Algorithm of the dynamic key.`,
+	},
+	"private_key_type": &framework.FieldSchema{
+		Type:        framework.TypeString,
+		Description: `Format of the dynamic private key.`,
+	},
+	"key_passphrase": &framework.FieldSchema{
+		Type:        framework.TypeString,
+		Description: `Generated passphrase encrypting the dynamic private key.`,
+	},
+	"fingerprint_md5": &framework.FieldSchema{
+		Type:        framework.TypeString,
+		Description: `MD5 fingerprint of the dynamic public key.`,
+	},
+	"fingerprint_sha256": &framework.FieldSchema{
+		Type:        framework.TypeString,
+		Description: `SHA256 fingerprint of the dynamic public key.`,
+	},
+	"issue_time": &framework.FieldSchema{
+		Type:        framework.TypeString,
+		Description: `Time the credentials were issued at, in RFC 3339 format.`,
+	},
+	"expiration_time": &framework.FieldSchema{
+		Type:        framework.TypeString,
+		Description: `Time the lease of the credentials expires at, in RFC 3339 format.`,
+	},
+	"dry_run": &framework.FieldSchema{
+		Type:        framework.TypeBool,
+		Description: `Set for dry runs, which issue no credentials.`,
+	},
+	"ttl": &framework.FieldSchema{
+		Type:        framework.TypeDurationSecond,
+		Description: `TTL the credentials would have, for dry runs.`,
+	},
+}
+
 const pathCredsCreateHelpSyn = `
 Creates a credential for establishing SSH connection with the remote host.
 `
@@ -328,4 +1026,9 @@ then "ssh/creds/web" would generate a key for 'web' role.
 
 Keys will have a lease associated with them. The access keys can be
 revoked by using the lease ID.
+
+With 'dry_run' set, the request is checked as if credentials were issued and
+the username, IP, port, key type and lease duration they would have are
+returned. No key is generated or installed, no OTP is stored and no lease is
+created. Invalid requests fail with the same errors as without 'dry_run'.
 `
@@ -9,8 +9,11 @@ func pathFetchPublicKey(b *backend) *framework.Path {
 	return &framework.Path{
 		Pattern: `public_key`,
 
-		Callbacks: map[logical.Operation]framework.OperationFunc{
-			logical.ReadOperation: b.pathFetchPublicKey,
+		Operations: map[logical.Operation]*framework.PathOperation{
+			logical.ReadOperation: &framework.PathOperation{
+				Callback: b.pathFetchPublicKey,
+				Summary:  "Read the public key of the CA in OpenSSH format.",
+			},
 		},
 
 		HelpSynopsis:    `Retrieve the public key.`,
@@ -19,6 +22,10 @@ func pathFetchPublicKey(b *backend) *framework.Path {
 }
 
 func (b *backend) pathFetchPublicKey(req *logical.Request, data *framework.FieldData) (*logical.Response, error) {
+	if err := checkFieldTypes(data); err != nil {
+		return nil, err
+	}
+
 	publicKeyEntry, err := caKey(req.Storage, caPublicKey)
 	if err != nil {
 		return nil, err
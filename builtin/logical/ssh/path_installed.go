@@ -0,0 +1,299 @@
+package ssh
+
+import (
+	"fmt"
+	"time"
+
+	"github.com/hashicorp/vault/helper/strutil"
+	"github.com/hashicorp/vault/logical"
+	"github.com/hashicorp/vault/logical/framework"
+)
+
+// Structure to hold a dynamic key which is installed on its targets. It is
+// written when the key is issued and deleted once the key has been removed
+// from all of them, so that keys left behind, for instance when a lease was
+// revoked without its internal data, can be found and removed.
+type installedKey struct {
+	dynamicKeyInstallation
+
+	CreatedAt time.Time `json:"created_at"`
+}
+
+func pathListInstalled(b *backend) *framework.Path {
+	return &framework.Path{
+		Pattern: "installed/?$",
+
+		Operations: map[logical.Operation]*framework.PathOperation{
+			logical.ListOperation: &framework.PathOperation{
+				Callback: b.pathInstalledList,
+				Summary:  "List the dynamic keys which are installed on their targets.",
+			},
+		},
+
+		HelpSynopsis:    pathInstalledHelpSyn,
+		HelpDescription: pathInstalledHelpDesc,
+	}
+}
+
+func pathInstalledCleanup(b *backend) *framework.Path {
+	return &framework.Path{
+		Pattern: "installed/cleanup$",
+		Fields: map[string]*framework.FieldSchema{
+			"ids": &framework.FieldSchema{
+				Type: framework.TypeCommaStringSlice,
+				Description: `Identifiers of the installed keys to remove. Defaults to
+				all of them.`,
+			},
+		},
+
+		Operations: map[logical.Operation]*framework.PathOperation{
+			logical.UpdateOperation: &framework.PathOperation{
+				Callback: b.pathInstalledCleanup,
+				Summary:  "Remove installed dynamic keys from their targets.",
+			},
+		},
+
+		HelpSynopsis:    pathInstalledCleanupHelpSyn,
+		HelpDescription: pathInstalledCleanupHelpDesc,
+	}
+}
+
+func pathInstalled(b *backend) *framework.Path {
+	return &framework.Path{
+		Pattern: "installed/" + framework.GenericNameRegex("id"),
+		Fields: map[string]*framework.FieldSchema{
+			"id": &framework.FieldSchema{
+				Type:        framework.TypeString,
+				Description: "[Required] Identifier of the installed key",
+			},
+		},
+
+		Operations: map[logical.Operation]*framework.PathOperation{
+			logical.ReadOperation: &framework.PathOperation{
+				Callback: b.pathInstalledRead,
+				Summary:  "Read where a dynamic key is installed.",
+			},
+		},
+
+		HelpSynopsis:    pathInstalledHelpSyn,
+		HelpDescription: pathInstalledHelpDesc,
+	}
+}
+
+func (b *backend) pathInstalledList(req *logical.Request, d *framework.FieldData) (*logical.Response, error) {
+	if err := checkFieldTypes(d); err != nil {
+		return nil, err
+	}
+
+	ids, err := req.Storage.List("installed/")
+	if err != nil {
+		return nil, err
+	}
+
+	keyInfo := map[string]interface{}{}
+	for _, id := range ids {
+		installed, err := b.getInstalledKey(req.Storage, id)
+		if err != nil {
+			return nil, err
+		}
+		if installed == nil {
+			continue
+		}
+		keyInfo[id] = map[string]interface{}{
+			"ips":                installed.IPs,
+			"username":           installed.Username,
+			"fingerprint_sha256": installed.FingerprintSHA256,
+		}
+	}
+
+	return logical.ListResponseWithInfo(ids, keyInfo), nil
+}
+
+func (b *backend) pathInstalledRead(req *logical.Request, d *framework.FieldData) (*logical.Response, error) {
+	if err := checkFieldTypes(d); err != nil {
+		return nil, err
+	}
+
+	installed, err := b.getInstalledKey(req.Storage, d.Get("id").(string))
+	if err != nil {
+		return nil, err
+	}
+	if installed == nil {
+		return nil, nil
+	}
+
+	return &logical.Response{
+		Data: map[string]interface{}{
+			"admin_user":         installed.AdminUser,
+			"username":           installed.Username,
+			"ips":                installed.IPs,
+			"port":               installed.Port,
+			"role_name":          installed.RoleName,
+			"host_key_name":      installed.HostKeyName,
+			"host_key_version":   installed.HostKeyVersion,
+			"bastion_host":       installed.BastionHost,
+			"fingerprint_sha256": installed.FingerprintSHA256,
+			"created_at":         installed.CreatedAt.Format(time.RFC3339),
+		},
+	}, nil
+}
+
+// pathInstalledCleanup attempts to remove the installed keys from all of the
+// targets they are still recorded on. Unlike revocations, failed removals are
+// not queued but reported, and the keys stay listed.
+func (b *backend) pathInstalledCleanup(req *logical.Request, d *framework.FieldData) (*logical.Response, error) {
+	if err := checkFieldTypes(d); err != nil {
+		return nil, err
+	}
+
+	ids := d.Get("ids").([]string)
+	if len(ids) == 0 {
+		var err error
+		ids, err = req.Storage.List("installed/")
+		if err != nil {
+			return nil, err
+		}
+	}
+
+	removed := []string{}
+	failed := map[string]interface{}{}
+	for _, id := range strutil.RemoveDuplicates(ids, false) {
+		failure, err := b.removeInstalledKey(req.Storage, id)
+		if err != nil {
+			return nil, err
+		}
+		if failure != "" {
+			failed[id] = failure
+			continue
+		}
+		removed = append(removed, id)
+	}
+
+	return &logical.Response{
+		Data: map[string]interface{}{
+			"removed": removed,
+			"failed":  failed,
+		},
+	}, nil
+}
+
+// removeInstalledKey removes the installed key from the targets it is still
+// recorded on. It returns a description of the failure if the key is not
+// found or could not be removed from some of them, which stay recorded.
+func (b *backend) removeInstalledKey(s logical.Storage, id string) (string, error) {
+	installed, err := b.getInstalledKey(s, id)
+	if err != nil {
+		return "", err
+	}
+	if installed == nil {
+		return "installed key not found", nil
+	}
+
+	failures := forEachHost(installed.IPs, func(ip string) error {
+		target := installed.forHost(ip)
+		if err := b.uninstallDynamicKey(s, &target); err != nil {
+			return err
+		}
+		return b.markUninstalled(s, id, ip)
+	})
+	if len(failures) != 0 {
+		return formatHostErrors(failures), nil
+	}
+	return "", nil
+}
+
+func (b *backend) getInstalledKey(s logical.Storage, id string) (*installedKey, error) {
+	entry, err := s.Get("installed/" + id)
+	if err != nil {
+		return nil, err
+	}
+	if entry == nil {
+		return nil, nil
+	}
+
+	var result installedKey
+	if err := entry.DecodeJSON(&result); err != nil {
+		return nil, err
+	}
+	return &result, nil
+}
+
+// putInstalledKey records the installation of a dynamic key on its targets.
+func (b *backend) putInstalledKey(s logical.Storage, installation *dynamicKeyInstallation) error {
+	entry, err := logical.StorageEntryJSON("installed/"+installation.InstallationID, &installedKey{
+		dynamicKeyInstallation: *installation,
+		CreatedAt:              time.Now().UTC(),
+	})
+	if err != nil {
+		return err
+	}
+	return s.Put(entry)
+}
+
+// markUninstalled records that the key was removed from the target. Once it
+// has been removed from all of them, the record is deleted. Keys issued
+// before installations were recorded have no identifier and are ignored.
+func (b *backend) markUninstalled(s logical.Storage, id, ip string) error {
+	if id == "" {
+		return nil
+	}
+
+	b.installedLock.Lock()
+	defer b.installedLock.Unlock()
+
+	installed, err := b.getInstalledKey(s, id)
+	if err != nil {
+		return fmt.Errorf("error reading installed key %q: %v", id, err)
+	}
+	if installed == nil {
+		return nil
+	}
+
+	var remaining []string
+	for _, installedIP := range installed.IPs {
+		if installedIP != ip {
+			remaining = append(remaining, installedIP)
+		}
+	}
+	if len(remaining) == 0 {
+		if err := s.Delete("installed/" + id); err != nil {
+			return fmt.Errorf("error deleting installed key %q: %v", id, err)
+		}
+		return nil
+	}
+
+	installed.IPs = remaining
+	installed.IP = remaining[0]
+	entry, err := logical.StorageEntryJSON("installed/"+id, installed)
+	if err != nil {
+		return err
+	}
+	return s.Put(entry)
+}
+
+const pathInstalledHelpSyn = `
+List the dynamic keys which are installed on their targets.
+`
+
+const pathInstalledHelpDesc = `
+Every issued dynamic key is recorded until it has been removed from all of its
+targets, independently of its lease. Keys whose removal is pending a retry
+stay listed for the targets they are still installed on. This allows checking
+what Vault believes is installed against the authorized_keys files of the
+targets, and finding keys left behind, for instance when a lease was revoked
+without its internal data.
+
+Listing this endpoint returns the identifiers of the installed keys along
+with their targets. Reading 'installed/<id>' returns the details of a key.
+`
+
+const pathInstalledCleanupHelpSyn = `
+Remove installed dynamic keys from their targets.
+`
+
+const pathInstalledCleanupHelpDesc = `
+Attempts to remove the given installed keys, or all of them, from the targets
+they are recorded on. Keys which were removed from all of their targets are no
+longer listed. Failed removals are not retried; they are returned and the keys
+stay listed.
+`
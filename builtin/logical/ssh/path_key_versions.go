@@ -0,0 +1,212 @@
+package ssh
+
+import (
+	"fmt"
+	"sort"
+	"strconv"
+	"time"
+
+	"github.com/hashicorp/vault/logical"
+	"github.com/hashicorp/vault/logical/framework"
+)
+
+func pathKeyVersions(b *backend) *framework.Path {
+	return &framework.Path{
+		Pattern: "keys/" + framework.GenericNameRegex("key_name") + "/versions$",
+		Fields: map[string]*framework.FieldSchema{
+			"key_name": &framework.FieldSchema{
+				Type:        framework.TypeNameString,
+				Description: "[Required] Name of the key",
+			},
+		},
+		Operations: map[logical.Operation]*framework.PathOperation{
+			logical.ReadOperation: &framework.PathOperation{
+				Callback: b.pathKeyVersionsRead,
+				Summary:  "List the versions of a shared key.",
+			},
+		},
+		HelpSynopsis:    pathKeyVersionsSyn,
+		HelpDescription: pathKeyVersionsDesc,
+	}
+}
+
+func pathKeyPrune(b *backend) *framework.Path {
+	return &framework.Path{
+		Pattern: "keys/" + framework.GenericNameRegex("key_name") + "/prune$",
+		Fields: map[string]*framework.FieldSchema{
+			"key_name": &framework.FieldSchema{
+				Type:        framework.TypeNameString,
+				Description: "[Required] Name of the key",
+			},
+			"min_version": &framework.FieldSchema{
+				Type:        framework.TypeInt,
+				Description: "[Required] Versions of the key older than this are deleted. The latest version is never deleted",
+			},
+		},
+		Operations: map[logical.Operation]*framework.PathOperation{
+			logical.UpdateOperation: &framework.PathOperation{
+				Callback: b.pathKeyPrune,
+				Summary:  "Prune old versions of a shared key.",
+			},
+		},
+		HelpSynopsis:    pathKeyVersionsSyn,
+		HelpDescription: pathKeyVersionsDesc,
+	}
+}
+
+func keyVersionsPrefix(keyName string) string {
+	return fmt.Sprintf("key_versions/%s/", keyName)
+}
+
+func (b *backend) putKeyVersion(s logical.Storage, keyName string, hostKey *sshHostKey) error {
+	entry, err := logical.StorageEntryJSON(keyVersionsPrefix(keyName)+strconv.Itoa(hostKey.version()), hostKey)
+	if err != nil {
+		return err
+	}
+	return s.Put(entry)
+}
+
+// getKeyVersions returns every version of the named key, newest first.
+func (b *backend) getKeyVersions(s logical.Storage, keyName string) ([]*sshHostKey, error) {
+	latest, err := b.getKey(s, keyName)
+	if err != nil {
+		return nil, err
+	}
+	if latest == nil {
+		return nil, nil
+	}
+
+	entries, err := s.List(keyVersionsPrefix(keyName))
+	if err != nil {
+		return nil, err
+	}
+
+	var previous []*sshHostKey
+	for _, entry := range entries {
+		storageEntry, err := s.Get(keyVersionsPrefix(keyName) + entry)
+		if err != nil {
+			return nil, err
+		}
+		if storageEntry == nil {
+			continue
+		}
+		var hostKey sshHostKey
+		if err := storageEntry.DecodeJSON(&hostKey); err != nil {
+			return nil, err
+		}
+		previous = append(previous, &hostKey)
+	}
+	sort.Slice(previous, func(i, j int) bool {
+		return previous[i].version() > previous[j].version()
+	})
+
+	return append([]*sshHostKey{latest}, previous...), nil
+}
+
+// keyVersionsForUninstall returns the versions of the named key in the order
+// in which they are tried to uninstall a dynamic key: the given version first,
+// followed by the others, newest first. Dynamic keys installed before versions
+// were recorded have version 0 and try the latest version first.
+func (b *backend) keyVersionsForUninstall(s logical.Storage, keyName string, version int) ([]*sshHostKey, error) {
+	versions, err := b.getKeyVersions(s, keyName)
+	if err != nil {
+		return nil, err
+	}
+	for i, hostKey := range versions {
+		if hostKey.version() == version {
+			return append([]*sshHostKey{hostKey}, append(versions[:i:i], versions[i+1:]...)...), nil
+		}
+	}
+	return versions, nil
+}
+
+func (b *backend) pathKeyVersionsRead(req *logical.Request, d *framework.FieldData) (*logical.Response, error) {
+	if err := checkFieldTypes(d); err != nil {
+		return nil, err
+	}
+
+	versions, err := b.getKeyVersions(req.Storage, d.Get("key_name").(string))
+	if err != nil {
+		return nil, err
+	}
+	if len(versions) == 0 {
+		return nil, nil
+	}
+
+	versionInfo := map[string]interface{}{}
+	for _, hostKey := range versions {
+		var createdAt string
+		if !hostKey.CreatedAt.IsZero() {
+			createdAt = hostKey.CreatedAt.Format(time.RFC3339)
+		}
+		versionInfo[strconv.Itoa(hostKey.version())] = map[string]interface{}{
+			"algorithm":   hostKey.Algorithm,
+			"fingerprint": hostKey.fingerprint(),
+			"created_at":  createdAt,
+		}
+	}
+
+	return &logical.Response{
+		Data: map[string]interface{}{
+			"latest_version": versions[0].version(),
+			"versions":       versionInfo,
+		},
+	}, nil
+}
+
+func (b *backend) pathKeyPrune(req *logical.Request, d *framework.FieldData) (*logical.Response, error) {
+	if err := checkFieldTypes(d); err != nil {
+		return nil, err
+	}
+
+	keyName := d.Get("key_name").(string)
+	minVersion := d.Get("min_version").(int)
+	if minVersion <= 0 {
+		return logical.ErrorResponse("min_version must be a positive number"), nil
+	}
+
+	b.keyLock.Lock()
+	defer b.keyLock.Unlock()
+
+	versions, err := b.getKeyVersions(req.Storage, keyName)
+	if err != nil {
+		return nil, err
+	}
+	if len(versions) == 0 {
+		return logical.ErrorResponse(fmt.Sprintf("key %q not found", keyName)), nil
+	}
+
+	var deleted int
+	for _, hostKey := range versions[1:] {
+		if hostKey.version() >= minVersion {
+			continue
+		}
+		if err := req.Storage.Delete(keyVersionsPrefix(keyName) + strconv.Itoa(hostKey.version())); err != nil {
+			return nil, err
+		}
+		deleted++
+	}
+
+	return &logical.Response{
+		Data: map[string]interface{}{
+			"deleted": deleted,
+		},
+	}, nil
+}
+
+const pathKeyVersionsSyn = `
+Inspect and prune the versions of a shared key.
+`
+
+const pathKeyVersionsDesc = `
+Every write to 'keys/<name>' creates a new version of the key. The previous
+versions are kept so that dynamic keys installed with them can be uninstalled
+after the key is rotated. Reading 'keys/<name>/versions' returns the algorithm,
+fingerprint and registration time of every version, but never the keys
+themselves.
+
+Once the leases of the dynamic keys installed with old versions have expired,
+those versions can be deleted by writing to 'keys/<name>/prune' with
+'min_version' set to the oldest version to keep. The latest version is always
+kept.
+`
@@ -1,16 +1,82 @@
 package ssh
 
 import (
+	"encoding/pem"
 	"fmt"
+	"strings"
+	"time"
 
 	"golang.org/x/crypto/ssh"
 
+	"github.com/hashicorp/vault/helper/strutil"
 	"github.com/hashicorp/vault/logical"
 	"github.com/hashicorp/vault/logical/framework"
 )
 
 type sshHostKey struct {
-	Key string `json:"key"`
+	Key      string `json:"key"`
+	Password string `json:"password"`
+
+	// Set if the private key is held by an SSH agent on the Vault host
+	// rather than stored by Vault
+	Agent            bool   `json:"agent"`
+	AgentFingerprint string `json:"agent_fingerprint"`
+	AgentSocket      string `json:"agent_socket"`
+
+	// Detected when the private key is registered. Not set for keys
+	// registered before these were recorded.
+	Algorithm   string `json:"algorithm"`
+	Fingerprint string `json:"fingerprint"`
+
+	// Time the key was registered. Not set for keys registered before this
+	// was recorded.
+	CreatedAt time.Time `json:"created_at"`
+
+	// Incremented every time the key is written. Previous versions are kept
+	// so that dynamic keys installed with them can still be uninstalled.
+	Version int `json:"version"`
+}
+
+// namedHostKey is a host key along with the name it is registered under.
+type namedHostKey struct {
+	name string
+	*sshHostKey
+}
+
+// version returns the version of the key. Keys written before versions were
+// recorded are version 1.
+func (k *sshHostKey) version() int {
+	if k.Version == 0 {
+		return 1
+	}
+	return k.Version
+}
+
+// fingerprint returns the fingerprint of the private key, or for keys held by
+// an SSH agent, the fingerprint selecting the key of the agent.
+func (k *sshHostKey) fingerprint() string {
+	if k.Agent {
+		return k.AgentFingerprint
+	}
+	return k.Fingerprint
+}
+
+func pathListKeys(b *backend) *framework.Path {
+	return &framework.Path{
+		Pattern: "keys/?$",
+
+		Operations: map[logical.Operation]*framework.PathOperation{
+			logical.ListOperation: &framework.PathOperation{
+				Callback:    b.pathKeysList,
+				Summary:     "List the shared keys.",
+				StandbySafe: true,
+				Response:    listResponse("Names of the shared keys.", "Algorithm, fingerprint and creation time of the shared keys."),
+			},
+		},
+
+		HelpSynopsis:    pathKeysSyn,
+		HelpDescription: pathKeysDesc,
+	}
 }
 
 func pathKeys(b *backend) *framework.Path {
@@ -18,17 +84,48 @@ func pathKeys(b *backend) *framework.Path {
 		Pattern: "keys/" + framework.GenericNameRegex("key_name"),
 		Fields: map[string]*framework.FieldSchema{
 			"key_name": &framework.FieldSchema{
-				Type:        framework.TypeString,
+				Type:        framework.TypeNameString,
 				Description: "[Required] Name of the key",
 			},
 			"key": &framework.FieldSchema{
 				Type:        framework.TypeString,
-				Description: "[Required] SSH private key with super user privileges in host",
+				Description: "[Required unless password is set] SSH private key with super user privileges in host",
+			},
+			"password": &framework.FieldSchema{
+				Type:        framework.TypeString,
+				Description: "[Required unless key is set] Password of the admin user in host. If a key is set as well, it is only used if authenticating with the key fails",
+			},
+			"agent": &framework.FieldSchema{
+				Type:        framework.TypeBool,
+				Description: "If set, the private key is held by an SSH agent on the Vault host instead of being stored in Vault. Cannot be combined with key",
+			},
+			"fingerprint": &framework.FieldSchema{
+				Type:        framework.TypeString,
+				Description: "SHA256 or MD5 fingerprint of the key of the SSH agent to use. Defaults to trying all the keys of the agent",
+			},
+			"agent_socket": &framework.FieldSchema{
+				Type:        framework.TypeString,
+				Description: "Path of the socket of the SSH agent. Defaults to the value of SSH_AUTH_SOCK for the Vault process",
+			},
+			"force": &framework.FieldSchema{
+				Type:        framework.TypeBool,
+				Description: "If set, the key is deleted even if roles still use it",
 			},
 		},
-		Callbacks: map[logical.Operation]framework.OperationFunc{
-			logical.UpdateOperation: b.pathKeysWrite,
-			logical.DeleteOperation: b.pathKeysDelete,
+		Operations: map[logical.Operation]*framework.PathOperation{
+			logical.ReadOperation: &framework.PathOperation{
+				Callback: b.pathKeysRead,
+				Summary:  "Read the public key and fingerprint of a shared key.",
+				Response: keyResponse,
+			},
+			logical.UpdateOperation: &framework.PathOperation{
+				Callback: b.pathKeysWrite,
+				Summary:  "Register a shared private key.",
+			},
+			logical.DeleteOperation: &framework.PathOperation{
+				Callback: b.pathKeysDelete,
+				Summary:  "Remove a shared key.",
+			},
 		},
 		HelpSynopsis:    pathKeysSyn,
 		HelpDescription: pathKeysDesc,
@@ -51,40 +148,226 @@ func (b *backend) getKey(s logical.Storage, n string) (*sshHostKey, error) {
 	return &result, nil
 }
 
+func (b *backend) pathKeysRead(req *logical.Request, d *framework.FieldData) (*logical.Response, error) {
+	if err := checkFieldTypes(d); err != nil {
+		return nil, err
+	}
+
+	hostKey, err := b.getKey(req.Storage, d.Get("key_name").(string))
+	if err != nil {
+		return nil, err
+	}
+	if hostKey == nil {
+		return nil, nil
+	}
+
+	// Neither the private key nor the password is returned. The public key
+	// and its fingerprint let clients check which key is registered.
+	var publicKey string
+	algorithm := hostKey.Algorithm
+	fingerprint := hostKey.Fingerprint
+	if hostKey.Key != "" {
+		signer, err := ssh.ParsePrivateKey([]byte(hostKey.Key))
+		if err != nil {
+			return nil, fmt.Errorf("error parsing stored key: %v", err)
+		}
+		publicKey = strings.TrimSpace(string(ssh.MarshalAuthorizedKey(signer.PublicKey())))
+		if fingerprint == "" {
+			algorithm = signer.PublicKey().Type()
+			fingerprint = ssh.FingerprintSHA256(signer.PublicKey())
+		}
+	}
+	if hostKey.Agent {
+		fingerprint = hostKey.fingerprint()
+	}
+
+	return &logical.Response{
+		Data: map[string]interface{}{
+			"public_key":   publicKey,
+			"has_key":      hostKey.Key != "",
+			"has_password": hostKey.Password != "",
+			"agent":        hostKey.Agent,
+			"algorithm":    algorithm,
+			"fingerprint":  fingerprint,
+			"agent_socket": hostKey.AgentSocket,
+			"version":      hostKey.version(),
+		},
+	}, nil
+}
+
+func (b *backend) pathKeysList(req *logical.Request, d *framework.FieldData) (*logical.Response, error) {
+	if err := checkFieldTypes(d); err != nil {
+		return nil, err
+	}
+
+	entries, err := req.Storage.List("keys/")
+	if err != nil {
+		return nil, err
+	}
+
+	keyInfo := map[string]interface{}{}
+	for _, entry := range entries {
+		hostKey, err := b.getKey(req.Storage, entry)
+		if err != nil {
+			return nil, fmt.Errorf("error retrieving key %q: %v", entry, err)
+		}
+		if hostKey == nil {
+			continue
+		}
+
+		var createdAt string
+		if !hostKey.CreatedAt.IsZero() {
+			createdAt = hostKey.CreatedAt.Format(time.RFC3339)
+		}
+		keyInfo[entry] = map[string]interface{}{
+			"algorithm":   hostKey.Algorithm,
+			"fingerprint": hostKey.fingerprint(),
+			"created_at":  createdAt,
+		}
+	}
+
+	return logical.ListResponseWithInfo(entries, keyInfo), nil
+}
+
 func (b *backend) pathKeysDelete(req *logical.Request, d *framework.FieldData) (*logical.Response, error) {
+	if err := checkFieldTypes(d); err != nil {
+		return nil, err
+	}
+
 	keyName := d.Get("key_name").(string)
+
+	// Deleting a key breaks the roles using it, so that has to be forced
+	roles, err := b.rolesUsingKey(req.Storage, keyName)
+	if err != nil {
+		return nil, err
+	}
+	if len(roles) != 0 && !d.Get("force").(bool) {
+		return logical.ErrorResponse(fmt.Sprintf("key %q is used by roles %s; set force to delete it anyway", keyName, strings.Join(roles, ", "))), nil
+	}
+
+	b.keyLock.Lock()
+	defer b.keyLock.Unlock()
+
 	keyPath := fmt.Sprintf("keys/%s", keyName)
-	err := req.Storage.Delete(keyPath)
+	err = req.Storage.Delete(keyPath)
 	if err != nil {
 		return nil, err
 	}
-	return nil, nil
+
+	// Previous versions are of no use without the key
+	versions, err := req.Storage.List(keyVersionsPrefix(keyName))
+	if err != nil {
+		return nil, err
+	}
+	for _, version := range versions {
+		if err := req.Storage.Delete(keyVersionsPrefix(keyName) + version); err != nil {
+			return nil, err
+		}
+	}
+
+	if len(roles) == 0 {
+		return nil, nil
+	}
+	resp := &logical.Response{}
+	resp.AddWarning(fmt.Sprintf("roles %s use the deleted key and cannot issue credentials until they are updated", strings.Join(roles, ", ")))
+	return resp, nil
+}
+
+// rolesUsingKey returns the names of the roles which use the named key, either
+// for their targets or for their bastion.
+func (b *backend) rolesUsingKey(s logical.Storage, keyName string) ([]string, error) {
+	roleNames, err := s.List("roles/")
+	if err != nil {
+		return nil, err
+	}
+
+	var result []string
+	for _, roleName := range roleNames {
+		role, err := b.getRole(s, roleName)
+		if err != nil {
+			return nil, fmt.Errorf("error retrieving role %q: %v", roleName, err)
+		}
+		if role == nil || role.KeyType != KeyTypeDynamic {
+			continue
+		}
+		if strutil.StrListContains(role.hostKeyNames(), keyName) || role.BastionKeyName == keyName {
+			result = append(result, roleName)
+		}
+	}
+	return result, nil
 }
 
 func (b *backend) pathKeysWrite(req *logical.Request, d *framework.FieldData) (*logical.Response, error) {
+	if err := checkFieldTypes(d); err != nil {
+		return nil, err
+	}
+
 	keyName := d.Get("key_name").(string)
 	if keyName == "" {
 		return logical.ErrorResponse("Missing key_name"), nil
 	}
 
 	keyString := d.Get("key").(string)
+	password := d.Get("password").(string)
+	useAgent := d.Get("agent").(bool)
+	fingerprint := strings.TrimSpace(d.Get("fingerprint").(string))
+	agentSocket := d.Get("agent_socket").(string)
+
+	if keyString == "" && password == "" && !useAgent {
+		return logical.ErrorResponse("Missing key, password or agent"), nil
+	}
+
+	if useAgent {
+		if keyString != "" {
+			return logical.ErrorResponse("key cannot be set for keys held by an SSH agent"), nil
+		}
+		if fingerprint != "" && !validFingerprint(fingerprint) {
+			return logical.ErrorResponse(fmt.Sprintf("invalid fingerprint %q", fingerprint)), nil
+		}
+	} else if fingerprint != "" || agentSocket != "" {
+		return logical.ErrorResponse("fingerprint and agent_socket are only applicable if agent is set"), nil
+	}
+
+	hostKey := &sshHostKey{
+		Key:              keyString,
+		Password:         password,
+		Agent:            useAgent,
+		AgentFingerprint: fingerprint,
+		AgentSocket:      agentSocket,
+		CreatedAt:        time.Now().UTC(),
+	}
 
 	// Check if the key provided is infact a private key
-	signer, err := ssh.ParsePrivateKey([]byte(keyString))
-	if err != nil || signer == nil {
-		return logical.ErrorResponse("Invalid key"), nil
+	if keyString != "" {
+		signer, err := parseHostPrivateKey(keyString)
+		if err != nil {
+			return logical.ErrorResponse(fmt.Sprintf("Invalid key: %v", err)), nil
+		}
+		hostKey.Algorithm = signer.PublicKey().Type()
+		hostKey.Fingerprint = ssh.FingerprintSHA256(signer.PublicKey())
 	}
 
-	if keyString == "" {
-		return logical.ErrorResponse("Missing key"), nil
+	b.keyLock.Lock()
+	defer b.keyLock.Unlock()
+
+	// The current version of the key is kept so that dynamic keys installed
+	// with it can still be uninstalled
+	previous, err := b.getKey(req.Storage, keyName)
+	if err != nil {
+		return nil, err
+	}
+	hostKey.Version = 1
+	if previous != nil {
+		if err := b.putKeyVersion(req.Storage, keyName, previous); err != nil {
+			return nil, err
+		}
+		hostKey.Version = previous.version() + 1
 	}
 
 	keyPath := fmt.Sprintf("keys/%s", keyName)
 
 	// Store the key
-	entry, err := logical.StorageEntryJSON(keyPath, map[string]interface{}{
-		"key": keyString,
-	})
+	entry, err := logical.StorageEntryJSON(keyPath, hostKey)
 	if err != nil {
 		return nil, err
 	}
@@ -94,6 +377,63 @@ func (b *backend) pathKeysWrite(req *logical.Request, d *framework.FieldData) (*
 	return nil, nil
 }
 
+// parseHostPrivateKey parses the private key of a shared key, describing why
+// keys which cannot be used are rejected.
+func parseHostPrivateKey(key string) (ssh.Signer, error) {
+	block, _ := pem.Decode([]byte(key))
+	if block == nil {
+		return nil, fmt.Errorf("no PEM encoded private key found; the key may be truncated")
+	}
+	if strings.Contains(block.Headers["Proc-Type"], "ENCRYPTED") {
+		return nil, fmt.Errorf("the key is protected by a passphrase; register an unencrypted key")
+	}
+
+	signer, err := ssh.ParsePrivateKey([]byte(key))
+	if err != nil {
+		if strings.Contains(err.Error(), "encrypted") {
+			return nil, fmt.Errorf("the key is protected by a passphrase; register an unencrypted key")
+		}
+		return nil, err
+	}
+	return signer, nil
+}
+
+// keyResponse is the schema of the fields of shared keys as they are read.
+var keyResponse = map[string]*framework.FieldSchema{
+	"public_key": &framework.FieldSchema{
+		Type:        framework.TypeString,
+		Description: `Public key of the shared key.`,
+	},
+	"has_key": &framework.FieldSchema{
+		Type:        framework.TypeBool,
+		Description: `Whether a private key is registered.`,
+	},
+	"has_password": &framework.FieldSchema{
+		Type:        framework.TypeBool,
+		Description: `Whether a password is registered.`,
+	},
+	"agent": &framework.FieldSchema{
+		Type:        framework.TypeBool,
+		Description: `Whether the key is held by an SSH agent.`,
+	},
+	"agent_socket": &framework.FieldSchema{
+		Type:        framework.TypeString,
+		Description: `Socket of the SSH agent.`,
+	},
+	"algorithm": &framework.FieldSchema{
+		Type:        framework.TypeString,
+		Description: `Algorithm of the key.`,
+	},
+	"fingerprint": &framework.FieldSchema{
+		Type:        framework.TypeString,
+		Description: `SHA256 fingerprint of the public key.`,
+	},
+	"version": &framework.FieldSchema{
+		Type:        framework.TypeInt,
+		Description: `Current version of the key.`,
+	},
+}
+
 const pathKeysSyn = `
 Register a shared private key with Vault.
 `
@@ -103,6 +443,28 @@ Vault uses this key to install and uninstall dynamic keys in remote hosts. This
 key should have sudoer privileges in remote hosts. This enables installing keys
 for unprivileged usernames.
 
+For hosts which do not allow the admin user to log in with a key, a password
+can be registered instead of, or in addition to, the private key. If both are
+registered, the key is tried first. Reading the key returns the public key, its
+algorithm and SHA256 fingerprint and which credentials are registered, but never
+the private key or the password.
+
+To keep the private key out of Vault, it can instead be held by an SSH agent
+on the Vault host by setting 'agent'. The agent is reached through the socket
+at 'agent_socket', or SSH_AUTH_SOCK if that is not set, and 'fingerprint'
+selects which of its keys is used.
+
+Writing an existing key creates a new version of it. Dynamic keys are installed
+with the latest version, and uninstalled with the version they were installed
+with, falling back to the other versions if the target rejects it. This allows
+the key to be rotated without breaking the revocation of existing leases.
+Previous versions are listed at "keys/<name>/versions" and can be pruned using
+"keys/<name>/prune".
+
+Listing "keys/" returns the names of the registered keys along with the
+algorithm, fingerprint and registration time of each. Keys which are still
+used by roles are only deleted if 'force' is set.
+
 If this backend is mounted as "ssh", then the endpoint for registering shared
 key is "ssh/keys/<name>". The name given here can be associated with any number
 of roles via the endpoint "ssh/roles/".
@@ -2,7 +2,6 @@ package ssh
 
 import (
 	"fmt"
-	"net"
 
 	"github.com/hashicorp/vault/logical"
 	"github.com/hashicorp/vault/logical/framework"
@@ -17,8 +16,12 @@ func pathLookup(b *backend) *framework.Path {
 				Description: "[Required] IP address of remote host",
 			},
 		},
-		Callbacks: map[logical.Operation]framework.OperationFunc{
-			logical.UpdateOperation: b.pathLookupWrite,
+		Operations: map[logical.Operation]*framework.PathOperation{
+			logical.UpdateOperation: &framework.PathOperation{
+				Callback:    b.pathLookupWrite,
+				Summary:     "List the roles serving an IP address.",
+				StandbySafe: true,
+			},
 		},
 		HelpSynopsis:    pathLookupSyn,
 		HelpDescription: pathLookupDesc,
@@ -26,39 +29,25 @@ func pathLookup(b *backend) *framework.Path {
 }
 
 func (b *backend) pathLookupWrite(req *logical.Request, d *framework.FieldData) (*logical.Response, error) {
+	if err := checkFieldTypes(d); err != nil {
+		return nil, err
+	}
+
 	ipAddr := d.Get("ip").(string)
 	if ipAddr == "" {
 		return logical.ErrorResponse("Missing ip"), nil
 	}
-	ip := net.ParseIP(ipAddr)
-	if ip == nil {
-		return logical.ErrorResponse(fmt.Sprintf("Invalid IP %q", ip.String())), nil
+	ip, ok := canonicalIP(ipAddr)
+	if !ok {
+		return logical.ErrorResponse(fmt.Sprintf("Invalid IP %q", ipAddr)), nil
 	}
 
-	// Get all the roles created in the backend.
-	keys, err := req.Storage.List("roles/")
+	// Look for roles which would issue credentials for the given IP.
+	matchingRoles, err := b.rolesForIP(req.Storage, ip)
 	if err != nil {
 		return nil, err
 	}
 
-	// Look for roles which has CIDR blocks that encompasses the given IP
-	// and create a list out of it.
-	var matchingRoles []string
-	for _, role := range keys {
-		if contains, _ := roleContainsIP(req.Storage, role, ip.String()); contains {
-			matchingRoles = append(matchingRoles, role)
-		}
-	}
-
-	// Add roles that are allowed to accept any IP address.
-	zeroAddressEntry, err := b.getZeroAddressRoles(req.Storage)
-	if err != nil {
-		return nil, err
-	}
-	if zeroAddressEntry != nil {
-		matchingRoles = append(matchingRoles, zeroAddressEntry.Roles...)
-	}
-
 	// This list may potentially reveal more information than it is supposed to.
 	// The roles for which the client is not authorized to will also be displayed.
 	// However, if the client tries to use the role for which the client is not
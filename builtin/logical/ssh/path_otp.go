@@ -0,0 +1,68 @@
+package ssh
+
+import (
+	"github.com/hashicorp/vault/logical"
+	"github.com/hashicorp/vault/logical/framework"
+)
+
+func pathOTP(b *backend) *framework.Path {
+	return &framework.Path{
+		Pattern: "otp/" + framework.GenericNameRegex("salted_otp"),
+		Fields: map[string]*framework.FieldSchema{
+			"salted_otp": &framework.FieldSchema{
+				Type:        framework.TypeString,
+				Description: "[Required] Salted value of the OTP, as stored by the backend",
+			},
+		},
+		Operations: map[logical.Operation]*framework.PathOperation{
+			logical.ReadOperation: &framework.PathOperation{
+				Callback: b.pathOTPRead,
+				Summary:  "Read the stored entry of an unused OTP.",
+			},
+		},
+		HelpSynopsis:    pathOTPHelpSyn,
+		HelpDescription: pathOTPHelpDesc,
+	}
+}
+
+func (b *backend) pathOTPRead(req *logical.Request, d *framework.FieldData) (*logical.Response, error) {
+	if err := checkFieldTypes(d); err != nil {
+		return nil, err
+	}
+
+	otpEntry, err := b.getOTP(req.Storage, d.Get("salted_otp").(string))
+	if err != nil {
+		return nil, err
+	}
+	if otpEntry == nil {
+		return nil, nil
+	}
+
+	resp := &logical.Response{
+		Data: map[string]interface{}{
+			"username":  otpEntry.Username,
+			"ip":        otpEntry.IP,
+			"role_name": otpEntry.RoleName,
+		},
+	}
+	if otpEntry.MaxUses > 1 {
+		resp.Data["remaining_uses"] = otpEntry.RemainingUses
+	}
+	otpEntry.addMetadata(resp.Data)
+
+	return resp, nil
+}
+
+const pathOTPHelpSyn = `
+Inspect the stored entry of an unused OTP.
+`
+
+const pathOTPHelpDesc = `
+Every generated OTP is stored under its salted value until it is used or its
+lease is revoked. Reading 'otp/<salted>' returns the username and IP of the
+entry together with the role it was created under, when it was created, its
+lease duration, the display name of the token it was issued to and, for OTPs
+which can be used several times, the number of uses left. Details
+which were not recorded when the OTP was created are omitted. The OTP itself
+cannot be recovered from the entry, and reading it does not use it up.
+`
@@ -0,0 +1,315 @@
+package ssh
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"fmt"
+	"time"
+
+	"github.com/hashicorp/go-multierror"
+	"github.com/hashicorp/vault/logical"
+	"github.com/hashicorp/vault/logical/framework"
+)
+
+const (
+	// Pending revocations are retried after this interval, doubling with
+	// every failed attempt up to revocationRetryMaxInterval.
+	revocationRetryBaseInterval = time.Minute
+	revocationRetryMaxInterval  = time.Hour
+)
+
+// Structure to hold a dynamic key which could not be removed from its target
+// and whose removal is pending a retry.
+type pendingRevocation struct {
+	dynamicKeyInstallation
+
+	CreatedAt   time.Time `json:"created_at"`
+	NextAttempt time.Time `json:"next_attempt"`
+	Attempts    int       `json:"attempts"`
+	LastError   string    `json:"last_error"`
+	Failed      bool      `json:"failed"`
+}
+
+func pathListRevocations(b *backend) *framework.Path {
+	return &framework.Path{
+		Pattern: "revocations/?$",
+
+		Operations: map[logical.Operation]*framework.PathOperation{
+			logical.ListOperation: &framework.PathOperation{
+				Callback: b.pathRevocationsList,
+				Summary:  "List the dynamic keys which could not be removed from their targets.",
+			},
+		},
+
+		HelpSynopsis:    pathRevocationsHelpSyn,
+		HelpDescription: pathRevocationsHelpDesc,
+	}
+}
+
+func pathRevocations(b *backend) *framework.Path {
+	return &framework.Path{
+		Pattern: "revocations/" + framework.GenericNameRegex("id"),
+		Fields: map[string]*framework.FieldSchema{
+			"id": &framework.FieldSchema{
+				Type:        framework.TypeString,
+				Description: "[Required] Identifier of the pending revocation",
+			},
+		},
+
+		Operations: map[logical.Operation]*framework.PathOperation{
+			logical.ReadOperation: &framework.PathOperation{
+				Callback: b.pathRevocationRead,
+				Summary:  "Read a failed removal of a dynamic key.",
+			},
+			logical.DeleteOperation: &framework.PathOperation{
+				Callback: b.pathRevocationDelete,
+				Summary:  "Stop retrying the removal of a dynamic key.",
+			},
+		},
+
+		HelpSynopsis:    pathRevocationsHelpSyn,
+		HelpDescription: pathRevocationsHelpDesc,
+	}
+}
+
+func (b *backend) pathRevocationsList(req *logical.Request, d *framework.FieldData) (*logical.Response, error) {
+	if err := checkFieldTypes(d); err != nil {
+		return nil, err
+	}
+
+	ids, err := req.Storage.List("revocations/")
+	if err != nil {
+		return nil, err
+	}
+
+	keyInfo := map[string]interface{}{}
+	for _, id := range ids {
+		pending, err := b.getPendingRevocation(req.Storage, id)
+		if err != nil {
+			return nil, err
+		}
+		if pending == nil {
+			continue
+		}
+		keyInfo[id] = map[string]interface{}{
+			"ip":       pending.IP,
+			"username": pending.Username,
+			"attempts": pending.Attempts,
+			"failed":   pending.Failed,
+		}
+	}
+
+	return logical.ListResponseWithInfo(ids, keyInfo), nil
+}
+
+func (b *backend) pathRevocationRead(req *logical.Request, d *framework.FieldData) (*logical.Response, error) {
+	if err := checkFieldTypes(d); err != nil {
+		return nil, err
+	}
+
+	pending, err := b.getPendingRevocation(req.Storage, d.Get("id").(string))
+	if err != nil {
+		return nil, err
+	}
+	if pending == nil {
+		return nil, nil
+	}
+
+	return &logical.Response{
+		Data: map[string]interface{}{
+			"admin_user":           pending.AdminUser,
+			"username":             pending.Username,
+			"ip":                   pending.IP,
+			"port":                 pending.Port,
+			"host_key_name":        pending.HostKeyName,
+			"host_key_version":     pending.HostKeyVersion,
+			"bastion_host":         pending.BastionHost,
+			"bastion_port":         pending.BastionPort,
+			"bastion_key_name":     pending.BastionKeyName,
+			"sudo_command":         pending.SudoCommand,
+			"dynamic_public_key":   pending.DynamicPublicKey,
+			"authorized_keys_path": pending.authorizedKeysFile(),
+			"fingerprint_sha256":   pending.FingerprintSHA256,
+			"created_at":           pending.CreatedAt.Format(time.RFC3339),
+			"next_attempt":         pending.NextAttempt.Format(time.RFC3339),
+			"attempts":             pending.Attempts,
+			"last_error":           pending.LastError,
+			"failed":               pending.Failed,
+		},
+	}, nil
+}
+
+func (b *backend) pathRevocationDelete(req *logical.Request, d *framework.FieldData) (*logical.Response, error) {
+	if err := checkFieldTypes(d); err != nil {
+		return nil, err
+	}
+
+	b.revocationLock.Lock()
+	defer b.revocationLock.Unlock()
+
+	if err := req.Storage.Delete("revocations/" + d.Get("id").(string)); err != nil {
+		return nil, err
+	}
+	return nil, nil
+}
+
+func (b *backend) getPendingRevocation(s logical.Storage, id string) (*pendingRevocation, error) {
+	entry, err := s.Get("revocations/" + id)
+	if err != nil {
+		return nil, err
+	}
+	if entry == nil {
+		return nil, nil
+	}
+
+	var result pendingRevocation
+	if err := entry.DecodeJSON(&result); err != nil {
+		return nil, err
+	}
+	return &result, nil
+}
+
+func (b *backend) putPendingRevocation(s logical.Storage, id string, pending *pendingRevocation) error {
+	entry, err := logical.StorageEntryJSON("revocations/"+id, pending)
+	if err != nil {
+		return err
+	}
+	return s.Put(entry)
+}
+
+// pendingRevocationID returns the identifier of the pending revocation of the
+// dynamic key on the host, so that repeated failures of the same removal are
+// recorded in a single entry.
+func pendingRevocationID(intSec *dynamicKeyInstallation) string {
+	sum := sha256.Sum256([]byte(intSec.DynamicPublicKey + "\x00" + intSec.IP))
+	return hex.EncodeToString(sum[:16])
+}
+
+// queueRevocation records a failed removal of a dynamic key from its target,
+// so that the removal is retried by the periodic function.
+func (b *backend) queueRevocation(s logical.Storage, intSec *dynamicKeyInstallation, revokeErr error) error {
+	return b.recordRevocationFailure(s, pendingRevocationID(intSec), intSec, revokeErr)
+}
+
+// dequeueRevocation deletes the pending revocation of a dynamic key which has
+// been removed from its target.
+func (b *backend) dequeueRevocation(s logical.Storage, intSec *dynamicKeyInstallation) error {
+	b.revocationLock.Lock()
+	defer b.revocationLock.Unlock()
+
+	return s.Delete("revocations/" + pendingRevocationID(intSec))
+}
+
+// recordRevocationFailure counts a failed attempt in the pending revocation,
+// scheduling the next attempt or, once past the configured maximum age,
+// marking it as failed. If intSec is given, the entry is created if it does
+// not exist; otherwise entries deleted in the meantime are left alone.
+func (b *backend) recordRevocationFailure(s logical.Storage, id string, intSec *dynamicKeyInstallation, revokeErr error) error {
+	config, err := b.getRevocationConfig(s)
+	if err != nil {
+		return err
+	}
+
+	b.revocationLock.Lock()
+	defer b.revocationLock.Unlock()
+
+	now := time.Now().UTC()
+	pending, err := b.getPendingRevocation(s, id)
+	if err != nil {
+		return err
+	}
+	if pending == nil {
+		if intSec == nil {
+			return nil
+		}
+		pending = &pendingRevocation{
+			dynamicKeyInstallation: *intSec,
+			CreatedAt:              now,
+		}
+	}
+
+	pending.Attempts++
+	pending.LastError = revokeErr.Error()
+	switch {
+	case pending.Failed:
+	case now.Sub(pending.CreatedAt) >= config.MaxAge:
+		pending.Failed = true
+		b.Logger().Error("ssh: giving up on removing dynamic key", "id", id, "ip", pending.IP, "username", pending.Username, "fingerprint", pending.FingerprintSHA256, "error", revokeErr)
+	default:
+		pending.NextAttempt = now.Add(revocationRetryBackoff(pending.Attempts))
+	}
+	return b.putPendingRevocation(s, id, pending)
+}
+
+// retryPendingRevocations attempts to remove the dynamic keys of the pending
+// revocations that are due. Successfully removed keys are deleted from the
+// queue. Revocations older than the configured maximum age are marked as
+// failed and are no longer retried, but kept for inspection. The targets are
+// contacted without holding the revocation lock.
+func (b *backend) retryPendingRevocations(s logical.Storage) error {
+	ids, err := s.List("revocations/")
+	if err != nil {
+		return err
+	}
+
+	var result error
+	now := time.Now().UTC()
+	for _, id := range ids {
+		pending, err := b.getPendingRevocation(s, id)
+		if err != nil {
+			result = multierror.Append(result, fmt.Errorf("error reading pending revocation %q: %v", id, err))
+			continue
+		}
+		if pending == nil || pending.Failed || now.Before(pending.NextAttempt) {
+			continue
+		}
+
+		err = b.uninstallDynamicKey(s, &pending.dynamicKeyInstallation)
+		if err != nil {
+			if err := b.recordRevocationFailure(s, id, nil, err); err != nil {
+				result = multierror.Append(result, fmt.Errorf("error updating pending revocation %q: %v", id, err))
+			}
+			continue
+		}
+
+		if err := b.markUninstalled(s, pending.InstallationID, pending.IP); err != nil {
+			result = multierror.Append(result, err)
+		}
+		b.revocationLock.Lock()
+		err = s.Delete("revocations/" + id)
+		b.revocationLock.Unlock()
+		if err != nil {
+			result = multierror.Append(result, fmt.Errorf("error deleting pending revocation %q: %v", id, err))
+		}
+	}
+
+	return result
+}
+
+// revocationRetryBackoff returns the interval to wait before the next retry
+// after the given number of failed attempts.
+func revocationRetryBackoff(attempts int) time.Duration {
+	interval := revocationRetryBaseInterval
+	for i := 1; i < attempts && interval < revocationRetryMaxInterval; i++ {
+		interval *= 2
+	}
+	if interval > revocationRetryMaxInterval {
+		interval = revocationRetryMaxInterval
+	}
+	return interval
+}
+
+const pathRevocationsHelpSyn = `
+Inspect dynamic keys which could not be removed from their targets.
+`
+
+const pathRevocationsHelpDesc = `
+When a dynamic key lease is revoked while its target is unreachable, the key
+is queued and its removal is retried with exponential backoff until the
+'max_age' configured at 'config/revocation' has passed. After that the entry
+is marked as failed and kept, so that the key can be removed manually.
+
+Listing this endpoint returns the identifiers of the queued revocations along
+with the target of each. Reading 'revocations/<id>' returns the details of a
+revocation, and deleting it removes the entry from the queue.
+`
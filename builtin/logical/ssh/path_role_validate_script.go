@@ -0,0 +1,198 @@
+package ssh
+
+import (
+	"bytes"
+	"context"
+	"fmt"
+	"net/http"
+	"os/exec"
+	"path"
+	"strings"
+	"time"
+
+	"github.com/hashicorp/vault/helper/strutil"
+	"github.com/hashicorp/vault/logical"
+	"github.com/hashicorp/vault/logical/framework"
+)
+
+// The syntax check of an install script is abandoned after this long
+const scriptSyntaxCheckTimeout = 10 * time.Second
+
+// Interpreters whose scripts are checked with 'bash -n'
+var syntaxCheckedInterpreters = []string{"sh", "bash", "dash"}
+
+func pathRoleValidateScript(b *backend) *framework.Path {
+	return &framework.Path{
+		Pattern: "roles/" + framework.GenericNameRegex("role") + "/validate-script$",
+		Fields: map[string]*framework.FieldSchema{
+			"role": &framework.FieldSchema{
+				Type:        framework.TypeNameString,
+				Description: "[Required] Name of the dynamic role",
+			},
+			"install_script": &framework.FieldSchema{
+				Type: framework.TypeString,
+				Description: `Script to validate instead of the install script of
+				the role, e.g. before updating the role.`,
+			},
+		},
+		Operations: map[logical.Operation]*framework.PathOperation{
+			logical.UpdateOperation: &framework.PathOperation{
+				Callback: b.pathRoleValidateScript,
+				Summary:  "Check the install script of a dynamic role without running it.",
+			},
+		},
+		HelpSynopsis:    pathRoleValidateScriptSyn,
+		HelpDescription: pathRoleValidateScriptDesc,
+	}
+}
+
+func (b *backend) pathRoleValidateScript(req *logical.Request, d *framework.FieldData) (*logical.Response, error) {
+	if err := checkFieldTypes(d); err != nil {
+		return nil, err
+	}
+
+	roleName := d.Get("role").(string)
+	role, err := b.getRole(req.Storage, roleName)
+	if err != nil {
+		return nil, err
+	}
+	if role == nil {
+		return nil, logical.CodedError(http.StatusNotFound, fmt.Sprintf("Unknown role: %s", roleName))
+	}
+	if role.KeyType != KeyTypeDynamic {
+		return logical.ErrorResponse("install scripts only apply to roles of type 'dynamic'"), nil
+	}
+
+	installScript := d.Get("install_script").(string)
+	if installScript == "" {
+		installScript, err = b.installScript(req.Storage, role)
+		if err != nil {
+			return nil, err
+		}
+	}
+	maxSize, err := b.maxInstallScriptSize(req.Storage)
+	if err != nil {
+		return nil, err
+	}
+
+	resp := &logical.Response{}
+	diagnostics := []string{}
+	if err := checkInstallScript(installScript, maxSize); err != nil {
+		diagnostics = append(diagnostics, err.Error())
+	} else {
+		// Templates are rendered for both operations, since their branches
+		// differ, with values resembling those of an actual installation.
+		rendered := map[string]string{"": installScript}
+		if isInstallScriptTemplate(installScript) {
+			rendered = map[string]string{}
+			for _, install := range []bool{true, false} {
+				script, err := renderInstallScript(installScript, &installScriptData{
+					PublicKeyFile:      "00000000-0000-0000-0000-000000000000",
+					Username:           role.DefaultUser,
+					AuthorizedKeysPath: expandAuthorizedKeysPath(role.AuthorizedKeysPath, role.DefaultUser),
+					Install:            install,
+					Uninstall:          !install,
+				})
+				if err != nil {
+					return nil, err
+				}
+				operation := "uninstall"
+				if install {
+					operation = "install"
+				}
+				rendered[operation] = script
+			}
+		}
+
+		interpreter := scriptInterpreter(installScript)
+		bash, err := exec.LookPath("bash")
+		switch {
+		case !strutil.StrListContains(syntaxCheckedInterpreters, interpreter):
+			resp.AddWarning(fmt.Sprintf("The syntax of %q scripts is not checked", interpreter))
+		case err != nil:
+			resp.AddWarning("bash is not available on the Vault server; the syntax was not checked")
+		default:
+			for _, operation := range []string{"", "install", "uninstall"} {
+				script, ok := rendered[operation]
+				if !ok {
+					continue
+				}
+				for _, diagnostic := range checkScriptSyntax(bash, script) {
+					if operation != "" {
+						diagnostic = operation + ": " + diagnostic
+					}
+					diagnostics = append(diagnostics, diagnostic)
+				}
+			}
+		}
+	}
+
+	resp.Data = map[string]interface{}{
+		"valid":       len(diagnostics) == 0,
+		"diagnostics": diagnostics,
+	}
+	return resp, nil
+}
+
+// scriptInterpreter returns the name of the interpreter named by the
+// interpreter line of the script, looking through 'env'.
+func scriptInterpreter(script string) string {
+	line := strings.TrimLeft(script, " \t\r\n")
+	if i := strings.IndexByte(line, '\n'); i >= 0 {
+		line = line[:i]
+	}
+	fields := strings.Fields(strings.TrimPrefix(line, "#!"))
+	if len(fields) == 0 {
+		return ""
+	}
+	interpreter := path.Base(fields[0])
+	if interpreter == "env" && len(fields) > 1 {
+		interpreter = path.Base(fields[1])
+	}
+	return interpreter
+}
+
+// checkScriptSyntax parses the script with 'bash -n' without running it and
+// returns the reported errors.
+func checkScriptSyntax(bash, script string) []string {
+	ctx, cancel := context.WithTimeout(context.Background(), scriptSyntaxCheckTimeout)
+	defer cancel()
+
+	var stderr bytes.Buffer
+	cmd := exec.CommandContext(ctx, bash, "-n")
+	cmd.Stdin = strings.NewReader(script)
+	cmd.Stderr = &stderr
+	err := cmd.Run()
+	if err == nil {
+		return nil
+	}
+	if ctx.Err() != nil {
+		return []string{"syntax check timed out"}
+	}
+
+	var diagnostics []string
+	for _, line := range strings.Split(strings.TrimSpace(stderr.String()), "\n") {
+		if line = strings.TrimSpace(line); line != "" {
+			diagnostics = append(diagnostics, strings.TrimPrefix(line, "bash: "))
+		}
+	}
+	if len(diagnostics) == 0 {
+		diagnostics = append(diagnostics, fmt.Sprintf("syntax check failed: %v", err))
+	}
+	return diagnostics
+}
+
+const pathRoleValidateScriptSyn = `
+Check the install script of a dynamic role without running it.
+`
+
+const pathRoleValidateScriptDesc = `
+Checks the install script of the role, or the given 'install_script', the way
+it would be checked when written, then parses it with 'bash -n' on the Vault
+server to find syntax errors. Scripts written as templates are rendered with
+sample values for installing and uninstalling keys, and both are checked. No
+target is connected to and nothing is run.
+
+Returns whether the script is valid along with the problems found. Scripts
+for other interpreters than sh, bash and dash are not parsed.
+`
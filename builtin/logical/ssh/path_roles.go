@@ -2,12 +2,15 @@ package ssh
 
 import (
 	"fmt"
+	"regexp"
+	"strconv"
 	"strings"
 
 	"time"
 
-	"github.com/hashicorp/vault/helper/cidrutil"
+	"github.com/hashicorp/vault/helper/jsonutil"
 	"github.com/hashicorp/vault/helper/parseutil"
+	"github.com/hashicorp/vault/helper/strutil"
 	"github.com/hashicorp/vault/logical"
 	"github.com/hashicorp/vault/logical/framework"
 )
@@ -18,22 +21,132 @@ const (
 	KeyTypeCA      = "ca"
 )
 
+const (
+	KeyAlgorithmRSA     = "rsa"
+	KeyAlgorithmECDSA   = "ecdsa"
+	KeyAlgorithmED25519 = "ed25519"
+)
+
+const (
+	PrivateKeyFormatPEM     = "pem"
+	PrivateKeyFormatOpenSSH = "openssh"
+)
+
+const (
+	OTPFormatUUID   = "uuid"
+	OTPFormatBase32 = "base32"
+	OTPFormatDigits = "digits"
+)
+
+const (
+	otpBase32Alphabet = "ABCDEFGHIJKLMNOPQRSTUVWXYZ234567"
+	otpDigitsAlphabet = "0123456789"
+
+	// Shorter OTPs are too easy to guess; an 8 digit OTP still has only
+	// about 26 bits of entropy.
+	minOTPLength = 8
+	maxOTPLength = 64
+
+	// Upper bound for the number of times an OTP of a role can be verified
+	maxOTPUses = 100
+)
+
+var defaultOTPLengths = map[string]int{
+	OTPFormatBase32: 16,
+	OTPFormatDigits: 10,
+}
+
+// RSA dynamic keys are 2048 bits long unless the role sets one of the other
+// allowed lengths. 1024 bit keys are still accepted for existing roles but are
+// deprecated.
+const (
+	defaultRSAKeyBits    = 2048
+	deprecatedRSAKeyBits = 1024
+)
+
+var allowedRSAKeyBits = []int{1024, 2048, 3072, 4096, 8192}
+
+// Install scripts of roles using sudo are run with this command unless the
+// role configures another. '-n' makes sudo fail instead of prompting for a
+// password.
+const defaultSudoCommand = "sudo -n"
+
+// cidrList holds the CIDR blocks of a role. Roles written before the blocks
+// were stored as a list hold them as a comma separated string, which is
+// accepted when decoding.
+type cidrList []string
+
+// UnmarshalJSON implements JSON unmarshaling
+func (c *cidrList) UnmarshalJSON(data []byte) error {
+	var list []string
+	if err := jsonutil.DecodeJSON(data, &list); err == nil {
+		*c = list
+		return nil
+	}
+
+	var legacy string
+	if err := jsonutil.DecodeJSON(data, &legacy); err != nil {
+		return err
+	}
+	*c = nil
+	for _, item := range strings.Split(legacy, ",") {
+		if item = strings.TrimSpace(item); item != "" {
+			*c = append(*c, item)
+		}
+	}
+	return nil
+}
+
+// list returns the blocks as a non-nil slice, so that an empty list is
+// returned as an empty array rather than null.
+func (c cidrList) list() []string {
+	if c == nil {
+		return []string{}
+	}
+	return c
+}
+
 // Structure that represents a role in SSH backend. This is a common role structure
 // for both OTP and Dynamic roles. Not all the fields are mandatory for both type.
 // Some are applicable for one and not for other. It doesn't matter.
 type sshRole struct {
 	KeyType                string            `mapstructure:"key_type" json:"key_type"`
 	KeyName                string            `mapstructure:"key" json:"key"`
+	KeyNames               []string          `mapstructure:"key_names" json:"key_names"`
 	KeyBits                int               `mapstructure:"key_bits" json:"key_bits"`
+	Algorithm              string            `mapstructure:"algorithm" json:"algorithm"`
+	Curve                  int               `mapstructure:"curve" json:"curve"`
+	PrivateKeyFormat       string            `mapstructure:"private_key_format" json:"private_key_format"`
 	AdminUser              string            `mapstructure:"admin_user" json:"admin_user"`
 	DefaultUser            string            `mapstructure:"default_user" json:"default_user"`
-	CIDRList               string            `mapstructure:"cidr_list" json:"cidr_list"`
-	ExcludeCIDRList        string            `mapstructure:"exclude_cidr_list" json:"exclude_cidr_list"`
+	DefaultUserTemplate    string            `mapstructure:"default_user_template" json:"default_user_template"`
+	CIDRList               cidrList          `mapstructure:"cidr_list" json:"cidr_list"`
+	ExcludeCIDRList        cidrList          `mapstructure:"exclude_cidr_list" json:"exclude_cidr_list"`
 	Port                   int               `mapstructure:"port" json:"port"`
+	AllowedPorts           string            `mapstructure:"allowed_ports" json:"allowed_ports"`
 	InstallScript          string            `mapstructure:"install_script" json:"install_script"`
 	AllowedUsers           string            `mapstructure:"allowed_users" json:"allowed_users"`
 	AllowedDomains         string            `mapstructure:"allowed_domains" json:"allowed_domains"`
+	ResolveHostnames       bool              `mapstructure:"resolve_hostnames" json:"resolve_hostnames"`
+	OTPFormat              string            `mapstructure:"otp_format" json:"otp_format"`
+	OTPLength              int               `mapstructure:"otp_length" json:"otp_length"`
+	OTPMaxUses             int               `mapstructure:"otp_max_uses" json:"otp_max_uses"`
+	OTPTTL                 time.Duration     `mapstructure:"otp_ttl" json:"otp_ttl"`
+	BindToClientIP         bool              `mapstructure:"bind_to_client_ip" json:"bind_to_client_ip"`
+	AllowedRedeemerCIDRs   cidrList          `mapstructure:"allowed_redeemer_cidrs" json:"allowed_redeemer_cidrs"`
 	KeyOptionSpecs         string            `mapstructure:"key_option_specs" json:"key_option_specs"`
+	AuthorizedKeysPath     string            `mapstructure:"authorized_keys_path" json:"authorized_keys_path"`
+	ConnectionTimeout      time.Duration     `mapstructure:"connection_timeout" json:"connection_timeout"`
+	ConnectionRetries      int               `mapstructure:"connection_retries" json:"connection_retries"`
+	BastionHost            string            `mapstructure:"bastion_host" json:"bastion_host"`
+	BastionPort            int               `mapstructure:"bastion_port" json:"bastion_port"`
+	BastionKeyName         string            `mapstructure:"bastion_key_name" json:"bastion_key_name"`
+	KnownHosts             string            `mapstructure:"known_hosts" json:"known_hosts"`
+	InsecureIgnoreHostKey  bool              `mapstructure:"insecure_ignore_host_key" json:"insecure_ignore_host_key"`
+	UseSudo                bool              `mapstructure:"use_sudo" json:"use_sudo"`
+	SudoCommand            string            `mapstructure:"sudo_command" json:"sudo_command"`
+	MaxCredsPerMinute      int               `mapstructure:"max_creds_per_minute" json:"max_creds_per_minute"`
+	MaxCredsBurst          int               `mapstructure:"max_creds_burst" json:"max_creds_burst"`
 	MaxTTL                 string            `mapstructure:"max_ttl" json:"max_ttl"`
 	TTL                    string            `mapstructure:"ttl" json:"ttl"`
 	DefaultCriticalOptions map[string]string `mapstructure:"default_critical_options" json:"default_critical_options"`
@@ -52,8 +165,13 @@ func pathListRoles(b *backend) *framework.Path {
 	return &framework.Path{
 		Pattern: "roles/?$",
 
-		Callbacks: map[logical.Operation]framework.OperationFunc{
-			logical.ListOperation: b.pathRoleList,
+		Operations: map[logical.Operation]*framework.PathOperation{
+			logical.ListOperation: &framework.PathOperation{
+				Callback:    b.pathRoleList,
+				Summary:     "List the roles.",
+				StandbySafe: true,
+				Response:    listResponse("Names of the roles.", "Key type of the roles."),
+			},
 		},
 
 		HelpSynopsis:    pathRoleHelpSyn,
@@ -66,7 +184,7 @@ func pathRoles(b *backend) *framework.Path {
 		Pattern: "roles/" + framework.GenericNameRegex("role"),
 		Fields: map[string]*framework.FieldSchema{
 			"role": &framework.FieldSchema{
-				Type: framework.TypeString,
+				Type: framework.TypeNameString,
 				Description: `
 				[Required for all types]
 				Name of the role being created.`,
@@ -78,6 +196,14 @@ func pathRoles(b *backend) *framework.Path {
 				Name of the registered key in Vault. Before creating the role, use the
 				'keys/' endpoint to create a named key.`,
 			},
+			"key_names": &framework.FieldSchema{
+				Type: framework.TypeCommaStringSlice,
+				Description: `
+				[Dynamic type only] [Not applicable for OTP type] [Not applicable for CA type]
+				Names of registered keys in Vault, tried in order to connect to the
+				targets. Targets rejecting a key are tried with the next one. Mutually
+				exclusive with 'key'.`,
+			},
 			"admin_user": &framework.FieldSchema{
 				Type: framework.TypeString,
 				Description: `
@@ -96,23 +222,45 @@ func pathRoles(b *backend) *framework.Path {
 				When the endpoint 'creds/' is used without a username, this
 				value will be used as default username.`,
 			},
-			"cidr_list": &framework.FieldSchema{
+			"default_user_template": &framework.FieldSchema{
 				Type: framework.TypeString,
 				Description: `
 				[Optional for Dynamic type] [Optional for OTP type] [Not applicable for CA type]
-				Comma separated list of CIDR blocks for which the role is applicable for.
-				CIDR blocks can belong to more than one role.`,
+				Template rendering the username used when 'creds/' is called without a
+				username, from the token of the request, e.g. '{{.DisplayName | lowercase}}'.
+				'{{.DisplayName}}' and '{{.Metadata.<key>}}' are available, along with the
+				functions 'lowercase', 'uppercase' and 'replace'. Falls back to
+				default_user if the template renders empty. Rendered usernames must be
+				valid account names and present in allowed_users.`,
+			},
+			"cidr_list": &framework.FieldSchema{
+				Type: framework.TypeCommaStringSlice,
+				Description: `
+				[Optional for Dynamic type] [Optional for OTP type] [Not applicable for CA type]
+				List of CIDR blocks for which the role is applicable for, given as an
+				array or a comma separated string. CIDR blocks can belong to more than
+				one role. Entries without a mask are taken to be single hosts.`,
+			},
+			"check_overlaps": &framework.FieldSchema{
+				Type: framework.TypeBool,
+				Description: `
+				[Optional for Dynamic type] [Optional for OTP type] [Not applicable for CA type]
+				If set, the other roles are searched for CIDR blocks intersecting the
+				'cidr_list' of this role and a warning naming them is returned. The
+				setting is not stored.`,
 			},
 			"exclude_cidr_list": &framework.FieldSchema{
-				Type: framework.TypeString,
+				Type: framework.TypeCommaStringSlice,
 				Description: `
 				[Optional for Dynamic type] [Optional for OTP type] [Not applicable for CA type]
-				Comma separated list of CIDR blocks. IP addresses belonging to these blocks are not
+				List of CIDR blocks. IP addresses belonging to these blocks are not
 				accepted by the role. This is particularly useful when big CIDR blocks are being used
 				by the role and certain parts of it needs to be kept out.`,
 			},
 			"port": &framework.FieldSchema{
-				Type: framework.TypeInt,
+				Type:      framework.TypeInt,
+				Default:   22,
+				Validator: validateRolePort,
 				Description: `
 				[Optional for Dynamic type] [Optional for OTP type] [Not applicable for CA type]
 				Port number for SSH connection. Default is '22'. Port number does not
@@ -120,9 +268,37 @@ func pathRoles(b *backend) *framework.Path {
 				to inform client about the port number to use. Port number will be
 				returned to client by Vault server along with OTP.`,
 			},
-			"key_type": &framework.FieldSchema{
+			"allowed_ports": &framework.FieldSchema{
 				Type: framework.TypeString,
 				Description: `
+				[Optional for Dynamic type] [Optional for OTP type] [Not applicable for CA type]
+				Comma separated list of ports and port ranges, such as '22,2200-2299',
+				which clients can request instead of 'port' when generating credentials.
+				'port' must be one of them. If not set, only 'port' can be used. The
+				list is stored sorted, with overlapping ranges merged.`,
+			},
+			"max_creds_per_minute": &framework.FieldSchema{
+				Type:      framework.TypeInt,
+				Validator: validateNotNegative,
+				Description: `
+				[Optional for Dynamic type] [Optional for OTP type] [Not applicable for CA type]
+				Maximum number of credentials issued for the role per minute. Requests
+				beyond it are refused until the limit allows them again. Defaults to 0,
+				which does not limit the rate.`,
+			},
+			"max_creds_burst": &framework.FieldSchema{
+				Type:      framework.TypeInt,
+				Validator: validateNotNegative,
+				Description: `
+				[Optional for Dynamic type] [Optional for OTP type] [Not applicable for CA type]
+				Number of credentials which can be issued at once before
+				'max_creds_per_minute' takes effect. Defaults to 'max_creds_per_minute'.`,
+			},
+			"key_type": &framework.FieldSchema{
+				Type:      framework.TypeString,
+				Validator: validateKeyType,
+				Required:  true,
+				Description: `
 				[Required for all types]
 				Type of key used to login to hosts. It can be either 'otp', 'dynamic' or 'ca'.
 				'otp' type requires agent to be installed in remote hosts.`,
@@ -131,18 +307,38 @@ func pathRoles(b *backend) *framework.Path {
 				Type: framework.TypeInt,
 				Description: `
 				[Optional for Dynamic type] [Not applicable for OTP type] [Not applicable for CA type]
-				Length of the RSA dynamic key in bits. It is 1024 by default or it can be 2048.`,
+				Length of the RSA dynamic key in bits. It is 2048 by default or it can be
+				3072, 4096 or 8192. 1024 is accepted as well but is deprecated.`,
+			},
+			"algorithm": &framework.FieldSchema{
+				Type:    framework.TypeString,
+				Default: KeyAlgorithmRSA,
+				Description: `
+				[Optional for Dynamic type] [Not applicable for OTP type] [Not applicable for CA type]
+				Algorithm of the dynamic key. It can be 'rsa', 'ecdsa' or 'ed25519'. Defaults to 'rsa'.`,
+			},
+			"curve": &framework.FieldSchema{
+				Type: framework.TypeInt,
+				Description: `
+				[Optional for Dynamic type] [Not applicable for OTP type] [Not applicable for CA type]
+				Size of the elliptic curve used by 'ecdsa' dynamic keys. It can be 256, 384
+				or 521. Defaults to 256.`,
 			},
 			"install_script": &framework.FieldSchema{
 				Type: framework.TypeString,
 				Description: `
 				[Optional for Dynamic type] [Not-applicable for OTP type] [Not applicable for CA type]
 				Script used to install and uninstall public keys in the target machine.
-				The inbuilt default install script will be for Linux hosts. For sample
-				script, refer the project documentation website.`,
+				If not specified, the script configured at 'config/install_script' is
+				used, which defaults to the inbuilt install script for Linux hosts. For
+				sample script, refer the project documentation website. Scripts can use
+				the placeholders '{{.PublicKeyFile}}', '{{.Username}}',
+				'{{.AuthorizedKeysPath}}', '{{.Install}}' and '{{.Uninstall}}' instead
+				of the positional arguments. The script must start with an interpreter
+				line and is limited to the 'max_size' of 'config/install_script'.`,
 			},
 			"allowed_users": &framework.FieldSchema{
-				Type: framework.TypeString,
+				Type: framework.TypeCommaStringSlice,
 				Description: `
 				[Optional for all types] [Works differently for CA type]
 				If this option is not specified, or is '*', client can request a
@@ -158,11 +354,85 @@ func pathRoles(b *backend) *framework.Path {
 				`,
 			},
 			"allowed_domains": &framework.FieldSchema{
-				Type: framework.TypeString,
+				Type: framework.TypeCommaStringSlice,
 				Description: `
-				[Not applicable for Dynamic type] [Not applicable for OTP type] [Optional for CA type]
+				[Optional for Dynamic type] [Optional for OTP type] [Optional for CA type]
 				If this option is not specified, client can request for a signed certificate for any
 				valid host. If only certain domains are allowed, then this list enforces it.
+				For Dynamic and OTP types, this is a comma separated list of hostname
+				patterns, e.g. "*.prod.example.com", that a 'hostname' supplied to the
+				creds endpoint must match.
+				`,
+			},
+			"resolve_hostnames": &framework.FieldSchema{
+				Type: framework.TypeBool,
+				Description: `
+				[Optional for Dynamic type] [Optional for OTP type] [Not applicable for CA type]
+				If set, the creds endpoint accepts a 'hostname' instead of an 'ip'. The
+				hostname is resolved and every address it resolves to must be allowed
+				by the role. Defaults to false.
+				`,
+			},
+			"private_key_format": &framework.FieldSchema{
+				Type: framework.TypeString,
+				Description: `
+				[Optional for Dynamic type] [Not applicable for OTP type] [Not applicable for CA type]
+				Encoding of the generated private keys. Can be 'pem' for the PKCS#1
+				(RSA) and SEC1 (ECDSA) PEM encodings, or 'openssh' for the OpenSSH
+				private key format. Defaults to 'pem', except for ed25519 keys which
+				only support 'openssh'.
+				`,
+			},
+			"otp_format": &framework.FieldSchema{
+				Type: framework.TypeString,
+				Description: `
+				[Not applicable for Dynamic type] [Optional for OTP type] [Not applicable for CA type]
+				Format of the generated OTPs. Can be 'uuid', 'base32' or 'digits'.
+				Defaults to 'uuid'.
+				`,
+			},
+			"otp_length": &framework.FieldSchema{
+				Type: framework.TypeInt,
+				Description: `
+				[Not applicable for Dynamic type] [Optional for OTP type] [Not applicable for CA type]
+				Number of characters in the generated OTPs. Not applicable for the
+				'uuid' format. Must be between 8 and 64; defaults to 16 for 'base32'
+				and 10 for 'digits'.
+				`,
+			},
+			"otp_max_uses": &framework.FieldSchema{
+				Type:    framework.TypeInt,
+				Default: 1,
+				Description: `
+				[Not applicable for Dynamic type] [Optional for OTP type] [Not applicable for CA type]
+				Number of times each OTP can be verified before it is deleted, for
+				instance to hop through a bastion host with the same OTP. Must be
+				between 1 and 100; defaults to 1.
+				`,
+			},
+			"otp_ttl": &framework.FieldSchema{
+				Type: framework.TypeDurationSecond,
+				Description: `
+				[Not applicable for Dynamic type] [Optional for OTP type] [Not applicable for CA type]
+				Duration within which the OTPs must be verified, independently of their
+				lease, which can last longer. Defaults to the lease duration.
+				`,
+			},
+			"bind_to_client_ip": &framework.FieldSchema{
+				Type: framework.TypeBool,
+				Description: `
+				[Not applicable for Dynamic type] [Optional for OTP type] [Not applicable for CA type]
+				If set, OTPs can only be verified for SSH connections coming from the
+				address which requested them, as reported by the helper on the target.
+				`,
+			},
+			"allowed_redeemer_cidrs": &framework.FieldSchema{
+				Type: framework.TypeCommaStringSlice,
+				Description: `
+				[Not applicable for Dynamic type] [Optional for OTP type] [Not applicable for CA type]
+				CIDR blocks SSH connections can come from, in addition to the address
+				which requested the OTP, for instance when clients reach Vault through
+				NAT. Requires bind_to_client_ip.
 				`,
 			},
 			"key_option_specs": &framework.FieldSchema{
@@ -171,23 +441,113 @@ func pathRoles(b *backend) *framework.Path {
 				[Optional for Dynamic type] [Not applicable for OTP type] [Not applicable for CA type]
 				Comma separated option specifications which will be prefixed to RSA key in
 				authorized_keys file. Options should be valid and comply with authorized_keys
-				file format and should not contain spaces.
+				file format and should not contain spaces outside of quoted values. Newlines
+				and '#' characters are not allowed.
 				`,
 			},
-			"ttl": &framework.FieldSchema{
+			"authorized_keys_path": &framework.FieldSchema{
 				Type: framework.TypeString,
 				Description: `
-				[Not applicable for Dynamic type] [Not applicable for OTP type] [Optional for CA type]
+				[Optional for Dynamic type] [Not applicable for OTP type] [Not applicable for CA type]
+				Path of the authorized_keys file the keys are installed in, for targets
+				whose sshd_config sets AuthorizedKeysFile. It must be absolute or start
+				with '~/' for the home directory of the user, and can contain '%u' or
+				'{{.Username}}' for the username. Defaults to
+				'/home/<username>/.ssh/authorized_keys'.
+				`,
+			},
+			"connection_timeout": &framework.FieldSchema{
+				Type: framework.TypeDurationSecond,
+				Description: `
+				[Optional for Dynamic type] [Not applicable for OTP type] [Not applicable for CA type]
+				Duration after which an attempt to connect to the target is abandoned.
+				Defaults to the 'connection_timeout' at 'config/connection'.
+				`,
+			},
+			"connection_retries": &framework.FieldSchema{
+				Type: framework.TypeInt,
+				Description: `
+				[Optional for Dynamic type] [Not applicable for OTP type] [Not applicable for CA type]
+				Number of times a failed attempt to connect to the target is retried.
+				Defaults to the 'connection_retries' at 'config/connection'.
+				`,
+			},
+			"bastion_host": &framework.FieldSchema{
+				Type: framework.TypeString,
+				Description: `
+				[Optional for Dynamic type] [Not applicable for OTP type] [Not applicable for CA type]
+				Host through which the targets are connected to, for targets which are
+				not reachable directly. Vault logs into the bastion as 'admin_user' and
+				tunnels the connection to the target through it.
+				`,
+			},
+			"bastion_port": &framework.FieldSchema{
+				Type: framework.TypeInt,
+				Description: `
+				[Optional for Dynamic type] [Not applicable for OTP type] [Not applicable for CA type]
+				Port number of the SSH server on 'bastion_host'. Defaults to 22.
+				`,
+			},
+			"bastion_key_name": &framework.FieldSchema{
+				Type: framework.TypeString,
+				Description: `
+				[Optional for Dynamic type] [Not applicable for OTP type] [Not applicable for CA type]
+				Name of the registered shared key used to log into 'bastion_host'.
+				Defaults to 'key'.
+				`,
+			},
+			"known_hosts": &framework.FieldSchema{
+				Type: framework.TypeString,
+				Description: `
+				[Optional for Dynamic type] [Not applicable for OTP type] [Not applicable for CA type]
+				Host keys of the targets and of 'bastion_host', in the format of
+				OpenSSH's known_hosts files. Connections to hosts presenting other
+				host keys fail.
+				`,
+			},
+			"insecure_ignore_host_key": &framework.FieldSchema{
+				Type: framework.TypeBool,
+				Description: `
+				[Optional for Dynamic type] [Not applicable for OTP type] [Not applicable for CA type]
+				Records that the host keys of the targets are deliberately not
+				verified, as for roles without 'known_hosts'. Mutually exclusive with
+				'known_hosts'.
+				`,
+			},
+			"use_sudo": &framework.FieldSchema{
+				Type: framework.TypeBool,
+				Description: `
+				[Optional for Dynamic type] [Not applicable for OTP type] [Not applicable for CA type]
+				If set, the install script is run with sudo, for admin users which are
+				not privileged to edit the authorized_keys files of other users. The
+				admin user must be allowed to run the script without a password.
+				`,
+			},
+			"sudo_command": &framework.FieldSchema{
+				Type: framework.TypeString,
+				Description: `
+				[Optional for Dynamic type] [Not applicable for OTP type] [Not applicable for CA type]
+				Command the install script is run with if 'use_sudo' is set. Defaults
+				to 'sudo -n'.
+				`,
+			},
+			"ttl": &framework.FieldSchema{
+				Type:    framework.TypeDurationSecond,
+				Aliases: []string{"lease"},
+				Description: `
+				[Optional for Dynamic type] [Optional for OTP type] [Optional for CA type]
 				The lease duration if no specific lease duration is
 				requested. The lease duration controls the expiration
-				of certificates issued by this backend. Defaults to
-				the value of max_ttl.`,
+				of credentials and certificates issued by this backend.
+				For the CA type, defaults to the value of max_ttl. For the
+				OTP and Dynamic types, defaults to the mount default.`,
 			},
 			"max_ttl": &framework.FieldSchema{
-				Type: framework.TypeString,
+				Type:    framework.TypeDurationSecond,
+				Aliases: []string{"lease_max"},
 				Description: `
-				[Not applicable for Dynamic type] [Not applicable for OTP type] [Optional for CA type]
-				The maximum allowed lease duration
+				[Optional for Dynamic type] [Optional for OTP type] [Optional for CA type]
+				The maximum allowed lease duration. Defaults to the mount maximum.
 				`,
 			},
 			"allowed_critical_options": &framework.FieldSchema{
@@ -207,23 +567,25 @@ func pathRoles(b *backend) *framework.Path {
 				`,
 			},
 			"default_critical_options": &framework.FieldSchema{
-				Type: framework.TypeMap,
+				Type: framework.TypeKVPairs,
 				Description: `
 				[Not applicable for Dynamic type] [Not applicable for OTP type]
 				[Optional for CA type] Critical options certificates should
 				have if none are provided when signing. This field takes in key
-				value pairs in JSON format.  Note that these are not restricted
-				by "allowed_critical_options". Defaults to none.
+				value pairs in JSON format or as repeated "name=value" pairs.
+				Note that these are not restricted by
+				"allowed_critical_options". Defaults to none.
 				`,
 			},
 			"default_extensions": &framework.FieldSchema{
-				Type: framework.TypeMap,
+				Type: framework.TypeKVPairs,
 				Description: `
 				[Not applicable for Dynamic type] [Not applicable for OTP type]
 				[Optional for CA type] Extensions certificates should have if
 				none are provided when signing. This field takes in key value
-				pairs in JSON format. Note that these are not restricted by
-				"allowed_extensions". Defaults to none.
+				pairs in JSON format or as repeated "name=value" pairs. Note
+				that these are not restricted by "allowed_extensions".
+				Defaults to none.
 				`,
 			},
 			"allow_user_certificates": &framework.FieldSchema{
@@ -277,49 +639,205 @@ func pathRoles(b *backend) *framework.Path {
 				'{{public_key_hash}}' - A SHA256 checksum of the public key that is being signed.
 				`,
 			},
+			"revoke_outstanding": &framework.FieldSchema{
+				Type: framework.TypeBool,
+				Description: `
+				[Only applicable when deleting the role]
+				If set, the dynamic keys issued by the role are removed from their targets
+				and its unused OTPs are deleted before the role is deleted.
+				`,
+			},
 		},
 
-		Callbacks: map[logical.Operation]framework.OperationFunc{
-			logical.ReadOperation:   b.pathRoleRead,
-			logical.UpdateOperation: b.pathRoleWrite,
-			logical.DeleteOperation: b.pathRoleDelete,
+		ExistenceCheck: b.pathRoleExistenceCheck,
+
+		Operations: map[logical.Operation]*framework.PathOperation{
+			logical.ReadOperation: &framework.PathOperation{
+				Callback:    b.pathRoleRead,
+				Summary:     "Read a role.",
+				StandbySafe: true,
+				Response:    roleResponse,
+			},
+			logical.CreateOperation: &framework.PathOperation{
+				Callback: b.pathRoleWrite,
+				Summary:  "Create a role; fields default when omitted.",
+			},
+			logical.UpdateOperation: &framework.PathOperation{
+				Callback: b.pathRoleWrite,
+				Summary:  "Update the given fields of a role, keeping the others.",
+			},
+			logical.DeleteOperation: &framework.PathOperation{
+				Callback: b.pathRoleDelete,
+				Summary:  "Remove a role.",
+			},
 		},
 
+		ValidateFieldValues: true,
+
 		HelpSynopsis:    pathRoleHelpSyn,
 		HelpDescription: pathRoleHelpDesc,
 	}
 }
 
+// validateKeyType checks the key_type of a role. Key types are not case
+// sensitive.
+func validateKeyType(v interface{}) error {
+	switch strings.ToLower(v.(string)) {
+	case "", KeyTypeOTP, KeyTypeDynamic, KeyTypeCA:
+		return nil
+	}
+	return fmt.Errorf("must be %q, %q or %q", KeyTypeOTP, KeyTypeDynamic, KeyTypeCA)
+}
+
+// validateRolePort checks the port of a role. 0 selects the default port.
+func validateRolePort(v interface{}) error {
+	port := v.(int)
+	if port != 0 && (port < minPort || port > maxPort) {
+		return fmt.Errorf("must be between %d and %d", minPort, maxPort)
+	}
+	return nil
+}
+
+func validateNotNegative(v interface{}) error {
+	if v.(int) < 0 {
+		return fmt.Errorf("must not be negative")
+	}
+	return nil
+}
+
+func (b *backend) pathRoleExistenceCheck(req *logical.Request, d *framework.FieldData) (bool, error) {
+	role, err := b.getRole(req.Storage, d.Get("role").(string))
+	if err != nil {
+		return false, err
+	}
+	return role != nil, nil
+}
+
+// dependentRoleFields lists the fields whose values depend on another field.
+// When the field is given in an update, the fields depending on it are not
+// carried over from the role, since they may not suit its new value.
+var dependentRoleFields = map[string][]string{
+	"key":                      []string{"key_names"},
+	"key_names":                []string{"key"},
+	"algorithm":                []string{"key_bits", "curve", "private_key_format"},
+	"otp_format":               []string{"otp_length"},
+	"bastion_host":             []string{"bastion_port", "bastion_key_name"},
+	"known_hosts":              []string{"insecure_ignore_host_key"},
+	"insecure_ignore_host_key": []string{"known_hosts"},
+	"use_sudo":                 []string{"sudo_command"},
+	"bind_to_client_ip":        []string{"allowed_redeemer_cidrs"},
+	"max_creds_per_minute":     []string{"max_creds_burst"},
+}
+
+// mergeRoleFields carries the values of the role over to the fields which are
+// not given in an update, so that the role is updated rather than replaced.
+// Roles whose key type is changed are replaced.
+func (b *backend) mergeRoleFields(s logical.Storage, role *sshRole, d *framework.FieldData) error {
+	if keyType, ok := d.GetOk("key_type"); ok && !strings.EqualFold(keyType.(string), role.KeyType) {
+		return nil
+	}
+
+	current, err := b.roleData(s, role)
+	if err != nil {
+		return err
+	}
+	// The role stores either its own install script or none to use the
+	// shared one, and its keys are all in key_names
+	current["install_script"] = role.InstallScript
+	delete(current, "key")
+
+	skipped := make(map[string]bool)
+	for field := range d.Raw {
+		for _, dependent := range dependentRoleFields[field] {
+			skipped[dependent] = true
+		}
+	}
+	for field, value := range current {
+		if _, ok := d.Schema[field]; !ok || skipped[field] {
+			continue
+		}
+		// Fields given under a deprecated alias are given as well
+		if _, ok, _ := d.GetOkErr(field); !ok {
+			d.Raw[field] = value
+		}
+	}
+	return nil
+}
+
 func (b *backend) pathRoleWrite(req *logical.Request, d *framework.FieldData) (*logical.Response, error) {
+	if err := checkFieldTypes(d); err != nil {
+		return nil, err
+	}
+
 	roleName := d.Get("role").(string)
 	if roleName == "" {
 		return logical.ErrorResponse("missing role name"), nil
 	}
 
+	// Updates only change the fields they give
+	if req.Operation == logical.UpdateOperation {
+		role, err := b.getRole(req.Storage, roleName)
+		if err != nil {
+			return nil, err
+		}
+		if role != nil {
+			if err := b.mergeRoleFields(req.Storage, role, d); err != nil {
+				return nil, err
+			}
+		}
+	}
+
 	// Allowed users is an optional field, applicable for both OTP and Dynamic types.
-	allowedUsers := d.Get("allowed_users").(string)
+	// It is given as a list or a comma separated string and stored as the
+	// latter.
+	allowedUsers := strings.Join(d.Get("allowed_users").([]string), ",")
+	allowedDomains := strings.Join(d.Get("allowed_domains").([]string), ",")
 
-	// Validate the CIDR blocks
-	cidrList := d.Get("cidr_list").(string)
-	if cidrList != "" {
-		valid, err := cidrutil.ValidateCIDRListString(cidrList, ",")
-		if err != nil {
-			return nil, fmt.Errorf("failed to validate cidr_list: %v", err)
+	// Validate and normalize the CIDR blocks
+	var warnings []string
+	cidrList, cidrWarnings, err := normalizeCIDRList(d.Get("cidr_list").([]string))
+	if err != nil {
+		return logical.ErrorResponse(fmt.Sprintf("failed to validate cidr_list: %v", err)), nil
+	}
+	warnings = append(warnings, cidrWarnings...)
+	if len(cidrList) != 0 {
+		// A zero-address block would allow any IP. That has to be an
+		// explicit decision, made by registering the role with
+		// config/zeroaddress.
+		for _, item := range cidrList {
+			if !isZeroAddressCIDR(item) {
+				continue
+			}
+			registered, err := b.isZeroAddressRole(req.Storage, roleName)
+			if err != nil {
+				return nil, err
+			}
+			if !registered {
+				return logical.ErrorResponse(fmt.Sprintf("cidr_list entry %q allows any IP; to allow that, register the role with config/zeroaddress", item)), nil
+			}
+			break
 		}
-		if !valid {
-			return logical.ErrorResponse("failed to validate cidr_list"), nil
+		for _, item := range cidrList {
+			if isBroadCIDR(item) {
+				warnings = append(warnings, fmt.Sprintf("cidr_list entry %q is very broad; consider narrowing it to the hosts the role is meant for", item))
+			}
 		}
 	}
 
-	// Validate the excluded CIDR blocks
-	excludeCidrList := d.Get("exclude_cidr_list").(string)
-	if excludeCidrList != "" {
-		valid, err := cidrutil.ValidateCIDRListString(excludeCidrList, ",")
+	// Validate and normalize the excluded CIDR blocks
+	excludeCidrList, excludeCidrWarnings, err := normalizeCIDRList(d.Get("exclude_cidr_list").([]string))
+	if err != nil {
+		return logical.ErrorResponse(fmt.Sprintf("failed to validate exclude_cidr_list: %v", err)), nil
+	}
+	warnings = append(warnings, excludeCidrWarnings...)
+
+	if d.Get("check_overlaps").(bool) && len(cidrList) != 0 {
+		overlapping, err := b.rolesOverlappingCIDRs(req.Storage, roleName, cidrList)
 		if err != nil {
-			return nil, fmt.Errorf("failed to validate exclude_cidr_list entry: %v", err)
+			return nil, err
 		}
-		if !valid {
-			return logical.ErrorResponse(fmt.Sprintf("failed to validate exclude_cidr_list entry: %v", err)), nil
+		if len(overlapping) != 0 {
+			warnings = append(warnings, fmt.Sprintf("cidr_list intersects the CIDR blocks of roles: %s", strings.Join(overlapping, ", ")))
 		}
 	}
 
@@ -328,12 +846,68 @@ func (b *backend) pathRoleWrite(req *logical.Request, d *framework.FieldData) (*
 		port = 22
 	}
 
+	// The port of the role has to be one of the allowed ports as well
+	allowedPorts, err := normalizePortRanges(d.Get("allowed_ports").(string))
+	if err != nil {
+		return logical.ErrorResponse(fmt.Sprintf("failed to validate allowed_ports: %v", err)), nil
+	}
+	if allowedPorts != "" {
+		portRanges, err := parsePortRanges(allowedPorts)
+		if err != nil {
+			return nil, err
+		}
+		if !portInRanges(port, portRanges) {
+			return logical.ErrorResponse(fmt.Sprintf("port %d is not within allowed_ports %s", port, allowedPorts)), nil
+		}
+	}
+
+	maxCredsPerMinute := d.Get("max_creds_per_minute").(int)
+	maxCredsBurst := d.Get("max_creds_burst").(int)
+	if maxCredsBurst != 0 && maxCredsPerMinute == 0 {
+		return logical.ErrorResponse("max_creds_burst requires max_creds_per_minute"), nil
+	}
+	if maxCredsBurst == 0 {
+		maxCredsBurst = maxCredsPerMinute
+	}
+
 	keyType := d.Get("key_type").(string)
 	if keyType == "" {
 		return logical.ErrorResponse("missing key type"), nil
 	}
 	keyType = strings.ToLower(keyType)
 
+	// Without CIDR blocks, OTP and dynamic roles allow any IP, which needs
+	// the same explicit decision as a zero-address block
+	if (keyType == KeyTypeOTP || keyType == KeyTypeDynamic) && len(cidrList) == 0 {
+		registered, err := b.isZeroAddressRole(req.Storage, roleName)
+		if err != nil {
+			return nil, err
+		}
+		if !registered {
+			return logical.ErrorResponse("empty cidr_list allows any IP; to allow that, register the role with config/zeroaddress"), nil
+		}
+	}
+
+	defaultUserTemplate := d.Get("default_user_template").(string)
+	if defaultUserTemplate != "" {
+		if keyType == KeyTypeCA {
+			return logical.ErrorResponse("default_user_template is not applicable for the CA type"), nil
+		}
+		if err := validateDefaultUserTemplate(defaultUserTemplate); err != nil {
+			return logical.ErrorResponse(fmt.Sprintf("invalid default_user_template: %v", err)), nil
+		}
+	}
+
+	// The lease durations of OTP and dynamic credentials. The CA type
+	// validates these on its own.
+	ttl := roleTTL(d, "ttl")
+	maxTTL := roleTTL(d, "max_ttl")
+	if keyType == KeyTypeOTP || keyType == KeyTypeDynamic {
+		if errResp := b.validateRoleTTLs(ttl, maxTTL); errResp != nil {
+			return errResp, nil
+		}
+	}
+
 	var roleEntry sshRole
 	if keyType == KeyTypeOTP {
 		defaultUser := d.Get("default_user").(string)
@@ -348,37 +922,120 @@ func (b *backend) pathRoleWrite(req *logical.Request, d *framework.FieldData) (*
 			return logical.ErrorResponse("admin user not required for OTP type"), nil
 		}
 
+		otpFormat := strings.ToLower(d.Get("otp_format").(string))
+		otpLength := d.Get("otp_length").(int)
+		switch otpFormat {
+		case "", OTPFormatUUID:
+			otpFormat = OTPFormatUUID
+			if otpLength != 0 {
+				return logical.ErrorResponse("otp_length is not applicable for the uuid format"), nil
+			}
+		case OTPFormatBase32, OTPFormatDigits:
+			if otpLength == 0 {
+				otpLength = defaultOTPLengths[otpFormat]
+			}
+			if otpLength < minOTPLength || otpLength > maxOTPLength {
+				return logical.ErrorResponse(fmt.Sprintf("otp_length must be between %d and %d", minOTPLength, maxOTPLength)), nil
+			}
+		default:
+			return logical.ErrorResponse(fmt.Sprintf("invalid otp_format %q; must be %q, %q or %q", otpFormat, OTPFormatUUID, OTPFormatBase32, OTPFormatDigits)), nil
+		}
+
+		otpMaxUses := d.Get("otp_max_uses").(int)
+		if otpMaxUses == 0 {
+			otpMaxUses = 1
+		}
+		if otpMaxUses < 1 || otpMaxUses > maxOTPUses {
+			return logical.ErrorResponse(fmt.Sprintf("otp_max_uses must be between 1 and %d", maxOTPUses)), nil
+		}
+
+		otpTTL := time.Duration(d.Get("otp_ttl").(int)) * time.Second
+		if otpTTL < 0 {
+			return logical.ErrorResponse("otp_ttl must not be negative"), nil
+		}
+
+		bindToClientIP := d.Get("bind_to_client_ip").(bool)
+		allowedRedeemerCIDRs, redeemerWarnings, err := normalizeCIDRList(d.Get("allowed_redeemer_cidrs").([]string))
+		if err != nil {
+			return logical.ErrorResponse(fmt.Sprintf("failed to validate allowed_redeemer_cidrs: %v", err)), nil
+		}
+		if len(allowedRedeemerCIDRs) != 0 && !bindToClientIP {
+			return logical.ErrorResponse("allowed_redeemer_cidrs requires bind_to_client_ip"), nil
+		}
+		warnings = append(warnings, redeemerWarnings...)
+
 		// Below are the only fields used from the role structure for OTP type.
 		roleEntry = sshRole{
-			DefaultUser:     defaultUser,
-			CIDRList:        cidrList,
-			ExcludeCIDRList: excludeCidrList,
-			KeyType:         KeyTypeOTP,
-			Port:            port,
-			AllowedUsers:    allowedUsers,
+			DefaultUser:          defaultUser,
+			DefaultUserTemplate:  defaultUserTemplate,
+			CIDRList:             cidrList,
+			ExcludeCIDRList:      excludeCidrList,
+			KeyType:              KeyTypeOTP,
+			Port:                 port,
+			AllowedPorts:         allowedPorts,
+			AllowedUsers:         allowedUsers,
+			AllowedDomains:       allowedDomains,
+			ResolveHostnames:     d.Get("resolve_hostnames").(bool),
+			OTPFormat:            otpFormat,
+			OTPLength:            otpLength,
+			OTPMaxUses:           otpMaxUses,
+			OTPTTL:               otpTTL,
+			TTL:                  ttl,
+			MaxTTL:               maxTTL,
+			MaxCredsPerMinute:    maxCredsPerMinute,
+			MaxCredsBurst:        maxCredsBurst,
+			BindToClientIP:       bindToClientIP,
+			AllowedRedeemerCIDRs: allowedRedeemerCIDRs,
 		}
 	} else if keyType == KeyTypeDynamic {
 		defaultUser := d.Get("default_user").(string)
 		if defaultUser == "" {
 			return logical.ErrorResponse("missing default user"), nil
 		}
-		// Key name is required by dynamic type and not by OTP type.
+		// Key name is required by dynamic type and not by OTP type. Several
+		// keys can be given, which are tried in order.
 		keyName := d.Get("key").(string)
-		if keyName == "" {
+		keyNames := d.Get("key_names").([]string)
+		switch {
+		case keyName != "" && len(keyNames) != 0:
+			return logical.ErrorResponse("key and key_names are mutually exclusive"), nil
+		case keyName != "":
+			keyNames = []string{keyName}
+		case len(keyNames) == 0:
 			return logical.ErrorResponse("missing key name"), nil
 		}
-		keyEntry, err := req.Storage.Get(fmt.Sprintf("keys/%s", keyName))
-		if err != nil || keyEntry == nil {
-			return logical.ErrorResponse(fmt.Sprintf("invalid 'key': %q", keyName)), nil
+		for i, name := range keyNames {
+			if strutil.StrListContains(keyNames[:i], name) {
+				return logical.ErrorResponse(fmt.Sprintf("duplicate key name %q in 'key_names'", name)), nil
+			}
+			keyEntry, err := req.Storage.Get(fmt.Sprintf("keys/%s", name))
+			if err != nil || keyEntry == nil {
+				return logical.ErrorResponse(fmt.Sprintf("invalid 'key': %q", name)), nil
+			}
 		}
+		keyName = keyNames[0]
 
+		// An empty script makes the role use the shared install script
+		// configured at 'config/install_script'.
 		installScript := d.Get("install_script").(string)
+		if installScript != "" {
+			maxSize, err := b.maxInstallScriptSize(req.Storage)
+			if err != nil {
+				return nil, err
+			}
+			if err := checkInstallScript(installScript, maxSize); err != nil {
+				return logical.ErrorResponse(fmt.Sprintf("invalid install_script: %v", err)), nil
+			}
+		}
 		keyOptionSpecs := d.Get("key_option_specs").(string)
-
-		// Setting the default script here. The script will install the
-		// generated public key in the authorized_keys file of linux host.
-		if installScript == "" {
-			installScript = DefaultPublicKeyInstallScript
+		if err := validateKeyOptionSpecs(keyOptionSpecs); err != nil {
+			return logical.ErrorResponse(fmt.Sprintf("invalid key_option_specs: %v", err)), nil
+		}
+		authorizedKeysPath := d.Get("authorized_keys_path").(string)
+		if authorizedKeysPath != "" {
+			if err := validateAuthorizedKeysPath(authorizedKeysPath); err != nil {
+				return logical.ErrorResponse(fmt.Sprintf("invalid authorized_keys_path: %v", err)), nil
+			}
 		}
 
 		adminUser := d.Get("admin_user").(string)
@@ -386,30 +1043,156 @@ func (b *backend) pathRoleWrite(req *logical.Request, d *framework.FieldData) (*
 			return logical.ErrorResponse("missing admin username"), nil
 		}
 
-		// This defaults to 1024 and it can also be 2048.
+		algorithm := strings.ToLower(d.Get("algorithm").(string))
+		if algorithm == "" {
+			algorithm = KeyAlgorithmRSA
+		}
+
 		keyBits := d.Get("key_bits").(int)
-		if keyBits != 0 && keyBits != 1024 && keyBits != 2048 {
-			return logical.ErrorResponse("invalid key_bits field"), nil
+		curve := d.Get("curve").(int)
+		if curve != 0 && algorithm != KeyAlgorithmECDSA {
+			return logical.ErrorResponse("curve is only applicable for ecdsa keys"), nil
+		}
+
+		switch algorithm {
+		case KeyAlgorithmRSA:
+			if keyBits == 0 {
+				keyBits = defaultRSAKeyBits
+			}
+			valid := false
+			for _, allowed := range allowedRSAKeyBits {
+				if keyBits == allowed {
+					valid = true
+					break
+				}
+			}
+			if !valid {
+				return logical.ErrorResponse(fmt.Sprintf("invalid key_bits %d; must be one of %s", keyBits, strings.Trim(fmt.Sprint(allowedRSAKeyBits), "[]"))), nil
+			}
+			if keyBits == deprecatedRSAKeyBits {
+				warnings = append(warnings, "1024 bit RSA keys are deprecated; use key_bits of 2048 or more")
+			}
+		case KeyAlgorithmECDSA:
+			if keyBits != 0 {
+				return logical.ErrorResponse("key_bits is not applicable for ecdsa keys; use curve"), nil
+			}
+			switch curve {
+			case 0:
+				curve = 256
+			case 256, 384, 521:
+			default:
+				return logical.ErrorResponse(fmt.Sprintf("invalid curve %d; must be 256, 384 or 521", curve)), nil
+			}
+		case KeyAlgorithmED25519:
+			// ed25519 keys have a fixed size
+			if keyBits != 0 {
+				return logical.ErrorResponse("key_bits is not applicable for ed25519 keys"), nil
+			}
+		default:
+			return logical.ErrorResponse(fmt.Sprintf("invalid algorithm %q; must be %q, %q or %q", algorithm, KeyAlgorithmRSA, KeyAlgorithmECDSA, KeyAlgorithmED25519)), nil
+		}
+
+		privateKeyFormat := strings.ToLower(d.Get("private_key_format").(string))
+		if errResp := validatePrivateKeyFormat(algorithm, privateKeyFormat); errResp != nil {
+			return errResp, nil
+		}
+
+		connectionTimeout := time.Duration(d.Get("connection_timeout").(int)) * time.Second
+		connectionRetries := d.Get("connection_retries").(int)
+		if errResp := validateConnectionSettings(connectionTimeout, connectionRetries); errResp != nil {
+			return errResp, nil
 		}
 
-		// If user has not set this field, default it to 1024
-		if keyBits == 0 {
-			keyBits = 1024
+		// Targets which are only reachable through a bastion are connected
+		// to through it.
+		bastionHost := strings.TrimSpace(d.Get("bastion_host").(string))
+		if ip, ok := canonicalIP(bastionHost); ok {
+			// The port is joined to IPv6 addresses with brackets when
+			// connecting, so they are stored without
+			bastionHost = ip
+		}
+		bastionPort := d.Get("bastion_port").(int)
+		bastionKeyName := d.Get("bastion_key_name").(string)
+		if bastionHost == "" {
+			if bastionPort != 0 || bastionKeyName != "" {
+				return logical.ErrorResponse("bastion_port and bastion_key_name require bastion_host"), nil
+			}
+		} else {
+			if strings.ContainsAny(bastionHost, " \t/") {
+				return logical.ErrorResponse(fmt.Sprintf("invalid bastion_host %q", bastionHost)), nil
+			}
+			if bastionPort == 0 {
+				bastionPort = 22
+			}
+			if bastionPort < minPort || bastionPort > maxPort {
+				return logical.ErrorResponse(fmt.Sprintf("bastion_port must be between %d and %d", minPort, maxPort)), nil
+			}
+			if bastionKeyName == "" {
+				bastionKeyName = keyName
+			}
+			bastionKey, err := b.getKey(req.Storage, bastionKeyName)
+			if err != nil || bastionKey == nil {
+				return logical.ErrorResponse(fmt.Sprintf("invalid 'bastion_key_name': %q", bastionKeyName)), nil
+			}
+		}
+
+		// Without pinned host keys, the generated keys and the shared key
+		// could be handed to whoever intercepts the connections.
+		knownHosts := strings.TrimSpace(d.Get("known_hosts").(string))
+		insecureIgnoreHostKey := d.Get("insecure_ignore_host_key").(bool)
+		if knownHosts != "" {
+			if insecureIgnoreHostKey {
+				return logical.ErrorResponse("known_hosts and insecure_ignore_host_key are mutually exclusive"), nil
+			}
+			if _, err := parseKnownHosts(knownHosts); err != nil {
+				return logical.ErrorResponse(fmt.Sprintf("invalid known_hosts: %v", err)), nil
+			}
+		}
+
+		useSudo := d.Get("use_sudo").(bool)
+		sudoCommand := strings.TrimSpace(d.Get("sudo_command").(string))
+		if sudoCommand != "" && !useSudo {
+			return logical.ErrorResponse("sudo_command requires use_sudo"), nil
+		}
+		if strings.ContainsAny(sudoCommand, ";&|\n") {
+			return logical.ErrorResponse(fmt.Sprintf("invalid sudo_command %q", sudoCommand)), nil
 		}
 
 		// Store all the fields required by dynamic key type
 		roleEntry = sshRole{
-			KeyName:         keyName,
-			AdminUser:       adminUser,
-			DefaultUser:     defaultUser,
-			CIDRList:        cidrList,
-			ExcludeCIDRList: excludeCidrList,
-			Port:            port,
-			KeyType:         KeyTypeDynamic,
-			KeyBits:         keyBits,
-			InstallScript:   installScript,
-			AllowedUsers:    allowedUsers,
-			KeyOptionSpecs:  keyOptionSpecs,
+			KeyName:               keyName,
+			KeyNames:              keyNames,
+			AdminUser:             adminUser,
+			DefaultUser:           defaultUser,
+			DefaultUserTemplate:   defaultUserTemplate,
+			CIDRList:              cidrList,
+			ExcludeCIDRList:       excludeCidrList,
+			Port:                  port,
+			AllowedPorts:          allowedPorts,
+			KeyType:               KeyTypeDynamic,
+			KeyBits:               keyBits,
+			Algorithm:             algorithm,
+			Curve:                 curve,
+			PrivateKeyFormat:      privateKeyFormat,
+			InstallScript:         installScript,
+			AllowedUsers:          allowedUsers,
+			AllowedDomains:        allowedDomains,
+			ResolveHostnames:      d.Get("resolve_hostnames").(bool),
+			KeyOptionSpecs:        keyOptionSpecs,
+			AuthorizedKeysPath:    authorizedKeysPath,
+			ConnectionTimeout:     connectionTimeout,
+			ConnectionRetries:     connectionRetries,
+			BastionHost:           bastionHost,
+			BastionPort:           bastionPort,
+			BastionKeyName:        bastionKeyName,
+			KnownHosts:            knownHosts,
+			InsecureIgnoreHostKey: insecureIgnoreHostKey,
+			UseSudo:               useSudo,
+			SudoCommand:           sudoCommand,
+			TTL:                   ttl,
+			MaxTTL:                maxTTL,
+			MaxCredsPerMinute:     maxCredsPerMinute,
+			MaxCredsBurst:         maxCredsBurst,
 		}
 	} else if keyType == KeyTypeCA {
 		role, errorResponse := b.createCARole(allowedUsers, d.Get("default_user").(string), d)
@@ -429,19 +1212,27 @@ func (b *backend) pathRoleWrite(req *logical.Request, d *framework.FieldData) (*
 	if err := req.Storage.Put(entry); err != nil {
 		return nil, err
 	}
-	return nil, nil
+
+	if len(warnings) == 0 {
+		return nil, nil
+	}
+	resp := &logical.Response{}
+	for _, warning := range warnings {
+		resp.AddWarning(warning)
+	}
+	return resp, nil
 }
 
 func (b *backend) createCARole(allowedUsers, defaultUser string, data *framework.FieldData) (*sshRole, *logical.Response) {
 	role := &sshRole{
-		MaxTTL: data.Get("max_ttl").(string),
-		TTL:    data.Get("ttl").(string),
+		MaxTTL: roleTTL(data, "max_ttl"),
+		TTL:    roleTTL(data, "ttl"),
 		AllowedCriticalOptions: data.Get("allowed_critical_options").(string),
 		AllowedExtensions:      data.Get("allowed_extensions").(string),
 		AllowUserCertificates:  data.Get("allow_user_certificates").(bool),
 		AllowHostCertificates:  data.Get("allow_host_certificates").(bool),
 		AllowedUsers:           allowedUsers,
-		AllowedDomains:         data.Get("allowed_domains").(string),
+		AllowedDomains:         strings.Join(data.Get("allowed_domains").([]string), ","),
 		DefaultUser:            defaultUser,
 		AllowBareDomains:       data.Get("allow_bare_domains").(bool),
 		AllowSubdomains:        data.Get("allow_subdomains").(bool),
@@ -454,8 +1245,8 @@ func (b *backend) createCARole(allowedUsers, defaultUser string, data *framework
 		return nil, logical.ErrorResponse("Either 'allow_user_certificates' or 'allow_host_certificates' must be set to 'true'")
 	}
 
-	defaultCriticalOptions := convertMapToStringValue(data.Get("default_critical_options").(map[string]interface{}))
-	defaultExtensions := convertMapToStringValue(data.Get("default_extensions").(map[string]interface{}))
+	defaultCriticalOptions := data.Get("default_critical_options").(map[string]string)
+	defaultExtensions := data.Get("default_extensions").(map[string]string)
 
 	var maxTTL time.Duration
 	maxSystemTTL := b.System().MaxLeaseTTL()
@@ -503,6 +1294,284 @@ func (b *backend) createCARole(allowedUsers, defaultUser string, data *framework
 	return role, nil
 }
 
+// keyAlgorithm returns the algorithm of the dynamic keys generated for the
+// role. Roles created before the algorithm was configurable use RSA.
+func (r *sshRole) keyAlgorithm() string {
+	if r.Algorithm == "" {
+		return KeyAlgorithmRSA
+	}
+	return r.Algorithm
+}
+
+// privateKeyFormat returns the encoding of the private keys generated for the
+// role, falling back to the default of the key algorithm.
+func (r *sshRole) privateKeyFormat() string {
+	if r.PrivateKeyFormat != "" {
+		return r.PrivateKeyFormat
+	}
+	if r.keyAlgorithm() == KeyAlgorithmED25519 {
+		return PrivateKeyFormatOpenSSH
+	}
+	return PrivateKeyFormatPEM
+}
+
+// validatePrivateKeyFormat checks that private keys of the algorithm can be
+// encoded in the format. An empty format selects the default.
+func validatePrivateKeyFormat(algorithm, format string) *logical.Response {
+	switch format {
+	case "", PrivateKeyFormatOpenSSH:
+		return nil
+	case PrivateKeyFormatPEM:
+		if algorithm == KeyAlgorithmED25519 {
+			return logical.ErrorResponse("ed25519 keys can only use the openssh private_key_format")
+		}
+		return nil
+	default:
+		return logical.ErrorResponse(fmt.Sprintf("invalid private_key_format %q; must be %q or %q", format, PrivateKeyFormatPEM, PrivateKeyFormatOpenSSH))
+	}
+}
+
+// Characters allowed in authorized_keys paths once the username placeholders
+// are filled in. Anything else could be interpreted by the shell the install
+// script is run with.
+var authorizedKeysPathRegex = regexp.MustCompile(`^[A-Za-z0-9._/-]+$`)
+
+// validateAuthorizedKeysPath checks that the authorized_keys path of a role is
+// absolute or relative to the home directory of the user, names a file and
+// contains no characters which have a meaning to the shell.
+func validateAuthorizedKeysPath(path string) error {
+	if err := validateExpandedAuthorizedKeysPath(expandAuthorizedKeysPath(path, "user")); err != nil {
+		if err == errAuthorizedKeysPathCharacters {
+			return fmt.Errorf("must only contain letters, digits, '.', '_', '-' and '/' besides '%%u' and '{{.Username}}'")
+		}
+		return err
+	}
+	return nil
+}
+
+var errAuthorizedKeysPathCharacters = fmt.Errorf("must only contain letters, digits, '.', '_', '-' and '/'")
+
+// validateExpandedAuthorizedKeysPath checks an authorized_keys path with the
+// username filled in. Since the username comes from the request, this has to
+// be checked again for every username the path is expanded with.
+func validateExpandedAuthorizedKeysPath(expanded string) error {
+	if !strings.HasPrefix(expanded, "/") {
+		return fmt.Errorf("must be absolute or start with '~/'")
+	}
+	if !authorizedKeysPathRegex.MatchString(expanded) {
+		return errAuthorizedKeysPathCharacters
+	}
+	for _, segment := range strings.Split(expanded, "/") {
+		if segment == ".." {
+			return fmt.Errorf("must not contain '..'")
+		}
+	}
+	if strings.HasSuffix(expanded, "/") {
+		return fmt.Errorf("must name a file")
+	}
+	return nil
+}
+
+// expandAuthorizedKeysPath returns the path of the authorized_keys file of the
+// user, filling in the username and the home directory. An empty path yields
+// the default location.
+func expandAuthorizedKeysPath(path, username string) string {
+	if path == "" {
+		return fmt.Sprintf("/home/%s/.ssh/authorized_keys", username)
+	}
+	expanded := strings.NewReplacer("%u", username, "{{.Username}}", username).Replace(path)
+	if strings.HasPrefix(expanded, "~/") {
+		expanded = fmt.Sprintf("/home/%s/%s", username, expanded[2:])
+	}
+	return expanded
+}
+
+// validateKeyOptionSpecs checks that the option specifications can be prefixed
+// to a key in the authorized_keys file without corrupting it. Newlines would
+// split the entry and '#' would turn the rest of it into a comment. Outside of
+// double quoted values, options must not contain whitespace, since that ends
+// the options field.
+func validateKeyOptionSpecs(keyOptionSpecs string) error {
+	if strings.ContainsAny(keyOptionSpecs, "\r\n") {
+		return fmt.Errorf("must not contain newlines")
+	}
+	if strings.Contains(keyOptionSpecs, "#") {
+		return fmt.Errorf("must not contain '#'")
+	}
+
+	inQuote := false
+	for i := 0; i < len(keyOptionSpecs); i++ {
+		switch c := keyOptionSpecs[i]; {
+		case c == '\\' && inQuote:
+			// Skip the escaped character
+			i++
+		case c == '"':
+			inQuote = !inQuote
+		case (c == ' ' || c == '\t') && !inQuote:
+			return fmt.Errorf("must not contain whitespace outside of quoted values")
+		}
+	}
+	if inQuote {
+		return fmt.Errorf("unterminated quoted value")
+	}
+	return nil
+}
+
+// otpFormat returns the OTP format of the role. Roles created before the
+// format was configurable generate UUIDs.
+func (r *sshRole) otpFormat() string {
+	if r.OTPFormat == "" {
+		return OTPFormatUUID
+	}
+	return r.OTPFormat
+}
+
+// otpMaxUses returns the number of times the OTPs of the role can be
+// verified. OTPs of roles written before this was configurable are single
+// use.
+func (r *sshRole) otpMaxUses() int {
+	if r.OTPMaxUses == 0 {
+		return 1
+	}
+	return r.OTPMaxUses
+}
+
+// portAllowed reports whether credentials of the role can be generated for the
+// given port. Besides the port of the role, any port in allowed_ports can be
+// requested. Roles written before the port of the role had to be within
+// allowed_ports may not include it there.
+func (r *sshRole) portAllowed(port int) (bool, error) {
+	if port == r.Port {
+		return true, nil
+	}
+	portRanges, err := parsePortRanges(r.AllowedPorts)
+	if err != nil {
+		return false, err
+	}
+	return portInRanges(port, portRanges), nil
+}
+
+// allowedPortsList returns the ports which can be requested from the role, for
+// use in error messages.
+func (r *sshRole) allowedPortsList() string {
+	if r.AllowedPorts == "" {
+		return strconv.Itoa(r.Port)
+	}
+	if portRanges, err := parsePortRanges(r.AllowedPorts); err == nil && !portInRanges(r.Port, portRanges) {
+		return fmt.Sprintf("%d,%s", r.Port, r.AllowedPorts)
+	}
+	return r.AllowedPorts
+}
+
+// legacyDefaultPublicKeyInstallScript is the built-in script as copied into
+// roles while it started with a blank line.
+const legacyDefaultPublicKeyInstallScript = "\n" + DefaultPublicKeyInstallScript
+
+// usesSharedInstallScript reports whether the role installs keys with the
+// shared install script. Roles created before the script could be shared
+// stored a copy of the built-in script, which is treated the same as not
+// setting one.
+func (r *sshRole) usesSharedInstallScript() bool {
+	return r.InstallScript == "" ||
+		r.InstallScript == DefaultPublicKeyInstallScript ||
+		r.InstallScript == legacyDefaultPublicKeyInstallScript
+}
+
+// customInstallScript returns the install script defined by the role, or an
+// empty string if the role uses the shared install script.
+func (r *sshRole) customInstallScript() string {
+	if r.usesSharedInstallScript() {
+		return ""
+	}
+	return r.InstallScript
+}
+
+// sudoCommand returns the command the install script is run with, or an
+// empty string if the role does not use sudo.
+func (r *sshRole) sudoCommand() string {
+	if !r.UseSudo {
+		return ""
+	}
+	if r.SudoCommand == "" {
+		return defaultSudoCommand
+	}
+	return r.SudoCommand
+}
+
+// installScript returns the script used to install keys for the role.
+func (b *backend) installScript(s logical.Storage, role *sshRole) (string, error) {
+	if !role.usesSharedInstallScript() {
+		return role.InstallScript, nil
+	}
+	return b.sharedInstallScript(s)
+}
+
+// hostKeyNames returns the names of the keys used to connect to the targets,
+// in the order they are tried. Roles written before several keys could be
+// given only have KeyName.
+func (r *sshRole) hostKeyNames() []string {
+	if len(r.KeyNames) != 0 {
+		return r.KeyNames
+	}
+	if r.KeyName == "" {
+		return nil
+	}
+	return []string{r.KeyName}
+}
+
+// allowedUsersList returns the parsed list of users the role can generate
+// credentials for, in addition to the default user.
+func (r *sshRole) allowedUsersList() []string {
+	return strutil.RemoveDuplicates(strutil.ParseStringSlice(r.AllowedUsers, ","), false)
+}
+
+// roleTTL returns the ttl or max_ttl given for a role in the form roles store
+// them, or "" to leave it unset. Roles written before the fields took
+// durations may store any string parseutil.ParseDurationSecond accepts.
+func roleTTL(d *framework.FieldData, field string) string {
+	secs := d.Get(field).(int)
+	if secs == 0 {
+		return ""
+	}
+	return (time.Duration(secs) * time.Second).String()
+}
+
+// validateRoleTTLs checks the ttl and max_ttl of an OTP or dynamic role
+// against each other and the mount maximum.
+func (b *backend) validateRoleTTLs(ttlRaw, maxTTLRaw string) *logical.Response {
+	ttl, maxTTL, err := parseRoleTTLs(ttlRaw, maxTTLRaw)
+	if err != nil {
+		return logical.ErrorResponse(err.Error())
+	}
+	if maxSystemTTL := b.System().MaxLeaseTTL(); maxTTL > maxSystemTTL || ttl > maxSystemTTL {
+		return logical.ErrorResponse("ttl and max_ttl must not be higher than the backend maximum")
+	}
+	if maxTTL > 0 && ttl > maxTTL {
+		return logical.ErrorResponse(`"ttl" value must be less than "max_ttl"`)
+	}
+	return nil
+}
+
+// parseRoleTTLs parses the ttl and max_ttl of an OTP or dynamic role. Unset
+// values are returned as zero, in which case the mount values apply.
+func parseRoleTTLs(ttlRaw, maxTTLRaw string) (time.Duration, time.Duration, error) {
+	var ttl, maxTTL time.Duration
+	var err error
+	if ttlRaw != "" {
+		ttl, err = parseutil.ParseDurationSecond(ttlRaw)
+		if err != nil {
+			return 0, 0, fmt.Errorf("invalid ttl: %v", err)
+		}
+	}
+	if maxTTLRaw != "" {
+		maxTTL, err = parseutil.ParseDurationSecond(maxTTLRaw)
+		if err != nil {
+			return 0, 0, fmt.Errorf("invalid max_ttl: %v", err)
+		}
+	}
+	return ttl, maxTTL, nil
+}
+
 func (b *backend) getRole(s logical.Storage, n string) (*sshRole, error) {
 	entry, err := s.Get("roles/" + n)
 	if err != nil {
@@ -520,16 +1589,68 @@ func (b *backend) getRole(s logical.Storage, n string) (*sshRole, error) {
 	return &result, nil
 }
 
+// rolesOverlappingCIDRs returns the names of the roles other than the given
+// one whose CIDR blocks intersect the list, each followed by its key type.
+func (b *backend) rolesOverlappingCIDRs(s logical.Storage, roleName string, cidrs []string) ([]string, error) {
+	names, err := s.List("roles/")
+	if err != nil {
+		return nil, err
+	}
+
+	var overlapping []string
+	for _, name := range names {
+		if name == roleName {
+			continue
+		}
+		role, err := b.getRole(s, name)
+		if err != nil {
+			return nil, fmt.Errorf("error retrieving role %q: %v", name, err)
+		}
+		// CA roles do not restrict the IPs of the hosts
+		if role == nil || role.KeyType == KeyTypeCA {
+			continue
+		}
+		if cidrListsOverlap(cidrs, role.CIDRList) {
+			overlapping = append(overlapping, fmt.Sprintf("%s (%s)", name, role.KeyType))
+		}
+	}
+	return overlapping, nil
+}
+
 func (b *backend) pathRoleList(req *logical.Request, d *framework.FieldData) (*logical.Response, error) {
+	if err := checkFieldTypes(d); err != nil {
+		return nil, err
+	}
+
 	entries, err := req.Storage.List("roles/")
 	if err != nil {
 		return nil, err
 	}
 
-	return logical.ListResponse(entries), nil
+	// Include the key type of every role so that clients can tell the
+	// roles apart without reading each of them.
+	keyInfo := map[string]interface{}{}
+	for _, entry := range entries {
+		role, err := b.getRole(req.Storage, entry)
+		if err != nil {
+			return nil, fmt.Errorf("error retrieving role %q: %v", entry, err)
+		}
+		if role == nil {
+			continue
+		}
+		keyInfo[entry] = map[string]interface{}{
+			"key_type": role.KeyType,
+		}
+	}
+
+	return logical.ListResponseWithInfo(entries, keyInfo), nil
 }
 
 func (b *backend) pathRoleRead(req *logical.Request, d *framework.FieldData) (*logical.Response, error) {
+	if err := checkFieldTypes(d); err != nil {
+		return nil, err
+	}
+
 	role, err := b.getRole(req.Storage, d.Get("role").(string))
 	if err != nil {
 		return nil, err
@@ -538,63 +1659,126 @@ func (b *backend) pathRoleRead(req *logical.Request, d *framework.FieldData) (*l
 		return nil, nil
 	}
 
+	data, err := b.roleData(req.Storage, role)
+	if err != nil {
+		return nil, err
+	}
+	resp := &logical.Response{
+		Data: data,
+	}
+	if _, ok := data["install_script"]; ok {
+		resp.AuditRedact = []string{"install_script"}
+	}
+	return resp, nil
+}
+
+// roleData returns the fields of the role as they are read.
+func (b *backend) roleData(s logical.Storage, role *sshRole) (map[string]interface{}, error) {
 	// Return information should be based on the key type of the role
 	if role.KeyType == KeyTypeOTP {
-		return &logical.Response{
-			Data: map[string]interface{}{
-				"default_user":      role.DefaultUser,
-				"cidr_list":         role.CIDRList,
-				"exclude_cidr_list": role.ExcludeCIDRList,
-				"key_type":          role.KeyType,
-				"port":              role.Port,
-				"allowed_users":     role.AllowedUsers,
-			},
+		return map[string]interface{}{
+			"default_user":           role.DefaultUser,
+			"default_user_template":  role.DefaultUserTemplate,
+			"cidr_list":              role.CIDRList.list(),
+			"exclude_cidr_list":      role.ExcludeCIDRList.list(),
+			"key_type":               role.KeyType,
+			"port":                   role.Port,
+			"allowed_ports":          role.AllowedPorts,
+			"allowed_users":          role.allowedUsersList(),
+			"allowed_domains":        role.AllowedDomains,
+			"resolve_hostnames":      role.ResolveHostnames,
+			"otp_format":             role.otpFormat(),
+			"otp_length":             role.OTPLength,
+			"otp_max_uses":           role.otpMaxUses(),
+			"otp_ttl":                int64(role.OTPTTL.Seconds()),
+			"bind_to_client_ip":      role.BindToClientIP,
+			"allowed_redeemer_cidrs": role.AllowedRedeemerCIDRs.list(),
+			"max_creds_per_minute":   role.MaxCredsPerMinute,
+			"max_creds_burst":        role.MaxCredsBurst,
+			"ttl":                    role.TTL,
+			"max_ttl":                role.MaxTTL,
 		}, nil
 	} else if role.KeyType == KeyTypeCA {
-		return &logical.Response{
-			Data: map[string]interface{}{
-				"allowed_users":   role.AllowedUsers,
-				"allowed_domains": role.AllowedDomains,
-				"default_user":    role.DefaultUser,
-				"max_ttl":         role.MaxTTL,
-				"ttl":             role.TTL,
-				"allowed_critical_options": role.AllowedCriticalOptions,
-				"allowed_extensions":       role.AllowedExtensions,
-				"allow_user_certificates":  role.AllowUserCertificates,
-				"allow_host_certificates":  role.AllowHostCertificates,
-				"allow_bare_domains":       role.AllowBareDomains,
-				"allow_subdomains":         role.AllowSubdomains,
-				"allow_user_key_ids":       role.AllowUserKeyIDs,
-				"key_id_format":            role.KeyIDFormat,
-				"key_type":                 role.KeyType,
-				"default_critical_options": role.DefaultCriticalOptions,
-				"default_extensions":       role.DefaultExtensions,
-			},
+		return map[string]interface{}{
+			"allowed_users":   role.AllowedUsers,
+			"allowed_domains": role.AllowedDomains,
+			"default_user":    role.DefaultUser,
+			"max_ttl":         role.MaxTTL,
+			"ttl":             role.TTL,
+			"allowed_critical_options": role.AllowedCriticalOptions,
+			"allowed_extensions":       role.AllowedExtensions,
+			"allow_user_certificates":  role.AllowUserCertificates,
+			"allow_host_certificates":  role.AllowHostCertificates,
+			"allow_bare_domains":       role.AllowBareDomains,
+			"allow_subdomains":         role.AllowSubdomains,
+			"allow_user_key_ids":       role.AllowUserKeyIDs,
+			"key_id_format":            role.KeyIDFormat,
+			"key_type":                 role.KeyType,
+			"default_critical_options": role.DefaultCriticalOptions,
+			"default_extensions":       role.DefaultExtensions,
 		}, nil
 	} else {
-		return &logical.Response{
-			Data: map[string]interface{}{
-				"key":               role.KeyName,
-				"admin_user":        role.AdminUser,
-				"default_user":      role.DefaultUser,
-				"cidr_list":         role.CIDRList,
-				"exclude_cidr_list": role.ExcludeCIDRList,
-				"port":              role.Port,
-				"key_type":          role.KeyType,
-				"key_bits":          role.KeyBits,
-				"allowed_users":     role.AllowedUsers,
-				"key_option_specs":  role.KeyOptionSpecs,
-				// Returning install script will make the output look messy.
-				// But this is one way for clients to see the script that is
-				// being used to install the key. If there is some problem,
-				// the script can be modified and configured by clients.
-				"install_script": role.InstallScript,
-			},
-		}, nil
+		installScript, err := b.installScript(s, role)
+		if err != nil {
+			return nil, err
+		}
+		installScriptSource := "custom"
+		if role.usesSharedInstallScript() {
+			installScriptSource = "shared"
+		}
+
+		data := map[string]interface{}{
+			"key":                      role.KeyName,
+			"key_names":                role.hostKeyNames(),
+			"admin_user":               role.AdminUser,
+			"default_user":             role.DefaultUser,
+			"default_user_template":    role.DefaultUserTemplate,
+			"cidr_list":                role.CIDRList.list(),
+			"exclude_cidr_list":        role.ExcludeCIDRList.list(),
+			"port":                     role.Port,
+			"allowed_ports":            role.AllowedPorts,
+			"key_type":                 role.KeyType,
+			"key_bits":                 role.KeyBits,
+			"algorithm":                role.keyAlgorithm(),
+			"private_key_format":       role.privateKeyFormat(),
+			"allowed_users":            role.allowedUsersList(),
+			"allowed_domains":          role.AllowedDomains,
+			"resolve_hostnames":        role.ResolveHostnames,
+			"key_option_specs":         role.KeyOptionSpecs,
+			"authorized_keys_path":     role.AuthorizedKeysPath,
+			"connection_timeout":       int64(role.ConnectionTimeout.Seconds()),
+			"connection_retries":       role.ConnectionRetries,
+			"bastion_host":             role.BastionHost,
+			"bastion_port":             role.BastionPort,
+			"bastion_key_name":         role.BastionKeyName,
+			"known_hosts":              role.KnownHosts,
+			"insecure_ignore_host_key": role.InsecureIgnoreHostKey,
+			"use_sudo":                 role.UseSudo,
+			"sudo_command":             role.SudoCommand,
+			"max_creds_per_minute":     role.MaxCredsPerMinute,
+			"max_creds_burst":          role.MaxCredsBurst,
+			"ttl":                      role.TTL,
+			"max_ttl":                  role.MaxTTL,
+			// Returning install script will make the output look messy.
+			// But this is one way for clients to see the script that is
+			// being used to install the key. If there is some problem,
+			// the script can be modified and configured by clients.
+			"install_script":        installScript,
+			"install_script_source": installScriptSource,
+		}
+		// The curve only applies to ECDSA keys
+		if role.keyAlgorithm() == KeyAlgorithmECDSA {
+			data["curve"] = role.Curve
+		}
+		return data, nil
 	}
 }
 
 func (b *backend) pathRoleDelete(req *logical.Request, d *framework.FieldData) (*logical.Response, error) {
+	if err := checkFieldTypes(d); err != nil {
+		return nil, err
+	}
+
 	roleName := d.Get("role").(string)
 
 	// If the role was given privilege to accept any IP address, there will
@@ -605,11 +1789,310 @@ func (b *backend) pathRoleDelete(req *logical.Request, d *framework.FieldData) (
 		return nil, err
 	}
 
+	installedIDs, saltedOTPs, err := b.outstandingCredentials(req.Storage, roleName)
+	if err != nil {
+		return nil, err
+	}
+
+	// Credentials which could not be revoked are reported. The role is
+	// deleted regardless, since removing the keys does not depend on it.
+	revoked := []string{}
+	failed := map[string]interface{}{}
+	revokeOutstanding := d.Get("revoke_outstanding").(bool)
+	if revokeOutstanding {
+		for _, id := range installedIDs {
+			removed, err := b.removeInstalledKey(req.Storage, id)
+			if err != nil {
+				return nil, err
+			}
+			if removed != "" {
+				failed[id] = removed
+				continue
+			}
+			revoked = append(revoked, id)
+		}
+		for _, saltedOTP := range saltedOTPs {
+			if err := b.deleteOTP(req.Storage, saltedOTP); err != nil {
+				failed[saltedOTP] = err.Error()
+				continue
+			}
+			revoked = append(revoked, saltedOTP)
+		}
+	}
+
 	err = req.Storage.Delete(fmt.Sprintf("roles/%s", roleName))
 	if err != nil {
 		return nil, err
 	}
-	return nil, nil
+
+	// A role created later under the same name starts with a full bucket
+	b.credsLimiter.reset(roleName)
+
+	if len(installedIDs) == 0 && len(saltedOTPs) == 0 {
+		return nil, nil
+	}
+	resp := &logical.Response{
+		Data: map[string]interface{}{
+			"outstanding":    len(installedIDs) + len(saltedOTPs),
+			"installed_keys": installedIDs,
+			"otps":           saltedOTPs,
+		},
+	}
+	if !revokeOutstanding {
+		if len(installedIDs) != 0 {
+			resp.AddWarning(fmt.Sprintf("%d dynamic keys issued by the role remain installed on their targets; they are listed at 'installed/' and removed when their leases expire, or can be removed with 'installed/cleanup'", len(installedIDs)))
+		}
+		return resp, nil
+	}
+
+	resp.Data["revoked"] = revoked
+	resp.Data["failed"] = failed
+	if len(failed) != 0 {
+		resp.AddWarning(fmt.Sprintf("%d credentials issued by the role could not be revoked; dynamic keys which could not be removed stay listed at 'installed/'", len(failed)))
+	}
+	return resp, nil
+}
+
+// outstandingCredentials returns the identifiers of the installed dynamic keys
+// and the salted values of the unused OTPs which were issued by the role.
+// Dynamic keys issued before installations were recorded cannot be found.
+func (b *backend) outstandingCredentials(s logical.Storage, roleName string) ([]string, []string, error) {
+	installedIDs := []string{}
+	ids, err := s.List("installed/")
+	if err != nil {
+		return nil, nil, err
+	}
+	for _, id := range ids {
+		installed, err := b.getInstalledKey(s, id)
+		if err != nil {
+			return nil, nil, fmt.Errorf("error reading installed key %q: %v", id, err)
+		}
+		if installed != nil && installed.RoleName == roleName {
+			installedIDs = append(installedIDs, id)
+		}
+	}
+
+	saltedOTPs := []string{}
+	entries, err := s.List("otp/")
+	if err != nil {
+		return nil, nil, err
+	}
+	for _, saltedOTP := range entries {
+		otpEntry, err := b.getOTP(s, saltedOTP)
+		if err != nil {
+			return nil, nil, fmt.Errorf("error fetching OTP entry: %v", err)
+		}
+		if otpEntry != nil && otpEntry.RoleName == roleName {
+			saltedOTPs = append(saltedOTPs, saltedOTP)
+		}
+	}
+
+	return installedIDs, saltedOTPs, nil
+}
+
+// roleResponse is the schema of the fields of roles as they are read, which
+// depend on their key type.
+var roleResponse = map[string]*framework.FieldSchema{
+	"key_type": &framework.FieldSchema{
+		Type:        framework.TypeString,
+		Description: `Type of credentials of the role: otp, dynamic or ca.`,
+	},
+	"default_user": &framework.FieldSchema{
+		Type:        framework.TypeString,
+		Description: `User the credentials are for if none is requested.`,
+	},
+	"default_user_template": &framework.FieldSchema{
+		Type:        framework.TypeString,
+		Description: `Template of the default user.`,
+	},
+	"allowed_users": &framework.FieldSchema{
+		Type:        framework.TypeCommaStringSlice,
+		Description: `Users credentials can be requested for, as a list, or as a comma-separated string for CA roles.`,
+	},
+	"allowed_domains": &framework.FieldSchema{
+		Type:        framework.TypeString,
+		Description: `Comma-separated list of the domains of the hosts the role serves.`,
+	},
+	"cidr_list": &framework.FieldSchema{
+		Type:        framework.TypeStringSlice,
+		Description: `CIDR blocks of the hosts the role serves.`,
+	},
+	"exclude_cidr_list": &framework.FieldSchema{
+		Type:        framework.TypeStringSlice,
+		Description: `CIDR blocks excluded from cidr_list.`,
+	},
+	"port": &framework.FieldSchema{
+		Type:        framework.TypeInt,
+		Description: `Default SSH port of the hosts.`,
+	},
+	"allowed_ports": &framework.FieldSchema{
+		Type:        framework.TypeString,
+		Description: `Ports credentials can be requested for.`,
+	},
+	"resolve_hostnames": &framework.FieldSchema{
+		Type:        framework.TypeBool,
+		Description: `Whether hostnames are resolved to match cidr_list.`,
+	},
+	"otp_format": &framework.FieldSchema{
+		Type:        framework.TypeString,
+		Description: `Format of the generated OTPs.`,
+	},
+	"otp_length": &framework.FieldSchema{
+		Type:        framework.TypeInt,
+		Description: `Length of the generated OTPs.`,
+	},
+	"otp_max_uses": &framework.FieldSchema{
+		Type:        framework.TypeInt,
+		Description: `Number of times an OTP can be used.`,
+	},
+	"otp_ttl": &framework.FieldSchema{
+		Type:        framework.TypeDurationSecond,
+		Description: `Time an OTP remains valid, in seconds.`,
+	},
+	"bind_to_client_ip": &framework.FieldSchema{
+		Type:        framework.TypeBool,
+		Description: `Whether OTPs can only be verified for the address they were requested from.`,
+	},
+	"allowed_redeemer_cidrs": &framework.FieldSchema{
+		Type:        framework.TypeStringSlice,
+		Description: `CIDR blocks of the clients allowed to verify OTPs.`,
+	},
+	"key": &framework.FieldSchema{
+		Type:        framework.TypeString,
+		Description: `Name of the shared key used to install dynamic keys.`,
+	},
+	"key_names": &framework.FieldSchema{
+		Type:        framework.TypeStringSlice,
+		Description: `Names of the shared keys tried in order to install dynamic keys.`,
+	},
+	"admin_user": &framework.FieldSchema{
+		Type:        framework.TypeString,
+		Description: `Admin user on the hosts which installs dynamic keys.`,
+	},
+	"key_bits": &framework.FieldSchema{
+		Type:        framework.TypeInt,
+		Description: `Length of the dynamic keys in bits.`,
+	},
+	"algorithm": &framework.FieldSchema{
+		Type:        framework.TypeString,
+		Description: `Algorithm of the dynamic keys.`,
+	},
+	"curve": &framework.FieldSchema{
+		Type:        framework.TypeString,
+		Description: `Curve of the dynamic keys when the algorithm is ECDSA.`,
+	},
+	"private_key_format": &framework.FieldSchema{
+		Type:        framework.TypeString,
+		Description: `Default format of the dynamic private keys.`,
+	},
+	"key_option_specs": &framework.FieldSchema{
+		Type:        framework.TypeString,
+		Description: `Options prepended to the installed public keys.`,
+	},
+	"authorized_keys_path": &framework.FieldSchema{
+		Type:        framework.TypeString,
+		Description: `Path of the authorized_keys file on the hosts.`,
+	},
+	"connection_timeout": &framework.FieldSchema{
+		Type:        framework.TypeDurationSecond,
+		Description: `Timeout of the connections to the hosts, in seconds.`,
+	},
+	"connection_retries": &framework.FieldSchema{
+		Type:        framework.TypeInt,
+		Description: `Number of retries of the connections to the hosts.`,
+	},
+	"bastion_host": &framework.FieldSchema{
+		Type:        framework.TypeString,
+		Description: `Bastion host the hosts are reached through.`,
+	},
+	"bastion_port": &framework.FieldSchema{
+		Type:        framework.TypeInt,
+		Description: `SSH port of the bastion host.`,
+	},
+	"bastion_key_name": &framework.FieldSchema{
+		Type:        framework.TypeString,
+		Description: `Name of the shared key used for the bastion host.`,
+	},
+	"known_hosts": &framework.FieldSchema{
+		Type:        framework.TypeString,
+		Description: `Known host keys of the hosts.`,
+	},
+	"insecure_ignore_host_key": &framework.FieldSchema{
+		Type:        framework.TypeBool,
+		Description: `Whether the host keys of the hosts are not checked.`,
+	},
+	"use_sudo": &framework.FieldSchema{
+		Type:        framework.TypeBool,
+		Description: `Whether the install script is run with sudo.`,
+	},
+	"sudo_command": &framework.FieldSchema{
+		Type:        framework.TypeString,
+		Description: `Command used to run the install script with privileges.`,
+	},
+	"install_script": &framework.FieldSchema{
+		Type:        framework.TypeString,
+		Description: `Script installing the dynamic keys.`,
+	},
+	"install_script_source": &framework.FieldSchema{
+		Type:        framework.TypeString,
+		Description: `Whether the install script is the shared one or the custom one of the role.`,
+	},
+	"max_creds_per_minute": &framework.FieldSchema{
+		Type:        framework.TypeInt,
+		Description: `Number of credentials which can be issued per minute.`,
+	},
+	"max_creds_burst": &framework.FieldSchema{
+		Type:        framework.TypeInt,
+		Description: `Number of credentials which can be issued at once.`,
+	},
+	"ttl": &framework.FieldSchema{
+		Type:        framework.TypeString,
+		Description: `TTL of the credentials, such as "1h0m0s".`,
+	},
+	"max_ttl": &framework.FieldSchema{
+		Type:        framework.TypeString,
+		Description: `Maximum TTL of the credentials, such as "1h0m0s".`,
+	},
+	"allowed_critical_options": &framework.FieldSchema{
+		Type:        framework.TypeString,
+		Description: `Comma-separated list of the critical options certificates can have.`,
+	},
+	"allowed_extensions": &framework.FieldSchema{
+		Type:        framework.TypeString,
+		Description: `Comma-separated list of the extensions certificates can have.`,
+	},
+	"default_critical_options": &framework.FieldSchema{
+		Type:        framework.TypeKVPairs,
+		Description: `Critical options certificates have if none are requested.`,
+	},
+	"default_extensions": &framework.FieldSchema{
+		Type:        framework.TypeKVPairs,
+		Description: `Extensions certificates have if none are requested.`,
+	},
+	"allow_user_certificates": &framework.FieldSchema{
+		Type:        framework.TypeBool,
+		Description: `Whether user certificates can be signed.`,
+	},
+	"allow_host_certificates": &framework.FieldSchema{
+		Type:        framework.TypeBool,
+		Description: `Whether host certificates can be signed.`,
+	},
+	"allow_bare_domains": &framework.FieldSchema{
+		Type:        framework.TypeBool,
+		Description: `Whether host certificates can be signed for the bare allowed domains.`,
+	},
+	"allow_subdomains": &framework.FieldSchema{
+		Type:        framework.TypeBool,
+		Description: `Whether host certificates can be signed for the subdomains of the allowed domains.`,
+	},
+	"allow_user_key_ids": &framework.FieldSchema{
+		Type:        framework.TypeBool,
+		Description: `Whether clients can request the key ID of certificates.`,
+	},
+	"key_id_format": &framework.FieldSchema{
+		Type:        framework.TypeString,
+		Description: `Template of the key ID of certificates.`,
+	},
 }
 
 const pathRoleHelpSyn = `
@@ -627,4 +2110,12 @@ If the backend is mounted at "ssh" and the role is created at "ssh/roles/web",
 then a user could request for a credential at "ssh/creds/web" for an IP that
 belongs to the role. The credential will be for the 'default_user' registered
 with the role. There is also an optional parameter 'username' for 'creds/' endpoint.
+
+Writing to an existing role only changes the parameters which are given. Fields
+which depend on a given parameter, such as 'key_bits' on 'algorithm', are reset,
+and changing the 'key_type' replaces the role.
+
+Deleting a role does not revoke the leases of the credentials it issued. The
+dynamic keys still installed and the unused OTPs of the role are returned, and
+with 'revoke_outstanding' set, they are removed before the role is deleted.
 `
@@ -5,6 +5,7 @@ import (
 	"crypto/sha256"
 	"errors"
 	"fmt"
+	"net/http"
 	"strconv"
 	"strings"
 	"time"
@@ -33,17 +34,21 @@ func pathSign(b *backend) *framework.Path {
 	return &framework.Path{
 		Pattern: "sign/" + framework.GenericNameRegex("role"),
 
-		Callbacks: map[logical.Operation]framework.OperationFunc{
-			logical.UpdateOperation: b.pathSign,
+		Operations: map[logical.Operation]*framework.PathOperation{
+			logical.UpdateOperation: &framework.PathOperation{
+				Callback: b.pathSign,
+				Summary:  "Sign a public key with the CA.",
+			},
 		},
 
 		Fields: map[string]*framework.FieldSchema{
 			"role": &framework.FieldSchema{
-				Type:        framework.TypeString,
+				Type:        framework.TypeNameString,
 				Description: `The desired role with configuration for this request.`,
 			},
 			"ttl": &framework.FieldSchema{
-				Type: framework.TypeString,
+				Type:    framework.TypeDurationSecond,
+				Aliases: []string{"lease"},
 				Description: `The requested Time To Live for the SSH certificate;
 sets the expiration date. If not specified
 the role default, backend default, or system
@@ -68,12 +73,12 @@ be later than the role max TTL.`,
 				Description: `Key id that the created certificate should have. If not specified, the display name of the token will be used.`,
 			},
 			"critical_options": &framework.FieldSchema{
-				Type:        framework.TypeMap,
-				Description: `Critical options that the certificate should be signed for.`,
+				Type:        framework.TypeKVPairs,
+				Description: `Critical options that the certificate should be signed for, as a JSON object or as repeated "name=value" pairs.`,
 			},
 			"extensions": &framework.FieldSchema{
-				Type:        framework.TypeMap,
-				Description: `Extensions that the certificate should be signed for.`,
+				Type:        framework.TypeKVPairs,
+				Description: `Extensions that the certificate should be signed for, as a JSON object or as repeated "name=value" pairs.`,
 			},
 		},
 
@@ -83,6 +88,10 @@ be later than the role max TTL.`,
 }
 
 func (b *backend) pathSign(req *logical.Request, data *framework.FieldData) (*logical.Response, error) {
+	if err := checkFieldTypes(data); err != nil {
+		return nil, err
+	}
+
 	roleName := data.Get("role").(string)
 
 	// Get the role
@@ -91,7 +100,7 @@ func (b *backend) pathSign(req *logical.Request, data *framework.FieldData) (*lo
 		return nil, err
 	}
 	if role == nil {
-		return logical.ErrorResponse(fmt.Sprintf("Unknown role: %s", roleName)), nil
+		return nil, logical.CodedError(http.StatusNotFound, fmt.Sprintf("Unknown role: %s", roleName))
 	}
 
 	return b.pathSignCertificate(req, data, role)
@@ -133,6 +142,12 @@ func (b *backend) pathSignCertificate(req *logical.Request, data *framework.Fiel
 		}
 	}
 
+	// A certificate without principals is valid for any principal, so it is
+	// never handed out.
+	if len(parsedPrincipals) == 0 {
+		return logical.ErrorResponse("empty valid_principals; specify valid_principals or configure a default_user on the role"), nil
+	}
+
 	ttl, err := b.calculateTTL(data, role)
 	if err != nil {
 		return logical.ErrorResponse(err.Error()), nil
@@ -183,12 +198,21 @@ func (b *backend) pathSignCertificate(req *logical.Request, data *framework.Fiel
 		return nil, fmt.Errorf("error marshaling signed certificate")
 	}
 
-	response := &logical.Response{
-		Data: map[string]interface{}{
-			"serial_number": strconv.FormatUint(certificate.Serial, 16),
-			"signed_key":    string(signedSSHCertificate),
-		},
-	}
+	serialNumber := strconv.FormatUint(certificate.Serial, 16)
+	response := b.Secret(SecretSignedKeyType).Response(map[string]interface{}{
+		"serial_number": serialNumber,
+		"signed_key":    string(signedSSHCertificate),
+		"valid_after":   time.Unix(int64(certificate.ValidAfter), 0).UTC().Format(time.RFC3339),
+		"valid_before":  time.Unix(int64(certificate.ValidBefore), 0).UTC().Format(time.RFC3339),
+		// Fingerprints of the signed public key, as logged by sshd when
+		// the certificate is used
+		"fingerprint_md5":    ssh.FingerprintLegacyMD5(userPublicKey),
+		"fingerprint_sha256": ssh.FingerprintSHA256(userPublicKey),
+	}, map[string]interface{}{
+		"serial_number":      serialNumber,
+		"fingerprint_sha256": ssh.FingerprintSHA256(userPublicKey),
+	})
+	response.Secret.TTL = ttl
 
 	return response, nil
 }
@@ -293,13 +317,11 @@ func (b *backend) calculateKeyId(data *framework.FieldData, req *logical.Request
 }
 
 func (b *backend) calculateCriticalOptions(data *framework.FieldData, role *sshRole) (map[string]string, error) {
-	unparsedCriticalOptions := data.Get("critical_options").(map[string]interface{})
-	if len(unparsedCriticalOptions) == 0 {
+	criticalOptions := data.Get("critical_options").(map[string]string)
+	if len(criticalOptions) == 0 {
 		return role.DefaultCriticalOptions, nil
 	}
 
-	criticalOptions := convertMapToStringValue(unparsedCriticalOptions)
-
 	if role.AllowedCriticalOptions != "" {
 		notAllowedOptions := []string{}
 		allowedCriticalOptions := strings.Split(role.AllowedCriticalOptions, ",")
@@ -319,13 +341,11 @@ func (b *backend) calculateCriticalOptions(data *framework.FieldData, role *sshR
 }
 
 func (b *backend) calculateExtensions(data *framework.FieldData, role *sshRole) (map[string]string, error) {
-	unparsedExtensions := data.Get("extensions").(map[string]interface{})
-	if len(unparsedExtensions) == 0 {
+	extensions := data.Get("extensions").(map[string]string)
+	if len(extensions) == 0 {
 		return role.DefaultExtensions, nil
 	}
 
-	extensions := convertMapToStringValue(unparsedExtensions)
-
 	if role.AllowedExtensions != "" {
 		notAllowed := []string{}
 		allowedExtensions := strings.Split(role.AllowedExtensions, ",")
@@ -347,19 +367,15 @@ func (b *backend) calculateExtensions(data *framework.FieldData, role *sshRole)
 func (b *backend) calculateTTL(data *framework.FieldData, role *sshRole) (time.Duration, error) {
 
 	var ttl, maxTTL time.Duration
-	var ttlField string
-	ttlFieldInt, ok := data.GetOk("ttl")
-	if !ok {
-		ttlField = role.TTL
-	} else {
-		ttlField = ttlFieldInt.(string)
-	}
-
-	if len(ttlField) == 0 {
+	requestedTTL := time.Duration(data.Get("ttl").(int)) * time.Second
+	switch {
+	case requestedTTL != 0:
+		ttl = requestedTTL
+	case len(role.TTL) == 0:
 		ttl = b.System().DefaultLeaseTTL()
-	} else {
+	default:
 		var err error
-		ttl, err = parseutil.ParseDurationSecond(ttlField)
+		ttl, err = parseutil.ParseDurationSecond(role.TTL)
 		if err != nil {
 			return 0, fmt.Errorf("invalid requested ttl: %s", err)
 		}
@@ -378,7 +394,7 @@ func (b *backend) calculateTTL(data *framework.FieldData, role *sshRole) (time.D
 	if ttl > maxTTL {
 		// Don't error if they were using system defaults, only error if
 		// they specifically chose a bad TTL
-		if len(ttlField) == 0 {
+		if requestedTTL == 0 && len(role.TTL) == 0 {
 			ttl = maxTTL
 		} else {
 			return 0, fmt.Errorf("ttl is larger than maximum allowed (%d)", maxTTL/time.Second)
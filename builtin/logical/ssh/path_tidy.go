@@ -0,0 +1,152 @@
+package ssh
+
+import (
+	"fmt"
+	"sync/atomic"
+	"time"
+
+	"github.com/hashicorp/vault/helper/locksutil"
+	"github.com/hashicorp/vault/logical"
+	"github.com/hashicorp/vault/logical/framework"
+)
+
+// OTP entries are tidied by the periodic function at this interval.
+const otpTidyInterval = time.Hour
+
+func pathTidy(b *backend) *framework.Path {
+	return &framework.Path{
+		Pattern: "tidy$",
+
+		Operations: map[logical.Operation]*framework.PathOperation{
+			logical.UpdateOperation: &framework.PathOperation{
+				Callback: b.pathTidyUpdate,
+				Summary:  "Delete the stored entries of expired OTPs.",
+			},
+		},
+
+		HelpSynopsis:    pathTidySyn,
+		HelpDescription: pathTidyDesc,
+	}
+}
+
+func (b *backend) pathTidyUpdate(req *logical.Request, d *framework.FieldData) (*logical.Response, error) {
+	if err := checkFieldTypes(d); err != nil {
+		return nil, err
+	}
+
+	scanned, deleted, err := b.tidyOTPs(req.Storage)
+	if err != nil {
+		return nil, err
+	}
+
+	return &logical.Response{
+		Data: map[string]interface{}{
+			"scanned": scanned,
+			"deleted": deleted,
+		},
+	}, nil
+}
+
+// tidyOTPs deletes the entries of OTPs whose lease has expired, or which can
+// no longer be verified because their otp_ttl has passed. These are left
+// behind when the lease of an unused OTP could not be revoked. It returns the
+// number of entries scanned and deleted.
+func (b *backend) tidyOTPs(s logical.Storage) (int, int, error) {
+	grabbed := atomic.CompareAndSwapUint32(&b.tidyOTPCASGuard, 0, 1)
+	if grabbed {
+		defer atomic.StoreUint32(&b.tidyOTPCASGuard, 0)
+	} else {
+		return 0, 0, fmt.Errorf("OTP tidy operation already running")
+	}
+
+	saltedOTPs, err := s.List("otp/")
+	if err != nil {
+		return 0, 0, err
+	}
+
+	var deleted int
+	for _, saltedOTP := range saltedOTPs {
+		tidied, err := b.tidyOTP(s, saltedOTP)
+		if err != nil {
+			return 0, 0, err
+		}
+		if tidied {
+			deleted++
+		}
+	}
+
+	return len(saltedOTPs), deleted, nil
+}
+
+// tidyOTP deletes the entry of the OTP if it has expired and reports whether
+// it was deleted. The lock of the entry is held throughout so that it is not
+// modified while the OTP is being verified.
+func (b *backend) tidyOTP(s logical.Storage, saltedOTP string) (bool, error) {
+	lock := locksutil.LockForKey(b.otpLocks, saltedOTP)
+	lock.Lock()
+	defer lock.Unlock()
+
+	otpEntry, err := b.getOTP(s, saltedOTP)
+	if err != nil {
+		return false, fmt.Errorf("error fetching OTP entry: %v", err)
+	}
+	if otpEntry == nil {
+		// Used or revoked since listing
+		return false, nil
+	}
+
+	// Entries created before the issuance time was recorded are given one
+	// now. They are removed once the longest possible lease would have
+	// expired.
+	if otpEntry.CreatedAt.IsZero() {
+		otpEntry.CreatedAt = time.Now().UTC()
+		otpEntry.TTL = b.System().MaxLeaseTTL()
+		entry, err := logical.StorageEntryJSON("otp/"+saltedOTP, otpEntry)
+		if err != nil {
+			return false, err
+		}
+		return false, s.Put(entry)
+	}
+
+	if !otpEntry.expired() {
+		return false, nil
+	}
+	if err := s.Delete("otp/" + saltedOTP); err != nil {
+		return false, fmt.Errorf("error deleting OTP entry: %v", err)
+	}
+	return true, nil
+}
+
+// expired reports whether the lease of the OTP has expired, or the OTP can no
+// longer be verified, whichever comes first. OTPs without a recorded issuance
+// time never expire.
+func (o *sshOTP) expired() bool {
+	if o.redemptionExpired() {
+		return true
+	}
+	if o.CreatedAt.IsZero() {
+		return false
+	}
+	return time.Now().After(o.CreatedAt.Add(o.TTL))
+}
+
+// redemptionExpired reports whether the time within which the role requires
+// the OTP to be verified has passed.
+func (o *sshOTP) redemptionExpired() bool {
+	return !o.ExpiresAt.IsZero() && time.Now().After(o.ExpiresAt)
+}
+
+const pathTidySyn = `
+Delete the stored entries of expired OTPs.
+`
+
+const pathTidyDesc = `
+Every generated OTP is stored until it is used or its lease is revoked. If the
+revocation of an unused OTP fails, its entry is left behind. This endpoint
+deletes the entries of OTPs whose lease has expired and returns the number of
+entries scanned and deleted. The same cleanup is run by the backend once an
+hour.
+
+Entries of OTPs created before their issuance time was recorded are deleted
+once the maximum lease TTL of the mount has passed since the first tidy.
+`
@@ -1,7 +1,11 @@
 package ssh
 
 import (
+	"fmt"
+	"time"
+
 	"github.com/hashicorp/vault/api"
+	"github.com/hashicorp/vault/helper/locksutil"
 	"github.com/hashicorp/vault/logical"
 	"github.com/hashicorp/vault/logical/framework"
 )
@@ -14,9 +18,16 @@ func pathVerify(b *backend) *framework.Path {
 				Type:        framework.TypeString,
 				Description: "[Required] One-Time-Key that needs to be validated",
 			},
+			"client_ip": &framework.FieldSchema{
+				Type:        framework.TypeString,
+				Description: "[Required for OTPs bound to the client] Source address of the SSH connection the OTP is presented for",
+			},
 		},
-		Callbacks: map[logical.Operation]framework.OperationFunc{
-			logical.UpdateOperation: b.pathVerifyWrite,
+		Operations: map[logical.Operation]*framework.PathOperation{
+			logical.UpdateOperation: &framework.PathOperation{
+				Callback: b.pathVerifyWrite,
+				Summary:  "Validate an OTP provided by the Vault SSH Agent.",
+			},
 		},
 		HelpSynopsis:    pathVerifyHelpSyn,
 		HelpDescription: pathVerifyHelpDesc,
@@ -40,7 +51,11 @@ func (b *backend) getOTP(s logical.Storage, n string) (*sshOTP, error) {
 	return &result, nil
 }
 
-func (b *backend) pathVerifyWrite(req *logical.Request, d *framework.FieldData) (*logical.Response, error) {
+func (b *backend) pathVerifyWrite(req *logical.Request, d *framework.FieldData) (resp *logical.Response, retErr error) {
+	if err := checkFieldTypes(d); err != nil {
+		return nil, err
+	}
+
 	otp := d.Get("otp").(string)
 
 	// If OTP is not a UUID and a string matching VerifyEchoRequest, then the
@@ -54,6 +69,10 @@ func (b *backend) pathVerifyWrite(req *logical.Request, d *framework.FieldData)
 		}, nil
 	}
 
+	defer func(start time.Time) {
+		b.recordOperation(start, operationOutcome(resp, retErr, "verified"), "otp", "verify")
+	}(time.Now())
+
 	// Create the salt of OTP because entry would have been create with the
 	// salt and not directly of the OTP. Salt will yield the same value which
 	// because the seed is the same, the backend salt.
@@ -63,6 +82,13 @@ func (b *backend) pathVerifyWrite(req *logical.Request, d *framework.FieldData)
 	}
 	otpSalted := salt.SaltID(otp)
 
+	// Hold the lock of the entry from reading it until it is deleted or
+	// its uses are counted, so that concurrent verifications of the same
+	// OTP cannot use it more often than allowed.
+	lock := locksutil.LockForKey(b.otpLocks, otpSalted)
+	lock.Lock()
+	defer lock.Unlock()
+
 	// Return nil if there is no entry found for the OTP
 	otpEntry, err := b.getOTP(req.Storage, otpSalted)
 	if err != nil {
@@ -72,20 +98,101 @@ func (b *backend) pathVerifyWrite(req *logical.Request, d *framework.FieldData)
 		return logical.ErrorResponse("OTP not found"), nil
 	}
 
-	// Delete the OTP if found. This is what makes the key an OTP.
-	err = req.Storage.Delete("otp/" + otpSalted)
-	if err != nil {
-		return nil, err
+	// The entry of an expired OTP is kept until its lease is revoked or it
+	// is tidied up.
+	if otpEntry.redemptionExpired() {
+		return logical.ErrorResponse("OTP expired"), nil
+	}
+
+	// OTPs bound to the client which requested them are not consumed by
+	// attempts from other addresses, so that these cannot lock out the
+	// client.
+	if otpEntry.ClientIP != "" {
+		if errResp := otpEntry.checkRedeemer(d.Get("client_ip").(string)); errResp != nil {
+			return errResp, nil
+		}
+	}
+
+	// Delete the OTP once it has been used up. This is what makes the key
+	// an OTP. OTPs of roles allowing several uses are kept until then.
+	remainingUses := otpEntry.RemainingUses - 1
+	if remainingUses > 0 {
+		otpEntry.RemainingUses = remainingUses
+		entry, err := logical.StorageEntryJSON("otp/"+otpSalted, otpEntry)
+		if err != nil {
+			return nil, err
+		}
+		if err := req.Storage.Put(entry); err != nil {
+			return nil, err
+		}
+	} else {
+		remainingUses = 0
+		if err := req.Storage.Delete("otp/" + otpSalted); err != nil {
+			return nil, err
+		}
 	}
 
 	// Return username and IP only if there were no problems uptill this point.
-	return &logical.Response{
+	resp = &logical.Response{
 		Data: map[string]interface{}{
-			"username":  otpEntry.Username,
-			"ip":        otpEntry.IP,
-			"role_name": otpEntry.RoleName,
+			"username": otpEntry.Username,
+			"ip":       otpEntry.IP,
 		},
-	}, nil
+	}
+
+	// Single use OTPs have no uses left once verified.
+	if otpEntry.MaxUses > 1 {
+		resp.Data["remaining_uses"] = remainingUses
+	}
+
+	// OTPs created before the role name was recorded don't have one.
+	if otpEntry.RoleName != "" {
+		resp.Data["role_name"] = otpEntry.RoleName
+	}
+	otpEntry.addMetadata(resp.Data)
+
+	return resp, nil
+}
+
+// checkRedeemer checks that the SSH connection the OTP is presented for comes
+// from the address which requested it or from one of the allowed redeemer
+// CIDR blocks.
+func (o *sshOTP) checkRedeemer(clientIP string) *logical.Response {
+	if clientIP == "" {
+		return logical.ErrorResponse("OTP is bound to the client which requested it; missing client_ip")
+	}
+	ip, ok := canonicalIP(clientIP)
+	if !ok {
+		return logical.ErrorResponse(fmt.Sprintf("invalid client_ip %q", clientIP))
+	}
+	if ip == o.ClientIP {
+		return nil
+	}
+	if len(o.AllowedRedeemerCIDRs) != 0 {
+		block, err := cidrListMatch(ip, o.AllowedRedeemerCIDRs)
+		if err == nil && block != "" {
+			return nil
+		}
+	}
+	return logical.ErrorResponse(fmt.Sprintf("OTP cannot be used from %s", ip))
+}
+
+// addMetadata adds the issuance details of the OTP which have been recorded to
+// the response data.
+func (o *sshOTP) addMetadata(data map[string]interface{}) {
+	if !o.CreatedAt.IsZero() {
+		data["created_at"] = o.CreatedAt.Format(time.RFC3339)
+		data["ttl"] = int64(o.TTL.Seconds())
+	}
+	if o.DisplayName != "" {
+		data["display_name"] = o.DisplayName
+	}
+	if !o.ExpiresAt.IsZero() {
+		data["expires_at"] = o.ExpiresAt.Format(time.RFC3339)
+	}
+	if o.ClientIP != "" {
+		data["client_ip"] = o.ClientIP
+	}
 }
 
 const pathVerifyHelpSyn = `
@@ -96,6 +203,9 @@ const pathVerifyHelpDesc = `
 This path will be used by Vault SSH Agent runnin in the remote hosts. The OTP
 provided by the client is sent to Vault for validation by the agent. If Vault
 finds an entry for the OTP, it responds with the username and IP it is associated
-with. Agent uses this information to authenticate the client. Vault deletes the
-OTP after validating it once.
+with, along with the name of the role the OTP was created under, when it was
+created and the display name of the token it was issued to. Agent uses this
+information to authenticate the client. Vault deletes the OTP after validating
+it once, or after the number of uses allowed by the 'otp_max_uses' of its role,
+and returns the number of remaining uses in 'remaining_uses'.
 `
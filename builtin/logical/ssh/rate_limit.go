@@ -0,0 +1,74 @@
+package ssh
+
+import (
+	"sync"
+	"time"
+)
+
+// credsRateLimiter limits the rate at which credentials are issued for each
+// role using a token bucket per role. The buckets are held in memory only,
+// so they start out full after a restart.
+type credsRateLimiter struct {
+	sync.Mutex
+	buckets map[string]*tokenBucket
+
+	// now returns the current time. It is replaced in tests.
+	now func() time.Time
+}
+
+type tokenBucket struct {
+	tokens float64
+	last   time.Time
+}
+
+func newCredsRateLimiter() *credsRateLimiter {
+	return &credsRateLimiter{
+		buckets: map[string]*tokenBucket{},
+		now:     time.Now,
+	}
+}
+
+// allow takes a token from the bucket of the role and reports whether there
+// was one. The bucket holds up to burst tokens and is refilled with
+// perMinute tokens per minute. Since the limits are taken from the role on
+// every call, updating the role takes effect immediately.
+func (l *credsRateLimiter) allow(roleName string, perMinute, burst int) bool {
+	l.Lock()
+	defer l.Unlock()
+
+	now := l.now()
+	bucket, ok := l.buckets[roleName]
+	if !ok {
+		bucket = &tokenBucket{
+			tokens: float64(burst),
+			last:   now,
+		}
+		l.buckets[roleName] = bucket
+	}
+
+	bucket.tokens += now.Sub(bucket.last).Minutes() * float64(perMinute)
+	if bucket.tokens > float64(burst) {
+		bucket.tokens = float64(burst)
+	}
+	bucket.last = now
+
+	if bucket.tokens < 1 {
+		return false
+	}
+	bucket.tokens--
+	return true
+}
+
+// reset forgets the bucket of the role.
+func (l *credsRateLimiter) reset(roleName string) {
+	l.Lock()
+	defer l.Unlock()
+	delete(l.buckets, roleName)
+}
+
+// resetAll forgets the buckets of all roles.
+func (l *credsRateLimiter) resetAll() {
+	l.Lock()
+	defer l.Unlock()
+	l.buckets = map[string]*tokenBucket{}
+}
@@ -2,6 +2,8 @@ package ssh
 
 import (
 	"fmt"
+	"strings"
+	"time"
 
 	"github.com/hashicorp/errwrap"
 	"github.com/hashicorp/vault/logical"
@@ -30,42 +32,315 @@ func secretDynamicKey(b *backend) *framework.Secret {
 	}
 }
 
+// secretDynamicKeyRenew extends the lease of a dynamic key by the role ttl,
+// without exceeding the role max_ttl counted from the issue time of the
+// lease. Unset values fall back to the mount values. The key is already
+// installed, so the target is not contacted.
 func (b *backend) secretDynamicKeyRenew(req *logical.Request, d *framework.FieldData) (*logical.Response, error) {
-	f := framework.LeaseExtend(0, 0, b.System())
-	return f(req, d)
+	// Leases created before roles carried their own durations have no
+	// role name and only observe the mount values.
+	var ttl, maxTTL time.Duration
+	if roleName, ok := req.Secret.InternalData["role_name"].(string); ok && roleName != "" {
+		role, err := b.getRole(req.Storage, roleName)
+		if err != nil {
+			return nil, fmt.Errorf("error retrieving role: %v", err)
+		}
+		if role != nil {
+			ttl, maxTTL, err = parseRoleTTLs(role.TTL, role.MaxTTL)
+			if err != nil {
+				return nil, err
+			}
+		}
+	}
+
+	f := framework.LeaseExtend(ttl, maxTTL, b.System())
+	resp, err := f(req, d)
+	if err == framework.ErrLeaseMaxReached {
+		return logical.ErrorResponse("lease can no longer be renewed"), nil
+	}
+	return resp, err
+}
+
+// dynamicKeyInstallation holds the information needed to remove a dynamic
+// key from the target it was installed in.
+type dynamicKeyInstallation struct {
+	AdminUser        string `json:"admin_user" mapstructure:"admin_user"`
+	Username         string `json:"username" mapstructure:"username"`
+	IP               string `json:"ip" mapstructure:"ip"`
+	HostKeyName      string `json:"host_key_name" mapstructure:"host_key_name"`
+	HostKeyVersion   int    `json:"host_key_version" mapstructure:"host_key_version"`
+	DynamicPublicKey string `json:"dynamic_public_key" mapstructure:"dynamic_public_key"`
+	InstallScript    string `json:"install_script" mapstructure:"install_script"`
+	Port             int    `json:"port" mapstructure:"port"`
+	RoleName         string `json:"role_name" mapstructure:"role_name"`
+	BastionHost      string `json:"bastion_host" mapstructure:"bastion_host"`
+	BastionPort      int    `json:"bastion_port" mapstructure:"bastion_port"`
+	BastionKeyName   string `json:"bastion_key_name" mapstructure:"bastion_key_name"`
+	SudoCommand      string `json:"sudo_command" mapstructure:"sudo_command"`
+
+	// Identifier of the record of the installation at 'installed/'. Not set
+	// for keys installed before installations were recorded.
+	InstallationID string `json:"installation_id" mapstructure:"installation_id"`
+
+	// Every host the key was installed on, if it was installed on several.
+	// IP is the first of these.
+	IPs []string `json:"ips,omitempty" mapstructure:"ips"`
+
+	// The keys of the role in the order they are tried, and the key each
+	// host accepted. HostKeyName and HostKeyVersion are those of IP. Not
+	// set for keys installed before roles could have several keys.
+	HostKeyNames []string              `json:"host_key_names,omitempty" mapstructure:"host_key_names"`
+	HostKeys     map[string]hostKeyRef `json:"host_keys,omitempty" mapstructure:"host_keys"`
+
+	// Not set for keys installed before fingerprints were recorded
+	FingerprintSHA256 string `json:"fingerprint_sha256" mapstructure:"fingerprint_sha256"`
+
+	// The exact line written to the authorized_keys file, including the
+	// options of the key. Not set for keys installed before the lines were
+	// recorded.
+	AuthorizedKeysLine string `json:"authorized_keys_line" mapstructure:"authorized_keys_line"`
+
+	// Path of the authorized_keys file the key was installed in. Not set for
+	// keys installed in the default location before the path was recorded.
+	AuthorizedKeysPath string `json:"authorized_keys_path" mapstructure:"authorized_keys_path"`
+}
+
+// hostKeyRef identifies the version of a host key a dynamic key was installed
+// with.
+type hostKeyRef struct {
+	Name    string `json:"name" mapstructure:"name"`
+	Version int    `json:"version" mapstructure:"version"`
 }
 
-func (b *backend) secretDynamicKeyRevoke(req *logical.Request, d *framework.FieldData) (*logical.Response, error) {
-	type sec struct {
-		AdminUser        string `mapstructure:"admin_user"`
-		Username         string `mapstructure:"username"`
-		IP               string `mapstructure:"ip"`
-		HostKeyName      string `mapstructure:"host_key_name"`
-		DynamicPublicKey string `mapstructure:"dynamic_public_key"`
-		InstallScript    string `mapstructure:"install_script"`
-		Port             int    `mapstructure:"port"`
+// forHost returns the installation of the key on one of its hosts.
+func (k *dynamicKeyInstallation) forHost(ip string) dynamicKeyInstallation {
+	target := *k
+	target.IP = ip
+	target.IPs = nil
+	if ref, ok := k.HostKeys[ip]; ok {
+		target.HostKeyName = ref.Name
+		target.HostKeyVersion = ref.Version
 	}
+	return target
+}
 
-	intSec := &sec{}
+// authorizedKeysEntry returns the content uploaded to the target to remove
+// the key. Install scripts remove the lines of authorized_keys which are
+// equal to it.
+func (k *dynamicKeyInstallation) authorizedKeysEntry() string {
+	if k.AuthorizedKeysLine == "" {
+		return k.DynamicPublicKey
+	}
+	return k.AuthorizedKeysLine
+}
+
+// authorizedKeysFile returns the path of the authorized_keys file the key was
+// installed in.
+func (k *dynamicKeyInstallation) authorizedKeysFile() string {
+	if k.AuthorizedKeysPath == "" {
+		return expandAuthorizedKeysPath("", k.Username)
+	}
+	return k.AuthorizedKeysPath
+}
+
+func (b *backend) secretDynamicKeyRevoke(req *logical.Request, d *framework.FieldData) (resp *logical.Response, retErr error) {
+	defer func(start time.Time) {
+		b.recordOperation(start, operationOutcome(resp, retErr, "revoked"), "revoke", KeyTypeDynamic)
+	}(time.Now())
+
+	intSec := &dynamicKeyInstallation{}
 	err := mapstructure.Decode(req.Secret.InternalData, intSec)
 	if err != nil {
 		return nil, errwrap.Wrapf("secret internal data could not be decoded: {{err}}", err)
 	}
 
-	// Fetch the host key using the key name
-	hostKey, err := b.getKey(req.Storage, intSec.HostKeyName)
+	return nil, b.removeDynamicKey(req.Storage, intSec)
+}
+
+// Kind of the WAL entries written before dynamic keys are installed
+const dynamicKeyWALKind = "dynamic_key_install"
+
+// walRollbackMinAge returns the age after which the WAL entry of a dynamic key
+// is taken to belong to an installation which was interrupted. It is twice the
+// longest time an installation can take with the connection settings of
+// 'config/connection' and of the roles overriding them.
+func (b *backend) walRollbackMinAge(req *logical.Request) (time.Duration, error) {
+	config, err := b.getConnectionConfig(req.Storage)
+	if err != nil {
+		return 0, err
+	}
+	longest := installationDuration(config, 1)
+
+	roleNames, err := req.Storage.List("roles/")
+	if err != nil {
+		return 0, err
+	}
+	for _, roleName := range roleNames {
+		role, err := b.getRole(req.Storage, roleName)
+		if err != nil {
+			return 0, err
+		}
+		if role == nil || role.KeyType != KeyTypeDynamic {
+			continue
+		}
+		roleConfig := *config
+		if role.ConnectionTimeout > 0 {
+			roleConfig.Timeout = role.ConnectionTimeout
+		}
+		if role.ConnectionRetries > 0 {
+			roleConfig.Retries = role.ConnectionRetries
+		}
+		if d := installationDuration(&roleConfig, len(role.hostKeyNames())); d > longest {
+			longest = d
+		}
+	}
+	return 2 * longest, nil
+}
+
+// installationDuration returns the longest time the installation of a dynamic
+// key can take with the connection settings when each target is tried with up
+// to hostKeys host keys. Every attempt may connect to a bastion and to the
+// target, with every retry, before running the install script, and the
+// targets are installed on maxConcurrentHosts at a time.
+func installationDuration(config *connectionConfig, hostKeys int) time.Duration {
+	if hostKeys < 1 {
+		hostKeys = 1
+	}
+	dial := time.Duration(config.Retries+1) * config.Timeout
+	for retry := 1; retry <= config.Retries; retry++ {
+		dial += time.Duration(retry) * connectionRetryInterval
+	}
+	attempt := 2*dial + sshOperationTimeout
+	rounds := (maxHostsPerRequest + maxConcurrentHosts - 1) / maxConcurrentHosts
+	return time.Duration(rounds*hostKeys) * attempt
+}
+
+// walRollback removes a dynamic key whose installation was interrupted before
+// it could be recorded, from every host it may have been installed on.
+func (b *backend) walRollback(req *logical.Request, kind string, data interface{}) error {
+	if kind != dynamicKeyWALKind {
+		return fmt.Errorf("unknown WAL entry kind %q", kind)
+	}
+
+	intSec := &dynamicKeyInstallation{}
+	if err := mapstructure.Decode(data, intSec); err != nil {
+		return errwrap.Wrapf("WAL entry could not be decoded: {{err}}", err)
+	}
+
+	b.Logger().Warn("ssh: removing dynamic key of an interrupted installation", "ips", strings.Join(intSec.IPs, ","), "username", intSec.Username)
+	return b.removeDynamicKey(req.Storage, intSec)
+}
+
+// removeDynamicKey removes the dynamic key from every host it was installed
+// on. Removals which fail are queued to be retried periodically and are also
+// returned as errors, so that the caller can retry them as well.
+func (b *backend) removeDynamicKey(s logical.Storage, intSec *dynamicKeyInstallation) error {
+	ips := intSec.IPs
+	if len(ips) == 0 {
+		ips = []string{intSec.IP}
+	}
+
+	failures := forEachHost(ips, func(ip string) error {
+		target := intSec.forHost(ip)
+		err := b.uninstallDynamicKey(s, &target)
+		if err == nil {
+			b.Logger().Info("ssh: removed dynamic key", "ip", ip, "username", target.Username, "fingerprint", target.FingerprintSHA256)
+			if err := b.markUninstalled(s, target.InstallationID, ip); err != nil {
+				return err
+			}
+			return b.dequeueRevocation(s, &target)
+		}
+
+		// The target may only be unreachable for a while. Rather than
+		// leaving the key installed, queue the revocation to be retried
+		// periodically.
+		b.Logger().Warn("ssh: failed to remove dynamic key, queueing it for retry", "ip", ip, "username", target.Username, "fingerprint", target.FingerprintSHA256, "error", err)
+		if qErr := b.queueRevocation(s, &target, err); qErr != nil {
+			return fmt.Errorf("error removing public key from authorized_keys file in target: %v; failed to queue the revocation: %v", err, qErr)
+		}
+		return err
+	})
+
+	switch {
+	case len(failures) == 0:
+		return nil
+	case len(ips) == 1:
+		return failures[ips[0]]
+	default:
+		return fmt.Errorf("failed to remove the dynamic key from some of the hosts: %s", formatHostErrors(failures))
+	}
+}
+
+// uninstallDynamicKey removes the dynamic key from the authorized_keys file
+// in the target.
+func (b *backend) uninstallDynamicKey(s logical.Storage, intSec *dynamicKeyInstallation) error {
+	// Fetch the versions of the host key, starting with the one the key was
+	// installed with, followed by those of the other keys of the role
+	hostKeys, err := b.keyVersionsForUninstall(s, intSec.HostKeyName, intSec.HostKeyVersion)
 	if err != nil {
-		return nil, fmt.Errorf("key %q not found error: %v", intSec.HostKeyName, err)
+		return fmt.Errorf("key %q not found error: %v", intSec.HostKeyName, err)
+	}
+	for _, keyName := range intSec.HostKeyNames {
+		if keyName == intSec.HostKeyName {
+			continue
+		}
+		versions, err := b.keyVersionsForUninstall(s, keyName, 0)
+		if err != nil {
+			return fmt.Errorf("key %q not found error: %v", keyName, err)
+		}
+		hostKeys = append(hostKeys, versions...)
+	}
+	if len(hostKeys) == 0 {
+		return fmt.Errorf("key %q not found", intSec.HostKeyName)
 	}
-	if hostKey == nil {
-		return nil, fmt.Errorf("key %q not found", intSec.HostKeyName)
+
+	// Keys of roles using the shared install script are uninstalled with
+	// the script that is currently shared.
+	installScript := intSec.InstallScript
+	if installScript == "" {
+		installScript, err = b.sharedInstallScript(s)
+		if err != nil {
+			return err
+		}
 	}
 
-	// Remove the public key from authorized_keys file in target machine
-	// The last param 'false' indicates that the key should be uninstalled.
-	err = b.installPublicKeyInTarget(intSec.AdminUser, intSec.Username, intSec.IP, intSec.Port, hostKey.Key, intSec.DynamicPublicKey, intSec.InstallScript, false)
+	// The connection settings of the role are used while it exists
+	var role *sshRole
+	if intSec.RoleName != "" {
+		role, err = b.getRole(s, intSec.RoleName)
+		if err != nil {
+			return fmt.Errorf("error retrieving role: %v", err)
+		}
+	}
+	connConfig, err := b.roleConnectionConfig(s, role)
 	if err != nil {
-		return nil, fmt.Errorf("error removing public key from authorized_keys file in target")
+		return err
 	}
-	return nil, nil
+
+	// Keys are removed through the bastion they were installed through
+	bastion, err := b.getBastion(s, intSec.BastionHost, intSec.BastionPort, intSec.BastionKeyName, intSec.AdminUser)
+	if err != nil {
+		return err
+	}
+
+	// If the target rejects a version of the host key, for instance because
+	// the host key has been rotated since, the other versions and keys are
+	// tried. Other errors would only repeat for every version.
+	for _, hostKey := range hostKeys {
+		// The last param 'false' indicates that the key should be uninstalled.
+		err = b.installPublicKeyFunc(intSec.AdminUser, intSec.Username, intSec.IP, intSec.Port, hostKey, connConfig, bastion, intSec.authorizedKeysEntry(), installScript, intSec.SudoCommand, intSec.authorizedKeysFile(), false)
+		if err == nil || !isAuthenticationError(err) {
+			break
+		}
+	}
+	if err != nil {
+		return fmt.Errorf("error removing public key from authorized_keys file in target: %v", err)
+	}
+	return nil
+}
+
+// isAuthenticationError reports whether the error is caused by the target
+// rejecting the credentials of the host key.
+func isAuthenticationError(err error) bool {
+	return strings.Contains(err.Error(), "unable to authenticate")
 }
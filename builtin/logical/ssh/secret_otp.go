@@ -2,7 +2,9 @@ package ssh
 
 import (
 	"fmt"
+	"time"
 
+	"github.com/hashicorp/vault/helper/locksutil"
 	"github.com/hashicorp/vault/logical"
 	"github.com/hashicorp/vault/logical/framework"
 )
@@ -23,7 +25,11 @@ func secretOTP(b *backend) *framework.Secret {
 	}
 }
 
-func (b *backend) secretOTPRevoke(req *logical.Request, d *framework.FieldData) (*logical.Response, error) {
+func (b *backend) secretOTPRevoke(req *logical.Request, d *framework.FieldData) (resp *logical.Response, retErr error) {
+	defer func(start time.Time) {
+		b.recordOperation(start, operationOutcome(resp, retErr, "revoked"), "revoke", KeyTypeOTP)
+	}(time.Now())
+
 	otpRaw, ok := req.Secret.InternalData["otp"]
 	if !ok {
 		return nil, fmt.Errorf("secret is missing internal data")
@@ -37,9 +43,18 @@ func (b *backend) secretOTPRevoke(req *logical.Request, d *framework.FieldData)
 	if err != nil {
 		return nil, err
 	}
-	err = req.Storage.Delete("otp/" + salt.SaltID(otp))
-	if err != nil {
+	if err := b.deleteOTP(req.Storage, salt.SaltID(otp)); err != nil {
 		return nil, err
 	}
 	return nil, nil
 }
+
+// deleteOTP deletes the entry of the OTP, holding its lock so that it is not
+// deleted while being verified.
+func (b *backend) deleteOTP(s logical.Storage, otpSalted string) error {
+	lock := locksutil.LockForKey(b.otpLocks, otpSalted)
+	lock.Lock()
+	defer lock.Unlock()
+
+	return s.Delete("otp/" + otpSalted)
+}
@@ -0,0 +1,29 @@
+package ssh
+
+import (
+	"github.com/hashicorp/vault/logical"
+	"github.com/hashicorp/vault/logical/framework"
+)
+
+const SecretSignedKeyType = "secret_signed_key_type"
+
+func secretSignedKey(b *backend) *framework.Secret {
+	return &framework.Secret{
+		Type: SecretSignedKeyType,
+		Fields: map[string]*framework.FieldSchema{
+			"serial_number": &framework.FieldSchema{
+				Type:        framework.TypeString,
+				Description: "Serial number of the signed certificate",
+			},
+		},
+
+		Revoke: b.secretSignedKeyRevoke,
+	}
+}
+
+// SSH certificates cannot be recalled once they are handed out; they are
+// only valid until their expiration. The lease exists so that the issuance
+// can be tracked and revoked along with the token that requested it.
+func (b *backend) secretSignedKeyRevoke(req *logical.Request, d *framework.FieldData) (*logical.Response, error) {
+	return nil, nil
+}
@@ -2,20 +2,33 @@ package ssh
 
 import (
 	"bytes"
+	"crypto/ecdsa"
+	"crypto/elliptic"
 	"crypto/rand"
 	"crypto/rsa"
 	"crypto/x509"
 	"encoding/base64"
+	"encoding/binary"
+	"encoding/hex"
 	"encoding/pem"
 	"fmt"
+	"io"
+	"math/big"
 	"net"
+	"os"
+	"sort"
+	"strconv"
 	"strings"
+	"sync"
 	"time"
 
 	"github.com/hashicorp/vault/logical"
+	"github.com/hashicorp/vault/logical/framework"
 
 	log "github.com/mgutz/logxi/v1"
+	"golang.org/x/crypto/ed25519"
 	"golang.org/x/crypto/ssh"
+	"golang.org/x/crypto/ssh/agent"
 )
 
 // Creates a new RSA key pair with the given key length. The private key will be
@@ -39,31 +52,285 @@ func generateRSAKeys(keyBits int) (publicKeyRsa string, privateKeyRsa string, er
 	return
 }
 
+// Creates a new ECDSA key pair on the curve of the given size. The private key
+// will be of SEC1 pem format and the public key will be of OpenSSH format.
+func generateECDSAKeys(curveBits int) (publicKeyECDSA string, privateKeyECDSA string, err error) {
+	var curve elliptic.Curve
+	switch curveBits {
+	case 256:
+		curve = elliptic.P256()
+	case 384:
+		curve = elliptic.P384()
+	case 521:
+		curve = elliptic.P521()
+	default:
+		return "", "", fmt.Errorf("unsupported curve size %d", curveBits)
+	}
+
+	privateKey, err := ecdsa.GenerateKey(curve, rand.Reader)
+	if err != nil {
+		return "", "", fmt.Errorf("error generating ECDSA key-pair: %v", err)
+	}
+
+	privateKeyBytes, err := x509.MarshalECPrivateKey(privateKey)
+	if err != nil {
+		return "", "", fmt.Errorf("error marshaling ECDSA private key: %v", err)
+	}
+	privateKeyECDSA = string(pem.EncodeToMemory(&pem.Block{
+		Type:  "EC PRIVATE KEY",
+		Bytes: privateKeyBytes,
+	}))
+
+	sshPublicKey, err := ssh.NewPublicKey(privateKey.Public())
+	if err != nil {
+		return "", "", fmt.Errorf("error generating ECDSA key-pair: %v", err)
+	}
+	publicKeyECDSA = sshPublicKey.Type() + " " + base64.StdEncoding.EncodeToString(sshPublicKey.Marshal())
+	return
+}
+
+// Creates a new ed25519 key pair. The private key will be in the OpenSSH
+// private key format and the public key will be of OpenSSH format.
+func generateED25519Keys() (publicKeyED25519 string, privateKeyED25519 string, err error) {
+	publicKey, privateKey, err := ed25519.GenerateKey(rand.Reader)
+	if err != nil {
+		return "", "", fmt.Errorf("error generating ed25519 key-pair: %v", err)
+	}
+
+	sshPublicKey, err := ssh.NewPublicKey(publicKey)
+	if err != nil {
+		return "", "", fmt.Errorf("error generating ed25519 key-pair: %v", err)
+	}
+
+	privateKeyBlock, err := marshalOpenSSHPrivateKey(privateKey)
+	if err != nil {
+		return "", "", fmt.Errorf("error marshaling ed25519 private key: %v", err)
+	}
+
+	privateKeyED25519 = string(pem.EncodeToMemory(privateKeyBlock))
+	publicKeyED25519 = sshPublicKey.Type() + " " + base64.StdEncoding.EncodeToString(sshPublicKey.Marshal())
+	return
+}
+
+// Converts a private key generated by one of the functions above to the
+// given format. Keys are generated in the PEM format, except for ed25519 keys
+// which have no PEM encoding and are always generated in the OpenSSH format.
+func formatPrivateKey(privateKeyPEM, format string) (string, error) {
+	block, _ := pem.Decode([]byte(privateKeyPEM))
+	if block == nil {
+		return "", fmt.Errorf("failed to decode private key")
+	}
+
+	switch format {
+	case PrivateKeyFormatPEM:
+		if block.Type == "OPENSSH PRIVATE KEY" {
+			return "", fmt.Errorf("key cannot be encoded in the %q format", format)
+		}
+		return privateKeyPEM, nil
+	case PrivateKeyFormatOpenSSH:
+		if block.Type == "OPENSSH PRIVATE KEY" {
+			return privateKeyPEM, nil
+		}
+		privateKey, err := ssh.ParseRawPrivateKey([]byte(privateKeyPEM))
+		if err != nil {
+			return "", err
+		}
+		openSSHBlock, err := marshalOpenSSHPrivateKey(privateKey)
+		if err != nil {
+			return "", err
+		}
+		return string(pem.EncodeToMemory(openSSHBlock)), nil
+	default:
+		return "", fmt.Errorf("unknown private key format %q", format)
+	}
+}
+
+// Encrypts a PEM encoded private key with the passphrase using AES-256.
+func encryptPrivateKey(privateKeyPEM, passphrase string) (string, error) {
+	block, _ := pem.Decode([]byte(privateKeyPEM))
+	if block == nil {
+		return "", fmt.Errorf("failed to decode private key")
+	}
+	if block.Type == "OPENSSH PRIVATE KEY" {
+		return "", fmt.Errorf("keys in the %q format cannot be encrypted", PrivateKeyFormatOpenSSH)
+	}
+
+	encryptedBlock, err := x509.EncryptPEMBlock(rand.Reader, block.Type, block.Bytes, []byte(passphrase), x509.PEMCipherAES256)
+	if err != nil {
+		return "", err
+	}
+	return string(pem.EncodeToMemory(encryptedBlock)), nil
+}
+
+// Encodes an unencrypted RSA, ECDSA or ed25519 private key in the
+// openssh-key-v1 format described at
+// https://github.com/openssh/openssh-portable/blob/master/PROTOCOL.key
+func marshalOpenSSHPrivateKey(privateKey interface{}) (*pem.Block, error) {
+	var publicKey ssh.PublicKey
+	var keyFields []byte
+	var err error
+	switch key := privateKey.(type) {
+	case *rsa.PrivateKey:
+		publicKey, err = ssh.NewPublicKey(&key.PublicKey)
+		if err != nil {
+			return nil, err
+		}
+		key.Precompute()
+		keyFields = ssh.Marshal(struct {
+			N    *big.Int
+			E    *big.Int
+			D    *big.Int
+			Iqmp *big.Int
+			P    *big.Int
+			Q    *big.Int
+		}{
+			N:    key.N,
+			E:    big.NewInt(int64(key.E)),
+			D:    key.D,
+			Iqmp: key.Precomputed.Qinv,
+			P:    key.Primes[0],
+			Q:    key.Primes[1],
+		})
+	case *ecdsa.PrivateKey:
+		publicKey, err = ssh.NewPublicKey(&key.PublicKey)
+		if err != nil {
+			return nil, err
+		}
+		// The key type is of the form ecdsa-sha2-<curve name>
+		keyFields = ssh.Marshal(struct {
+			Curve string
+			Pub   []byte
+			D     *big.Int
+		}{
+			Curve: strings.TrimPrefix(publicKey.Type(), "ecdsa-sha2-"),
+			Pub:   elliptic.Marshal(key.Curve, key.X, key.Y),
+			D:     key.D,
+		})
+	case ed25519.PrivateKey:
+		publicKey, err = ssh.NewPublicKey(key.Public())
+		if err != nil {
+			return nil, err
+		}
+		keyFields = ssh.Marshal(struct {
+			Pub  []byte
+			Priv []byte
+		}{
+			Pub:  key[32:],
+			Priv: key,
+		})
+	case *ed25519.PrivateKey:
+		return marshalOpenSSHPrivateKey(*key)
+	default:
+		return nil, fmt.Errorf("unsupported private key type %T", privateKey)
+	}
+
+	checkBytes := make([]byte, 4)
+	if _, err := rand.Read(checkBytes); err != nil {
+		return nil, err
+	}
+	check := binary.BigEndian.Uint32(checkBytes)
+
+	privateKeyBlock := ssh.Marshal(struct {
+		Check1  uint32
+		Check2  uint32
+		Keytype string
+	}{
+		Check1:  check,
+		Check2:  check,
+		Keytype: publicKey.Type(),
+	})
+	privateKeyBlock = append(privateKeyBlock, keyFields...)
+	privateKeyBlock = append(privateKeyBlock, ssh.Marshal(struct {
+		Comment string
+	}{})...)
+
+	// The private key block is padded to the cipher block size, which is 8
+	// for the 'none' cipher, using the bytes 1, 2, 3, ...
+	for i := 1; len(privateKeyBlock)%8 != 0; i++ {
+		privateKeyBlock = append(privateKeyBlock, byte(i))
+	}
+
+	key := ssh.Marshal(struct {
+		CipherName   string
+		KdfName      string
+		KdfOpts      string
+		NumKeys      uint32
+		PubKey       []byte
+		PrivKeyBlock []byte
+	}{
+		CipherName:   "none",
+		KdfName:      "none",
+		NumKeys:      1,
+		PubKey:       publicKey.Marshal(),
+		PrivKeyBlock: privateKeyBlock,
+	})
+
+	return &pem.Block{
+		Type:  "OPENSSH PRIVATE KEY",
+		Bytes: append([]byte("openssh-key-v1\x00"), key...),
+	}, nil
+}
+
 // Public key and the script to install the key are uploaded to remote machine.
 // Public key is either added or removed from authorized_keys file using the
-// script. Default script is for a Linux machine and hence the path of the
-// authorized_keys file is hard coded to resemble Linux.
+// script. Default script is for a Linux machine and the authorized_keys file
+// is at 'authorizedKeysPath', which defaults to the Linux location unless the
+// role sets another. All of this is done over a single connection to the
+// target, which is bounded by sshOperationTimeout.
 //
-// The last param 'install' if false, uninstalls the key.
-func (b *backend) installPublicKeyInTarget(adminUser, username, ip string, port int, hostkey, dynamicPublicKey, installScript string, install bool) error {
+// If 'sudoCommand' is set, the script is run with it, e.g. 'sudo -n'. The
+// last param 'install' if false, uninstalls the key. A non-zero exit status
+// of the script is returned as an error.
+func (b *backend) installPublicKeyInTarget(adminUser, username, ip string, port int, hostKey *sshHostKey, connConfig *connectionConfig, bastion *bastionHost, dynamicPublicKey, installScript, sudoCommand, authorizedKeysPath string, install bool) error {
+	// The path is filled in from the request, so it is checked again before
+	// it reaches the shell of the target
+	if err := validateExpandedAuthorizedKeysPath(authorizedKeysPath); err != nil {
+		return fmt.Errorf("invalid authorized_keys path %q: %v", authorizedKeysPath, err)
+	}
+
 	// Transfer the newly generated public key to remote host under a random
 	// file name. This is to avoid name collisions from other requests.
-	_, publicKeyFileName, err := b.GenerateSaltedOTP()
+	_, publicKeyFileName, err := b.GenerateSaltedOTP(OTPFormatUUID, 0)
 	if err != nil {
 		return err
 	}
 
-	comm, err := createSSHComm(b.Logger(), adminUser, ip, port, hostkey)
+	comm, err := createSSHComm(b.Logger(), adminUser, ip, port, hostKey, connConfig, bastion)
 	if err != nil {
 		return err
 	}
 	defer comm.Close()
 
+	uploadStart := time.Now()
 	err = comm.Upload(publicKeyFileName, bytes.NewBufferString(dynamicPublicKey), nil)
 	if err != nil {
 		return fmt.Errorf("error uploading public key: %v", err)
 	}
 
+	var installOption string
+	if install {
+		installOption = "install"
+	} else {
+		installOption = "uninstall"
+	}
+
+	// Scripts written as templates get the values filled in instead of
+	// being passed them as arguments.
+	scriptArgs := fmt.Sprintf(" %s %s %s", installOption, shellQuote(publicKeyFileName), shellQuote(authorizedKeysPath))
+	if isInstallScriptTemplate(installScript) {
+		installScript, err = renderInstallScript(installScript, &installScriptData{
+			PublicKeyFile:      publicKeyFileName,
+			Username:           username,
+			AuthorizedKeysPath: authorizedKeysPath,
+			Install:            install,
+			Uninstall:          !install,
+		})
+		if err != nil {
+			return err
+		}
+		scriptArgs = ""
+	}
+
 	// Transfer the script required to install or uninstall the key to the remote
 	// host under a random file name as well. This is to avoid name collisions
 	// from other requests.
@@ -72,102 +339,733 @@ func (b *backend) installPublicKeyInTarget(adminUser, username, ip string, port
 	if err != nil {
 		return fmt.Errorf("error uploading install script: %v", err)
 	}
+	b.measureSince(uploadStart, "dynamic", "upload")
 
 	// Create a session to run remote command that triggers the script to install
 	// or uninstall the key.
 	session, err := comm.NewSession()
 	if err != nil {
-		return fmt.Errorf("unable to create SSH Session using public keys: %v", err)
+		return fmt.Errorf("unable to create SSH Session: %v", err)
 	}
 	if session == nil {
 		return fmt.Errorf("invalid session object")
 	}
 	defer session.Close()
 
-	authKeysFileName := fmt.Sprintf("/home/%s/.ssh/authorized_keys", username)
-
-	var installOption string
-	if install {
-		installOption = "install"
-	} else {
-		installOption = "uninstall"
-	}
-
-	// Give execute permissions to install script, run and delete it.
+	// Give execute permissions to install script, run and delete it. Only
+	// running the script needs the privileges of sudo, the files are
+	// uploaded to the home directory of the admin user. The command exits
+	// with the status of the script rather than that of removing it.
 	chmodCmd := fmt.Sprintf("chmod +x %s", scriptFileName)
-	scriptCmd := fmt.Sprintf("./%s %s %s %s", scriptFileName, installOption, publicKeyFileName, authKeysFileName)
+	scriptCmd := fmt.Sprintf("./%s%s", scriptFileName, scriptArgs)
+	if sudoCommand != "" {
+		scriptCmd = fmt.Sprintf("%s %s", sudoCommand, scriptCmd)
+	}
 	rmCmd := fmt.Sprintf("rm -f %s", scriptFileName)
-	targetCmd := fmt.Sprintf("%s;%s;%s", chmodCmd, scriptCmd, rmCmd)
+	targetCmd := fmt.Sprintf("%s && %s; rc=$?; %s; exit $rc", chmodCmd, scriptCmd, rmCmd)
+
+	var stderr bytes.Buffer
+	session.Stderr = &stderr
+	err = session.Run(targetCmd)
+
+	// The script did not run at all if sudo wanted a password
+	if sudoCommand != "" && sudoPasswordRequired(stderr.String()) {
+		return fmt.Errorf("sudo on %s requires a password for %q; allow %q to run the install script without one by adding NOPASSWD to its sudoers entry", ip, adminUser, adminUser)
+	}
 
-	session.Run(targetCmd)
+	// A failed script leaves the key installed, or not installed, so it
+	// fails the request as errors of the connection do
+	if err != nil {
+		if exitErr, ok := err.(*ssh.ExitError); ok {
+			return fmt.Errorf("install script on %s exited with status %d: %s", ip, exitErr.ExitStatus(), strings.TrimSpace(stderr.String()))
+		}
+		return fmt.Errorf("error running install script: %v", err)
+	}
 	return nil
 }
 
-// Takes an IP address and role name and checks if the IP is part
-// of CIDR blocks belonging to the role.
-func roleContainsIP(s logical.Storage, roleName string, ip string) (bool, error) {
-	if roleName == "" {
-		return false, fmt.Errorf("missing role name")
+// shellQuote quotes the argument so that a POSIX shell passes it on as a
+// single word without interpreting any of its characters.
+func shellQuote(arg string) string {
+	return "'" + strings.Replace(arg, "'", `'"'"'`, -1) + "'"
+}
+
+// sudoPasswordRequired reports whether the output of a command shows that
+// sudo refused to run it because it needed a password.
+func sudoPasswordRequired(output string) bool {
+	for _, message := range []string{
+		"sudo: a password is required",
+		"sudo: a terminal is required to read the password",
+		"sudo: no tty present and no askpass program specified",
+	} {
+		if strings.Contains(output, message) {
+			return true
+		}
 	}
+	return false
+}
 
-	if ip == "" {
-		return false, fmt.Errorf("missing ip")
+// Returns the names of the OTP and dynamic roles that would accept a
+// credential request for the given IP. This applies the same validation as
+// the creds endpoint, including exclude lists and zero-address roles.
+func (b *backend) rolesForIP(s logical.Storage, ip string) ([]string, error) {
+	keys, err := s.List("roles/")
+	if err != nil {
+		return nil, err
 	}
 
-	roleEntry, err := s.Get(fmt.Sprintf("roles/%s", roleName))
+	zeroAddressEntry, err := b.getZeroAddressRoles(s)
 	if err != nil {
-		return false, fmt.Errorf("error retrieving role %v", err)
+		return nil, err
 	}
-	if roleEntry == nil {
-		return false, fmt.Errorf("role %q not found", roleName)
+	var zeroAddressRoles []string
+	if zeroAddressEntry != nil {
+		zeroAddressRoles = zeroAddressEntry.Roles
 	}
 
-	var role sshRole
-	if err := roleEntry.DecodeJSON(&role); err != nil {
-		return false, fmt.Errorf("error decoding role %q", roleName)
+	matchingRoles := []string{}
+	for _, roleName := range keys {
+		role, err := b.getRole(s, roleName)
+		if err != nil {
+			return nil, fmt.Errorf("error retrieving role %q: %v", roleName, err)
+		}
+		// CA roles do not restrict the IPs of the hosts
+		if role == nil || role.KeyType == KeyTypeCA {
+			continue
+		}
+		if err := validateIP(ip, roleName, role.CIDRList, role.ExcludeCIDRList, zeroAddressRoles); err == nil {
+			matchingRoles = append(matchingRoles, roleName)
+		}
 	}
 
-	if matched, err := cidrListContainsIP(ip, role.CIDRList); err != nil {
-		return false, err
-	} else {
-		return matched, nil
+	return matchingRoles, nil
+}
+
+// canonicalIP parses the IP address and returns it in canonical form, which
+// is the compressed form for IPv6 addresses and the dotted form for IPv4
+// addresses, including IPv4-mapped IPv6 addresses. IPv6 addresses may be
+// enclosed in brackets, as they are in URLs.
+func canonicalIP(ip string) (string, bool) {
+	ip = strings.TrimSpace(ip)
+	if strings.HasPrefix(ip, "[") && strings.HasSuffix(ip, "]") {
+		ip = ip[1 : len(ip)-1]
+	}
+	parsed := net.ParseIP(ip)
+	if parsed == nil {
+		return "", false
 	}
+	return parsed.String(), true
 }
 
-// Returns true if the IP supplied by the user is part of the comma
-// separated CIDR blocks
-func cidrListContainsIP(ip, cidrList string) (bool, error) {
+// Returns true if the IP supplied by the user is part of the CIDR blocks
+func cidrListContainsIP(ip string, cidrList []string) (bool, error) {
 	if len(cidrList) == 0 {
 		return false, fmt.Errorf("IP does not belong to role")
 	}
-	for _, item := range strings.Split(cidrList, ",") {
+	block, err := cidrListMatch(ip, cidrList)
+	if err != nil {
+		return false, err
+	}
+	return block != "", nil
+}
+
+// cidrListMatch returns the first CIDR block in the list that contains the
+// given IP, or an empty string if none of them does.
+func cidrListMatch(ip string, cidrList []string) (string, error) {
+	for _, item := range cidrList {
+		// Lists are normalized when the role is written, but roles written
+		// before that may still contain whitespace.
+		item = strings.TrimSpace(item)
 		_, cidrIPNet, err := net.ParseCIDR(item)
 		if err != nil {
-			return false, fmt.Errorf("invalid CIDR entry %q", item)
+			return "", fmt.Errorf("invalid CIDR entry %q", item)
 		}
 		if cidrIPNet.Contains(net.ParseIP(ip)) {
-			return true, nil
+			return item, nil
+		}
+	}
+	return "", nil
+}
+
+// cidrListsOverlap reports whether any block of the first list intersects
+// any block of the second. Unparsable entries are ignored.
+func cidrListsOverlap(a, b []string) bool {
+	for _, itemA := range a {
+		_, netA, err := net.ParseCIDR(strings.TrimSpace(itemA))
+		if err != nil {
+			continue
+		}
+		for _, itemB := range b {
+			_, netB, err := net.ParseCIDR(strings.TrimSpace(itemB))
+			if err != nil {
+				continue
+			}
+			// Two CIDR blocks intersect exactly when one contains the
+			// network address of the other
+			if netA.Contains(netB.IP) || netB.Contains(netA.IP) {
+				return true
+			}
+		}
+	}
+	return false
+}
+
+// randomString returns a string of the given length with characters drawn
+// uniformly from the alphabet using crypto/rand.
+func randomString(alphabet string, length int) (string, error) {
+	max := big.NewInt(int64(len(alphabet)))
+	result := make([]byte, length)
+	for i := range result {
+		n, err := rand.Int(rand.Reader, max)
+		if err != nil {
+			return "", err
+		}
+		result[i] = alphabet[n.Int64()]
+	}
+	return string(result), nil
+}
+
+// normalizeCIDRList parses the list of CIDR blocks and returns it in
+// canonical form: entries are trimmed, lowercased, reduced to their network
+// address and deduplicated. Entries without a mask are taken to be single
+// hosts and are given a /32 or /128 mask, which is reported in the returned
+// warnings.
+func normalizeCIDRList(cidrList []string) ([]string, []string, error) {
+	var cidrs, warnings []string
+	seen := map[string]bool{}
+	for _, item := range cidrList {
+		item = strings.ToLower(strings.TrimSpace(item))
+		if item == "" {
+			continue
+		}
+		if !strings.Contains(item, "/") {
+			ip := net.ParseIP(item)
+			if ip == nil {
+				return nil, nil, fmt.Errorf("invalid CIDR entry %q", item)
+			}
+			mask := "/32"
+			if ip.To4() == nil {
+				mask = "/128"
+			}
+			warnings = append(warnings, fmt.Sprintf("CIDR entry %q has no mask; assuming %s", item, item+mask))
+			item += mask
+		}
+		_, cidrIPNet, err := net.ParseCIDR(item)
+		if err != nil {
+			return nil, nil, fmt.Errorf("invalid CIDR entry %q", item)
 		}
+		cidr := cidrIPNet.String()
+		if !seen[cidr] {
+			seen[cidr] = true
+			cidrs = append(cidrs, cidr)
+		}
+	}
+	return cidrs, warnings, nil
+}
+
+// isZeroAddressCIDR reports whether the CIDR block encompasses every address
+// of its family, i.e. 0.0.0.0/0 or ::/0.
+func isZeroAddressCIDR(cidr string) bool {
+	_, cidrIPNet, err := net.ParseCIDR(strings.TrimSpace(cidr))
+	if err != nil {
+		return false
 	}
-	return false, nil
+	ones, _ := cidrIPNet.Mask.Size()
+	return ones == 0
 }
 
-func createSSHComm(logger log.Logger, username, ip string, port int, hostkey string) (*comm, error) {
-	signer, err := ssh.ParsePrivateKey([]byte(hostkey))
+// isBroadCIDR reports whether the CIDR block is wider than a /8 for IPv4 or
+// a /32 for IPv6, short of encompassing every address.
+func isBroadCIDR(cidr string) bool {
+	_, cidrIPNet, err := net.ParseCIDR(strings.TrimSpace(cidr))
+	if err != nil {
+		return false
+	}
+	ones, bits := cidrIPNet.Mask.Size()
+	if ones == 0 {
+		return false
+	}
+	if bits == 32 {
+		return ones < 8
+	}
+	return ones < 32
+}
+
+const (
+	// Maximum number of hosts a dynamic key is installed on, or removed
+	// from, concurrently
+	maxConcurrentHosts = 8
+
+	// Maximum number of hosts a dynamic key can be installed on with a
+	// single request
+	maxHostsPerRequest = 64
+)
+
+// forEachHost calls f for each of the IPs, running at most maxConcurrentHosts
+// calls at a time, and returns the errors by IP.
+func forEachHost(ips []string, f func(ip string) error) map[string]error {
+	var lock sync.Mutex
+	var wg sync.WaitGroup
+	errs := map[string]error{}
+	sem := make(chan struct{}, maxConcurrentHosts)
+	for _, ip := range ips {
+		wg.Add(1)
+		sem <- struct{}{}
+		go func(ip string) {
+			defer wg.Done()
+			defer func() { <-sem }()
+			if err := f(ip); err != nil {
+				lock.Lock()
+				errs[ip] = err
+				lock.Unlock()
+			}
+		}(ip)
+	}
+	wg.Wait()
+	return errs
+}
+
+// formatHostErrors formats the errors by IP, ordered by IP.
+func formatHostErrors(errs map[string]error) string {
+	ips := make([]string, 0, len(errs))
+	for ip := range errs {
+		ips = append(ips, ip)
+	}
+	sort.Strings(ips)
+
+	formatted := make([]string, 0, len(ips))
+	for _, ip := range ips {
+		formatted = append(formatted, fmt.Sprintf("%s: %v", ip, errs[ip]))
+	}
+	return strings.Join(formatted, "; ")
+}
+
+// Bounds of valid TCP port numbers
+const (
+	minPort = 1
+	maxPort = 65535
+)
+
+// portRange is an inclusive range of port numbers.
+type portRange struct {
+	min int
+	max int
+}
+
+// parsePortRanges parses a comma separated list of ports and port ranges such
+// as '22,2222,8000-8100'. An empty list yields no ranges.
+func parsePortRanges(portList string) ([]portRange, error) {
+	var result []portRange
+	for _, item := range strings.Split(portList, ",") {
+		item = strings.TrimSpace(item)
+		if item == "" {
+			continue
+		}
+
+		bounds := strings.SplitN(item, "-", 2)
+		var portRange portRange
+		var err error
+		if portRange.min, err = strconv.Atoi(strings.TrimSpace(bounds[0])); err != nil {
+			return nil, fmt.Errorf("invalid port %q", item)
+		}
+		portRange.max = portRange.min
+		if len(bounds) == 2 {
+			if portRange.max, err = strconv.Atoi(strings.TrimSpace(bounds[1])); err != nil {
+				return nil, fmt.Errorf("invalid port range %q", item)
+			}
+		}
+
+		if portRange.min < minPort || portRange.max > maxPort {
+			return nil, fmt.Errorf("port %q must be between %d and %d", item, minPort, maxPort)
+		}
+		if portRange.min > portRange.max {
+			return nil, fmt.Errorf("invalid port range %q", item)
+		}
+		result = append(result, portRange)
+	}
+	return result, nil
+}
+
+// normalizePortRanges parses the comma separated list of ports and port ranges
+// and returns it sorted, with overlapping and adjacent ranges merged, e.g.
+// '2200-2299,22,2300' becomes '22,2200-2300'.
+func normalizePortRanges(portList string) (string, error) {
+	portRanges, err := parsePortRanges(portList)
+	if err != nil {
+		return "", err
+	}
+	sort.Slice(portRanges, func(i, j int) bool {
+		return portRanges[i].min < portRanges[j].min
+	})
+
+	var merged []portRange
+	for _, portRange := range portRanges {
+		if n := len(merged); n > 0 && portRange.min <= merged[n-1].max+1 {
+			if portRange.max > merged[n-1].max {
+				merged[n-1].max = portRange.max
+			}
+			continue
+		}
+		merged = append(merged, portRange)
+	}
+
+	items := make([]string, 0, len(merged))
+	for _, portRange := range merged {
+		if portRange.min == portRange.max {
+			items = append(items, strconv.Itoa(portRange.min))
+		} else {
+			items = append(items, fmt.Sprintf("%d-%d", portRange.min, portRange.max))
+		}
+	}
+	return strings.Join(items, ","), nil
+}
+
+// portInRanges reports whether the port is within any of the ranges.
+func portInRanges(port int, portRanges []portRange) bool {
+	for _, portRange := range portRanges {
+		if port >= portRange.min && port <= portRange.max {
+			return true
+		}
+	}
+	return false
+}
+
+// connectionTimeoutError is returned when every attempt to connect to a
+// target timed out.
+type connectionTimeoutError struct {
+	address string
+	elapsed time.Duration
+
+	// Set to "bastion" if connecting to the bastion in front of the
+	// target timed out
+	hop string
+}
+
+func (e *connectionTimeoutError) Error() string {
+	if e.hop != "" {
+		return fmt.Sprintf("timed out connecting to %s %s after %s", e.hop, e.address, e.elapsed)
+	}
+	return fmt.Sprintf("timed out connecting to %s after %s", e.address, e.elapsed)
+}
+
+// unreachableError is returned when no connection could be established to a
+// target or to the bastion in front of it.
+type unreachableError struct {
+	err error
+}
+
+func (e *unreachableError) Error() string {
+	return e.err.Error()
+}
+
+// isUnreachableError returns whether the error is due to a target or bastion
+// not accepting connections, as opposed to rejecting the key or failing to run
+// the install script.
+func isUnreachableError(err error) bool {
+	switch err.(type) {
+	case *connectionTimeoutError, *unreachableError:
+		return true
+	}
+	return false
+}
+
+// dialWithRetries connects to the address, retrying failed attempts with a
+// linear backoff as configured. If the last attempt timed out, a
+// *connectionTimeoutError is returned, otherwise an *unreachableError.
+func dialWithRetries(address string, connConfig *connectionConfig) (net.Conn, error) {
+	start := time.Now()
+	var err error
+	for attempt := 0; attempt <= connConfig.Retries; attempt++ {
+		if attempt > 0 {
+			time.Sleep(time.Duration(attempt) * connectionRetryInterval)
+		}
+
+		var c net.Conn
+		c, err = net.DialTimeout("tcp", address, connConfig.Timeout)
+		if err == nil {
+			return c, nil
+		}
+	}
+
+	if netErr, ok := err.(net.Error); ok && netErr.Timeout() {
+		return nil, &connectionTimeoutError{
+			address: address,
+			elapsed: time.Since(start).Round(time.Millisecond),
+		}
+	}
+	return nil, &unreachableError{err}
+}
+
+// Installing or uninstalling a key, which uploads the key and the install
+// script and runs the script over a single connection, must complete within
+// this duration.
+var sshOperationTimeout = 2 * time.Minute
+
+// Structure to hold the bastion through which the target of a dynamic key is
+// connected to.
+type bastionHost struct {
+	Address  string
+	Username string
+	HostKey  *sshHostKey
+}
+
+// getBastion returns the bastion at the host and port, which is logged into as
+// the user with the named shared key. A nil bastion is returned if no host is
+// given.
+func (b *backend) getBastion(s logical.Storage, host string, port int, keyName, username string) (*bastionHost, error) {
+	if host == "" {
+		return nil, nil
+	}
+
+	hostKey, err := b.getKey(s, keyName)
+	if err != nil {
+		return nil, fmt.Errorf("error retrieving bastion key %q: %v", keyName, err)
+	}
+	if hostKey == nil {
+		return nil, fmt.Errorf("bastion key %q not found", keyName)
+	}
+
+	return &bastionHost{
+		Address:  net.JoinHostPort(host, strconv.Itoa(port)),
+		Username: username,
+		HostKey:  hostKey,
+	}, nil
+}
+
+// tunnelConn is a connection to a target tunnelled through a bastion. Closing
+// it closes the connection to the bastion as well. The tunnel does not support
+// deadlines, so they are set on the connection to the bastion instead.
+type tunnelConn struct {
+	net.Conn
+	client    *ssh.Client
+	transport net.Conn
+}
+
+func (c *tunnelConn) Close() error {
+	c.Conn.Close()
+	return c.client.Close()
+}
+
+func (c *tunnelConn) SetDeadline(t time.Time) error {
+	return c.transport.SetDeadline(t)
+}
+
+func (c *tunnelConn) SetReadDeadline(t time.Time) error {
+	return c.transport.SetReadDeadline(t)
+}
+
+func (c *tunnelConn) SetWriteDeadline(t time.Time) error {
+	return c.transport.SetWriteDeadline(t)
+}
+
+// dialThroughBastion connects to the bastion and opens a tunnel from it to the
+// address. Errors name the hop which failed.
+func dialThroughBastion(bastion *bastionHost, address string, connConfig *connectionConfig) (net.Conn, error) {
+	authMethods, err := hostKeyAuthMethods(bastion.HostKey)
+	if err != nil {
+		return nil, fmt.Errorf("error connecting to bastion %s: %v", bastion.Address, err)
+	}
+
+	transport, err := dialWithRetries(bastion.Address, connConfig)
+	if err != nil {
+		if timeoutErr, ok := err.(*connectionTimeoutError); ok {
+			timeoutErr.hop = "bastion"
+			return nil, timeoutErr
+		}
+		return nil, &unreachableError{fmt.Errorf("error connecting to bastion %s: %v", bastion.Address, err)}
+	}
+
+	// The handshake with the bastion and opening the tunnel are bounded by
+	// the connection timeout as well.
+	if connConfig.Timeout > 0 {
+		transport.SetDeadline(time.Now().Add(connConfig.Timeout))
+	}
+
+	sshConn, chans, reqs, err := ssh.NewClientConn(transport, bastion.Address, &ssh.ClientConfig{
+		User:            bastion.Username,
+		Auth:            authMethods,
+		HostKeyCallback: connConfig.hostKeyCallback(),
+	})
+	if err != nil {
+		transport.Close()
+		return nil, fmt.Errorf("error connecting to bastion %s: %v", bastion.Address, err)
+	}
+	client := ssh.NewClient(sshConn, chans, reqs)
+
+	c, err := client.Dial("tcp", address)
+	if err != nil {
+		client.Close()
+		return nil, &unreachableError{fmt.Errorf("error connecting to target %s through bastion %s: %v", address, bastion.Address, err)}
+	}
+	transport.SetDeadline(time.Time{})
+
+	return &tunnelConn{
+		Conn:      c,
+		client:    client,
+		transport: transport,
+	}, nil
+}
+
+// Returns the methods to authenticate with the shared key. If the shared key
+// holds both a private key and a password, the private key is tried first and
+// the password is used as a fallback. Keys held by an SSH agent are tried in
+// place of a private key.
+func hostKeyAuthMethods(hostKey *sshHostKey) ([]ssh.AuthMethod, error) {
+	var authMethods []ssh.AuthMethod
+	if hostKey.Agent {
+		signers, err := agentSigners(hostKey)
+		if err != nil {
+			return nil, err
+		}
+		authMethods = append(authMethods, ssh.PublicKeys(signers...))
+	} else if hostKey.Key != "" {
+		signer, err := ssh.ParsePrivateKey([]byte(hostKey.Key))
+		if err != nil {
+			return nil, err
+		}
+		authMethods = append(authMethods, ssh.PublicKeys(signer))
+	}
+	if hostKey.Password != "" {
+		authMethods = append(authMethods, ssh.Password(hostKey.Password))
+	}
+	if len(authMethods) == 0 {
+		return nil, fmt.Errorf("shared key holds neither a private key nor a password")
+	}
+	return authMethods, nil
+}
+
+// agentSocket returns the path of the socket of the SSH agent holding the
+// shared key.
+func (k *sshHostKey) agentSocket() (string, error) {
+	if k.AgentSocket != "" {
+		return k.AgentSocket, nil
+	}
+	socket := os.Getenv("SSH_AUTH_SOCK")
+	if socket == "" {
+		return "", fmt.Errorf("no SSH agent socket is configured for the key and SSH_AUTH_SOCK is not set")
+	}
+	return socket, nil
+}
+
+// agentSigners returns signers for the keys held by the SSH agent of the shared
+// key which match its fingerprint, or for all the keys of the agent if no
+// fingerprint is set.
+func agentSigners(hostKey *sshHostKey) ([]ssh.Signer, error) {
+	socket, err := hostKey.agentSocket()
+	if err != nil {
+		return nil, err
+	}
+
+	conn, err := net.Dial("unix", socket)
+	if err != nil {
+		return nil, fmt.Errorf("error connecting to SSH agent at %s: %v", socket, err)
+	}
+	defer conn.Close()
+
+	keys, err := agent.NewClient(conn).List()
+	if err != nil {
+		return nil, fmt.Errorf("error listing the keys of SSH agent at %s: %v", socket, err)
+	}
+
+	var signers []ssh.Signer
+	for _, key := range keys {
+		if hostKey.AgentFingerprint != "" && !fingerprintMatches(key, hostKey.AgentFingerprint) {
+			continue
+		}
+		signers = append(signers, &agentSigner{
+			socket:    socket,
+			publicKey: key,
+		})
+	}
+	if len(signers) == 0 {
+		if hostKey.AgentFingerprint != "" {
+			return nil, fmt.Errorf("SSH agent at %s holds no key with fingerprint %s", socket, hostKey.AgentFingerprint)
+		}
+		return nil, fmt.Errorf("SSH agent at %s holds no keys", socket)
+	}
+	return signers, nil
+}
+
+// agentSigner signs with a key held by an SSH agent. The agent is connected to
+// for every signature, so that the signer does not depend on the lifetime of a
+// connection to the agent.
+type agentSigner struct {
+	socket    string
+	publicKey ssh.PublicKey
+}
+
+func (s *agentSigner) PublicKey() ssh.PublicKey {
+	return s.publicKey
+}
+
+func (s *agentSigner) Sign(rand io.Reader, data []byte) (*ssh.Signature, error) {
+	conn, err := net.Dial("unix", s.socket)
+	if err != nil {
+		return nil, fmt.Errorf("error connecting to SSH agent at %s: %v", s.socket, err)
+	}
+	defer conn.Close()
+
+	signature, err := agent.NewClient(conn).Sign(s.publicKey, data)
+	if err != nil {
+		return nil, fmt.Errorf("error signing with SSH agent at %s: %v", s.socket, err)
+	}
+	return signature, nil
+}
+
+// fingerprintMatches reports whether the fingerprint is that of the key. Both
+// SHA256 fingerprints and legacy MD5 fingerprints, optionally prefixed with
+// "MD5:", are accepted.
+func fingerprintMatches(key ssh.PublicKey, fingerprint string) bool {
+	if strings.HasPrefix(fingerprint, "SHA256:") {
+		return fingerprint == ssh.FingerprintSHA256(key)
+	}
+	return strings.ToLower(strings.TrimPrefix(fingerprint, "MD5:")) == ssh.FingerprintLegacyMD5(key)
+}
+
+// validFingerprint reports whether the string has the format of a SHA256 or
+// legacy MD5 fingerprint.
+func validFingerprint(fingerprint string) bool {
+	if strings.HasPrefix(fingerprint, "SHA256:") {
+		_, err := base64.RawStdEncoding.DecodeString(strings.TrimPrefix(fingerprint, "SHA256:"))
+		return err == nil && len(fingerprint) == len("SHA256:")+43
+	}
+	parts := strings.Split(strings.TrimPrefix(fingerprint, "MD5:"), ":")
+	if len(parts) != 16 {
+		return false
+	}
+	for _, part := range parts {
+		if _, err := hex.DecodeString(part); err != nil || len(part) != 2 {
+			return false
+		}
+	}
+	return true
+}
+
+// Creates a connection to the target authenticating with the shared key. If a
+// bastion is given, the connection is tunnelled through it.
+func createSSHComm(logger log.Logger, username, ip string, port int, hostKey *sshHostKey, connConfig *connectionConfig, bastion *bastionHost) (*comm, error) {
+	authMethods, err := hostKeyAuthMethods(hostKey)
 	if err != nil {
 		return nil, err
 	}
 
 	clientConfig := &ssh.ClientConfig{
-		User: username,
-		Auth: []ssh.AuthMethod{
-			ssh.PublicKeys(signer),
-		},
-		HostKeyCallback: ssh.InsecureIgnoreHostKey(),
+		User:            username,
+		Auth:            authMethods,
+		HostKeyCallback: connConfig.hostKeyCallback(),
 	}
 
+	address := net.JoinHostPort(ip, strconv.Itoa(port))
+
+	var dialErr error
 	connfunc := func() (net.Conn, error) {
-		c, err := net.DialTimeout("tcp", fmt.Sprintf("%s:%d", ip, port), 15*time.Second)
+		if bastion != nil {
+			var c net.Conn
+			c, dialErr = dialThroughBastion(bastion, address, connConfig)
+			return c, dialErr
+		}
+
+		c, err := dialWithRetries(address, connConfig)
 		if err != nil {
 			return nil, err
 		}
@@ -185,9 +1083,14 @@ func createSSHComm(logger log.Logger, username, ip string, port int, hostkey str
 		Pty:          false,
 		DisableAgent: true,
 		Logger:       logger,
+		Timeout:      sshOperationTimeout,
 	}
 
-	return SSHCommNew(fmt.Sprintf("%s:%d", ip, port), config)
+	comm, err := SSHCommNew(address, config)
+	if err != nil && bastion != nil && err != dialErr {
+		return nil, fmt.Errorf("error connecting to target %s through bastion %s: %v", address, bastion.Address, err)
+	}
+	return comm, err
 }
 
 func parsePublicSSHKey(key string) (ssh.PublicKey, error) {
@@ -205,14 +1108,6 @@ func parsePublicSSHKey(key string) (ssh.PublicKey, error) {
 	return ssh.ParsePublicKey([]byte(decodedKey))
 }
 
-func convertMapToStringValue(initial map[string]interface{}) map[string]string {
-	result := map[string]string{}
-	for key, value := range initial {
-		result[key] = fmt.Sprintf("%v", value)
-	}
-	return result
-}
-
 // Serve a template processor for custom format inputs
 func substQuery(tpl string, data map[string]string) string {
 	for k, v := range data {
@@ -221,3 +1116,35 @@ func substQuery(tpl string, data map[string]string) string {
 
 	return tpl
 }
+
+// listResponse returns the schema of the responses of list operations, which
+// give the information of the entries in key_info.
+func listResponse(keys, keyInfo string) map[string]*framework.FieldSchema {
+	return map[string]*framework.FieldSchema{
+		"keys": &framework.FieldSchema{
+			Type:        framework.TypeStringSlice,
+			Description: keys,
+		},
+		"key_info": &framework.FieldSchema{
+			Type:        framework.TypeMap,
+			Description: keyInfo,
+		},
+	}
+}
+
+// checkFieldTypes refuses the request as a bad request if a value given for
+// one of the fields does not convert to the type of the field. Handlers call
+// it before reading their fields, which cannot panic afterwards.
+func checkFieldTypes(d *framework.FieldData) error {
+	fields := make([]string, 0, len(d.Schema))
+	for field := range d.Schema {
+		fields = append(fields, field)
+	}
+	sort.Strings(fields)
+	for _, field := range fields {
+		if _, _, err := d.GetOkErr(field); err != nil {
+			return &logical.StatusBadRequest{Err: fmt.Sprintf("invalid value for field %s: %v", field, err)}
+		}
+	}
+	return nil
+}
@@ -35,13 +35,15 @@ func (c *DeleteCommand) Run(args []string) int {
 		return 2
 	}
 
-	if _, err := client.Logical().Delete(path); err != nil {
+	secret, err := client.Logical().Delete(path)
+	if err != nil {
 		c.Ui.Error(fmt.Sprintf(
 			"Error deleting '%s': %s", path, err))
 		return 1
 	}
 
 	c.Ui.Output(fmt.Sprintf("Success! Deleted '%s' if it existed.", path))
+	outputWarnings(c.Ui, secret)
 	return 0
 }
 
@@ -116,23 +116,8 @@ func (t TableFormatter) OutputList(ui cli.Ui, secret *api.Secret, list []interfa
 		}
 	}
 
-	tableOutputStr := columnize.Format(input, config)
-
-	// Print the warning separately because the length of first
-	// column in the output will be increased by the length of
-	// the longest warning string making the output look bad.
-	warningsInput := make([]string, 0, 5)
-	if len(secret.Warnings) != 0 {
-		warningsInput = append(warningsInput, "")
-		warningsInput = append(warningsInput, "The following warnings were returned from the Vault server:")
-		for _, warning := range secret.Warnings {
-			warningsInput = append(warningsInput, fmt.Sprintf("* %s", warning))
-		}
-	}
-
-	warningsOutputStr := columnize.Format(warningsInput, config)
-
-	ui.Output(fmt.Sprintf("%s\n%s", tableOutputStr, warningsOutputStr))
+	ui.Output(columnize.Format(input, config))
+	outputWarnings(ui, secret)
 
 	return nil
 }
@@ -203,23 +188,20 @@ func (t TableFormatter) OutputSecret(ui cli.Ui, secret, s *api.Secret) error {
 		}
 	}
 
-	tableOutputStr := columnize.Format(input, config)
-
-	// Print the warning separately because the length of first
-	// column in the output will be increased by the length of
-	// the longest warning string making the output look bad.
-	warningsInput := make([]string, 0, 5)
-	if len(s.Warnings) != 0 {
-		warningsInput = append(warningsInput, "")
-		warningsInput = append(warningsInput, "The following warnings were returned from the Vault server:")
-		for _, warning := range s.Warnings {
-			warningsInput = append(warningsInput, fmt.Sprintf("* %s", warning))
-		}
-	}
-
-	warningsOutputStr := columnize.Format(warningsInput, config)
-
-	ui.Output(fmt.Sprintf("%s\n%s", tableOutputStr, warningsOutputStr))
+	ui.Output(columnize.Format(input, config))
+	outputWarnings(ui, s)
 
 	return nil
 }
+
+// outputWarnings prints the warnings of the secret on the error output, so
+// that they neither break up the table nor end up in redirected output.
+func outputWarnings(ui cli.Ui, secret *api.Secret) {
+	if secret == nil || len(secret.Warnings) == 0 {
+		return
+	}
+	ui.Warn("\nThe following warnings were returned from the Vault server:")
+	for _, warning := range secret.Warnings {
+		ui.Warn(fmt.Sprintf("* %s", warning))
+	}
+}
@@ -7,6 +7,7 @@ import (
 	"github.com/ghodss/yaml"
 	"github.com/hashicorp/vault/api"
 	"github.com/hashicorp/vault/helper/jsonutil"
+	"github.com/mitchellh/cli"
 )
 
 var output string
@@ -80,3 +81,20 @@ func TestTableFormatter(t *testing.T) {
 		t.Fatal("did not find 'something'")
 	}
 }
+
+func TestTableFormatter_warnings(t *testing.T) {
+	ui := new(cli.MockUi)
+	s := api.Secret{
+		Data:     map[string]interface{}{"k": "something"},
+		Warnings: []string{"be careful"},
+	}
+	if err := outputWithFormat(ui, "table", &s, &s); err != 0 {
+		t.Fatal(err)
+	}
+	if strings.Contains(ui.OutputWriter.String(), "be careful") {
+		t.Fatalf("warning in output: %s", ui.OutputWriter.String())
+	}
+	if !strings.Contains(ui.ErrorWriter.String(), "* be careful") {
+		t.Fatalf("warning not in error output: %s", ui.ErrorWriter.String())
+	}
+}
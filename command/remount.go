@@ -61,9 +61,9 @@ Usage: vault remount [options] from to
   Remount a mounted secret backend to a new path.
 
   This command remounts a secret backend that is already mounted to
-  a new path. All the secrets from the old path will be revoked, but
-  the data associated with the backend (such as configuration), will
-  be preserved.
+  a new path. The data associated with the backend (such as
+  configuration) is preserved, and the leases of secrets from the old
+  path are moved to the new one, changing the prefix of their lease IDs.
 
   Example: vault remount secret/ generic/
 
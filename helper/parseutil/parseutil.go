@@ -18,7 +18,10 @@ func ParseDurationSecond(in interface{}) (time.Duration, error) {
 	}
 	switch in.(type) {
 	case string:
-		inp := in.(string)
+		inp := strings.TrimSpace(in.(string))
+		if inp == "" {
+			return dur, nil
+		}
 		var err error
 		// Look for a suffix otherwise its a plain second value
 		if strings.HasSuffix(inp, "s") || strings.HasSuffix(inp, "m") || strings.HasSuffix(inp, "h") {
@@ -27,10 +30,14 @@ func ParseDurationSecond(in interface{}) (time.Duration, error) {
 				return dur, err
 			}
 		} else {
-			// Plain integer
+			// Plain integer, or a fractional number of seconds
 			secs, err := strconv.ParseInt(inp, 10, 64)
 			if err != nil {
-				return dur, err
+				fsecs, ferr := strconv.ParseFloat(inp, 64)
+				if ferr != nil {
+					return dur, err
+				}
+				return time.Duration(fsecs * float64(time.Second)), nil
 			}
 			dur = time.Duration(secs) * time.Second
 		}
@@ -46,6 +53,10 @@ func ParseDurationSecond(in interface{}) (time.Duration, error) {
 		dur = time.Duration(in.(uint32)) * time.Second
 	case uint64:
 		dur = time.Duration(in.(uint64)) * time.Second
+	case float32:
+		dur = time.Duration(float64(in.(float32)) * float64(time.Second))
+	case float64:
+		dur = time.Duration(in.(float64) * float64(time.Second))
 	default:
 		return 0, errors.New("could not parse duration from input")
 	}
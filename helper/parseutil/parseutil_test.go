@@ -28,6 +28,30 @@ func Test_ParseDurationSecond(t *testing.T) {
 	if outp != time.Duration(4352)*time.Second {
 		t.Fatal("not equivalent")
 	}
+	outp, err = ParseDurationSecond(json.Number("1.5"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if outp != 1500*time.Millisecond {
+		t.Fatal("not equivalent")
+	}
+	outp, err = ParseDurationSecond(float64(90))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if outp != 90*time.Second {
+		t.Fatal("not equivalent")
+	}
+	outp, err = ParseDurationSecond("")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if outp != 0 {
+		t.Fatal("not equivalent")
+	}
+	if _, err := ParseDurationSecond("soon"); err == nil {
+		t.Fatal("expected error")
+	}
 }
 
 func Test_ParseBool(t *testing.T) {
@@ -57,8 +57,10 @@ func Handler(core *vault.Core) http.Handler {
 	mux.Handle("/v1/sys/generate-root/update", handleRequestForwarding(core, handleSysGenerateRootUpdate(core)))
 	mux.Handle("/v1/sys/rekey/init", handleRequestForwarding(core, handleSysRekeyInit(core, false)))
 	mux.Handle("/v1/sys/rekey/update", handleRequestForwarding(core, handleSysRekeyUpdate(core, false)))
+	mux.Handle("/v1/sys/rekey/cancel", handleRequestForwarding(core, handleSysRekeyCancel(core, false)))
 	mux.Handle("/v1/sys/rekey-recovery-key/init", handleRequestForwarding(core, handleSysRekeyInit(core, true)))
 	mux.Handle("/v1/sys/rekey-recovery-key/update", handleRequestForwarding(core, handleSysRekeyUpdate(core, true)))
+	mux.Handle("/v1/sys/rekey-recovery-key/cancel", handleRequestForwarding(core, handleSysRekeyCancel(core, true)))
 	mux.Handle("/v1/sys/wrapping/lookup", handleRequestForwarding(core, handleLogical(core, false, wrappingVerificationFunc)))
 	mux.Handle("/v1/sys/wrapping/rewrap", handleRequestForwarding(core, handleLogical(core, false, wrappingVerificationFunc)))
 	mux.Handle("/v1/sys/wrapping/unwrap", handleRequestForwarding(core, handleLogical(core, false, wrappingVerificationFunc)))
@@ -170,6 +172,11 @@ func handleRequestForwarding(core *vault.Core, handler http.Handler) http.Handle
 			handler.ServeHTTP(w, r)
 			return
 		}
+		if standbySafe(core, r) {
+			// No forwarding needed, the request only reads storage
+			handler.ServeHTTP(w, r)
+			return
+		}
 		if leaderAddr == "" {
 			respondError(w, http.StatusInternalServerError, fmt.Errorf("local node not active but active cluster node not found"))
 			return
@@ -205,6 +212,21 @@ func handleRequestForwarding(core *vault.Core, handler http.Handler) http.Handle
 	})
 }
 
+// standbySafe returns whether the request, made to a standby, is declared safe
+// on a standby by the backend of its mount, so that the standby serves it
+// itself instead of forwarding it to the active node.
+func standbySafe(core *vault.Core, r *http.Request) bool {
+	path, op, statusCode := logicalPathAndOperation(r)
+	if statusCode != 0 || r.Header.Get(WrapTTLHeaderName) != "" {
+		return false
+	}
+
+	return core.StandbySafe(&logical.Request{
+		Operation: op,
+		Path:      path,
+	})
+}
+
 // request is a helper to perform a request and properly exit in the
 // case of an error.
 func request(core *vault.Core, w http.ResponseWriter, rawReq *http.Request, r *logical.Request) (*logical.Response, bool) {
@@ -16,14 +16,17 @@ import (
 
 type PrepareRequestFunc func(*vault.Core, *logical.Request) error
 
-func buildLogicalRequest(core *vault.Core, w http.ResponseWriter, r *http.Request) (*logical.Request, int, error) {
+// logicalPathAndOperation returns the path and operation of the logical
+// request which the HTTP request maps to, or the status code to respond with
+// if it maps to none.
+func logicalPathAndOperation(r *http.Request) (string, logical.Operation, int) {
 	// Determine the path...
 	if !strings.HasPrefix(r.URL.Path, "/v1/") {
-		return nil, http.StatusNotFound, nil
+		return "", "", http.StatusNotFound
 	}
 	path := r.URL.Path[len("/v1/"):]
 	if path == "" {
-		return nil, http.StatusNotFound, nil
+		return "", "", http.StatusNotFound
 	}
 
 	// Determine the operation
@@ -39,7 +42,7 @@ func buildLogicalRequest(core *vault.Core, w http.ResponseWriter, r *http.Reques
 		if listStr != "" {
 			list, err := strconv.ParseBool(listStr)
 			if err != nil {
-				return nil, http.StatusBadRequest, nil
+				return "", "", http.StatusBadRequest
 			}
 			if list {
 				op = logical.ListOperation
@@ -51,7 +54,7 @@ func buildLogicalRequest(core *vault.Core, w http.ResponseWriter, r *http.Reques
 		op = logical.ListOperation
 	case "OPTIONS":
 	default:
-		return nil, http.StatusMethodNotAllowed, nil
+		return "", "", http.StatusMethodNotAllowed
 	}
 
 	if op == logical.ListOperation {
@@ -60,6 +63,15 @@ func buildLogicalRequest(core *vault.Core, w http.ResponseWriter, r *http.Reques
 		}
 	}
 
+	return path, op, 0
+}
+
+func buildLogicalRequest(core *vault.Core, w http.ResponseWriter, r *http.Request) (*logical.Request, int, error) {
+	path, op, statusCode := logicalPathAndOperation(r)
+	if statusCode != 0 {
+		return nil, statusCode, nil
+	}
+
 	// Parse the request if we can
 	var data map[string]interface{}
 	if op == logical.UpdateOperation {
@@ -156,6 +168,7 @@ func respondLogical(w http.ResponseWriter, r *http.Request, req *logical.Request
 					CreationPath:    resp.WrapInfo.CreationPath,
 					WrappedAccessor: resp.WrapInfo.WrappedAccessor,
 				},
+				Warnings: resp.Warnings,
 			}
 		} else {
 			httpResp = logical.LogicalResponseToHTTPResponse(resp)
@@ -1,8 +1,11 @@
 package http
 
 import (
+	"reflect"
+	"strings"
 	"testing"
 
+	"github.com/hashicorp/vault/api"
 	"github.com/hashicorp/vault/helper/jsonutil"
 	"github.com/hashicorp/vault/vault"
 )
@@ -71,3 +74,56 @@ func TestSysRevokePrefix(t *testing.T) {
 	resp := testHttpPut(t, token, addr+"/v1/sys/revoke-prefix/secret/foo/1234", nil)
 	testResponseStatus(t, resp, 204)
 }
+
+func TestSysLookupLease(t *testing.T) {
+	core, _, token := vault.TestCoreUnsealed(t)
+	ln, addr := TestServer(t, core)
+	defer ln.Close()
+
+	config := api.DefaultConfig()
+	config.Address = addr
+	client, err := api.NewClient(config)
+	if err != nil {
+		t.Fatal(err)
+	}
+	client.SetToken(token)
+
+	if _, err := client.Logical().Write("secret/foo", map[string]interface{}{
+		"data":  "bar",
+		"lease": "1h",
+	}); err != nil {
+		t.Fatal(err)
+	}
+	secret, err := client.Logical().Read("secret/foo")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	lease, err := client.Sys().LookupLease(secret.LeaseID)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if lease.Data["id"] != secret.LeaseID || lease.Data["renewable"] != true || lease.Data["last_renewal"] != nil {
+		t.Fatalf("bad: %#v", lease.Data)
+	}
+	if lease.Data["issue_time"] == nil || lease.Data["expire_time"] == nil || lease.Data["ttl"] == nil {
+		t.Fatalf("bad: %#v", lease.Data)
+	}
+
+	list, err := client.Sys().ListLeases("secret/foo")
+	if err != nil {
+		t.Fatal(err)
+	}
+	expected := []interface{}{strings.TrimPrefix(secret.LeaseID, "secret/foo/")}
+	if list == nil || !reflect.DeepEqual(list.Data["keys"], expected) {
+		t.Fatalf("bad: %#v", list)
+	}
+
+	list, err = client.Sys().ListLeases("secret/bar")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if list != nil {
+		t.Fatalf("bad: %#v", list)
+	}
+}
@@ -746,13 +746,15 @@ func TestSysTuneMount(t *testing.T) {
 		"warnings":       nil,
 		"auth":           nil,
 		"data": map[string]interface{}{
-			"default_lease_ttl": json.Number("259196400"),
-			"max_lease_ttl":     json.Number("259200000"),
-			"force_no_cache":    false,
+			"default_lease_ttl":    json.Number("259196400"),
+			"max_lease_ttl":        json.Number("259200000"),
+			"force_no_cache":       false,
+			"wal_rollback_min_age": json.Number("0"),
 		},
-		"default_lease_ttl": json.Number("259196400"),
-		"max_lease_ttl":     json.Number("259200000"),
-		"force_no_cache":    false,
+		"default_lease_ttl":    json.Number("259196400"),
+		"max_lease_ttl":        json.Number("259200000"),
+		"force_no_cache":       false,
+		"wal_rollback_min_age": json.Number("0"),
 	}
 
 	testResponseStatus(t, resp, 200)
@@ -762,13 +764,19 @@ func TestSysTuneMount(t *testing.T) {
 		t.Fatalf("bad:\nExpected: %#v\nActual:%#v", expected, actual)
 	}
 
-	// Set a low max
+	// Set a low max, and the minimum age of WAL entries to roll back
 	resp = testHttpPost(t, token, addr+"/v1/sys/mounts/secret/tune", map[string]interface{}{
-		"default_lease_ttl": "40s",
-		"max_lease_ttl":     "80s",
+		"default_lease_ttl":    "40s",
+		"max_lease_ttl":        "80s",
+		"wal_rollback_min_age": "2m",
 	})
 	testResponseStatus(t, resp, 204)
 
+	resp = testHttpPost(t, token, addr+"/v1/sys/mounts/secret/tune", map[string]interface{}{
+		"wal_rollback_min_age": "-1s",
+	})
+	testResponseStatus(t, resp, 400)
+
 	resp = testHttpGet(t, token, addr+"/v1/sys/mounts/secret/tune")
 	actual = map[string]interface{}{}
 	expected = map[string]interface{}{
@@ -779,13 +787,15 @@ func TestSysTuneMount(t *testing.T) {
 		"warnings":       nil,
 		"auth":           nil,
 		"data": map[string]interface{}{
-			"default_lease_ttl": json.Number("40"),
-			"max_lease_ttl":     json.Number("80"),
-			"force_no_cache":    false,
+			"default_lease_ttl":    json.Number("40"),
+			"max_lease_ttl":        json.Number("80"),
+			"force_no_cache":       false,
+			"wal_rollback_min_age": json.Number("120"),
 		},
-		"default_lease_ttl": json.Number("40"),
-		"max_lease_ttl":     json.Number("80"),
-		"force_no_cache":    false,
+		"default_lease_ttl":    json.Number("40"),
+		"max_lease_ttl":        json.Number("80"),
+		"force_no_cache":       false,
+		"wal_rollback_min_age": json.Number("120"),
 	}
 
 	testResponseStatus(t, resp, 200)
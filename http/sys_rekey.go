@@ -151,6 +151,25 @@ func handleSysRekeyInitDelete(core *vault.Core, recovery bool, w http.ResponseWr
 	respondOk(w, nil)
 }
 
+func handleSysRekeyCancel(core *vault.Core, recovery bool) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		standby, _ := core.Standby()
+		if standby {
+			respondStandby(core, w, r.URL)
+			return
+		}
+
+		switch {
+		case recovery && !core.SealAccess().RecoveryKeySupported():
+			respondError(w, http.StatusBadRequest, fmt.Errorf("recovery rekeying not supported"))
+		case r.Method == "POST" || r.Method == "PUT" || r.Method == "DELETE":
+			handleSysRekeyInitDelete(core, recovery, w, r)
+		default:
+			respondError(w, http.StatusMethodNotAllowed, nil)
+		}
+	})
+}
+
 func handleSysRekeyUpdate(core *vault.Core, recovery bool) http.Handler {
 	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
 		standby, _ := core.Standby()
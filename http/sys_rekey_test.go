@@ -152,6 +152,44 @@ func TestSysRekeyInit_Cancel(t *testing.T) {
 	}
 }
 
+func TestSysRekeyCancel(t *testing.T) {
+	core, _, token := vault.TestCoreUnsealed(t)
+	ln, addr := TestServer(t, core)
+	defer ln.Close()
+	TestServerAuth(t, addr, token)
+
+	resp := testHttpPut(t, token, addr+"/v1/sys/rekey/init", map[string]interface{}{
+		"secret_shares":    5,
+		"secret_threshold": 3,
+	})
+	testResponseStatus(t, resp, 200)
+
+	// A concurrent attempt is refused
+	resp = testHttpPut(t, token, addr+"/v1/sys/rekey/init", map[string]interface{}{
+		"secret_shares":    3,
+		"secret_threshold": 2,
+	})
+	testResponseStatus(t, resp, 400)
+
+	resp = testHttpPut(t, token, addr+"/v1/sys/rekey/cancel", nil)
+	testResponseStatus(t, resp, 204)
+
+	resp = testHttpGet(t, token, addr+"/v1/sys/rekey/init")
+	var actual map[string]interface{}
+	testResponseStatus(t, resp, 200)
+	testResponseBody(t, resp, &actual)
+	if actual["started"] != false || actual["nonce"] != "" {
+		t.Fatalf("bad: %#v", actual)
+	}
+
+	// Now a new one can be started
+	resp = testHttpPut(t, token, addr+"/v1/sys/rekey/init", map[string]interface{}{
+		"secret_shares":    3,
+		"secret_threshold": 2,
+	})
+	testResponseStatus(t, resp, 200)
+}
+
 func TestSysRekey_badKey(t *testing.T) {
 	core, _, token := vault.TestCoreUnsealed(t)
 	ln, addr := TestServer(t, core)
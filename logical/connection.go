@@ -4,8 +4,8 @@ import (
 	"crypto/tls"
 )
 
-// Connection represents the connection information for a request. This
-// is present on the Request structure for credential backends.
+// Connection represents the connection information for a request. The
+// TLS state is never audit logged.
 type Connection struct {
 	// RemoteAddr is the network address that sent the request.
 	RemoteAddr string `json:"remote_addr"`
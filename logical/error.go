@@ -1,10 +1,17 @@
 package logical
 
+// HTTPCodedError is an error which determines the HTTP status code of the
+// response, also when it is wrapped, for instance in a multierror.
 type HTTPCodedError interface {
 	Error() string
 	Code() int
 }
 
+// CodedError returns an error which is responded to with the given HTTP
+// status code, such as 403 for permission denials within a backend, 404 for
+// missing entries, 429 for exceeded rate limits or 503 for unreachable
+// upstream services. Codes which are not HTTP error statuses are responded to
+// with a 500.
 func CodedError(c int, s string) HTTPCodedError {
 	return &codedError{s, c}
 }
@@ -1,7 +1,7 @@
 package framework
 
 import (
-	"encoding/json"
+	"errors"
 	"fmt"
 	"io/ioutil"
 	"regexp"
@@ -12,7 +12,6 @@ import (
 
 	log "github.com/mgutz/logxi/v1"
 
-	"github.com/hashicorp/go-multierror"
 	"github.com/hashicorp/vault/helper/errutil"
 	"github.com/hashicorp/vault/helper/logformat"
 	"github.com/hashicorp/vault/helper/parseutil"
@@ -42,6 +41,12 @@ type Backend struct {
 	Paths        []*Path
 	PathsSpecial *logical.Paths
 
+	// RejectUnknownFields, if set, makes requests giving fields which are
+	// not in the schema of their path fail with an error response listing
+	// them, rather than having the fields ignored. Paths taking free-form
+	// data can opt out with AllowUnknownFields.
+	RejectUnknownFields bool
+
 	// Secrets is the list of secret types that this backend can
 	// return. It is used to automatically generate proper responses,
 	// and ease specifying callbacks for revocation, renewal, etc.
@@ -55,6 +60,10 @@ type Backend struct {
 	// entries in backend's storage, while the backend is still being used.
 	// (Note the different of this action from what `Clean` does, which is
 	// invoked just before the backend is unmounted).
+	//
+	// PeriodicFunc never runs concurrently with itself. The errors it returns
+	// are logged and do not prevent the WAL rollback which follows it. Tests
+	// can trigger it with TestBackendPeriodic.
 	PeriodicFunc periodicFunc
 
 	// WALRollback is called when a WAL entry (see wal.go) has to be rolled
@@ -63,20 +72,38 @@ type Backend struct {
 	// WALRollbackMinAge is the minimum age of a WAL entry before it is attempted
 	// to be rolled back. This should be longer than the maximum time it takes
 	// to successfully create a secret.
-	WALRollback       WALRollbackFunc
-	WALRollbackMinAge time.Duration
+	//
+	// WALRollbackMinAgeFunc, if set, is called on every rollback and returns
+	// the minimum age in place of WALRollbackMinAge, for backends where the
+	// time it takes to create a secret depends on their configuration.
+	WALRollback           WALRollbackFunc
+	WALRollbackMinAge     time.Duration
+	WALRollbackMinAgeFunc func(*logical.Request) (time.Duration, error)
 
 	// Clean is called on unload to clean up e.g any existing connections
-	// to the backend, if required.
+	// to the backend, if required. The core unloads backends when they are
+	// unmounted, when Vault is sealed and when the server shuts down.
+	//
+	// Clean is called once, after the requests in flight have completed,
+	// and no operation callbacks, including PeriodicFunc, are called
+	// afterwards. Goroutines started by the backend should be stopped here.
 	Clean CleanupFunc
 
 	// Initialize is called after a backend is created. Storage should not be
 	// written to before this function is called.
 	Init InitializeFunc
 
-	// Invalidate is called when a keys is modified if required
+	// Invalidate is called when a key of the backend's storage is modified
+	// outside of the backend, such as by a raw write through the system
+	// backend, so that it can drop the state it keeps in memory for it.
 	Invalidate InvalidateFunc
 
+	// InvalidateKeys registers the storage keys which back state kept in
+	// memory, along with the function dropping that state. Keys ending in
+	// '*' match by prefix. The functions of the matching keys are called
+	// before Invalidate.
+	InvalidateKeys map[string]InvalidateFunc
+
 	// AuthRenew is the callback to call when a RenewRequest for an
 	// authentication comes in. By default, renewal won't be allowed.
 	// See the built-in AuthRenew helpers in lease.go for common callbacks.
@@ -88,12 +115,28 @@ type Backend struct {
 	// Type is the logical.BackendType for the backend implementation
 	BackendType logical.BackendType
 
-	logger  log.Logger
-	system  logical.SystemView
-	once    sync.Once
-	pathsRe []*regexp.Regexp
+	// StrictResponses, if set, fails the requests whose responses do not
+	// match the Response schema of their operation, with fields it does not
+	// declare or values of other types. It is meant to be set by tests.
+	StrictResponses bool
+
+	logger       log.Logger
+	system       logical.SystemView
+	once         sync.Once
+	pathsRe      []*regexp.Regexp
+	routeOrder   []int
+	periodicLock sync.Mutex
+
+	// cleanupLock is held for reading while handling requests, so that
+	// Cleanup can wait for them to complete
+	cleanupLock sync.RWMutex
+	cleanedUp   bool
 }
 
+// ErrCleanedUp is returned for requests made to a backend after it was
+// cleaned up.
+var ErrCleanedUp = errors.New("backend has been cleaned up")
+
 // periodicFunc is the callback called when the RollbackManager's timer ticks.
 // This can be utilized by the backends to do anything it wants.
 type periodicFunc func(*logical.Request) error
@@ -120,6 +163,12 @@ type LicenseRegistrationFunc func(interface{}) error
 func (b *Backend) HandleExistenceCheck(req *logical.Request) (checkFound bool, exists bool, err error) {
 	b.once.Do(b.init)
 
+	b.cleanupLock.RLock()
+	defer b.cleanupLock.RUnlock()
+	if b.cleanedUp {
+		return false, false, ErrCleanedUp
+	}
+
 	// Ensure we are only doing this when one of the correct operations is in play
 	switch req.Operation {
 	case logical.CreateOperation:
@@ -168,6 +217,12 @@ func (b *Backend) HandleExistenceCheck(req *logical.Request) (checkFound bool, e
 func (b *Backend) HandleRequest(req *logical.Request) (*logical.Response, error) {
 	b.once.Do(b.init)
 
+	b.cleanupLock.RLock()
+	defer b.cleanupLock.RUnlock()
+	if b.cleanedUp {
+		return nil, ErrCleanedUp
+	}
+
 	// Check for special cased global operations. These don't route
 	// to a specific Path.
 	switch req.Operation {
@@ -201,11 +256,7 @@ func (b *Backend) HandleRequest(req *logical.Request) (*logical.Response, error)
 	}
 
 	// Look up the callback for this operation
-	var callback OperationFunc
-	var ok bool
-	if path.Callbacks != nil {
-		callback, ok = path.Callbacks[req.Operation]
-	}
+	callback, ok := path.callback(req.Operation)
 	if !ok {
 		if req.Operation == logical.HelpOperation {
 			callback = path.helpCallback
@@ -221,14 +272,98 @@ func (b *Backend) HandleRequest(req *logical.Request) (*logical.Response, error)
 		Schema: path.Fields}
 
 	if req.Operation != logical.HelpOperation {
+		if b.RejectUnknownFields && !path.AllowUnknownFields {
+			if unknown := unknownFields(req.Data, path.Fields); len(unknown) != 0 {
+				return logical.ErrorResponse(fmt.Sprintf("unknown fields: %s", strings.Join(unknown, ", "))), nil
+			}
+		}
+		// Data which does not convert to the types of the schema is the
+		// fault of the caller, and callbacks can rely on Get not panicking
 		err := fd.Validate()
 		if err != nil {
-			return nil, err
+			return nil, &logical.StatusBadRequest{Err: err.Error()}
+		}
+		if path.ValidateFieldValues {
+			if resp := fd.ValidateValues(); resp != nil {
+				return resp, nil
+			}
+		}
+		if req.Operation == logical.CreateOperation || req.Operation == logical.UpdateOperation {
+			if field, literal := b.reservedName(path, req.Path); field != "" {
+				return logical.ErrorResponse(fmt.Sprintf("%s: %q is reserved for the path %q", field, captures[field], literal)), nil
+			}
 		}
 	}
 
+	if path.standbySafe(req.Operation) {
+		callback = standbySafeCallback(callback)
+	}
+
 	// Call the callback with the request and the data
-	return callback(req, &fd)
+	resp, err := callback(req, &fd)
+	if err != nil || req.Operation == logical.HelpOperation {
+		return resp, err
+	}
+	if b.StrictResponses {
+		if err := checkResponse(path, req.Operation, resp); err != nil {
+			return nil, fmt.Errorf("the response to %s %q does not match its schema: %v", req.Operation, req.Path, err)
+		}
+	}
+
+	// Callers still using deprecated aliases are told to move on
+	if aliases := fd.UsedAliases(); len(aliases) != 0 {
+		if resp == nil {
+			resp = &logical.Response{}
+		}
+		used := make([]string, 0, len(aliases))
+		for alias := range aliases {
+			used = append(used, alias)
+		}
+		sort.Strings(used)
+		for _, alias := range used {
+			resp.AddWarning(fmt.Sprintf("%q is a deprecated alias of %q, which should be used instead", alias, aliases[alias]))
+		}
+	}
+	return resp, nil
+}
+
+// checkResponse returns an error if the data of the successful response
+// holds fields which the operation does not declare, or values which do not
+// convert to the declared types.
+func checkResponse(path *Path, op logical.Operation, resp *logical.Response) error {
+	o, ok := path.Operations[op]
+	if !ok || o == nil || o.Response == nil || resp == nil || resp.IsError() {
+		return nil
+	}
+	if unknown := unknownFields(resp.Data, o.Response); len(unknown) != 0 {
+		return fmt.Errorf("undeclared fields: %s", strings.Join(unknown, ", "))
+	}
+	fd := FieldData{
+		Raw:    resp.Data,
+		Schema: o.Response,
+	}
+	return fd.Validate()
+}
+
+// unknownFields returns the sorted keys of the data which are not in the
+// schema. Fields captured from the path are not part of the data.
+func unknownFields(data map[string]interface{}, schema map[string]*FieldSchema) []string {
+	known := make(map[string]bool, len(schema))
+	for k, s := range schema {
+		known[k] = true
+		for _, alias := range s.Aliases {
+			known[alias] = true
+		}
+	}
+
+	var unknown []string
+	for k := range data {
+		if !known[k] {
+			unknown = append(unknown, k)
+		}
+	}
+	sort.Strings(unknown)
+	return unknown
 }
 
 // SpecialPaths is the logical.Backend implementation.
@@ -236,8 +371,17 @@ func (b *Backend) SpecialPaths() *logical.Paths {
 	return b.PathsSpecial
 }
 
-// Cleanup is used to release resources and prepare to stop the backend
+// Cleanup is used to release resources and prepare to stop the backend. It
+// waits for the requests in flight and calls Clean once; requests made
+// afterwards fail with ErrCleanedUp.
 func (b *Backend) Cleanup() {
+	b.cleanupLock.Lock()
+	defer b.cleanupLock.Unlock()
+	if b.cleanedUp {
+		return
+	}
+	b.cleanedUp = true
+
 	if b.Clean != nil {
 		b.Clean()
 	}
@@ -254,6 +398,17 @@ func (b *Backend) Initialize() error {
 
 // InvalidateKey is used to clear caches and reset internal state on key changes
 func (b *Backend) InvalidateKey(key string) {
+	b.cleanupLock.RLock()
+	defer b.cleanupLock.RUnlock()
+	if b.cleanedUp {
+		return
+	}
+
+	for k, f := range b.InvalidateKeys {
+		if k == key || (strings.HasSuffix(k, "*") && strings.HasPrefix(key, k[:len(k)-1])) {
+			f(key)
+		}
+	}
 	if b.Invalidate != nil {
 		b.Invalidate(key)
 	}
@@ -263,6 +418,16 @@ func (b *Backend) InvalidateKey(key string) {
 func (b *Backend) Setup(config *logical.BackendConfig) error {
 	b.logger = config.Logger
 	b.system = config.System
+
+	// Shadowed paths are unreachable for some of their inputs, which is
+	// almost certainly unintended, so the backend is refused
+	var shadows []string
+	for _, shadow := range b.ShadowedPaths() {
+		shadows = append(shadows, shadow.String())
+	}
+	if len(shadows) != 0 {
+		return fmt.Errorf("paths are shadowed by earlier paths: %s", strings.Join(shadows, "; "))
+	}
 	return nil
 }
 
@@ -276,7 +441,9 @@ func (b *Backend) Logger() log.Logger {
 	return logformat.NewVaultLoggerWithWriter(ioutil.Discard, log.LevelOff)
 }
 
-// System returns the backend's system view.
+// System returns the backend's system view. Its lease durations are those
+// of the mount, including any tuning, so backends fall back to them rather
+// than to their own constants.
 func (b *Backend) System() logical.SystemView {
 	return b.system
 }
@@ -361,21 +528,52 @@ func (b *Backend) init() {
 		if len(p.Pattern) == 0 {
 			panic(fmt.Sprintf("Routing pattern cannot be blank"))
 		}
-		// Automatically anchor the pattern
-		if p.Pattern[0] != '^' {
+		// Automatically anchor the pattern. Alternations are grouped first
+		// so that the anchors apply to each of their branches.
+		if strings.Contains(p.Pattern, "|") {
+			pattern := strings.TrimPrefix(p.Pattern, "^")
+			if !p.PrefixMatch && strings.HasSuffix(pattern, "$") && !strings.HasSuffix(pattern, `\$`) {
+				pattern = pattern[:len(pattern)-1]
+			}
+			p.Pattern = "^(?:" + pattern + ")"
+		} else if p.Pattern[0] != '^' {
 			p.Pattern = "^" + p.Pattern
 		}
-		if p.Pattern[len(p.Pattern)-1] != '$' {
+		if !p.PrefixMatch && p.Pattern[len(p.Pattern)-1] != '$' {
 			p.Pattern = p.Pattern + "$"
 		}
 		b.pathsRe[i] = regexp.MustCompile(p.Pattern)
 	}
+
+	// Paths capturing the rest of the path are tried after all the others,
+	// the longest prefix first
+	prefixes := make([]int, len(b.Paths))
+	b.routeOrder = make([]int, len(b.Paths))
+	for i, p := range b.Paths {
+		b.routeOrder[i] = i
+		prefixes[i] = -1
+		if loc := matchAllSuffixRe.FindStringIndex(p.Pattern); loc != nil {
+			prefixes[i] = loc[0]
+		}
+	}
+	sort.SliceStable(b.routeOrder, func(i, j int) bool {
+		pi, pj := prefixes[b.routeOrder[i]], prefixes[b.routeOrder[j]]
+		if pi < 0 || pj < 0 {
+			return pi < 0 && pj >= 0
+		}
+		return pi > pj
+	})
 }
 
+// matchAllSuffixRe matches the end of anchored patterns ending in
+// MatchAllRegex
+var matchAllSuffixRe = regexp.MustCompile(`\(\?P<\w+>\(\?s:\.\*\)\)\$$`)
+
 func (b *Backend) route(path string) (*Path, map[string]string) {
 	b.once.Do(b.init)
 
-	for i, re := range b.pathsRe {
+	for _, i := range b.routeOrder {
+		re := b.pathsRe[i]
 		matches := re.FindStringSubmatch(path)
 		if matches == nil {
 			continue
@@ -472,15 +670,25 @@ func (b *Backend) handleRevokeRenew(
 func (b *Backend) handleRollback(
 	req *logical.Request) (*logical.Response, error) {
 	// Response is not expected from the periodic operation.
-	if b.PeriodicFunc != nil {
-		if err := b.PeriodicFunc(req); err != nil {
-			return nil, err
-		}
+	if err := b.runPeriodicFunc(req); err != nil {
+		b.Logger().Error("framework: periodic function failed", "path", req.Path, "error", err)
 	}
 
 	return b.handleWALRollback(req)
 }
 
+// runPeriodicFunc invokes the PeriodicFunc set on the backend, if any,
+// waiting for any invocation in progress to finish first.
+func (b *Backend) runPeriodicFunc(req *logical.Request) error {
+	if b.PeriodicFunc == nil {
+		return nil
+	}
+
+	b.periodicLock.Lock()
+	defer b.periodicLock.Unlock()
+	return b.PeriodicFunc(req)
+}
+
 func (b *Backend) handleAuthRenew(req *logical.Request) (*logical.Response, error) {
 	if b.AuthRenew == nil {
 		return logical.ErrorResponse("this auth type doesn't support renew"), nil
@@ -489,36 +697,55 @@ func (b *Backend) handleAuthRenew(req *logical.Request) (*logical.Response, erro
 	return b.AuthRenew(req, nil)
 }
 
+// handleWALRollback rolls back the WAL entries which are old enough. The
+// minimum age is WALRollbackMinAge, or the one WALRollbackMinAgeFunc returns,
+// unless the request gives a "min_age", such as the one tuned for the mount;
+// "immediate" rolls back every entry.
+// The response reports how many entries were processed and how many of
+// their rollbacks failed, listing the errors of the latter.
 func (b *Backend) handleWALRollback(
 	req *logical.Request) (*logical.Response, error) {
 	if b.WALRollback == nil {
 		return nil, logical.ErrUnsupportedOperation
 	}
 
-	var merr error
 	keys, err := ListWAL(req.Storage)
 	if err != nil {
 		return logical.ErrorResponse(err.Error()), nil
 	}
-	if len(keys) == 0 {
-		return nil, nil
-	}
 
 	// Calculate the minimum time that the WAL entries could be
 	// created in order to be rolled back.
 	age := b.WALRollbackMinAge
+	if b.WALRollbackMinAgeFunc != nil {
+		age, err = b.WALRollbackMinAgeFunc(req)
+		if err != nil {
+			return nil, err
+		}
+	}
 	if age == 0 {
 		age = 10 * time.Minute
 	}
+	if raw, ok := req.Data["min_age"]; ok {
+		minAge, err := parseutil.ParseDurationSecond(raw)
+		if err != nil {
+			return logical.ErrorResponse(fmt.Sprintf("invalid min_age: %v", err)), nil
+		}
+		if minAge > 0 {
+			age = minAge
+		}
+	}
 	minAge := time.Now().Add(-1 * age)
 	if _, ok := req.Data["immediate"]; ok {
 		minAge = time.Now().Add(1000 * time.Hour)
 	}
 
+	var processed int
+	var errs []string
 	for _, k := range keys {
 		entry, err := GetWAL(req.Storage, k)
 		if err != nil {
-			merr = multierror.Append(merr, err)
+			errs = append(errs, err.Error())
 			continue
 		}
 		if entry == nil {
@@ -531,6 +758,7 @@ func (b *Backend) handleWALRollback(
 		}
 
 		// Attempt a WAL rollback
+		processed++
 		err = b.WALRollback(req, entry.Kind, entry.Data)
 		if err != nil {
 			err = fmt.Errorf(
@@ -540,15 +768,20 @@ func (b *Backend) handleWALRollback(
 			err = DeleteWAL(req.Storage, k)
 		}
 		if err != nil {
-			merr = multierror.Append(merr, err)
+			errs = append(errs, err.Error())
 		}
 	}
 
-	if merr == nil {
-		return nil, nil
+	resp := &logical.Response{
+		Data: map[string]interface{}{
+			"processed": processed,
+			"failed":    len(errs),
+		},
 	}
-
-	return logical.ErrorResponse(merr.Error()), nil
+	if len(errs) != 0 {
+		resp.Data["errors"] = errs
+	}
+	return resp, nil
 }
 
 // FieldSchema is a basic schema to describe the format of a path field.
@@ -556,44 +789,39 @@ type FieldSchema struct {
 	Type        FieldType
 	Default     interface{}
 	Description string
+
+	// AllowedValues, if set, are the values the field can be given.
+	// Validator, if set, checks the value the field is given. Both are
+	// enforced by FieldData.ValidateValues, which runs before the callbacks
+	// of paths with ValidateFieldValues set. Fields whose rules depend on
+	// other fields are better checked by the callback.
+	AllowedValues []interface{}
+	Validator     func(interface{}) error
+
+	// Required marks the field as required in the help of the path. It is
+	// not enforced. Fields captured by the pattern of the path are always
+	// reported as required.
+	Required bool
+
+	// Aliases are deprecated names of the field, which requests can still
+	// give it under, e.g. after it was renamed. FieldData resolves them to
+	// the field, and responses warn about their use. Giving the field a
+	// different value under its name is an error.
+	Aliases []string
 }
 
 // DefaultOrZero returns the default value if it is set, or otherwise
-// the zero value of the type.
+// the zero value of the type. The default is converted like a value given
+// for the field, so that it has the type of the field. A default which
+// cannot be converted is returned as is, except for durations, which are
+// zero then.
 func (s *FieldSchema) DefaultOrZero() interface{} {
 	if s.Default != nil {
-		switch s.Type {
-		case TypeDurationSecond:
-			var result int
-			switch inp := s.Default.(type) {
-			case nil:
-				return s.Type.Zero()
-			case int:
-				result = inp
-			case int64:
-				result = int(inp)
-			case float32:
-				result = int(inp)
-			case float64:
-				result = int(inp)
-			case string:
-				dur, err := parseutil.ParseDurationSecond(inp)
-				if err != nil {
-					return s.Type.Zero()
-				}
-				result = int(dur.Seconds())
-			case json.Number:
-				valInt64, err := inp.Int64()
-				if err != nil {
-					return s.Type.Zero()
-				}
-				result = int(valInt64)
-			default:
-				return s.Type.Zero()
-			}
+		d := &FieldData{Raw: map[string]interface{}{"default": s.Default}}
+		if result, ok, err := d.getPrimitive("default", s); err == nil && ok {
 			return result
-
-		default:
+		}
+		if s.Type != TypeDurationSecond {
 			return s.Default
 		}
 	}
@@ -612,6 +840,8 @@ func (t FieldType) Zero() interface{} {
 		return false
 	case TypeMap:
 		return map[string]interface{}{}
+	case TypeKVPairs:
+		return map[string]string{}
 	case TypeDurationSecond:
 		return 0
 	case TypeSlice:
@@ -1,7 +1,13 @@
 package framework
 
 import (
+	"encoding/json"
+	"fmt"
 	"reflect"
+	"runtime"
+	"sort"
+	"strings"
+	"sync"
 	"sync/atomic"
 	"testing"
 	"time"
@@ -110,6 +116,10 @@ func TestBackendHandleRequest_badwrite(t *testing.T) {
 		t.Fatalf("should have thrown a conversion error")
 	}
 
+	// Conversion errors are the fault of the caller
+	if status, _ := logical.RespondErrorCommon(&logical.Request{Operation: logical.UpdateOperation}, nil, err); status != 400 {
+		t.Fatalf("bad: status: %d, err: %v", status, err)
+	}
 }
 
 func TestBackendHandleRequest_404(t *testing.T) {
@@ -172,6 +182,268 @@ func TestBackendHandleRequest_help(t *testing.T) {
 	}
 }
 
+func TestBackendHandleRequest_helpOperations(t *testing.T) {
+	callback := func(req *logical.Request, data *FieldData) (*logical.Response, error) {
+		return nil, nil
+	}
+	b := &Backend{
+		Paths: []*Path{
+			&Path{
+				Pattern: "foo/?$",
+				Callbacks: map[logical.Operation]OperationFunc{
+					logical.ListOperation:   callback,
+					logical.UpdateOperation: callback,
+				},
+			},
+		},
+	}
+
+	resp, err := b.HandleRequest(&logical.Request{
+		Operation: logical.HelpOperation,
+		Path:      "foo/",
+	})
+	if err != nil {
+		t.Fatalf("err: %s", err)
+	}
+	help, _ := resp.Data["help"].(string)
+	if !strings.Contains(help, "Operations:     list, update\n") {
+		t.Fatalf("bad: %s", help)
+	}
+}
+
+func TestBackendHandleRequest_helpSchema(t *testing.T) {
+	callback := func(req *logical.Request, data *FieldData) (*logical.Response, error) {
+		return nil, nil
+	}
+	b := &Backend{
+		Paths: []*Path{
+			&Path{
+				Pattern: "foo/" + GenericNameRegex("name"),
+				Fields: map[string]*FieldSchema{
+					"name": &FieldSchema{
+						Type:        TypeString,
+						Description: "Name",
+					},
+					"count": &FieldSchema{
+						Type:          TypeInt,
+						Default:       3,
+						AllowedValues: []interface{}{3, 4},
+					},
+					"mode": &FieldSchema{
+						Type:     TypeString,
+						Required: true,
+					},
+				},
+				Callbacks: map[logical.Operation]OperationFunc{
+					logical.ReadOperation:   callback,
+					logical.UpdateOperation: callback,
+				},
+				HelpSynopsis: "Foo",
+			},
+		},
+	}
+
+	resp, err := b.HandleRequest(&logical.Request{
+		Operation: logical.HelpOperation,
+		Path:      "foo/bar",
+	})
+	if err != nil {
+		t.Fatalf("err: %s", err)
+	}
+	schema, ok := resp.Data["schema"].(map[string]interface{})
+	if !ok {
+		t.Fatalf("bad: %#v", resp.Data)
+	}
+	if schema["synopsis"] != "Foo" || !reflect.DeepEqual(schema["operations"], []string{"read", "update"}) {
+		t.Fatalf("bad: %#v", schema)
+	}
+
+	expected := map[string]interface{}{
+		"name": map[string]interface{}{
+			"type":        "string",
+			"description": "Name",
+			"default":     nil,
+			"required":    true,
+		},
+		"count": map[string]interface{}{
+			"type":           "int",
+			"description":    "",
+			"default":        3,
+			"required":       false,
+			"allowed_values": []interface{}{3, 4},
+		},
+		"mode": map[string]interface{}{
+			"type":        "string",
+			"description": "",
+			"default":     nil,
+			"required":    true,
+		},
+	}
+	if !reflect.DeepEqual(schema["fields"], expected) {
+		t.Fatalf("bad: %#v", schema["fields"])
+	}
+}
+
+func TestBackendHandleRequest_operationHelp(t *testing.T) {
+	var called string
+	callback := func(name string) OperationFunc {
+		return func(req *logical.Request, data *FieldData) (*logical.Response, error) {
+			called = name
+			return nil, nil
+		}
+	}
+	b := &Backend{
+		Paths: []*Path{
+			&Path{
+				Pattern: "foo/" + GenericNameRegex("name"),
+				Fields: map[string]*FieldSchema{
+					"name": &FieldSchema{Type: TypeString},
+				},
+				Callbacks: map[logical.Operation]OperationFunc{
+					logical.DeleteOperation: callback("delete"),
+					logical.ReadOperation:   callback("read callback"),
+				},
+				Operations: map[logical.Operation]*PathOperation{
+					logical.ReadOperation: &PathOperation{
+						Callback:    callback("read"),
+						Summary:     "Reads the foo.",
+						StandbySafe: true,
+					},
+					logical.UpdateOperation: &PathOperation{
+						Callback:    callback("update"),
+						Summary:     "Issues a foo.",
+						Description: "Each foo is issued once.",
+					},
+				},
+				HelpSynopsis: "Foo",
+			},
+		},
+	}
+
+	// Operations take priority over Callbacks
+	for op, expected := range map[logical.Operation]string{
+		logical.ReadOperation:   "read",
+		logical.UpdateOperation: "update",
+		logical.DeleteOperation: "delete",
+	} {
+		if _, err := b.HandleRequest(&logical.Request{Operation: op, Path: "foo/bar"}); err != nil {
+			t.Fatalf("err: %s", err)
+		}
+		if called != expected {
+			t.Fatalf("bad: %s: called %s", op, called)
+		}
+	}
+
+	resp, err := b.HandleRequest(&logical.Request{
+		Operation: logical.HelpOperation,
+		Path:      "foo/bar",
+	})
+	if err != nil {
+		t.Fatalf("err: %s", err)
+	}
+	schema := resp.Data["schema"].(map[string]interface{})
+	if !reflect.DeepEqual(schema["operations"], []string{"delete", "read", "update"}) {
+		t.Fatalf("bad: %#v", schema["operations"])
+	}
+	expected := map[string]interface{}{
+		"read": map[string]interface{}{
+			"summary":      "Reads the foo.",
+			"description":  "",
+			"standby_safe": true,
+		},
+		"update": map[string]interface{}{
+			"summary":      "Issues a foo.",
+			"description":  "Each foo is issued once.",
+			"standby_safe": false,
+		},
+	}
+	if !reflect.DeepEqual(schema["operation_help"], expected) {
+		t.Fatalf("bad: %#v", schema["operation_help"])
+	}
+
+	help := resp.Data["help"].(string)
+	for _, s := range []string{
+		"## OPERATIONS\n\n    read: Reads the foo.\n\n    update: Issues a foo.\n        Each foo is issued once.\n\n## DESCRIPTION",
+	} {
+		if !strings.Contains(help, s) {
+			t.Fatalf("bad: %q", help)
+		}
+	}
+}
+
+func TestBackendHandleRequest_responseSchema(t *testing.T) {
+	var data map[string]interface{}
+	b := &Backend{
+		Paths: []*Path{
+			&Path{
+				Pattern: "foo",
+				Operations: map[logical.Operation]*PathOperation{
+					logical.ReadOperation: &PathOperation{
+						Callback: func(req *logical.Request, d *FieldData) (*logical.Response, error) {
+							return &logical.Response{Data: data}, nil
+						},
+						Response: map[string]*FieldSchema{
+							"name":  &FieldSchema{Type: TypeString, Description: "The name."},
+							"count": &FieldSchema{Type: TypeInt, Description: "The count."},
+						},
+					},
+				},
+			},
+		},
+	}
+
+	resp, err := b.HandleRequest(&logical.Request{
+		Operation: logical.HelpOperation,
+		Path:      "foo",
+	})
+	if err != nil {
+		t.Fatalf("err: %s", err)
+	}
+	expected := map[string]interface{}{
+		"name":  map[string]interface{}{"type": "string", "description": "The name."},
+		"count": map[string]interface{}{"type": "int", "description": "The count."},
+	}
+	operationHelp := resp.Data["schema"].(map[string]interface{})["operation_help"].(map[string]interface{})
+	if actual := operationHelp["read"].(map[string]interface{})["response"]; !reflect.DeepEqual(actual, expected) {
+		t.Fatalf("bad: %#v", actual)
+	}
+	if help := resp.Data["help"].(string); !strings.Contains(help, "Returns: count, name") {
+		t.Fatalf("bad: %s", help)
+	}
+
+	cases := []struct {
+		Data  map[string]interface{}
+		Error string
+	}{
+		{map[string]interface{}{"name": "bar", "count": 1}, ""},
+		{map[string]interface{}{"name": "bar"}, ""},
+		{nil, ""},
+		{map[string]interface{}{"name": "bar", "other": true}, "undeclared fields: other"},
+		{map[string]interface{}{"count": "many"}, "count"},
+	}
+	for _, tc := range cases {
+		data = tc.Data
+
+		// Responses are only checked in strict mode
+		b.StrictResponses = false
+		if _, err := b.HandleRequest(&logical.Request{Operation: logical.ReadOperation, Path: "foo"}); err != nil {
+			t.Fatalf("bad: %#v: %v", tc.Data, err)
+		}
+
+		b.StrictResponses = true
+		_, err := b.HandleRequest(&logical.Request{Operation: logical.ReadOperation, Path: "foo"})
+		if tc.Error == "" {
+			if err != nil {
+				t.Fatalf("bad: %#v: %v", tc.Data, err)
+			}
+			continue
+		}
+		if err == nil || !strings.Contains(err.Error(), tc.Error) {
+			t.Fatalf("bad: %#v: %v", tc.Data, err)
+		}
+	}
+}
+
 func TestBackendHandleRequest_helpRoot(t *testing.T) {
 	b := &Backend{
 		Help: "42",
@@ -338,6 +610,269 @@ func TestBackendHandleRequest_rollback(t *testing.T) {
 	}
 }
 
+func TestBackendHandleRequest_rollbackPeriodicError(t *testing.T) {
+	var periodicCalled, walCalled uint32
+	b := &Backend{
+		PeriodicFunc: func(req *logical.Request) error {
+			atomic.AddUint32(&periodicCalled, 1)
+			return fmt.Errorf("periodic failure")
+		},
+		WALRollback: func(req *logical.Request, kind string, data interface{}) error {
+			atomic.AddUint32(&walCalled, 1)
+			return nil
+		},
+		WALRollbackMinAge: 1 * time.Millisecond,
+	}
+
+	storage := new(logical.InmemStorage)
+	if _, err := PutWAL(storage, "kind", "foo"); err != nil {
+		t.Fatalf("err: %s", err)
+	}
+
+	time.Sleep(10 * time.Millisecond)
+
+	// Errors of the periodic function are logged and the WAL entries are
+	// still rolled back
+	_, err := b.HandleRequest(&logical.Request{
+		Operation: logical.RollbackOperation,
+		Path:      "",
+		Storage:   storage,
+	})
+	if err != nil {
+		t.Fatalf("err: %s", err)
+	}
+	if v := atomic.LoadUint32(&periodicCalled); v != 1 {
+		t.Fatalf("bad: %#v", v)
+	}
+	if v := atomic.LoadUint32(&walCalled); v != 1 {
+		t.Fatalf("bad: %#v", v)
+	}
+}
+
+func TestBackendHandleRequest_periodicSerialized(t *testing.T) {
+	var running, overlaps, called int32
+	b := &Backend{
+		PeriodicFunc: func(req *logical.Request) error {
+			if atomic.AddInt32(&running, 1) != 1 {
+				atomic.AddInt32(&overlaps, 1)
+			}
+			time.Sleep(5 * time.Millisecond)
+			atomic.AddInt32(&running, -1)
+			atomic.AddInt32(&called, 1)
+			return nil
+		},
+	}
+
+	storage := new(logical.InmemStorage)
+	var wg sync.WaitGroup
+	for i := 0; i < 5; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			b.HandleRequest(&logical.Request{
+				Operation: logical.RollbackOperation,
+				Storage:   storage,
+			})
+		}()
+	}
+	wg.Wait()
+	TestBackendPeriodic(t, b, storage)
+
+	if v := atomic.LoadInt32(&called); v != 6 {
+		t.Fatalf("bad: %#v", v)
+	}
+	if v := atomic.LoadInt32(&overlaps); v != 0 {
+		t.Fatalf("periodic function ran concurrently with itself %d times", v)
+	}
+}
+
+func TestBackendCleanup(t *testing.T) {
+	goroutines := runtime.NumGoroutine()
+
+	// The backend runs a worker, which the counter tracks
+	var workers, cleaned, callbacks int32
+	stopCh := make(chan struct{})
+	startWorker := func() {
+		atomic.AddInt32(&workers, 1)
+		go func() {
+			defer atomic.AddInt32(&workers, -1)
+			<-stopCh
+		}()
+	}
+
+	inFlight := make(chan struct{})
+	release := make(chan struct{})
+	b := &Backend{
+		Paths: []*Path{
+			&Path{
+				Pattern: "foo",
+				Callbacks: map[logical.Operation]OperationFunc{
+					logical.ReadOperation: func(req *logical.Request, d *FieldData) (*logical.Response, error) {
+						atomic.AddInt32(&callbacks, 1)
+						if req.Data != nil {
+							close(inFlight)
+							<-release
+						}
+						return nil, nil
+					},
+				},
+			},
+		},
+		Clean: func() {
+			atomic.AddInt32(&cleaned, 1)
+			close(stopCh)
+		},
+	}
+	startWorker()
+	startWorker()
+
+	// Cleanup waits for the requests in flight
+	reqDone := make(chan struct{})
+	go func() {
+		defer close(reqDone)
+		if _, err := b.HandleRequest(&logical.Request{
+			Operation: logical.ReadOperation,
+			Path:      "foo",
+			Data:      map[string]interface{}{},
+		}); err != nil {
+			t.Error(err)
+		}
+	}()
+	<-inFlight
+	cleanupDone := make(chan struct{})
+	go func() {
+		defer close(cleanupDone)
+		b.Cleanup()
+	}()
+	select {
+	case <-cleanupDone:
+		t.Fatal("cleaned up during a request")
+	case <-time.After(20 * time.Millisecond):
+	}
+	close(release)
+	<-reqDone
+	<-cleanupDone
+
+	// Clean is only called once
+	b.Cleanup()
+	if v := atomic.LoadInt32(&cleaned); v != 1 {
+		t.Fatalf("bad: cleaned: %d", v)
+	}
+
+	// No callbacks run afterwards
+	_, err := b.HandleRequest(&logical.Request{
+		Operation: logical.ReadOperation,
+		Path:      "foo",
+	})
+	if err != ErrCleanedUp {
+		t.Fatalf("bad: %#v", err)
+	}
+	if v := atomic.LoadInt32(&callbacks); v != 1 {
+		t.Fatalf("bad: callbacks: %d", v)
+	}
+
+	// The workers were stopped, and no other goroutine was left behind
+	for i := 0; atomic.LoadInt32(&workers) != 0; i++ {
+		if i == 100 {
+			t.Fatalf("bad: %d workers leaked", atomic.LoadInt32(&workers))
+		}
+		time.Sleep(time.Millisecond)
+	}
+	for i := 0; runtime.NumGoroutine() > goroutines; i++ {
+		if i == 100 {
+			t.Fatalf("bad: %d goroutines leaked", runtime.NumGoroutine()-goroutines)
+		}
+		time.Sleep(time.Millisecond)
+	}
+}
+
+func TestBackendInvalidateKey(t *testing.T) {
+	// The backend caches its config, read from storage on first use
+	var l sync.Mutex
+	var cached *logical.StorageEntry
+	var invalidated []string
+	b := &Backend{
+		Paths: []*Path{
+			&Path{
+				Pattern: "config",
+				Callbacks: map[logical.Operation]OperationFunc{
+					logical.ReadOperation: func(req *logical.Request, d *FieldData) (*logical.Response, error) {
+						l.Lock()
+						defer l.Unlock()
+						if cached == nil {
+							entry, err := req.Storage.Get("config")
+							if err != nil {
+								return nil, err
+							}
+							cached = entry
+						}
+						return &logical.Response{
+							Data: map[string]interface{}{"value": string(cached.Value)},
+						}, nil
+					},
+				},
+			},
+		},
+		InvalidateKeys: map[string]InvalidateFunc{
+			"config": func(string) {
+				l.Lock()
+				defer l.Unlock()
+				cached = nil
+			},
+			"roles/*": func(key string) {
+				invalidated = append(invalidated, key)
+			},
+		},
+		Invalidate: func(key string) {
+			invalidated = append(invalidated, "all:"+key)
+		},
+	}
+
+	storage := &logical.InmemStorage{}
+	read := func() string {
+		resp, err := b.HandleRequest(&logical.Request{
+			Operation: logical.ReadOperation,
+			Path:      "config",
+			Storage:   storage,
+		})
+		if err != nil {
+			t.Fatalf("err: %v", err)
+		}
+		return resp.Data["value"].(string)
+	}
+
+	storage.Put(&logical.StorageEntry{Key: "config", Value: []byte("foo")})
+	if v := read(); v != "foo" {
+		t.Fatalf("bad: %s", v)
+	}
+
+	// Storage is modified behind the backend's back, which keeps serving
+	// the cached entry until the key is invalidated
+	storage.Put(&logical.StorageEntry{Key: "config", Value: []byte("bar")})
+	if v := read(); v != "foo" {
+		t.Fatalf("bad: %s", v)
+	}
+	b.InvalidateKey("config")
+	if v := read(); v != "bar" {
+		t.Fatalf("bad: %s", v)
+	}
+
+	// Keys ending in '*' match by prefix and Invalidate sees every key
+	b.InvalidateKey("roles/foo")
+	b.InvalidateKey("rolesfoo")
+	expected := []string{"all:config", "roles/foo", "all:roles/foo", "all:rolesfoo"}
+	if !reflect.DeepEqual(invalidated, expected) {
+		t.Fatalf("bad: expected: %#v, got: %#v", expected, invalidated)
+	}
+
+	// Nothing is called once the backend is cleaned up
+	b.Cleanup()
+	b.InvalidateKey("roles/bar")
+	if !reflect.DeepEqual(invalidated, expected) {
+		t.Fatalf("bad: expected: %#v, got: %#v", expected, invalidated)
+	}
+}
+
 func TestBackendHandleRequest_rollbackMinAge(t *testing.T) {
 	var called uint32
 	callback := func(req *logical.Request, kind string, data interface{}) error {
@@ -371,6 +906,108 @@ func TestBackendHandleRequest_rollbackMinAge(t *testing.T) {
 	}
 }
 
+func TestBackendHandleRequest_rollbackMinAgeFunc(t *testing.T) {
+	var called uint32
+	b := &Backend{
+		WALRollback: func(req *logical.Request, kind string, data interface{}) error {
+			atomic.AddUint32(&called, 1)
+			return nil
+		},
+		WALRollbackMinAge: 1 * time.Millisecond,
+		WALRollbackMinAgeFunc: func(*logical.Request) (time.Duration, error) {
+			return time.Hour, nil
+		},
+	}
+
+	storage := new(logical.InmemStorage)
+	if _, err := PutWAL(storage, "kind", "foo"); err != nil {
+		t.Fatalf("err: %s", err)
+	}
+
+	time.Sleep(10 * time.Millisecond)
+
+	// The age returned by the function takes precedence
+	_, err := b.HandleRequest(&logical.Request{
+		Operation: logical.RollbackOperation,
+		Path:      "",
+		Storage:   storage,
+	})
+	if err != nil {
+		t.Fatalf("err: %s", err)
+	}
+	if v := atomic.LoadUint32(&called); v != 0 {
+		t.Fatalf("bad: %#v", v)
+	}
+
+	// Failing to determine the age fails the rollback
+	b.WALRollbackMinAgeFunc = func(*logical.Request) (time.Duration, error) {
+		return 0, fmt.Errorf("storage unavailable")
+	}
+	_, err = b.HandleRequest(&logical.Request{
+		Operation: logical.RollbackOperation,
+		Path:      "",
+		Storage:   storage,
+	})
+	if err == nil {
+		t.Fatal("expected error")
+	}
+}
+
+func TestBackendHandleRequest_rollbackTunedMinAge(t *testing.T) {
+	b := &Backend{
+		WALRollback: func(req *logical.Request, kind string, data interface{}) error {
+			if data == "bar" {
+				return fmt.Errorf("target unreachable")
+			}
+			return nil
+		},
+		WALRollbackMinAge: time.Hour,
+	}
+
+	storage := new(logical.InmemStorage)
+	for _, data := range []string{"foo", "bar"} {
+		if _, err := PutWAL(storage, "kind", data); err != nil {
+			t.Fatalf("err: %s", err)
+		}
+	}
+
+	time.Sleep(10 * time.Millisecond)
+
+	rollback := func(data map[string]interface{}) *logical.Response {
+		resp, err := b.HandleRequest(&logical.Request{
+			Operation: logical.RollbackOperation,
+			Path:      "",
+			Storage:   storage,
+			Data:      data,
+		})
+		if err != nil || resp == nil || resp.IsError() {
+			t.Fatalf("bad: err: %v, resp: %#v", err, resp)
+		}
+		return resp
+	}
+
+	// The entries are younger than the minimum age of the backend
+	resp := rollback(nil)
+	if resp.Data["processed"] != 0 || resp.Data["failed"] != 0 {
+		t.Fatalf("bad: %#v", resp.Data)
+	}
+
+	// The minimum age tuned for the mount takes precedence
+	resp = rollback(map[string]interface{}{"min_age": "1ms"})
+	if resp.Data["processed"] != 2 || resp.Data["failed"] != 1 {
+		t.Fatalf("bad: %#v", resp.Data)
+	}
+	if errs := resp.Data["errors"].([]string); len(errs) != 1 || !strings.Contains(errs[0], "target unreachable") {
+		t.Fatalf("bad: %#v", resp.Data)
+	}
+
+	// Only the failed entry is left to roll back
+	keys, err := ListWAL(storage)
+	if err != nil || len(keys) != 1 {
+		t.Fatalf("bad: err: %v, keys: %v", err, keys)
+	}
+}
+
 func TestBackendHandleRequest_unsupportedOperation(t *testing.T) {
 	callback := func(req *logical.Request, data *FieldData) (*logical.Response, error) {
 		return &logical.Response{
@@ -404,6 +1041,203 @@ func TestBackendHandleRequest_unsupportedOperation(t *testing.T) {
 	}
 }
 
+func TestBackendHandleRequest_validateFieldValues(t *testing.T) {
+	called := false
+	callback := func(req *logical.Request, data *FieldData) (*logical.Response, error) {
+		called = true
+		return nil, nil
+	}
+
+	path := &Path{
+		Pattern: `foo/bar`,
+		Fields: map[string]*FieldSchema{
+			"value": &FieldSchema{
+				Type:          TypeInt,
+				AllowedValues: []interface{}{1, 2},
+			},
+		},
+		Callbacks: map[logical.Operation]OperationFunc{
+			logical.UpdateOperation: callback,
+		},
+	}
+	b := &Backend{Paths: []*Path{path}}
+
+	// Without the flag, the callback is left to check the value
+	_, err := b.HandleRequest(&logical.Request{
+		Operation: logical.UpdateOperation,
+		Path:      "foo/bar",
+		Data:      map[string]interface{}{"value": "3"},
+	})
+	if err != nil || !called {
+		t.Fatalf("bad: err: %v, called: %v", err, called)
+	}
+
+	called = false
+	path.ValidateFieldValues = true
+	resp, err := b.HandleRequest(&logical.Request{
+		Operation: logical.UpdateOperation,
+		Path:      "foo/bar",
+		Data:      map[string]interface{}{"value": "3"},
+	})
+	if err != nil || called || resp == nil || !resp.IsError() {
+		t.Fatalf("bad: err: %v, called: %v, resp: %#v", err, called, resp)
+	}
+
+	resp, err = b.HandleRequest(&logical.Request{
+		Operation: logical.UpdateOperation,
+		Path:      "foo/bar",
+		Data:      map[string]interface{}{"value": "2"},
+	})
+	if err != nil || !called || resp != nil {
+		t.Fatalf("bad: err: %v, called: %v, resp: %#v", err, called, resp)
+	}
+}
+
+func TestBackendHandleRequest_unknownFields(t *testing.T) {
+	called := false
+	callback := func(req *logical.Request, data *FieldData) (*logical.Response, error) {
+		called = true
+		return nil, nil
+	}
+
+	path := &Path{
+		Pattern: `foo/(?P<name>\w+)`,
+		Fields: map[string]*FieldSchema{
+			"name":  &FieldSchema{Type: TypeString},
+			"value": &FieldSchema{Type: TypeInt},
+		},
+		Callbacks: map[logical.Operation]OperationFunc{
+			logical.UpdateOperation: callback,
+		},
+	}
+	b := &Backend{Paths: []*Path{path}, RejectUnknownFields: true}
+
+	// Fields captured from the path are known
+	resp, err := b.HandleRequest(&logical.Request{
+		Operation: logical.UpdateOperation,
+		Path:      "foo/bar",
+		Data:      map[string]interface{}{"value": 1},
+	})
+	if err != nil || !called || resp != nil {
+		t.Fatalf("bad: err: %v, called: %v, resp: %#v", err, called, resp)
+	}
+
+	called = false
+	resp, err = b.HandleRequest(&logical.Request{
+		Operation: logical.UpdateOperation,
+		Path:      "foo/bar",
+		Data:      map[string]interface{}{"value": 1, "vlaue": 1, "extra": 2},
+	})
+	if err != nil || called || resp == nil || !resp.IsError() {
+		t.Fatalf("bad: err: %v, called: %v, resp: %#v", err, called, resp)
+	}
+	if msg := resp.Data["error"].(string); msg != "unknown fields: extra, vlaue" {
+		t.Fatalf("bad: %s", msg)
+	}
+
+	path.AllowUnknownFields = true
+	resp, err = b.HandleRequest(&logical.Request{
+		Operation: logical.UpdateOperation,
+		Path:      "foo/bar",
+		Data:      map[string]interface{}{"value": 1, "extra": 2},
+	})
+	if err != nil || !called || resp != nil {
+		t.Fatalf("bad: err: %v, called: %v, resp: %#v", err, called, resp)
+	}
+}
+
+func TestBackendHandleRequest_aliases(t *testing.T) {
+	b := &Backend{
+		RejectUnknownFields: true,
+		Paths: []*Path{
+			&Path{
+				Pattern: "foo",
+				Fields: map[string]*FieldSchema{
+					"ttl": &FieldSchema{
+						Type:    TypeDurationSecond,
+						Aliases: []string{"lease"},
+					},
+				},
+				Callbacks: map[logical.Operation]OperationFunc{
+					logical.UpdateOperation: func(req *logical.Request, data *FieldData) (*logical.Response, error) {
+						if data.Get("ttl").(int) == 0 {
+							return nil, nil
+						}
+						return &logical.Response{
+							Data: map[string]interface{}{"ttl": data.Get("ttl")},
+						}, nil
+					},
+				},
+			},
+		},
+	}
+
+	cases := []struct {
+		Data     map[string]interface{}
+		TTL      interface{}
+		Warnings []string
+		Error    bool
+	}{
+		{map[string]interface{}{"ttl": "1h"}, 3600, nil, false},
+		{map[string]interface{}{"lease": "1h"}, 3600, []string{`"lease" is a deprecated alias of "ttl", which should be used instead`}, false},
+		{map[string]interface{}{"lease": "1h", "ttl": "1h"}, 3600, []string{`"lease" is a deprecated alias of "ttl", which should be used instead`}, false},
+		{map[string]interface{}{"lease": "1h", "ttl": "2h"}, nil, nil, true},
+
+		// Values are compared once converted to the type of the field
+		{map[string]interface{}{"lease": 3600, "ttl": "1h"}, 3600, []string{`"lease" is a deprecated alias of "ttl", which should be used instead`}, false},
+		{map[string]interface{}{"lease": "3600", "ttl": 3600}, 3600, []string{`"lease" is a deprecated alias of "ttl", which should be used instead`}, false},
+		{map[string]interface{}{"lease": json.Number("3600"), "ttl": "60m"}, 3600, []string{`"lease" is a deprecated alias of "ttl", which should be used instead`}, false},
+		{map[string]interface{}{"lease": 3600, "ttl": "2h"}, nil, nil, true},
+		{map[string]interface{}{"lease": "soon", "ttl": "1h"}, nil, nil, true},
+		{map[string]interface{}{"lease": "soon"}, nil, nil, true},
+
+		// Warnings are returned even without data
+		{map[string]interface{}{"lease": 0}, nil, []string{`"lease" is a deprecated alias of "ttl", which should be used instead`}, false},
+	}
+
+	for _, tc := range cases {
+		resp, err := b.HandleRequest(&logical.Request{
+			Operation: logical.UpdateOperation,
+			Path:      "foo",
+			Data:      tc.Data,
+		})
+		if tc.Error {
+			if _, ok := err.(*logical.StatusBadRequest); !ok {
+				t.Fatalf("bad: %#v: %#v", tc.Data, err)
+			}
+			continue
+		}
+		if err != nil {
+			t.Fatalf("bad: %#v: %v", tc.Data, err)
+		}
+		var ttl interface{}
+		var warnings []string
+		if resp != nil {
+			ttl = resp.Data["ttl"]
+			warnings = resp.Warnings
+		}
+		if ttl != tc.TTL || !reflect.DeepEqual(warnings, tc.Warnings) {
+			t.Fatalf("bad: %#v: %#v", tc.Data, resp)
+		}
+	}
+
+	// The help lists the aliases
+	resp, err := b.HandleRequest(&logical.Request{
+		Operation: logical.HelpOperation,
+		Path:      "foo",
+	})
+	if err != nil {
+		t.Fatalf("err: %v", err)
+	}
+	fields := resp.Data["schema"].(map[string]interface{})["fields"].(map[string]interface{})
+	if aliases := fields["ttl"].(map[string]interface{})["deprecated_aliases"]; !reflect.DeepEqual(aliases, []string{"lease"}) {
+		t.Fatalf("bad: %#v", fields["ttl"])
+	}
+	if help := resp.Data["help"].(string); !strings.Contains(help, "deprecated aliases: lease") {
+		t.Fatalf("bad: %s", help)
+	}
+}
+
 func TestBackendHandleRequest_urlPriority(t *testing.T) {
 	callback := func(req *logical.Request, data *FieldData) (*logical.Response, error) {
 		return &logical.Response{
@@ -481,6 +1315,30 @@ func TestBackendRoute(t *testing.T) {
 			"sys/mounts",
 			"^sys/mounts$",
 		},
+
+		"alternation": {
+			[]string{"foo|bar$"},
+			"bar",
+			"^(?:foo|bar)$",
+		},
+
+		"anchor-alternation-start": {
+			[]string{"foo|bar"},
+			"foobar",
+			"",
+		},
+
+		"anchor-alternation-end": {
+			[]string{"foo|bar"},
+			"foo/bar",
+			"",
+		},
+
+		"anchor-generic-name": {
+			[]string{"creds/" + GenericNameRegex("role")},
+			"creds/foo/extra",
+			"",
+		},
 	}
 
 	for n, tc := range cases {
@@ -503,6 +1361,167 @@ func TestBackendRoute(t *testing.T) {
 	}
 }
 
+func TestBackendRoute_prefixMatch(t *testing.T) {
+	b := &Backend{
+		Paths: []*Path{
+			&Path{Pattern: "foo/", PrefixMatch: true},
+			&Path{Pattern: "bar|baz/", PrefixMatch: true},
+		},
+	}
+
+	cases := map[string]string{
+		"foo/":        "^foo/",
+		"foo/bar":     "^foo/",
+		"baz/foo":     "^(?:bar|baz/)",
+		"barfoo":      "^(?:bar|baz/)",
+		"qux/foo/bar": "",
+	}
+	for path, expected := range cases {
+		match := ""
+		if result := b.Route(path); result != nil {
+			match = result.Pattern
+		}
+		if match != expected {
+			t.Fatalf("bad: %s\n\nExpected: %s\nGot: %s", path, expected, match)
+		}
+	}
+}
+
+func TestBackendRoute_matchAll(t *testing.T) {
+	b := &Backend{
+		Paths: []*Path{
+			&Path{Pattern: "kv/" + MatchAllRegex("path")},
+			&Path{Pattern: "kv/team/" + MatchAllRegex("path")},
+			&Path{Pattern: "kv/config"},
+			&Path{Pattern: "kv/?$"},
+		},
+	}
+
+	cases := map[string]struct {
+		Match string
+		Path  string
+	}{
+		"kv":             {"^kv/?$", ""},
+		"kv/":            {"^kv/?$", ""},
+		"kv/config":      {"^kv/config$", ""},
+		"kv/config/foo":  {"^kv/(?P<path>(?s:.*))$", "config/foo"},
+		"kv/a/b/c":       {"^kv/(?P<path>(?s:.*))$", "a/b/c"},
+		"kv/a/b/":        {"^kv/(?P<path>(?s:.*))$", "a/b/"},
+		"kv/a%2Fb/c d":   {"^kv/(?P<path>(?s:.*))$", "a%2Fb/c d"},
+		"kv/a\nb/c":      {"^kv/(?P<path>(?s:.*))$", "a\nb/c"},
+		"kv/team/a/b":    {"^kv/team/(?P<path>(?s:.*))$", "a/b"},
+		"kv/team/":       {"^kv/team/(?P<path>(?s:.*))$", ""},
+		"kv/teams/a":     {"^kv/(?P<path>(?s:.*))$", "teams/a"},
+		"other/kv/a/b/c": {"", ""},
+	}
+	for input, tc := range cases {
+		result, captures := b.route(input)
+		match := ""
+		if result != nil {
+			match = result.Pattern
+		}
+		if match != tc.Match || captures["path"] != tc.Path {
+			t.Fatalf("bad: %q\n\nExpected: %s %q\nGot: %s %q", input, tc.Match, tc.Path, match, captures["path"])
+		}
+	}
+
+	// The catch-all paths do not shadow the paths routed before them
+	if shadows := b.ShadowedPaths(); len(shadows) != 0 {
+		t.Fatalf("bad: %v", shadows)
+	}
+}
+
+func TestBackendHandleRequest_matchAll(t *testing.T) {
+	write := func(req *logical.Request, data *FieldData) (*logical.Response, error) {
+		entry, err := logical.StorageEntryJSON(data.Get("path").(string), req.Data)
+		if err != nil {
+			return nil, err
+		}
+		return nil, req.Storage.Put(entry)
+	}
+	read := func(req *logical.Request, data *FieldData) (*logical.Response, error) {
+		entry, err := req.Storage.Get(data.Get("path").(string))
+		if err != nil || entry == nil {
+			return nil, err
+		}
+		return &logical.Response{Data: map[string]interface{}{"key": entry.Key}}, nil
+	}
+	list := func(req *logical.Request, data *FieldData) (*logical.Response, error) {
+		keys, err := req.Storage.List(ListPrefix(data.Get("path").(string)))
+		if err != nil {
+			return nil, err
+		}
+		return logical.ListResponse(keys), nil
+	}
+
+	b := &Backend{
+		Paths: []*Path{
+			&Path{
+				Pattern: "kv/" + MatchAllRegex("path"),
+				Fields: map[string]*FieldSchema{
+					"path": &FieldSchema{Type: TypeString},
+				},
+				Callbacks: map[logical.Operation]OperationFunc{
+					logical.ReadOperation:   read,
+					logical.UpdateOperation: write,
+					logical.ListOperation:   list,
+				},
+			},
+		},
+	}
+
+	storage := new(logical.InmemStorage)
+	keys := []string{"a/b/c/d", "a/b/e", "a/f", "a%2Fg", "h i/j%20k", "l"}
+	for _, key := range keys {
+		_, err := b.HandleRequest(&logical.Request{
+			Operation: logical.UpdateOperation,
+			Path:      "kv/" + key,
+			Data:      map[string]interface{}{"value": key},
+			Storage:   storage,
+		})
+		if err != nil {
+			t.Fatalf("bad: %s: %v", key, err)
+		}
+	}
+	for _, key := range keys {
+		resp, err := b.HandleRequest(&logical.Request{
+			Operation: logical.ReadOperation,
+			Path:      "kv/" + key,
+			Storage:   storage,
+		})
+		if err != nil || resp == nil || resp.Data["key"] != key {
+			t.Fatalf("bad: %s: err: %v, resp: %#v", key, err, resp)
+		}
+	}
+
+	cases := map[string][]string{
+		"kv/":      []string{"a%2Fg", "a/", "h i/", "l"},
+		"kv/a":     []string{"b/", "f"},
+		"kv/a/":    []string{"b/", "f"},
+		"kv/a/b/":  []string{"c/", "e"},
+		"kv/a/b/c": []string{"d"},
+		"kv/h i/":  []string{"j%20k"},
+		"kv/l/":    nil,
+	}
+	for path, expected := range cases {
+		resp, err := b.HandleRequest(&logical.Request{
+			Operation: logical.ListOperation,
+			Path:      path,
+			Storage:   storage,
+		})
+		if err != nil || resp == nil {
+			t.Fatalf("bad: %s: err: %v, resp: %#v", path, err, resp)
+		}
+		actual, _ := resp.Data["keys"].([]string)
+		sort.Strings(actual)
+		if len(actual) != 0 || len(expected) != 0 {
+			if !reflect.DeepEqual(actual, expected) {
+				t.Fatalf("bad: %s: expected: %#v, got: %#v", path, expected, actual)
+			}
+		}
+	}
+}
+
 func TestBackendSecret(t *testing.T) {
 	cases := map[string]struct {
 		Secrets []*Secret
@@ -568,6 +1587,31 @@ func TestFieldSchemaDefaultOrZero(t *testing.T) {
 			&FieldSchema{Type: TypeDurationSecond},
 			0,
 		},
+
+		"default int string": {
+			&FieldSchema{Type: TypeInt, Default: "22"},
+			22,
+		},
+
+		"default bool string": {
+			&FieldSchema{Type: TypeBool, Default: "true"},
+			true,
+		},
+
+		"default comma string slice": {
+			&FieldSchema{Type: TypeCommaStringSlice, Default: "a, b"},
+			[]string{"a", "b"},
+		},
+
+		"default invalid": {
+			&FieldSchema{Type: TypeInt, Default: "foo"},
+			"foo",
+		},
+
+		"default duration invalid": {
+			&FieldSchema{Type: TypeDurationSecond, Default: "foo"},
+			0,
+		},
 	}
 
 	for name, tc := range cases {
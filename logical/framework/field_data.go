@@ -2,15 +2,23 @@ package framework
 
 import (
 	"encoding/json"
-	"errors"
 	"fmt"
+	"reflect"
 	"regexp"
+	"sort"
+	"strings"
 
+	"github.com/hashicorp/vault/helper/jsonutil"
 	"github.com/hashicorp/vault/helper/parseutil"
 	"github.com/hashicorp/vault/helper/strutil"
+	"github.com/hashicorp/vault/logical"
 	"github.com/mitchellh/mapstructure"
 )
 
+// nameStringRegex matches the values of TypeNameString fields, the same way
+// GenericNameRegex matches names in paths
+var nameStringRegex = regexp.MustCompile(`^\w(([\w-.]+)?\w)?$`)
+
 // FieldData is the structure passed to the callback to handle a path
 // containing the populated parameters for fields. This should be used
 // instead of the raw (*vault.Request).Data to access data in a type-safe
@@ -29,12 +37,26 @@ func (d *FieldData) Validate() error {
 
 		schema, ok := d.Schema[field]
 		if !ok {
-			continue
+			// Values given under a deprecated alias are those of the field,
+			// which must not be given a different one
+			alias := field
+			if field, schema, ok = d.aliasOf(alias); !ok {
+				continue
+			}
+			other, _ := d.rawValue(field, schema)
+			same, err := sameValue(schema, other, value)
+			if err != nil {
+				return fmt.Errorf("Error converting input %v for field %s: %s", value, alias, err)
+			}
+			if !same {
+				return fmt.Errorf("%s is given different values under its deprecated alias %s", field, alias)
+			}
 		}
 
 		switch schema.Type {
 		case TypeBool, TypeInt, TypeMap, TypeDurationSecond, TypeString,
-			TypeNameString, TypeSlice, TypeStringSlice, TypeCommaStringSlice:
+			TypeNameString, TypeSlice, TypeStringSlice, TypeCommaStringSlice,
+			TypeKVPairs:
 			_, _, err := d.getPrimitive(field, schema)
 			if err != nil {
 				return fmt.Errorf("Error converting input %v for field %s: %s", value, field, err)
@@ -48,6 +70,132 @@ func (d *FieldData) Validate() error {
 	return nil
 }
 
+// ValidateValues checks the values given for fields against the
+// AllowedValues and Validator of their schema. It returns an error response
+// listing every invalid field, or nil if all of them are valid. Fields which
+// are not given are not checked, and neither are their defaults.
+func (d *FieldData) ValidateValues() *logical.Response {
+	// Values given under deprecated aliases are checked as their fields'
+	seen := make(map[string]bool, len(d.Raw))
+	fields := make([]string, 0, len(d.Raw))
+	for key := range d.Raw {
+		field := key
+		if _, ok := d.Schema[key]; !ok {
+			if field, _, ok = d.aliasOf(key); !ok {
+				continue
+			}
+		}
+		if !seen[field] {
+			seen[field] = true
+			fields = append(fields, field)
+		}
+	}
+	sort.Strings(fields)
+
+	var violations []string
+	for _, field := range fields {
+		schema, ok := d.Schema[field]
+		if !ok || (len(schema.AllowedValues) == 0 && schema.Validator == nil) {
+			continue
+		}
+		value, ok, err := d.GetOkErr(field)
+		if err != nil || !ok {
+			continue
+		}
+
+		if len(schema.AllowedValues) != 0 && !allowedValue(schema.AllowedValues, value) {
+			allowed := make([]string, len(schema.AllowedValues))
+			for i, v := range schema.AllowedValues {
+				allowed[i] = formatValue(v)
+			}
+			violations = append(violations, fmt.Sprintf("%s: %s is not one of %s", field, formatValue(value), strings.Join(allowed, ", ")))
+			continue
+		}
+		if schema.Validator != nil {
+			if err := schema.Validator(value); err != nil {
+				violations = append(violations, fmt.Sprintf("%s: %v", field, err))
+			}
+		}
+	}
+
+	if len(violations) == 0 {
+		return nil
+	}
+	return logical.ErrorResponse("invalid field values: " + strings.Join(violations, "; "))
+}
+
+// rawValue returns the value given for the field, under its name or else
+// under one of its deprecated aliases.
+func (d *FieldData) rawValue(k string, schema *FieldSchema) (interface{}, bool) {
+	if raw, ok := d.Raw[k]; ok {
+		return raw, true
+	}
+	for _, alias := range schema.Aliases {
+		if raw, ok := d.Raw[alias]; ok {
+			return raw, true
+		}
+	}
+	return nil, false
+}
+
+// sameValue returns whether the raw values are the same once converted to
+// the type of the field, such as 3600, "3600" and "1h" for a duration.
+func sameValue(schema *FieldSchema, a, b interface{}) (bool, error) {
+	var values []interface{}
+	for _, raw := range []interface{}{a, b} {
+		d := &FieldData{Raw: map[string]interface{}{"value": raw}}
+		value, _, err := d.getPrimitive("value", schema)
+		if err != nil {
+			return false, err
+		}
+		values = append(values, value)
+	}
+	return reflect.DeepEqual(values[0], values[1]), nil
+}
+
+// aliasOf returns the field of which the key is a deprecated alias.
+func (d *FieldData) aliasOf(key string) (string, *FieldSchema, bool) {
+	for field, schema := range d.Schema {
+		for _, alias := range schema.Aliases {
+			if alias == key {
+				return field, schema, true
+			}
+		}
+	}
+	return "", nil, false
+}
+
+// UsedAliases returns the deprecated aliases the data uses, mapped to their
+// fields.
+func (d *FieldData) UsedAliases() map[string]string {
+	result := map[string]string{}
+	for key := range d.Raw {
+		if _, ok := d.Schema[key]; ok {
+			continue
+		}
+		if field, _, ok := d.aliasOf(key); ok {
+			result[key] = field
+		}
+	}
+	return result
+}
+
+func allowedValue(allowed []interface{}, value interface{}) bool {
+	for _, v := range allowed {
+		if reflect.DeepEqual(v, value) {
+			return true
+		}
+	}
+	return false
+}
+
+func formatValue(v interface{}) string {
+	if s, ok := v.(string); ok {
+		return fmt.Sprintf("%q", s)
+	}
+	return fmt.Sprintf("%v", v)
+}
+
 // Get gets the value for the given field. If the key is an invalid field,
 // FieldData will panic. If you want a safer version of this method, use
 // GetOk. If the field k is not set, the default value (if set) will be
@@ -79,7 +227,10 @@ func (d *FieldData) GetDefaultOrZero(k string) interface{} {
 }
 
 // GetOk gets the value for the given field. The second return value
-// will be false if the key is invalid or the key is not set at all.
+// will be false if the key is invalid or the key is not set at all, which
+// tells fields left out apart from fields given their zero value. It panics
+// if the value does not convert to the type of the field; callbacks are only
+// called with data which converts, other callers should use GetOkErr.
 func (d *FieldData) GetOk(k string) (interface{}, bool) {
 	schema, ok := d.Schema[k]
 	if !ok {
@@ -110,7 +261,8 @@ func (d *FieldData) GetOkErr(k string) (interface{}, bool, error) {
 
 	switch schema.Type {
 	case TypeBool, TypeInt, TypeMap, TypeDurationSecond, TypeString,
-		TypeNameString, TypeSlice, TypeStringSlice, TypeCommaStringSlice:
+		TypeNameString, TypeSlice, TypeStringSlice, TypeCommaStringSlice,
+		TypeKVPairs:
 		return d.getPrimitive(k, schema)
 	default:
 		return nil, false,
@@ -120,7 +272,7 @@ func (d *FieldData) GetOkErr(k string) (interface{}, bool, error) {
 
 func (d *FieldData) getPrimitive(
 	k string, schema *FieldSchema) (interface{}, bool, error) {
-	raw, ok := d.Raw[k]
+	raw, ok := d.rawValue(k, schema)
 	if !ok {
 		return nil, false, nil
 	}
@@ -152,20 +304,36 @@ func (d *FieldData) getPrimitive(
 		if err := mapstructure.WeakDecode(raw, &result); err != nil {
 			return nil, true, err
 		}
-		matched, err := regexp.MatchString("^\\w(([\\w-.]+)?\\w)?$", result)
+		if !nameStringRegex.MatchString(result) {
+			return nil, true, fmt.Errorf("%q is not a valid name: names consist of letters, digits, '_', '-' and '.', and start and end with a letter, digit or '_'", result)
+		}
+		return result, true, nil
+
+	case TypeMap:
+		result, err := parseMap(raw)
 		if err != nil {
 			return nil, true, err
 		}
-		if !matched {
-			return nil, true, errors.New("field does not match the formatting rules")
-		}
 		return result, true, nil
 
-	case TypeMap:
-		var result map[string]interface{}
-		if err := mapstructure.WeakDecode(raw, &result); err != nil {
+	case TypeKVPairs:
+		m, err := parseMap(raw)
+		if err != nil {
 			return nil, true, err
 		}
+		result := make(map[string]string, len(m))
+		for key, value := range m {
+			switch v := value.(type) {
+			case string:
+				result[key] = v
+			case json.Number:
+				result[key] = v.String()
+			case bool, int, int32, int64, uint, uint32, uint64, float32, float64:
+				result[key] = fmt.Sprint(v)
+			default:
+				return nil, true, fmt.Errorf("the value of %q must be a string, not %#v", key, v)
+			}
+		}
 		return result, true, nil
 
 	case TypeDurationSecond:
@@ -189,21 +357,18 @@ func (d *FieldData) getPrimitive(
 			result = int(inp)
 		case float64:
 			result = int(inp)
-		case string:
+		case string, json.Number:
 			dur, err := parseutil.ParseDurationSecond(inp)
 			if err != nil {
-				return nil, true, err
+				return nil, true, fmt.Errorf("invalid duration %q: must be a number of seconds or a duration such as \"90m\" or \"1h30m\"", inp)
 			}
 			result = int(dur.Seconds())
-		case json.Number:
-			valInt64, err := inp.Int64()
-			if err != nil {
-				return nil, true, err
-			}
-			result = int(valInt64)
 		default:
 			return nil, false, fmt.Errorf("invalid input '%v'", raw)
 		}
+		if result < 0 {
+			return nil, true, fmt.Errorf("invalid duration '%v': must not be negative", raw)
+		}
 		return result, true, nil
 
 	case TypeSlice:
@@ -234,9 +399,88 @@ func (d *FieldData) getPrimitive(
 		if err := decoder.Decode(raw); err != nil {
 			return nil, false, err
 		}
-		return strutil.TrimStrings(result), true, nil
+
+		// Elements are trimmed and empty ones dropped, so that "a, b," and
+		// ["a", "b"] are the same
+		items := []string{}
+		for _, item := range strutil.TrimStrings(result) {
+			if item != "" {
+				items = append(items, item)
+			}
+		}
+		return items, true, nil
 
 	default:
 		panic(fmt.Sprintf("Unknown type: %s", schema.Type))
 	}
 }
+
+// parseMap returns the map given as an object, as a string holding a JSON
+// object or a single "key=value" pair, or as a list of such pairs, which is
+// what repeated command line arguments give.
+func parseMap(raw interface{}) (map[string]interface{}, error) {
+	switch v := raw.(type) {
+	case map[string]interface{}:
+		return v, nil
+
+	case map[interface{}]interface{}:
+		result := make(map[string]interface{}, len(v))
+		for key, value := range v {
+			s, ok := key.(string)
+			if !ok {
+				return nil, fmt.Errorf("key %#v is not a string", key)
+			}
+			result[s] = value
+		}
+		return result, nil
+
+	case string:
+		if strings.TrimSpace(v) == "" {
+			return map[string]interface{}{}, nil
+		}
+		if strings.HasPrefix(strings.TrimSpace(v), "{") {
+			var result map[string]interface{}
+			if err := jsonutil.DecodeJSON([]byte(v), &result); err != nil {
+				return nil, fmt.Errorf("invalid JSON object: %v", err)
+			}
+			return result, nil
+		}
+		return parseKVPairs([]interface{}{v})
+
+	case []string:
+		pairs := make([]interface{}, 0, len(v))
+		for _, pair := range v {
+			pairs = append(pairs, pair)
+		}
+		return parseKVPairs(pairs)
+
+	case []interface{}:
+		return parseKVPairs(v)
+	}
+
+	var result map[string]interface{}
+	if err := mapstructure.WeakDecode(raw, &result); err != nil {
+		return nil, err
+	}
+	return result, nil
+}
+
+// parseKVPairs returns the map of the given "key=value" pairs.
+func parseKVPairs(pairs []interface{}) (map[string]interface{}, error) {
+	result := make(map[string]interface{}, len(pairs))
+	for _, raw := range pairs {
+		pair, ok := raw.(string)
+		if !ok {
+			return nil, fmt.Errorf("%#v is not a key=value pair", raw)
+		}
+		parts := strings.SplitN(pair, "=", 2)
+		if len(parts) != 2 || parts[0] == "" {
+			return nil, fmt.Errorf("%q is not a key=value pair", pair)
+		}
+		if _, ok := result[parts[0]]; ok {
+			return nil, fmt.Errorf("key %q is given more than once", parts[0])
+		}
+		result[parts[0]] = parts[1]
+	}
+	return result, nil
+}
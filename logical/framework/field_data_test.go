@@ -1,7 +1,10 @@
 package framework
 
 import (
+	"encoding/json"
+	"errors"
 	"reflect"
+	"strings"
 	"testing"
 )
 
@@ -136,6 +139,28 @@ func TestFieldDataGet(t *testing.T) {
 			42,
 		},
 
+		"duration type, compound duration value": {
+			map[string]*FieldSchema{
+				"foo": &FieldSchema{Type: TypeDurationSecond},
+			},
+			map[string]interface{}{
+				"foo": "1h30m",
+			},
+			"foo",
+			5400,
+		},
+
+		"duration type, json number value": {
+			map[string]*FieldSchema{
+				"foo": &FieldSchema{Type: TypeDurationSecond},
+			},
+			map[string]interface{}{
+				"foo": json.Number("42.5"),
+			},
+			"foo",
+			42,
+		},
+
 		"duration type, nil value": {
 			map[string]*FieldSchema{
 				"foo": &FieldSchema{Type: TypeDurationSecond},
@@ -224,6 +249,28 @@ func TestFieldDataGet(t *testing.T) {
 			[]string{},
 		},
 
+		"comma string slice type, comma string with whitespace and empty values": {
+			map[string]*FieldSchema{
+				"foo": &FieldSchema{Type: TypeCommaStringSlice},
+			},
+			map[string]interface{}{
+				"foo": " value1, ,value2 ,",
+			},
+			"foo",
+			[]string{"value1", "value2"},
+		},
+
+		"comma string slice type, string slice with whitespace and empty values": {
+			map[string]*FieldSchema{
+				"foo": &FieldSchema{Type: TypeCommaStringSlice},
+			},
+			map[string]interface{}{
+				"foo": []interface{}{" value1", "", "value2 "},
+			},
+			"foo",
+			[]string{"value1", "value2"},
+		},
+
 		"commma string slice type, string slice with one value": {
 			map[string]*FieldSchema{
 				"foo": &FieldSchema{Type: TypeCommaStringSlice},
@@ -257,6 +304,115 @@ func TestFieldDataGet(t *testing.T) {
 			[]string{},
 		},
 
+		"map type, JSON string value": {
+			map[string]*FieldSchema{
+				"foo": &FieldSchema{Type: TypeMap},
+			},
+			map[string]interface{}{
+				"foo": `{"child": {"name": "bar"}}`,
+			},
+			"foo",
+			map[string]interface{}{
+				"child": map[string]interface{}{"name": "bar"},
+			},
+		},
+
+		"map type, key/value pair": {
+			map[string]*FieldSchema{
+				"foo": &FieldSchema{Type: TypeMap},
+			},
+			map[string]interface{}{
+				"foo": "child=a=b",
+			},
+			"foo",
+			map[string]interface{}{
+				"child": "a=b",
+			},
+		},
+
+		"map type, empty string": {
+			map[string]*FieldSchema{
+				"foo": &FieldSchema{Type: TypeMap},
+			},
+			map[string]interface{}{
+				"foo": "",
+			},
+			"foo",
+			map[string]interface{}{},
+		},
+
+		"key/value pairs type, repeated pairs": {
+			map[string]*FieldSchema{
+				"foo": &FieldSchema{Type: TypeKVPairs},
+			},
+			map[string]interface{}{
+				"foo": []interface{}{"permit-pty=", "source-address=10.0.0.0/8,10.1.0.1"},
+			},
+			"foo",
+			map[string]string{
+				"permit-pty":     "",
+				"source-address": "10.0.0.0/8,10.1.0.1",
+			},
+		},
+
+		"key/value pairs type, object with scalar values": {
+			map[string]*FieldSchema{
+				"foo": &FieldSchema{Type: TypeKVPairs},
+			},
+			map[string]interface{}{
+				"foo": map[string]interface{}{
+					"a": "b",
+					"c": json.Number("1"),
+					"d": true,
+				},
+			},
+			"foo",
+			map[string]string{"a": "b", "c": "1", "d": "true"},
+		},
+
+		"key/value pairs type, JSON string value": {
+			map[string]*FieldSchema{
+				"foo": &FieldSchema{Type: TypeKVPairs},
+			},
+			map[string]interface{}{
+				"foo": `{"a": "b", "c": 2}`,
+			},
+			"foo",
+			map[string]string{"a": "b", "c": "2"},
+		},
+
+		"key/value pairs type, not set": {
+			map[string]*FieldSchema{
+				"foo": &FieldSchema{Type: TypeKVPairs},
+			},
+			map[string]interface{}{},
+			"foo",
+			map[string]string{},
+		},
+
+		"deprecated alias": {
+			map[string]*FieldSchema{
+				"foo": &FieldSchema{Type: TypeString, Aliases: []string{"bar", "baz"}},
+			},
+			map[string]interface{}{
+				"baz": "value",
+			},
+			"foo",
+			"value",
+		},
+
+		"field given under its name and an alias": {
+			map[string]*FieldSchema{
+				"foo": &FieldSchema{Type: TypeString, Aliases: []string{"bar"}},
+			},
+			map[string]interface{}{
+				"foo": "value",
+				"bar": "value",
+			},
+			"foo",
+			"value",
+		},
+
 		"name string type, valid string": {
 			map[string]*FieldSchema{
 				"foo": &FieldSchema{Type: TypeNameString},
@@ -299,6 +455,96 @@ func TestFieldDataGet(t *testing.T) {
 	}
 }
 
+func TestFieldDataGet_defaults(t *testing.T) {
+	schema := map[string]*FieldSchema{
+		"enabled": &FieldSchema{Type: TypeBool, Default: true},
+		"port":    &FieldSchema{Type: TypeInt, Default: 22},
+		"name":    &FieldSchema{Type: TypeString, Default: "foo"},
+	}
+
+	cases := map[string]struct {
+		Raw   map[string]interface{}
+		Key   string
+		Value interface{}
+		Ok    bool
+	}{
+		"bool omitted": {
+			map[string]interface{}{},
+			"enabled",
+			true,
+			false,
+		},
+
+		"bool explicitly false": {
+			map[string]interface{}{"enabled": false},
+			"enabled",
+			false,
+			true,
+		},
+
+		"bool explicitly false string": {
+			map[string]interface{}{"enabled": "false"},
+			"enabled",
+			false,
+			true,
+		},
+
+		"int omitted": {
+			map[string]interface{}{},
+			"port",
+			22,
+			false,
+		},
+
+		"int explicitly zero": {
+			map[string]interface{}{"port": 0},
+			"port",
+			0,
+			true,
+		},
+
+		"int explicitly zero string": {
+			map[string]interface{}{"port": "0"},
+			"port",
+			0,
+			true,
+		},
+
+		"string omitted": {
+			map[string]interface{}{},
+			"name",
+			"foo",
+			false,
+		},
+
+		"string explicitly empty": {
+			map[string]interface{}{"name": ""},
+			"name",
+			"",
+			true,
+		},
+	}
+
+	for name, tc := range cases {
+		data := &FieldData{
+			Raw:    tc.Raw,
+			Schema: schema,
+		}
+
+		if actual := data.Get(tc.Key); !reflect.DeepEqual(actual, tc.Value) {
+			t.Fatalf("bad: %s: Get: expected: %#v, got: %#v", name, tc.Value, actual)
+		}
+
+		actual, ok := data.GetOk(tc.Key)
+		if ok != tc.Ok {
+			t.Fatalf("bad: %s: GetOk: expected ok: %v, got: %v", name, tc.Ok, ok)
+		}
+		if ok && !reflect.DeepEqual(actual, tc.Value) {
+			t.Fatalf("bad: %s: GetOk: expected: %#v, got: %#v", name, tc.Value, actual)
+		}
+	}
+}
+
 func TestFieldDataGet_Error(t *testing.T) {
 	cases := map[string]struct {
 		Schema map[string]*FieldSchema
@@ -332,6 +578,15 @@ func TestFieldDataGet_Error(t *testing.T) {
 			},
 			"foo",
 		},
+		"name string type, path separator": {
+			map[string]*FieldSchema{
+				"foo": &FieldSchema{Type: TypeNameString},
+			},
+			map[string]interface{}{
+				"foo": "bar/baz",
+			},
+			"foo",
+		},
 		"name string type, empty string": {
 			map[string]*FieldSchema{
 				"foo": &FieldSchema{Type: TypeNameString},
@@ -341,6 +596,89 @@ func TestFieldDataGet_Error(t *testing.T) {
 			},
 			"foo",
 		},
+		"map type, non-string key": {
+			map[string]*FieldSchema{
+				"foo": &FieldSchema{Type: TypeMap},
+			},
+			map[string]interface{}{
+				"foo": map[interface{}]interface{}{1: "bar"},
+			},
+			"foo",
+		},
+		"map type, invalid JSON object": {
+			map[string]*FieldSchema{
+				"foo": &FieldSchema{Type: TypeMap},
+			},
+			map[string]interface{}{
+				"foo": `{"bar": }`,
+			},
+			"foo",
+		},
+		"map type, not a pair": {
+			map[string]*FieldSchema{
+				"foo": &FieldSchema{Type: TypeMap},
+			},
+			map[string]interface{}{
+				"foo": []interface{}{"a=b", "c"},
+			},
+			"foo",
+		},
+		"map type, repeated key": {
+			map[string]*FieldSchema{
+				"foo": &FieldSchema{Type: TypeMap},
+			},
+			map[string]interface{}{
+				"foo": []interface{}{"a=b", "a=c"},
+			},
+			"foo",
+		},
+		"key/value pairs type, nested object": {
+			map[string]*FieldSchema{
+				"foo": &FieldSchema{Type: TypeKVPairs},
+			},
+			map[string]interface{}{
+				"foo": map[string]interface{}{
+					"a": map[string]interface{}{"b": "c"},
+				},
+			},
+			"foo",
+		},
+		"key/value pairs type, list value": {
+			map[string]*FieldSchema{
+				"foo": &FieldSchema{Type: TypeKVPairs},
+			},
+			map[string]interface{}{
+				"foo": `{"a": ["b"]}`,
+			},
+			"foo",
+		},
+		"duration type, negative value": {
+			map[string]*FieldSchema{
+				"foo": &FieldSchema{Type: TypeDurationSecond},
+			},
+			map[string]interface{}{
+				"foo": -1,
+			},
+			"foo",
+		},
+		"duration type, negative duration value": {
+			map[string]*FieldSchema{
+				"foo": &FieldSchema{Type: TypeDurationSecond},
+			},
+			map[string]interface{}{
+				"foo": "-5m",
+			},
+			"foo",
+		},
+		"duration type, unparseable value": {
+			map[string]*FieldSchema{
+				"foo": &FieldSchema{Type: TypeDurationSecond},
+			},
+			map[string]interface{}{
+				"foo": "soon",
+			},
+			"foo",
+		},
 	}
 
 	for _, tc := range cases {
@@ -353,5 +691,78 @@ func TestFieldDataGet_Error(t *testing.T) {
 		if err == nil {
 			t.Fatalf("error expected, none received")
 		}
+		if tc.Schema[tc.Key].Type == TypeNameString && !strings.Contains(err.Error(), "is not a valid name") {
+			t.Fatalf("bad: %v", err)
+		}
+	}
+}
+
+func TestFieldDataValidateValues(t *testing.T) {
+	schema := map[string]*FieldSchema{
+		"mode": &FieldSchema{
+			Type:          TypeString,
+			Default:       "bogus",
+			AllowedValues: []interface{}{"a", "b"},
+		},
+		"count": &FieldSchema{
+			Type: TypeInt,
+			Validator: func(v interface{}) error {
+				if v.(int) < 0 {
+					return errors.New("must not be negative")
+				}
+				return nil
+			},
+		},
+		"other": &FieldSchema{Type: TypeString},
+	}
+
+	cases := map[string]struct {
+		Raw    map[string]interface{}
+		Errors []string
+	}{
+		"no values, defaults not checked": {
+			map[string]interface{}{},
+			nil,
+		},
+		"valid values": {
+			map[string]interface{}{
+				"mode":  "b",
+				"count": "5",
+				"other": "foo",
+			},
+			nil,
+		},
+		"invalid values": {
+			map[string]interface{}{
+				"mode":  "c",
+				"count": -1,
+			},
+			[]string{
+				`count: must not be negative`,
+				`mode: "c" is not one of "a", "b"`,
+			},
+		},
+	}
+
+	for name, tc := range cases {
+		data := &FieldData{
+			Raw:    tc.Raw,
+			Schema: schema,
+		}
+
+		resp := data.ValidateValues()
+		if len(tc.Errors) == 0 {
+			if resp != nil {
+				t.Fatalf("bad: %s: %#v", name, resp)
+			}
+			continue
+		}
+		if resp == nil || !resp.IsError() {
+			t.Fatalf("bad: %s: expected error response", name)
+		}
+		expected := "invalid field values: " + strings.Join(tc.Errors, "; ")
+		if resp.Data["error"] != expected {
+			t.Fatalf("bad: %s\n\nExpected: %s\nGot: %s", name, expected, resp.Data["error"])
+		}
 	}
 }
@@ -8,6 +8,11 @@ const (
 	TypeString  FieldType = iota
 	TypeInt
 	TypeBool
+
+	// TypeMap represents a map of strings to values of any type. Besides a
+	// JSON object, it accepts a string holding a JSON object or a single
+	// "key=value" pair, or a list of such pairs, which is what repeating the
+	// parameter on the command line gives
 	TypeMap
 
 	// TypeDurationSecond represent as seconds, this can be either an
@@ -27,7 +32,14 @@ const (
 	// TypeNameString represents a name that is URI safe and follows specific
 	// rules.  These rules include start and end with an alphanumeric
 	// character and characters in the middle can be alphanumeric or . or -.
+	// Names captured from the path of a write cannot take the name of a
+	// literal path under the same prefix, such as "config" in "roles/config".
 	TypeNameString
+
+	// TypeKVPairs is a helper for TypeMap that returns a map of strings to
+	// strings. Values which are numbers or booleans are converted; nested
+	// objects and lists are refused.
+	TypeKVPairs
 )
 
 func (t FieldType) String() string {
@@ -42,6 +54,8 @@ func (t FieldType) String() string {
 		return "bool"
 	case TypeMap:
 		return "map"
+	case TypeKVPairs:
+		return "key/value pairs"
 	case TypeDurationSecond:
 		return "duration (sec)"
 	case TypeSlice, TypeStringSlice, TypeCommaStringSlice:
@@ -1,14 +1,21 @@
 package framework
 
 import (
+	"errors"
 	"fmt"
 	"time"
 
 	"github.com/hashicorp/vault/logical"
 )
 
+// ErrLeaseMaxReached is returned by the LeaseExtend callbacks when nothing is
+// left of the max TTL of the lease.
+var ErrLeaseMaxReached = errors.New("past the max TTL, cannot renew")
+
 // LeaseExtend returns an OperationFunc that can be used to simply extend the
-// lease of the auth/secret for the duration that was requested.
+// lease of the auth/secret for the duration that was requested. The lease is
+// never extended past its max TTL, counted from its issue time; once that is
+// reached, the callback returns ErrLeaseMaxReached.
 //
 // backendIncrement is the backend's requested increment -- perhaps from a user
 // request, perhaps from a role/config value. If not set, uses the mount/system
@@ -50,10 +57,10 @@ func LeaseExtend(backendIncrement, backendMax time.Duration, systemView logical.
 		// Get the current time
 		now := time.Now()
 
-		// If we are past the max TTL, we shouldn't be in this function...but
-		// fast path out if we are
-		if maxValidTime.Before(now) {
-			return nil, fmt.Errorf("past the max TTL, cannot renew")
+		// If we are at or past the max TTL, there is nothing left to extend
+		// the lease by
+		if !now.Before(maxValidTime) {
+			return nil, ErrLeaseMaxReached
 		}
 
 		// Basic max safety checks have passed, now let's figure out our
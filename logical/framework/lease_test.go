@@ -20,6 +20,7 @@ func TestLeaseExtend(t *testing.T) {
 		BackendDefault time.Duration
 		BackendMax     time.Duration
 		Increment      time.Duration
+		IssuedAgo      time.Duration
 		Result         time.Duration
 		Error          bool
 	}{
@@ -83,6 +84,31 @@ func TestLeaseExtend(t *testing.T) {
 			Increment: 40 * time.Hour,
 			Result:    30 * time.Hour,
 		},
+
+		"lease increment past what is left of the max, capped": {
+			Increment: 10 * time.Hour,
+			IssuedAgo: 25 * time.Hour,
+			Result:    5 * time.Hour,
+		},
+
+		"zero increment past what is left of the max, capped": {
+			BackendDefault: 10 * time.Hour,
+			IssuedAgo:      27 * time.Hour,
+			Result:         3 * time.Hour,
+		},
+
+		"past the max TTL": {
+			Increment: 1 * time.Hour,
+			IssuedAgo: 31 * time.Hour,
+			Error:     true,
+		},
+
+		"past the backend max": {
+			BackendMax: 4 * time.Hour,
+			Increment:  1 * time.Hour,
+			IssuedAgo:  5 * time.Hour,
+			Error:      true,
+		},
 	}
 
 	for name, tc := range cases {
@@ -90,7 +116,7 @@ func TestLeaseExtend(t *testing.T) {
 			Auth: &logical.Auth{
 				LeaseOptions: logical.LeaseOptions{
 					TTL:       1 * time.Hour,
-					IssueTime: now,
+					IssueTime: now.Add(-tc.IssuedAgo),
 					Increment: tc.Increment,
 				},
 			},
@@ -102,6 +128,9 @@ func TestLeaseExtend(t *testing.T) {
 			t.Fatalf("bad: %s\nerr: %s", name, err)
 		}
 		if tc.Error {
+			if err != ErrLeaseMaxReached {
+				t.Fatalf("bad: %s\nerr: %s", name, err)
+			}
 			continue
 		}
 
@@ -2,6 +2,7 @@ package framework
 
 import (
 	"fmt"
+	"regexp"
 	"sort"
 	"strings"
 
@@ -20,6 +21,25 @@ func OptionalParamRegex(name string) string {
 	return fmt.Sprintf("(/(?P<%s>.+))?", name)
 }
 
+// MatchAllRegex returns a regex string capturing the rest of the path,
+// slashes included, into the given field. A pattern ending in it is routed
+// like a prefix mount: paths under its prefix only go to it when no other
+// pattern matches them, and longer prefixes win over shorter ones, whatever
+// the order of the paths.
+func MatchAllRegex(name string) string {
+	return fmt.Sprintf(`(?P<%s>(?s:.*))`, name)
+}
+
+// ListPrefix returns the storage prefix to list the entries under a path
+// captured by MatchAllRegex. Keys of nested entries are returned by storage
+// as folders, with a trailing slash.
+func ListPrefix(path string) string {
+	if path != "" && !strings.HasSuffix(path, "/") {
+		path += "/"
+	}
+	return path
+}
+
 // PathAppend is a helper for appending lists of paths into a single
 // list.
 func PathAppend(paths ...[]*Path) []*Path {
@@ -38,8 +58,18 @@ type Path struct {
 	// This should be a valid regular expression. Named captures will be
 	// exposed as fields that should map to a schema in Fields. If a named
 	// capture is not a field in the Fields map, then it will be ignored.
+	//
+	// The pattern is anchored at both ends when the backend routes its
+	// first request, so that it has to match the whole path. Names should
+	// be captured with GenericNameRegex, and hierarchical keys spanning the
+	// rest of the path with MatchAllRegex.
 	Pattern string
 
+	// PrefixMatch, if set, leaves the end of Pattern unanchored so that it
+	// matches any path it is a prefix of. This is only meant for backends
+	// which relied on it before patterns were anchored.
+	PrefixMatch bool
+
 	// Fields is the mapping of data fields to a schema describing that
 	// field. Named captures in the Pattern also map to fields. If a named
 	// capture name matches a PUT body name, the named capture takes
@@ -59,6 +89,11 @@ type Path struct {
 	// callback will be called.
 	Callbacks map[logical.Operation]OperationFunc
 
+	// Operations are the operations of the path along with their help. They
+	// may be declared instead of Callbacks, or alongside them; an operation
+	// found in both is handled by the callback in Operations.
+	Operations map[logical.Operation]*PathOperation
+
 	// ExistenceCheck, if implemented, is used to query whether a given
 	// resource exists or not. This is used for ACL purposes: if an Update
 	// action is specified, and the existence check returns false, the action
@@ -67,6 +102,16 @@ type Path struct {
 	// must have UpdateCapability on the path.
 	ExistenceCheck func(*logical.Request, *FieldData) (bool, error)
 
+	// AllowUnknownFields, if set, lets requests give fields which are not in
+	// Fields even if the backend has RejectUnknownFields set, for paths
+	// taking free-form data.
+	AllowUnknownFields bool
+
+	// ValidateFieldValues, if set, checks the given fields against their
+	// AllowedValues and Validator before the callback is called. Requests
+	// with invalid values get an error response listing all of them.
+	ValidateFieldValues bool
+
 	// Help is text describing how to use this path. This will be used
 	// to auto-generate the help operation. The Path will automatically
 	// generate a parameter listing and URL structure based on the
@@ -82,6 +127,61 @@ type Path struct {
 	HelpDescription string
 }
 
+// PathOperation is an operation of a path, with help which is specific to it.
+type PathOperation struct {
+	// Callback is called to handle the operation.
+	Callback OperationFunc
+
+	// Summary is a one-sentence description of what the operation does.
+	Summary string
+
+	// Description is a long-form description of the operation, for details
+	// which do not apply to the other operations of the path.
+	Description string
+
+	// StandbySafe declares that the operation only reads storage and
+	// creates no leases, so that standby nodes serve it themselves instead
+	// of forwarding it to the active node. Writes to storage fail while it
+	// runs, as do responses carrying a secret or auth.
+	StandbySafe bool
+
+	// Response declares the fields of the data of successful responses, so
+	// that clients know what to expect. Responses need not hold all of
+	// them, e.g. when they depend on the type of a role. Only the type and
+	// description of the schemas are used.
+	Response map[string]*FieldSchema
+}
+
+// callback returns the callback for the operation.
+func (p *Path) callback(op logical.Operation) (OperationFunc, bool) {
+	if o, ok := p.Operations[op]; ok && o != nil && o.Callback != nil {
+		return o.Callback, true
+	}
+	callback, ok := p.Callbacks[op]
+	return callback, ok
+}
+
+// standbySafe returns whether the operation is declared safe on a standby.
+func (p *Path) standbySafe(op logical.Operation) bool {
+	o, ok := p.Operations[op]
+	return ok && o != nil && o.StandbySafe
+}
+
+// operations returns the sorted names of the operations of the path.
+func (p *Path) operations() []string {
+	var result []string
+	for op := range p.Callbacks {
+		result = append(result, string(op))
+	}
+	for op := range p.Operations {
+		if _, ok := p.Callbacks[op]; !ok {
+			result = append(result, string(op))
+		}
+	}
+	sort.Strings(result)
+	return result
+}
+
 func (p *Path) helpCallback(
 	req *logical.Request, data *FieldData) (*logical.Response, error) {
 	var tplData pathTemplateData
@@ -96,6 +196,41 @@ func (p *Path) helpCallback(
 		tplData.Description = "<no description>"
 	}
 
+	// List the operations the path supports, e.g. whether it can be listed,
+	// along with the help of those declaring it
+	tplData.Operations = p.operations()
+	operationsSchema := map[string]interface{}{}
+	for _, op := range tplData.Operations {
+		o := p.Operations[logical.Operation(op)]
+		if o == nil || (o.Summary == "" && o.Description == "" && len(o.Response) == 0) {
+			continue
+		}
+		opData := pathTemplateOperationData{
+			Operation:   op,
+			Summary:     strings.TrimSpace(o.Summary),
+			Description: strings.TrimSpace(o.Description),
+		}
+		opSchema := map[string]interface{}{
+			"summary":      opData.Summary,
+			"description":  opData.Description,
+			"standby_safe": o.StandbySafe,
+		}
+		if len(o.Response) != 0 {
+			responseSchema := make(map[string]interface{}, len(o.Response))
+			for k, schema := range o.Response {
+				opData.Response = append(opData.Response, k)
+				responseSchema[k] = map[string]interface{}{
+					"type":        schema.Type.String(),
+					"description": strings.TrimSpace(schema.Description),
+				}
+			}
+			sort.Strings(opData.Response)
+			opSchema["response"] = responseSchema
+		}
+		tplData.OperationHelp = append(tplData.OperationHelp, opData)
+		operationsSchema[op] = opSchema
+	}
+
 	// Alphabetize the fields
 	fieldKeys := make([]string, 0, len(p.Fields))
 	for k, _ := range p.Fields {
@@ -103,8 +238,18 @@ func (p *Path) helpCallback(
 	}
 	sort.Strings(fieldKeys)
 
-	// Build the field help
+	// Fields captured by the pattern are part of the path, so they are
+	// always given
+	captured := map[string]bool{}
+	if re, err := regexp.Compile(p.Pattern); err == nil {
+		for _, name := range re.SubexpNames() {
+			captured[name] = true
+		}
+	}
+
+	// Build the field help, along with its machine-readable form
 	tplData.Fields = make([]pathTemplateFieldData, len(fieldKeys))
+	fieldsSchema := make(map[string]interface{}, len(fieldKeys))
 	for i, k := range fieldKeys {
 		schema := p.Fields[k]
 		description := strings.TrimSpace(schema.Description)
@@ -116,7 +261,22 @@ func (p *Path) helpCallback(
 			Key:         k,
 			Type:        schema.Type.String(),
 			Description: description,
+			Aliases:     schema.Aliases,
+		}
+
+		fieldSchema := map[string]interface{}{
+			"type":        schema.Type.String(),
+			"description": strings.TrimSpace(schema.Description),
+			"default":     schema.Default,
+			"required":    schema.Required || captured[k],
 		}
+		if len(schema.AllowedValues) != 0 {
+			fieldSchema["allowed_values"] = schema.AllowedValues
+		}
+		if len(schema.Aliases) != 0 {
+			fieldSchema["deprecated_aliases"] = schema.Aliases
+		}
+		fieldsSchema[k] = fieldSchema
 	}
 
 	help, err := executeTemplate(pathHelpTemplate, &tplData)
@@ -124,15 +284,35 @@ func (p *Path) helpCallback(
 		return nil, fmt.Errorf("error executing template: %s", err)
 	}
 
-	return logical.HelpResponse(help, nil), nil
+	resp := logical.HelpResponse(help, nil)
+	resp.Data["schema"] = map[string]interface{}{
+		"pattern":     p.Pattern,
+		"synopsis":    strings.TrimSpace(p.HelpSynopsis),
+		"description": strings.TrimSpace(p.HelpDescription),
+		"operations":  tplData.Operations,
+		"fields":      fieldsSchema,
+	}
+	if len(operationsSchema) != 0 {
+		resp.Data["schema"].(map[string]interface{})["operation_help"] = operationsSchema
+	}
+	return resp, nil
 }
 
 type pathTemplateData struct {
-	Request      string
-	RoutePattern string
-	Synopsis     string
-	Description  string
-	Fields       []pathTemplateFieldData
+	Request       string
+	RoutePattern  string
+	Synopsis      string
+	Description   string
+	Operations    []string
+	OperationHelp []pathTemplateOperationData
+	Fields        []pathTemplateFieldData
+}
+
+type pathTemplateOperationData struct {
+	Operation   string
+	Summary     string
+	Description string
+	Response    []string
 }
 
 type pathTemplateFieldData struct {
@@ -140,20 +320,35 @@ type pathTemplateFieldData struct {
 	Type        string
 	Description string
 	URL         bool
+	Aliases     []string
 }
 
 const pathHelpTemplate = `
 Request:        {{.Request}}
 Matching Route: {{.RoutePattern}}
-
+{{ if .Operations -}}
+Operations:     {{join .Operations ", "}}
+{{ end }}
 {{.Synopsis}}
 
 {{ if .Fields -}}
 ## PARAMETERS
 {{range .Fields}}
-{{indent 4 .Key}} ({{.Type}})
+{{indent 4 .Key}} ({{.Type}}){{if .Aliases}}, deprecated aliases: {{join .Aliases ", "}}{{end}}
 {{indent 8 .Description}}
 {{end}}{{end}}
+{{- if .OperationHelp -}}
+## OPERATIONS
+{{range .OperationHelp}}
+{{indent 4 .Operation}}{{if .Summary}}: {{.Summary}}{{end}}
+{{- if .Description}}
+{{indent 8 .Description}}
+{{- end}}
+{{- if .Response}}
+{{indent 8 (printf "Returns: %s" (join .Response ", "))}}
+{{- end}}
+{{end}}
+{{end -}}
 ## DESCRIPTION
 
 {{.Description}}
@@ -0,0 +1,77 @@
+package framework
+
+import (
+	"regexp"
+	"regexp/syntax"
+	"strings"
+)
+
+// reservedName returns the field and path of the first TypeNameString capture
+// of the input which takes the name of a literal path of the backend under
+// the same prefix, such as a role named "config" next to "roles/config".
+// Entries of that name could not be addressed, since requests for them would
+// be routed to the literal path.
+func (b *Backend) reservedName(p *Path, input string) (string, string) {
+	var re *regexp.Regexp
+	for i, bp := range b.Paths {
+		if bp == p {
+			re = b.pathsRe[i]
+			break
+		}
+	}
+	if re == nil {
+		return "", ""
+	}
+
+	loc := re.FindStringSubmatchIndex(input)
+	if loc == nil {
+		return "", ""
+	}
+	for i, field := range re.SubexpNames() {
+		schema, ok := p.Fields[field]
+		if !ok || schema.Type != TypeNameString || loc[2*i] < 0 {
+			continue
+		}
+		prefix, name := input[:loc[2*i]], input[loc[2*i]:loc[2*i+1]]
+		for _, other := range b.Paths {
+			literal, ok := literalPattern(other.Pattern)
+			if !ok || !strings.HasPrefix(literal, prefix) {
+				continue
+			}
+			if strings.SplitN(literal[len(prefix):], "/", 2)[0] == name {
+				return field, literal
+			}
+		}
+	}
+
+	return "", ""
+}
+
+// literalPattern returns the input matched by the pattern if it matches
+// exactly one.
+func literalPattern(pattern string) (string, bool) {
+	re, err := syntax.Parse(pattern, syntax.Perl)
+	if err != nil {
+		return "", false
+	}
+	re = re.Simplify()
+
+	subs := []*syntax.Regexp{re}
+	if re.Op == syntax.OpConcat {
+		subs = re.Sub
+	}
+	var literal string
+	for _, sub := range subs {
+		switch sub.Op {
+		case syntax.OpLiteral:
+			if sub.Flags&syntax.FoldCase != 0 {
+				return "", false
+			}
+			literal += string(sub.Rune)
+		case syntax.OpBeginText, syntax.OpEndText, syntax.OpBeginLine, syntax.OpEndLine:
+		default:
+			return "", false
+		}
+	}
+	return literal, true
+}
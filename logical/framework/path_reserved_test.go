@@ -0,0 +1,119 @@
+package framework
+
+import (
+	"testing"
+
+	"github.com/hashicorp/vault/logical"
+)
+
+func TestLiteralPattern(t *testing.T) {
+	cases := map[string]struct {
+		Literal string
+		Ok      bool
+	}{
+		"config":              {"config", true},
+		"^config/ca$":         {"config/ca", true},
+		"installed/cleanup$":  {"installed/cleanup", true},
+		"keys/?$":             {"", false},
+		"(?i)config":          {"", false},
+		"roles/(?P<name>\\w)": {"", false},
+		"(read|write)":        {"", false},
+	}
+
+	for pattern, tc := range cases {
+		literal, ok := literalPattern(pattern)
+		if literal != tc.Literal || ok != tc.Ok {
+			t.Fatalf("bad: %s: expected: %q %v, got: %q %v", pattern, tc.Literal, tc.Ok, literal, ok)
+		}
+	}
+}
+
+func TestBackendHandleRequest_reservedNames(t *testing.T) {
+	callback := func(req *logical.Request, data *FieldData) (*logical.Response, error) {
+		return &logical.Response{
+			Data: map[string]interface{}{"name": data.Get("name")},
+		}, nil
+	}
+	nameField := map[string]*FieldSchema{
+		"name": &FieldSchema{Type: TypeNameString},
+	}
+	b := &Backend{
+		Paths: []*Path{
+			&Path{
+				Pattern: "config",
+			},
+			&Path{
+				Pattern: "roles/config/ca",
+			},
+			&Path{
+				Pattern: "roles/" + GenericNameRegex("name") + "/rotate",
+				Fields:  nameField,
+				Callbacks: map[logical.Operation]OperationFunc{
+					logical.UpdateOperation: callback,
+				},
+			},
+			&Path{
+				Pattern: "roles/" + GenericNameRegex("name"),
+				Fields:  nameField,
+				Callbacks: map[logical.Operation]OperationFunc{
+					logical.ReadOperation:   callback,
+					logical.UpdateOperation: callback,
+				},
+			},
+			&Path{
+				Pattern: GenericNameRegex("name"),
+				Fields:  nameField,
+				Callbacks: map[logical.Operation]OperationFunc{
+					logical.UpdateOperation: callback,
+				},
+			},
+			&Path{
+				Pattern: "other/" + GenericNameRegex("name"),
+				Fields: map[string]*FieldSchema{
+					"name": &FieldSchema{Type: TypeString},
+				},
+				Callbacks: map[logical.Operation]OperationFunc{
+					logical.UpdateOperation: callback,
+				},
+			},
+		},
+	}
+
+	cases := []struct {
+		Operation logical.Operation
+		Path      string
+		Error     string
+	}{
+		{logical.UpdateOperation, "roles/foo", ""},
+		{logical.UpdateOperation, "roles/config", `name: "config" is reserved for the path "roles/config/ca"`},
+		{logical.UpdateOperation, "roles/config/rotate", `name: "config" is reserved for the path "roles/config/ca"`},
+		{logical.UpdateOperation, "roles/configs", ""},
+		{logical.UpdateOperation, "roles", `name: "roles" is reserved for the path "roles/config/ca"`},
+		{logical.UpdateOperation, "foo", ""},
+
+		// Only writes are refused
+		{logical.ReadOperation, "roles/config", ""},
+
+		// Only names are checked
+		{logical.UpdateOperation, "other/config", ""},
+	}
+
+	for _, tc := range cases {
+		resp, err := b.HandleRequest(&logical.Request{
+			Operation: tc.Operation,
+			Path:      tc.Path,
+		})
+		if err != nil {
+			t.Fatalf("bad: %s: %v", tc.Path, err)
+		}
+		if tc.Error == "" {
+			if resp == nil || resp.IsError() {
+				t.Fatalf("bad: %s: %#v", tc.Path, resp)
+			}
+			continue
+		}
+		if resp == nil || !resp.IsError() || resp.Data["error"] != tc.Error {
+			t.Fatalf("bad: %s: %#v", tc.Path, resp)
+		}
+	}
+}
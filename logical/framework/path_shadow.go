@@ -0,0 +1,164 @@
+package framework
+
+import (
+	"fmt"
+	"regexp/syntax"
+	"strings"
+)
+
+// Upper bound for the number of example inputs generated for a pattern
+const maxPatternExamples = 64
+
+// PathShadow describes a path whose inputs are routed to an earlier path,
+// because the pattern of the earlier path matches them as well.
+type PathShadow struct {
+	// Pattern is the pattern of the path which is shadowed
+	Pattern string
+
+	// ShadowedBy is the pattern of the earlier path the input is routed to
+	ShadowedBy string
+
+	// Input is an input matching both patterns
+	Input string
+}
+
+func (s *PathShadow) String() string {
+	return fmt.Sprintf("pattern %q is shadowed by %q, which also matches %q", s.Pattern, s.ShadowedBy, s.Input)
+}
+
+// ShadowedPaths returns the paths of the backend which are shadowed by
+// paths routed before them. Two patterns matching the same input are only
+// reported if the input is routed to the earlier path while being meant for
+// the later one; ordering a specific pattern before a general one is fine.
+//
+// Inputs are derived from each pattern, filling its captures with sample
+// values, so overlaps which only show with particular values may go
+// unnoticed.
+func (b *Backend) ShadowedPaths() []*PathShadow {
+	b.once.Do(b.init)
+
+	var shadows []*PathShadow
+	for k, j := range b.routeOrder {
+		p := b.Paths[j]
+		reported := make(map[int]bool)
+		for _, input := range patternExamples(p.Pattern) {
+			if !b.pathsRe[j].MatchString(input) {
+				continue
+			}
+			for _, i := range b.routeOrder[:k] {
+				if !b.pathsRe[i].MatchString(input) {
+					continue
+				}
+				if !reported[i] {
+					reported[i] = true
+					shadows = append(shadows, &PathShadow{
+						Pattern:    p.Pattern,
+						ShadowedBy: b.Paths[i].Pattern,
+						Input:      input,
+					})
+				}
+				break
+			}
+		}
+	}
+
+	return shadows
+}
+
+// patternExamples returns inputs matching the pattern, covering each of its
+// alternations and optional parts. Invalid patterns have no examples.
+func patternExamples(pattern string) []string {
+	re, err := syntax.Parse(pattern, syntax.Perl)
+	if err != nil {
+		return nil
+	}
+	return regexpExamples(re.Simplify())
+}
+
+func regexpExamples(re *syntax.Regexp) []string {
+	switch re.Op {
+	case syntax.OpLiteral:
+		return []string{string(re.Rune)}
+
+	case syntax.OpCharClass:
+		if len(re.Rune) == 0 {
+			return nil
+		}
+		// Prefer a character which reads like a value in a path
+		for _, r := range "a0-." {
+			for i := 0; i+1 < len(re.Rune); i += 2 {
+				if re.Rune[i] <= r && r <= re.Rune[i+1] {
+					return []string{string(r)}
+				}
+			}
+		}
+		return []string{string(re.Rune[0])}
+
+	case syntax.OpAnyChar, syntax.OpAnyCharNotNL:
+		return []string{"a"}
+
+	case syntax.OpCapture:
+		// Captures are filled with values rather than left empty, since
+		// empty values are seldom what the path is meant for
+		var result []string
+		for _, example := range regexpExamples(re.Sub[0]) {
+			if example != "" {
+				result = append(result, example)
+			}
+		}
+		if len(result) == 0 {
+			return []string{""}
+		}
+		return result
+
+	case syntax.OpPlus:
+		return regexpExamples(re.Sub[0])
+
+	case syntax.OpStar, syntax.OpQuest:
+		return append([]string{""}, regexpExamples(re.Sub[0])...)
+
+	case syntax.OpRepeat:
+		sub := regexpExamples(re.Sub[0])
+		if len(sub) == 0 {
+			return nil
+		}
+		if re.Min == 0 {
+			return []string{"", sub[0]}
+		}
+		return []string{strings.Repeat(sub[0], re.Min)}
+
+	case syntax.OpConcat:
+		result := []string{""}
+		for _, sub := range re.Sub {
+			subExamples := regexpExamples(sub)
+			var next []string
+			for _, prefix := range result {
+				for _, suffix := range subExamples {
+					if len(next) == maxPatternExamples {
+						break
+					}
+					next = append(next, prefix+suffix)
+				}
+			}
+			result = next
+		}
+		return result
+
+	case syntax.OpAlternate:
+		var result []string
+		for _, sub := range re.Sub {
+			result = append(result, regexpExamples(sub)...)
+		}
+		if len(result) > maxPatternExamples {
+			result = result[:maxPatternExamples]
+		}
+		return result
+
+	case syntax.OpNoMatch:
+		return nil
+
+	default:
+		// Anchors, word boundaries and empty matches
+		return []string{""}
+	}
+}
@@ -0,0 +1,110 @@
+package framework
+
+import (
+	"reflect"
+	"regexp"
+	"strings"
+	"testing"
+
+	"github.com/hashicorp/vault/logical"
+)
+
+func TestPatternExamples(t *testing.T) {
+	patterns := []string{
+		`keys/config`,
+		`keys/(?P<name>\w+)`,
+		`keys/?$`,
+		`roles/` + GenericNameRegex("name") + `/(read|write)`,
+		`creds/(?P<name>.*)`,
+		`config/(?P<name>[^/]+)` + OptionalParamRegex("key"),
+	}
+
+	for _, pattern := range patterns {
+		examples := patternExamples(pattern)
+		if len(examples) == 0 {
+			t.Fatalf("bad: %s: no examples", pattern)
+		}
+		re := regexp.MustCompile("^" + pattern + "$")
+		for _, example := range examples {
+			if !re.MatchString(example) {
+				t.Fatalf("bad: %s: example %q does not match", pattern, example)
+			}
+		}
+	}
+
+	// Both branches of alternations and optional parts are covered
+	expected := []string{"keys", "keys/"}
+	if actual := patternExamples(`keys/?`); !reflect.DeepEqual(actual, expected) {
+		t.Fatalf("bad: expected: %#v, got: %#v", expected, actual)
+	}
+	expected = []string{"keys/read", "keys/write"}
+	if actual := patternExamples(`keys/(read|write)`); !reflect.DeepEqual(actual, expected) {
+		t.Fatalf("bad: expected: %#v, got: %#v", expected, actual)
+	}
+}
+
+func TestBackendShadowedPaths(t *testing.T) {
+	cases := map[string]struct {
+		Patterns []string
+		Shadows  []*PathShadow
+	}{
+		"shadowed literal": {
+			[]string{`keys/(?P<name>\w+)`, `keys/config`},
+			[]*PathShadow{
+				&PathShadow{
+					Pattern:    `^keys/config$`,
+					ShadowedBy: `^keys/(?P<name>\w+)$`,
+					Input:      "keys/config",
+				},
+			},
+		},
+
+		"specific first": {
+			[]string{`keys/config`, `keys/(?P<name>\w+)`},
+			nil,
+		},
+
+		"list and item": {
+			[]string{`keys/?$`, `keys/(?P<name>.*)`},
+			nil,
+		},
+
+		"shadowed alternation branch": {
+			[]string{`(?P<action>read|write)/(?P<name>\w+)`, `(list|write)/default`},
+			[]*PathShadow{
+				&PathShadow{
+					Pattern:    `^(?:(list|write)/default)$`,
+					ShadowedBy: `^(?:(?P<action>read|write)/(?P<name>\w+))$`,
+					Input:      "write/default",
+				},
+			},
+		},
+
+		"distinct": {
+			[]string{`foo/(?P<name>\w+)`, `bar/(?P<name>\w+)`, `foo/(?P<name>\w+)/bar`},
+			nil,
+		},
+	}
+
+	for name, tc := range cases {
+		b := &Backend{}
+		for _, pattern := range tc.Patterns {
+			b.Paths = append(b.Paths, &Path{Pattern: pattern})
+		}
+
+		if actual := b.ShadowedPaths(); !reflect.DeepEqual(actual, tc.Shadows) {
+			t.Fatalf("bad: %s\n\nExpected: %v\nGot: %v", name, tc.Shadows, actual)
+		}
+
+		// Backends with shadowed paths are refused at setup
+		err := b.Setup(logical.TestBackendConfig())
+		if (err != nil) != (len(tc.Shadows) != 0) {
+			t.Fatalf("bad: %s: err: %v", name, err)
+		}
+		for _, shadow := range tc.Shadows {
+			if !strings.Contains(err.Error(), shadow.String()) {
+				t.Fatalf("bad: %s: %s is not reported: %v", name, shadow, err)
+			}
+		}
+	}
+}
@@ -0,0 +1,53 @@
+package framework
+
+import (
+	"fmt"
+
+	"github.com/hashicorp/vault/logical"
+)
+
+// StandbySafe returns whether the path of the request declares its operation
+// safe to run on a standby node. It implements logical.StandbySafeBackend.
+func (b *Backend) StandbySafe(req *logical.Request) bool {
+	path, _ := b.route(req.Path)
+	return path != nil && path.standbySafe(req.Operation)
+}
+
+// standbySafeCallback wraps the callback of an operation declared safe on a
+// standby, holding it to the declaration: the storage of the request refuses
+// writes while it runs, and responses creating leases are refused.
+func standbySafeCallback(callback OperationFunc) OperationFunc {
+	return func(req *logical.Request, data *FieldData) (*logical.Response, error) {
+		storage := req.Storage
+		if storage != nil {
+			req.Storage = &standbySafeStorage{Storage: storage, operation: req.Operation}
+			defer func() {
+				req.Storage = storage
+			}()
+		}
+
+		resp, err := callback(req, data)
+		if err != nil {
+			return resp, err
+		}
+		if resp != nil && (resp.Secret != nil || resp.Auth != nil) {
+			return nil, fmt.Errorf("the %s operation of %q is declared safe on a standby but created a lease", req.Operation, req.Path)
+		}
+		return resp, nil
+	}
+}
+
+// standbySafeStorage is the storage of the operations declared safe on a
+// standby, which fails writes instead of letting them through.
+type standbySafeStorage struct {
+	logical.Storage
+	operation logical.Operation
+}
+
+func (s *standbySafeStorage) Put(entry *logical.StorageEntry) error {
+	return fmt.Errorf("cannot write %q: the %s operation is declared safe on a standby and must not write to storage", entry.Key, s.operation)
+}
+
+func (s *standbySafeStorage) Delete(key string) error {
+	return fmt.Errorf("cannot delete %q: the %s operation is declared safe on a standby and must not write to storage", key, s.operation)
+}
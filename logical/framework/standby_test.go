@@ -0,0 +1,104 @@
+package framework
+
+import (
+	"strings"
+	"testing"
+
+	"github.com/hashicorp/vault/logical"
+)
+
+func TestBackendHandleRequest_standbySafe(t *testing.T) {
+	write := func(req *logical.Request, data *FieldData) (*logical.Response, error) {
+		if err := req.Storage.Put(&logical.StorageEntry{Key: "foo", Value: []byte("bar")}); err != nil {
+			return nil, err
+		}
+		return nil, nil
+	}
+	read := func(req *logical.Request, data *FieldData) (*logical.Response, error) {
+		entry, err := req.Storage.Get("foo")
+		if err != nil {
+			return nil, err
+		}
+		return &logical.Response{
+			Data: map[string]interface{}{"value": string(entry.Value)},
+		}, nil
+	}
+	b := &Backend{
+		Paths: []*Path{
+			&Path{
+				Pattern: "foo",
+				Operations: map[logical.Operation]*PathOperation{
+					logical.ReadOperation:   &PathOperation{Callback: read, StandbySafe: true},
+					logical.UpdateOperation: &PathOperation{Callback: write},
+					logical.DeleteOperation: &PathOperation{Callback: write, StandbySafe: true},
+				},
+			},
+			&Path{
+				Pattern: "creds",
+				Operations: map[logical.Operation]*PathOperation{
+					logical.ReadOperation: &PathOperation{
+						Callback: func(req *logical.Request, data *FieldData) (*logical.Response, error) {
+							return &logical.Response{Secret: &logical.Secret{}}, nil
+						},
+						StandbySafe: true,
+					},
+				},
+			},
+		},
+	}
+
+	cases := []struct {
+		Operation logical.Operation
+		Path      string
+		Safe      bool
+	}{
+		{logical.ReadOperation, "foo", true},
+		{logical.UpdateOperation, "foo", false},
+		{logical.DeleteOperation, "foo", true},
+		{logical.ListOperation, "foo", false},
+		{logical.ReadOperation, "bar", false},
+	}
+	for _, tc := range cases {
+		req := &logical.Request{Operation: tc.Operation, Path: tc.Path}
+		if safe := b.StandbySafe(req); safe != tc.Safe {
+			t.Fatalf("bad: %s %s: %v", tc.Operation, tc.Path, safe)
+		}
+	}
+
+	// Operations which are not annotated write as usual
+	storage := &logical.InmemStorage{}
+	req := logical.TestRequest(t, logical.UpdateOperation, "foo")
+	req.Storage = storage
+	if _, err := b.HandleRequest(req); err != nil {
+		t.Fatalf("err: %v", err)
+	}
+
+	// Annotated operations can read, but writing fails and leaves the
+	// storage untouched
+	req = logical.TestRequest(t, logical.ReadOperation, "foo")
+	req.Storage = storage
+	resp, err := b.HandleRequest(req)
+	if err != nil || resp.Data["value"] != "bar" {
+		t.Fatalf("bad: %#v, %v", resp, err)
+	}
+	storage.Delete("foo")
+	req = logical.TestRequest(t, logical.DeleteOperation, "foo")
+	req.Storage = storage
+	_, err = b.HandleRequest(req)
+	if err == nil || !strings.Contains(err.Error(), "declared safe on a standby") {
+		t.Fatalf("bad: %v", err)
+	}
+	if entry, _ := storage.Get("foo"); entry != nil {
+		t.Fatalf("bad: %#v", entry)
+	}
+	if req.Storage != storage {
+		t.Fatalf("bad: the storage of the request was not restored")
+	}
+
+	// Nor can they create leases
+	req = logical.TestRequest(t, logical.ReadOperation, "creds")
+	resp, err = b.HandleRequest(req)
+	if err == nil || resp != nil {
+		t.Fatalf("bad: %#v, %v", resp, err)
+	}
+}
@@ -12,6 +12,7 @@ func executeTemplate(tpl string, data interface{}) (string, error) {
 	// Define the functions
 	funcs := map[string]interface{}{
 		"indent": funcIndent,
+		"join":   strings.Join,
 	}
 
 	// Parse the help template
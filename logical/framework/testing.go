@@ -2,6 +2,8 @@ package framework
 
 import (
 	"testing"
+
+	"github.com/hashicorp/vault/logical"
 )
 
 // TestBackendRoutes is a helper to test that all the given routes will
@@ -13,3 +15,24 @@ func TestBackendRoutes(t *testing.T, b *Backend, rs []string) {
 		}
 	}
 }
+
+// TestBackendPathsDistinct is a helper to test that no path of the backend
+// is shadowed by an earlier path matching its inputs.
+func TestBackendPathsDistinct(t *testing.T, b *Backend) {
+	for _, shadow := range b.ShadowedPaths() {
+		t.Errorf("bad paths: %s", shadow)
+	}
+}
+
+// TestBackendPeriodic triggers the PeriodicFunc of the backend with the
+// given storage, as the periodic tick of the RollbackManager does, and fails
+// the test if it returns an error.
+func TestBackendPeriodic(t *testing.T, b *Backend, s logical.Storage) {
+	req := &logical.Request{
+		Operation: logical.RollbackOperation,
+		Storage:   s,
+	}
+	if err := b.runPeriodicFunc(req); err != nil {
+		t.Fatalf("periodic function failed: %v", err)
+	}
+}
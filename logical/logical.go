@@ -63,8 +63,10 @@ type Backend interface {
 	// existence check function was found, the item exists or not.
 	HandleExistenceCheck(*Request) (bool, bool, error)
 
-	// Cleanup is invoked during an unmount of a backend to allow it to
-	// handle any cleanup like connection closing or releasing of file handles.
+	// Cleanup is invoked during an unmount of a backend, as well as when
+	// Vault is sealed or shut down, to allow it to handle any cleanup like
+	// connection closing or releasing of file handles. It may be invoked
+	// more than once.
 	Cleanup()
 
 	// Initialize is invoked after a backend is created. It is the place to run
@@ -87,6 +89,16 @@ type Backend interface {
 	RegisterLicense(interface{}) error
 }
 
+// StandbySafeBackend is implemented by backends which declare requests safe
+// to serve on a standby node, as they only read storage and create no
+// leases. Standby nodes serve such requests themselves rather than
+// forwarding them to the active node.
+type StandbySafeBackend interface {
+	// StandbySafe returns whether the request, whose path is relative to
+	// the mount of the backend, is safe to serve on a standby node.
+	StandbySafe(*Request) bool
+}
+
 // BackendConfig is provided to the factory to initialize the backend
 type BackendConfig struct {
 	// View should not be stored, and should only be used for initialization
@@ -57,9 +57,10 @@ type Request struct {
 	// headers.
 	Headers map[string][]string `json:"headers" structs:"headers" mapstructure:"headers"`
 
-	// Connection will be non-nil only for credential providers to
-	// inspect the connection information and potentially use it for
-	// authentication/protection.
+	// Connection holds the address of the client and the TLS state of its
+	// connection, including its certificates, for backends to use in
+	// authentication or to bind what they issue to the client. It is set by
+	// the HTTP handler and is nil for requests which Vault makes itself.
 	Connection *Connection `json:"connection" structs:"connection" mapstructure:"connection"`
 
 	// ClientToken is provided to the core so that the identity
@@ -77,6 +78,10 @@ type Request struct {
 	// name, but is useful for operators.
 	DisplayName string `json:"display_name" structs:"display_name" mapstructure:"display_name"`
 
+	// TokenMetadata is the metadata of the token used for the request, which
+	// backends can use to tailor dynamic secrets to the requesting entity.
+	TokenMetadata map[string]string `json:"token_metadata" structs:"token_metadata" mapstructure:"token_metadata"`
+
 	// MountPoint is provided so that a logical backend can generate
 	// paths relative to itself. The `Path` is effectively the client
 	// request path with the MountPoint trimmed off.
@@ -53,10 +53,22 @@ type Response struct {
 
 	// Information for wrapping the response in a cubbyhole
 	WrapInfo *wrapping.ResponseWrapInfo `json:"wrap_info" structs:"wrap_info" mapstructure:"wrap_info"`
+
+	// AuditRedact lists the keys of Data whose values must never reach the
+	// audit logs, not even as HMACs or with raw logging enabled. Audit
+	// backends replace these values with a fixed placeholder. The values
+	// are sent down to the user as usual.
+	AuditRedact []string `json:"audit_redact" structs:"audit_redact" mapstructure:"audit_redact"`
 }
 
-// AddWarning adds a warning into the response's warning list
+// AddWarning adds a warning into the response's warning list. Warnings
+// which are already in the list are not added again.
 func (r *Response) AddWarning(warning string) {
+	for _, w := range r.Warnings {
+		if w == warning {
+			return
+		}
+	}
 	if r.Warnings == nil {
 		r.Warnings = make([]string, 0, 1)
 	}
@@ -110,3 +122,23 @@ func ListResponse(keys []string) *Response {
 	}
 	return resp
 }
+
+// ListResponseWithInfo is used to format a response to a list operation and
+// return the keys as well as a map with corresponding key info. Info for keys
+// that are not present in the list is dropped.
+func ListResponseWithInfo(keys []string, keyInfo map[string]interface{}) *Response {
+	resp := ListResponse(keys)
+
+	keyInfoData := make(map[string]interface{})
+	for _, key := range keys {
+		if val, ok := keyInfo[key]; ok {
+			keyInfoData[key] = val
+		}
+	}
+
+	if len(keyInfoData) > 0 {
+		resp.Data["key_info"] = keyInfoData
+	}
+
+	return resp
+}
@@ -68,7 +68,9 @@ func RespondErrorCommon(req *Request, resp *Response, err error) (int, error) {
 
 	// Now, check the error itself; if it has a specific logical error, set the
 	// appropriate code
-	if err != nil {
+	if code, ok := codedErrorStatus(err); ok {
+		statusCode = code
+	} else if err != nil {
 		switch {
 		case errwrap.ContainsType(err, new(StatusBadRequest)):
 			statusCode = http.StatusBadRequest
@@ -105,7 +107,24 @@ func AdjustErrorStatusCode(status *int, err error) {
 	}
 
 	// Allow HTTPCoded error passthrough to specify a code
-	if t, ok := err.(HTTPCodedError); ok {
-		*status = t.Code()
+	if code, ok := codedErrorStatus(err); ok {
+		*status = code
 	}
 }
+
+// codedErrorStatus returns the code of the first HTTPCodedError found in err,
+// which may have been wrapped on its way up, for instance by the core. Codes
+// which are not HTTP error statuses are reported as internal server errors.
+func codedErrorStatus(err error) (int, bool) {
+	var code int
+	var found bool
+	errwrap.Walk(err, func(inErr error) {
+		if t, ok := inErr.(HTTPCodedError); ok && !found {
+			code, found = t.Code(), true
+		}
+	})
+	if found && (code < 400 || code > 599) {
+		code = http.StatusInternalServerError
+	}
+	return code, found
+}
@@ -0,0 +1,34 @@
+package logical
+
+import (
+	"errors"
+	"testing"
+
+	multierror "github.com/hashicorp/go-multierror"
+)
+
+func TestRespondErrorCommon_codedError(t *testing.T) {
+	req := &Request{Operation: UpdateOperation}
+	cases := map[string]struct {
+		Err      error
+		Expected int
+	}{
+		"coded":         {CodedError(404, "role not found"), 404},
+		"wrapped":       {multierror.Append(nil, CodedError(503, "unreachable")), 503},
+		"invalid code":  {CodedError(200, "not an error status"), 500},
+		"not coded":     {errors.New("failure"), 500},
+		"denied":        {multierror.Append(nil, ErrPermissionDenied), 403},
+		"wrapped first": {multierror.Append(CodedError(429, "rate limited"), CodedError(404, "not found")), 429},
+	}
+
+	for name, tc := range cases {
+		status, err := RespondErrorCommon(req, nil, tc.Err)
+		if err == nil {
+			t.Fatalf("bad: %s: no error", name)
+		}
+		AdjustErrorStatusCode(&status, err)
+		if status != tc.Expected {
+			t.Fatalf("bad: %s: expected: %d, got: %d", name, tc.Expected, status)
+		}
+	}
+}
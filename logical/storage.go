@@ -15,6 +15,10 @@ var ErrReadOnly = errors.New("Cannot write to readonly storage")
 
 // Storage is the way that logical backends are able read/write data.
 type Storage interface {
+	// List returns the keys directly under the prefix, relative to it and
+	// in lexicographic order. Keys further down are collapsed into one
+	// entry for the next level, ending with a slash, such as "bar/" for
+	// "foo/bar/baz" listed with the prefix "foo/".
 	List(prefix string) ([]string, error)
 	Get(string) (*StorageEntry, error)
 	Put(*StorageEntry) error
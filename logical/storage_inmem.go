@@ -1,6 +1,7 @@
 package logical
 
 import (
+	"sort"
 	"strings"
 	"sync"
 
@@ -80,6 +81,7 @@ func (s *InmemStorage) List(prefix string) ([]string, error) {
 		return false
 	}
 	s.root.WalkPrefix(prefix, walkFn)
+	sort.Strings(out)
 
 	return out, nil
 
@@ -62,6 +62,34 @@ func TestStorage(t *testing.T, s Storage) {
 	if len(keys) > 0 {
 		t.Fatalf("should have no keys to start: %#v", keys)
 	}
+
+	// Keys are listed in order, with the keys further down collapsed into
+	// a folder
+	nested := []string{"dir/zeta", "dir/sub/b", "dir/alpha", "dir/sub/a", "dir/sub0", "dir-x", "other"}
+	for _, key := range nested {
+		if err := s.Put(&StorageEntry{Key: key, Value: []byte("bar")}); err != nil {
+			t.Fatalf("put error: %s", err)
+		}
+	}
+	listCases := map[string][]string{
+		"":         []string{"dir-x", "dir/", "other"},
+		"dir/":     []string{"alpha", "sub/", "sub0", "zeta"},
+		"dir/sub/": []string{"a", "b"},
+	}
+	for prefix, expected := range listCases {
+		keys, err = s.List(prefix)
+		if err != nil {
+			t.Fatalf("list error: %s", err)
+		}
+		if !reflect.DeepEqual(keys, expected) {
+			t.Fatalf("bad keys for prefix %q: expected: %#v, got: %#v", prefix, expected, keys)
+		}
+	}
+	for _, key := range nested {
+		if err := s.Delete(key); err != nil {
+			t.Fatalf("delete error: %s", err)
+		}
+	}
 }
 
 func TestSystemView() *StaticSystemView {
@@ -318,6 +318,9 @@ func Test(tt TestT, c TestCase) {
 	if err == nil && resp.IsError() {
 		err = fmt.Errorf("Erroneous response:\n\n%#v", resp)
 	}
+	if err == nil && resp != nil && resp.Data["errors"] != nil {
+		err = fmt.Errorf("Failed rollbacks:\n\n%v", resp.Data["errors"])
+	}
 	if err != nil {
 		if !errwrap.Contains(err, logical.ErrUnsupportedOperation.Error()) {
 			tt.Error(fmt.Sprintf("[ERR] Rollback error: %s", err))
@@ -19,17 +19,62 @@ type ACL struct {
 	// globRules contains the path policies that glob
 	globRules *radix.Tree
 
+	// segmentRules contains the path policies with "*" segments, keyed by
+	// their pattern including any trailing glob
+	segmentRules *radix.Tree
+
+	// segmentPatterns holds the parsed patterns of segmentRules
+	segmentPatterns []*segmentPattern
+
+	// denyACLs holds an ACL of each attached policy with a deny rule, so
+	// that its denials can be checked against grants of the other policies
+	denyACLs []*ACL
+
 	// root is enabled if the "root" named policy is present.
 	root bool
 }
 
 // New is used to construct a policy based ACL from a set of policies.
 func NewACL(policies []*Policy) (*ACL, error) {
+	a, err := newACL(policies)
+	if err != nil {
+		return nil, err
+	}
+	if len(policies) < 2 {
+		return a, nil
+	}
+
+	for _, policy := range policies {
+		if policy == nil || !policyDenies(policy) {
+			continue
+		}
+		policyACL, err := newACL([]*Policy{policy})
+		if err != nil {
+			return nil, err
+		}
+		a.denyACLs = append(a.denyACLs, policyACL)
+	}
+	return a, nil
+}
+
+// policyDenies returns whether any path of the policy is denied.
+func policyDenies(policy *Policy) bool {
+	for _, pc := range policy.Paths {
+		if pc.Permissions.CapabilitiesBitmap&DenyCapabilityInt > 0 {
+			return true
+		}
+	}
+	return false
+}
+
+// newACL merges the rules of the policies into the trees of an ACL.
+func newACL(policies []*Policy) (*ACL, error) {
 	// Initialize
 	a := &ACL{
-		exactRules: radix.New(),
-		globRules:  radix.New(),
-		root:       false,
+		exactRules:   radix.New(),
+		globRules:    radix.New(),
+		segmentRules: radix.New(),
+		root:         false,
 	}
 
 	// Inject each policy
@@ -45,18 +90,25 @@ func NewACL(policies []*Policy) (*ACL, error) {
 		for _, pc := range policy.Paths {
 			// Check which tree to use
 			tree := a.exactRules
-			if pc.Glob {
+			key := pc.Prefix
+			switch {
+			case pc.SegmentWildcards:
+				tree = a.segmentRules
+				if pc.Glob {
+					key += "*"
+				}
+			case pc.Glob:
 				tree = a.globRules
 			}
 
 			// Check for an existing policy
-			raw, ok := tree.Get(pc.Prefix)
+			raw, ok := tree.Get(key)
 			if !ok {
 				clonedPerms, err := pc.Permissions.Clone()
 				if err != nil {
 					return nil, errwrap.Wrapf("error cloning ACL permissions: {{err}}", err)
 				}
-				tree.Insert(pc.Prefix, clonedPerms)
+				tree.Insert(key, clonedPerms)
 				continue
 			}
 
@@ -141,39 +193,202 @@ func NewACL(policies []*Policy) (*ACL, error) {
 			}
 
 		INSERT:
-			tree.Insert(pc.Prefix, existingPerms)
+			tree.Insert(key, existingPerms)
 
 		}
 	}
+
+	a.segmentRules.Walk(func(pattern string, raw interface{}) bool {
+		a.segmentPatterns = append(a.segmentPatterns, newSegmentPattern(pattern, raw.(*Permissions)))
+		return false
+	})
 	return a, nil
 }
 
+// permissions returns the permissions of the rule governing the path, or nil
+// if no rule matches it. The rule is chosen in this order of precedence:
+//
+//  1. A rule for exactly the path wins over any pattern.
+//  2. Otherwise the pattern matching the longest part of the path wins. A
+//     glob matches the path up to its trailing "*" and any other pattern
+//     matches all of it, so "secret/*/ci/admin*" wins over "secret/*/ci/*".
+//  3. Among patterns matching equally much, the one whose first wildcard
+//     comes later wins, so "secret/a/b*" wins over "secret/*/b".
+//  4. Then the pattern with fewer wildcards, and finally the pattern which
+//     sorts first.
+//
+// Rules for the same pattern in several policies have been merged into one
+// by NewACL: their capabilities are combined, except that "deny" in any of
+// them replaces all others, sudo included.
+//
+// Finally, a path denied by the rule governing it in any one attached policy
+// is denied, whatever the rule chosen above grants. Within a policy a longer
+// match may still carve a grant out of a denied pattern, but a policy cannot
+// grant what another one denies.
+func (a *ACL) permissions(path string) *Permissions {
+	perm := a.rulePermissions(path)
+	if perm != nil && perm.CapabilitiesBitmap&DenyCapabilityInt > 0 {
+		return perm
+	}
+	for _, policyACL := range a.denyACLs {
+		denied := policyACL.rulePermissions(path)
+		if denied != nil && denied.CapabilitiesBitmap&DenyCapabilityInt > 0 {
+			return denied
+		}
+	}
+	return perm
+}
+
+// rulePermissions returns the permissions of the merged rule governing the
+// path, or nil if no rule matches it.
+func (a *ACL) rulePermissions(path string) *Permissions {
+	if raw, ok := a.exactRules.Get(path); ok {
+		return raw.(*Permissions)
+	}
+
+	var best *patternMatch
+	if prefix, raw, ok := a.globRules.LongestPrefix(path); ok {
+		best = &patternMatch{
+			pattern:       prefix + "*",
+			length:        len(prefix),
+			firstWildcard: len(prefix),
+			wildcards:     1,
+			permissions:   raw.(*Permissions),
+		}
+	}
+	for _, sp := range a.segmentPatterns {
+		length := sp.match(path)
+		if length < 0 {
+			continue
+		}
+		m := &patternMatch{
+			pattern:       sp.pattern,
+			length:        length,
+			firstWildcard: sp.firstWildcard,
+			wildcards:     sp.wildcards,
+			permissions:   sp.permissions,
+		}
+		if best == nil || m.beats(best) {
+			best = m
+		}
+	}
+
+	if best == nil {
+		return nil
+	}
+	return best.permissions
+}
+
+// patternMatch is a pattern rule matching a path.
+type patternMatch struct {
+	pattern       string
+	length        int
+	firstWildcard int
+	wildcards     int
+	permissions   *Permissions
+}
+
+// beats returns whether the match takes precedence over the other one.
+func (m *patternMatch) beats(other *patternMatch) bool {
+	switch {
+	case m.length != other.length:
+		return m.length > other.length
+	case m.firstWildcard != other.firstWildcard:
+		return m.firstWildcard > other.firstWildcard
+	case m.wildcards != other.wildcards:
+		return m.wildcards < other.wildcards
+	}
+	return m.pattern < other.pattern
+}
+
+// segmentPattern is a path pattern in which "*" segments match any single
+// segment of a path. A trailing "*" is a glob, as in other patterns.
+type segmentPattern struct {
+	pattern       string
+	segments      []string
+	glob          bool
+	firstWildcard int
+	wildcards     int
+	permissions   *Permissions
+}
+
+func newSegmentPattern(pattern string, permissions *Permissions) *segmentPattern {
+	sp := &segmentPattern{
+		pattern:       pattern,
+		firstWildcard: -1,
+		permissions:   permissions,
+	}
+	// Policies always parse a trailing "*" as a glob
+	prefix := pattern
+	if strings.HasSuffix(prefix, "*") {
+		prefix = strings.TrimSuffix(prefix, "*")
+		sp.glob = true
+	}
+	sp.segments = strings.Split(prefix, "/")
+	if sp.glob {
+		sp.wildcards++
+		sp.firstWildcard = len(prefix)
+	}
+
+	offset := 0
+	for _, segment := range sp.segments {
+		if segment == "*" {
+			sp.wildcards++
+			if sp.firstWildcard < 0 || offset < sp.firstWildcard {
+				sp.firstWildcard = offset
+			}
+		}
+		offset += len(segment) + 1
+	}
+	return sp
+}
+
+// match returns the length of the part of the path matched by the pattern,
+// or -1 if it does not match.
+func (sp *segmentPattern) match(path string) int {
+	n := len(sp.segments)
+	var parts []string
+	if sp.glob {
+		parts = strings.SplitN(path, "/", n)
+	} else {
+		parts = strings.Split(path, "/")
+	}
+	if len(parts) != n {
+		return -1
+	}
+
+	length := 0
+	for i, segment := range sp.segments {
+		if sp.glob && i == n-1 {
+			switch {
+			case segment == "*":
+				return length
+			case !strings.HasPrefix(parts[i], segment):
+				return -1
+			}
+			return length + len(segment)
+		}
+		if segment != "*" && segment != parts[i] {
+			return -1
+		}
+		length += len(parts[i]) + 1
+	}
+	return len(path)
+}
+
 func (a *ACL) Capabilities(path string) (pathCapabilities []string) {
 	// Fast-path root
 	if a.root {
 		return []string{RootCapability}
 	}
 
-	// Find an exact matching rule, look for glob if no match
-	var capabilities uint32
-	raw, ok := a.exactRules.Get(path)
-
-	if ok {
-		perm := raw.(*Permissions)
-		capabilities = perm.CapabilitiesBitmap
-		goto CHECK
-	}
-
-	// Find a glob rule, default deny if no match
-	_, raw, ok = a.globRules.LongestPrefix(path)
-	if !ok {
+	// Find the governing rule, default deny if no match
+	perm := a.permissions(path)
+	if perm == nil {
 		return []string{DenyCapability}
-	} else {
-		perm := raw.(*Permissions)
-		capabilities = perm.CapabilitiesBitmap
 	}
+	capabilities := perm.CapabilitiesBitmap
 
-CHECK:
 	if capabilities&SudoCapabilityInt > 0 {
 		pathCapabilities = append(pathCapabilities, SudoCapability)
 	}
@@ -217,27 +432,13 @@ func (a *ACL) AllowOperation(req *logical.Request) (bool, bool) {
 		return true, false
 	}
 
-	var permissions *Permissions
-
-	// Find an exact matching rule, look for glob if no match
-	var capabilities uint32
-	raw, ok := a.exactRules.Get(path)
-	if ok {
-		permissions = raw.(*Permissions)
-		capabilities = permissions.CapabilitiesBitmap
-		goto CHECK
-	}
-
-	// Find a glob rule, default deny if no match
-	_, raw, ok = a.globRules.LongestPrefix(path)
-	if !ok {
+	// Find the governing rule, default deny if no match
+	permissions := a.permissions(path)
+	if permissions == nil {
 		return false, false
-	} else {
-		permissions = raw.(*Permissions)
-		capabilities = permissions.CapabilitiesBitmap
 	}
+	capabilities := permissions.CapabilitiesBitmap
 
-CHECK:
 	// Check if the minimum permissions are met
 	// If "deny" has been explicitly set, only deny will be in the map, so we
 	// only need to check for the existence of other values
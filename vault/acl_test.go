@@ -194,7 +194,9 @@ func testLayeredACL(t *testing.T, acl *ACL) {
 		{logical.ReadOperation, "prod/aws/foo", false, false},
 
 		{logical.ReadOperation, "sys/status", false, false},
-		{logical.UpdateOperation, "sys/seal", true, true},
+		// The sudo on "sys/seal" in one policy loses to the deny on "sys/*"
+		// in the other
+		{logical.UpdateOperation, "sys/seal", false, false},
 
 		{logical.ReadOperation, "foo/bar", false, false},
 		{logical.ListOperation, "foo/bar", false, false},
@@ -445,6 +447,142 @@ func TestACL_CreationRace(t *testing.T) {
 	wg.Wait()
 }
 
+func TestACL_SegmentWildcards(t *testing.T) {
+	policy1, err := Parse(segmentPolicy)
+	if err != nil {
+		t.Fatalf("err: %v", err)
+	}
+	policy2, err := Parse(segmentPolicy2)
+	if err != nil {
+		t.Fatalf("err: %v", err)
+	}
+	acl, err := NewACL([]*Policy{policy1, policy2})
+	if err != nil {
+		t.Fatalf("err: %v", err)
+	}
+
+	type tcase struct {
+		path         string
+		capabilities []string
+	}
+	tcases := []tcase{
+		// A "*" segment matches exactly one segment
+		{"secret/teams/a/ci/token", []string{"read", "list"}},
+		{"secret/teams/b/ci/deploy/key", []string{"read", "list"}},
+		{"secret/teams/a/b/ci/token", []string{"deny"}},
+		{"secret/teams/ci/token", []string{"deny"}},
+
+		// The longest match wins, and deny wins over grants
+		{"secret/teams/a/ci/admin", []string{"deny"}},
+		{"secret/teams/a/ci/admin/root", []string{"deny"}},
+		{"secret/teams/a/ci/administrators", []string{"deny"}},
+
+		// Without a glob the pattern matches whole paths only
+		{"secret/teams/a/config", []string{"read"}},
+		{"secret/teams/a/config/b", []string{"deny"}},
+
+		// An exact path wins over any pattern
+		{"secret/teams/ops/ci/admin", []string{"read"}},
+
+		// A pattern matching more of the path wins over a prefix glob
+		{"secret/teams/ops/ci/token", []string{"read", "list"}},
+		{"secret/teams/ops/other", []string{"sudo", "read", "update"}},
+		{"secret/shared/a/ci/token", []string{"read", "list"}},
+		{"secret/shared/b", []string{"update"}},
+
+		// Among equal matches the one whose first wildcard comes later wins
+		{"secret/tie/a/b", []string{"update"}},
+		{"secret/tie/c/b", []string{"read"}},
+
+		// Deny on a pattern in one policy wins over sudo in another
+		{"secret/apps/a/prod", []string{"deny"}},
+		{"secret/apps/a/dev", []string{"sudo", "read"}},
+
+		// Capabilities of the same pattern in several policies merge
+		{"secret/apps/a/stage", []string{"read", "update"}},
+	}
+
+	for _, tc := range tcases {
+		actual := acl.Capabilities(tc.path)
+		if !reflect.DeepEqual(actual, tc.capabilities) {
+			t.Fatalf("bad: path: %s\ngot\n%#v\nexpected\n%#v\n", tc.path, actual, tc.capabilities)
+		}
+	}
+
+	// Operations are checked against the same rule
+	request := &logical.Request{
+		Operation: logical.ReadOperation,
+		Path:      "secret/apps/a/prod",
+	}
+	if allowed, rootPrivs := acl.AllowOperation(request); allowed || rootPrivs {
+		t.Fatalf("bad: %v, %v", allowed, rootPrivs)
+	}
+	request.Path = "secret/apps/a/dev"
+	if allowed, rootPrivs := acl.AllowOperation(request); !allowed || !rootPrivs {
+		t.Fatalf("bad: %v, %v", allowed, rootPrivs)
+	}
+}
+
+func TestACL_DenyAcrossPolicies(t *testing.T) {
+	policy1, err := Parse(denyPolicy)
+	if err != nil {
+		t.Fatalf("err: %v", err)
+	}
+	policy2, err := Parse(denyPolicy2)
+	if err != nil {
+		t.Fatalf("err: %v", err)
+	}
+	acl, err := NewACL([]*Policy{policy1, policy2})
+	if err != nil {
+		t.Fatalf("err: %v", err)
+	}
+
+	type tcase struct {
+		path         string
+		capabilities []string
+	}
+	tcases := []tcase{
+		// A deny in one policy wins over longer matches in another
+		{"secret/foo", []string{"deny"}},
+		{"secret/foo/bar", []string{"deny"}},
+		{"secret/teams/a/ci", []string{"deny"}},
+
+		// A longer match carves a grant out of a deny in the same policy
+		{"secret/public/key", []string{"read"}},
+
+		// Unless another policy denies it
+		{"secret/public/private", []string{"deny"}},
+
+		// Paths outside of the denied ones keep their grants
+		{"other/foo", []string{"sudo", "update"}},
+		{"other/bar", []string{"read"}},
+	}
+
+	for _, tc := range tcases {
+		actual := acl.Capabilities(tc.path)
+		if !reflect.DeepEqual(actual, tc.capabilities) {
+			t.Fatalf("bad: path: %s\ngot\n%#v\nexpected\n%#v\n", tc.path, actual, tc.capabilities)
+		}
+	}
+
+	// The policy order does not matter
+	acl, err = NewACL([]*Policy{policy2, policy1})
+	if err != nil {
+		t.Fatalf("err: %v", err)
+	}
+	request := &logical.Request{
+		Operation: logical.ReadOperation,
+		Path:      "secret/foo",
+	}
+	if allowed, rootPrivs := acl.AllowOperation(request); allowed || rootPrivs {
+		t.Fatalf("bad: %v, %v", allowed, rootPrivs)
+	}
+	request.Path = "secret/public/key"
+	if allowed, rootPrivs := acl.AllowOperation(request); !allowed || rootPrivs {
+		t.Fatalf("bad: %v, %v", allowed, rootPrivs)
+	}
+}
+
 var tokenCreationPolicy = `
 name = "tokenCreation"
 path "auth/token/create*" {
@@ -502,6 +640,56 @@ path "foo/bar" {
 }
 `
 
+var segmentPolicy = `
+name = "teams"
+path "secret/teams/*/ci/*" {
+	capabilities = ["read", "list"]
+}
+path "secret/teams/*/ci/admin*" {
+	capabilities = ["deny"]
+}
+path "secret/teams/*/config" {
+	capabilities = ["read"]
+}
+path "secret/teams/ops/ci/admin" {
+	capabilities = ["read"]
+}
+path "secret/teams/ops/*" {
+	capabilities = ["read", "update", "sudo"]
+}
+path "secret/shared/*" {
+	capabilities = ["update"]
+}
+path "secret/tie/a/b*" {
+	capabilities = ["update"]
+}
+path "secret/tie/*/b" {
+	capabilities = ["read"]
+}
+path "secret/*/*/ci/*" {
+	capabilities = ["read", "list"]
+}
+path "secret/apps/*/prod" {
+	capabilities = ["read", "sudo"]
+}
+path "secret/apps/*/dev" {
+	capabilities = ["read", "sudo"]
+}
+path "secret/apps/*/stage" {
+	capabilities = ["read"]
+}
+`
+
+var segmentPolicy2 = `
+name = "apps"
+path "secret/apps/*/prod" {
+	capabilities = ["deny"]
+}
+path "secret/apps/*/stage" {
+	capabilities = ["update"]
+}
+`
+
 //test merging
 var mergingPolicies = `
 name = "ops"
@@ -763,3 +951,35 @@ path "test/star" {
 	}
 }
 `
+
+var denyPolicy = `
+name = "deny"
+path "secret/*" {
+	capabilities = ["deny"]
+}
+path "secret/public/*" {
+	capabilities = ["read"]
+}
+path "other/*" {
+	capabilities = ["read"]
+}
+`
+
+var denyPolicy2 = `
+name = "grant"
+path "secret/foo" {
+	capabilities = ["read", "update", "sudo"]
+}
+path "secret/foo/*" {
+	capabilities = ["read"]
+}
+path "secret/teams/*/ci" {
+	capabilities = ["read"]
+}
+path "secret/public/private" {
+	capabilities = ["deny"]
+}
+path "other/foo" {
+	capabilities = ["update", "sudo"]
+}
+`
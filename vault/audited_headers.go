@@ -131,16 +131,27 @@ func (c *Core) setupAuditedHeadersConfig() error {
 	view := c.systemBarrierView.SubView(auditedHeadersSubPath)
 
 	// Create the config
+	config, err := loadAuditedHeadersConfig(view)
+	if err != nil {
+		return err
+	}
+
+	c.auditedHeaders = config
+	return nil
+}
+
+// loadAuditedHeadersConfig loads the headers config stored in the view
+func loadAuditedHeadersConfig(view *BarrierView) (*AuditedHeadersConfig, error) {
 	out, err := view.Get(auditedHeadersEntry)
 	if err != nil {
-		return fmt.Errorf("failed to read config: %v", err)
+		return nil, fmt.Errorf("failed to read config: %v", err)
 	}
 
 	headers := make(map[string]*auditedHeaderSettings)
 	if out != nil {
 		err = out.DecodeJSON(&headers)
 		if err != nil {
-			return err
+			return nil, err
 		}
 	}
 
@@ -151,10 +162,8 @@ func (c *Core) setupAuditedHeadersConfig() error {
 		lowerHeaders[strings.ToLower(k)] = v
 	}
 
-	c.auditedHeaders = &AuditedHeadersConfig{
+	return &AuditedHeadersConfig{
 		Headers: lowerHeaders,
 		view:    view,
-	}
-
-	return nil
+	}, nil
 }
@@ -2,6 +2,7 @@ package vault
 
 import (
 	"errors"
+	"sort"
 	"strings"
 
 	"github.com/hashicorp/vault/logical"
@@ -46,7 +47,13 @@ func (v *BarrierView) List(prefix string) ([]string, error) {
 	if err := v.sanityCheck(prefix); err != nil {
 		return nil, err
 	}
-	return v.barrier.List(v.expandKey(prefix))
+	// Not every physical backend lists keys in order
+	keys, err := v.barrier.List(v.expandKey(prefix))
+	if err != nil {
+		return nil, err
+	}
+	sort.Strings(keys)
+	return keys, nil
 }
 
 // logical.Storage impl.
@@ -18,6 +18,24 @@ func TestBarrierView_spec(t *testing.T) {
 	logical.TestStorage(t, view)
 }
 
+// unorderedBarrier lists keys in reverse order, like physical backends which
+// do not order them.
+type unorderedBarrier struct {
+	BarrierStorage
+}
+
+func (b *unorderedBarrier) List(prefix string) ([]string, error) {
+	keys, err := b.BarrierStorage.List(prefix)
+	sort.Sort(sort.Reverse(sort.StringSlice(keys)))
+	return keys, err
+}
+
+func TestBarrierView_listOrder(t *testing.T) {
+	_, barrier, _ := mockBarrier(t)
+	view := NewBarrierView(&unorderedBarrier{barrier}, "foo/")
+	logical.TestStorage(t, view)
+}
+
 func TestBarrierView_BadKeysKeys(t *testing.T) {
 	_, barrier, _ := mockBarrier(t)
 	view := NewBarrierView(barrier, "foo/")
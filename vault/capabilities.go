@@ -2,12 +2,19 @@ package vault
 
 import (
 	"sort"
+	"strings"
 
+	"github.com/hashicorp/vault/helper/strutil"
 	"github.com/hashicorp/vault/logical"
 )
 
-// Capabilities is used to fetch the capabilities of the given token on the given path
+// Capabilities is used to fetch the capabilities of the given token on the given path.
+// They are computed from the policies of the token whether or not the path is
+// currently routed, but on root protected paths, such as under sys/audit,
+// nothing is permitted without sudo. Root tokens are permitted everything.
 func (c *Core) Capabilities(token, path string) ([]string, error) {
+	// Policy paths are relative to the API path, as are request paths
+	path = strings.TrimPrefix(path, "/")
 	if path == "" {
 		return nil, &logical.StatusBadRequest{Err: "missing path"}
 	}
@@ -47,6 +54,11 @@ func (c *Core) Capabilities(token, path string) ([]string, error) {
 	}
 
 	capabilities := acl.Capabilities(path)
+	if c.router.RootPath(path) &&
+		!strutil.StrListContains(capabilities, SudoCapability) &&
+		!strutil.StrListContains(capabilities, RootCapability) {
+		return []string{DenyCapability}, nil
+	}
 	sort.Strings(capabilities)
 	return capabilities, nil
 }
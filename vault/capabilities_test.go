@@ -43,3 +43,72 @@ func TestCapabilities(t *testing.T) {
 		t.Fatalf("bad: got\n%#v\nexpected\n%#v\n", actual, expected)
 	}
 }
+
+func TestCapabilities_rootPaths(t *testing.T) {
+	c, _, root := TestCoreUnsealed(t)
+
+	policy, _ := Parse(rootPathsPolicy)
+	if err := c.policyStore.SetPolicy(policy); err != nil {
+		t.Fatalf("err: %v", err)
+	}
+	ent := &TokenEntry{
+		ID:       "capabilitiestoken",
+		Path:     "testpath",
+		Policies: []string{"rootpaths"},
+	}
+	if err := c.tokenStore.create(ent); err != nil {
+		t.Fatalf("err: %v", err)
+	}
+
+	cases := map[string][]string{
+		// Root protected paths require sudo
+		"sys/audit/file":       []string{"deny"},
+		"sys/rotate":           []string{"read", "sudo", "update"},
+		"sys/mounts/secret":    []string{"read", "update"},
+		"auth/token/accessors": []string{"deny"},
+
+		// Paths need not be routed, and may be given with a leading slash
+		"nonexistent/foo":  []string{"create", "read"},
+		"/nonexistent/foo": []string{"create", "read"},
+	}
+	for path, expected := range cases {
+		actual, err := c.Capabilities("capabilitiestoken", path)
+		if err != nil {
+			t.Fatalf("err: %s", err)
+		}
+		if !reflect.DeepEqual(actual, expected) {
+			t.Fatalf("bad: %s: got\n%#v\nexpected\n%#v\n", path, actual, expected)
+		}
+	}
+
+	// Root tokens are permitted everything on root protected paths as well
+	for _, path := range []string{"sys/audit/file", "auth/token/accessors"} {
+		actual, err := c.Capabilities(root, path)
+		if err != nil {
+			t.Fatalf("err: %s", err)
+		}
+		expected := []string{"root"}
+		if !reflect.DeepEqual(actual, expected) {
+			t.Fatalf("bad: %s: got\n%#v\nexpected\n%#v\n", path, actual, expected)
+		}
+	}
+}
+
+var rootPathsPolicy = `
+name = "rootpaths"
+path "sys/audit/*" {
+	capabilities = ["read", "update"]
+}
+path "sys/rotate" {
+	capabilities = ["read", "update", "sudo"]
+}
+path "sys/mounts/*" {
+	capabilities = ["read", "update"]
+}
+path "auth/token/accessors" {
+	capabilities = ["list"]
+}
+path "nonexistent/*" {
+	capabilities = ["create", "read"]
+}
+`
@@ -189,6 +189,12 @@ type Core struct {
 	standbyStopCh    chan struct{}
 	manualStepDownCh chan struct{}
 
+	// standbyReads is what a standby sets up to serve the requests declared
+	// safe on a standby itself. It is built on the first such request and
+	// torn down when the standby becomes active.
+	standbyReads     *standbyReads
+	standbyReadsLock sync.RWMutex
+
 	// unlockInfo has the keys provided to Unseal until the threshold number of parts is available, as well as the operation nonce
 	unlockInfo *unlockInformation
 
@@ -676,7 +682,7 @@ func (c *Core) checkToken(req *logical.Request) (*logical.Auth, *TokenEntry, err
 		default:
 			c.logger.Error("core: failed to run existence check", "error", err)
 			if _, ok := err.(errutil.UserError); ok {
-				return nil, nil, err
+				return nil, nil, &logical.StatusBadRequest{Err: err.Error()}
 			} else {
 				return nil, nil, ErrInternalError
 			}
@@ -1467,6 +1473,7 @@ func stopReplicationImpl(c *Core) error {
 func (c *Core) runStandby(doneCh, stopCh, manualStepDownCh chan struct{}) {
 	defer close(doneCh)
 	defer close(manualStepDownCh)
+	defer c.teardownStandbyReads()
 	c.logger.Info("core: entering standby mode")
 
 	// Monitor for key rotation
@@ -1570,6 +1577,7 @@ func (c *Core) runStandby(doneCh, stopCh, manualStepDownCh chan struct{}) {
 		}
 
 		// Attempt the post-unseal process
+		c.teardownStandbyReads()
 		err = c.postUnseal()
 		if err == nil {
 			c.standby = false
@@ -2,6 +2,7 @@ package vault
 
 import (
 	"reflect"
+	"sync"
 	"testing"
 	"time"
 
@@ -976,6 +977,61 @@ func TestCore_LimitedUseToken(t *testing.T) {
 	}
 }
 
+func TestCore_LimitedUseToken_Concurrent(t *testing.T) {
+	c, _, root := TestCoreUnsealed(t)
+
+	req := logical.TestRequest(t, logical.UpdateOperation, "auth/token/create")
+	req.ClientToken = root
+	req.Data["num_uses"] = "2"
+	resp, err := c.HandleRequest(req)
+	if err != nil {
+		t.Fatalf("err: %v", err)
+	}
+	token := resp.Auth.ClientToken
+
+	// Race many requests on the two uses; only two may succeed and the rest
+	// must be denied rather than fail internally
+	var wg sync.WaitGroup
+	var lock sync.Mutex
+	var succeeded int
+	for i := 0; i < 20; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			_, err := c.HandleRequest(&logical.Request{
+				Operation: logical.UpdateOperation,
+				Path:      "secret/foo",
+				Data: map[string]interface{}{
+					"foo": "bar",
+				},
+				ClientToken: token,
+			})
+			lock.Lock()
+			defer lock.Unlock()
+			if err == nil {
+				succeeded++
+				return
+			}
+			if !errwrap.Contains(err, logical.ErrPermissionDenied.Error()) {
+				t.Errorf("err: %v", err)
+			}
+		}()
+	}
+	wg.Wait()
+
+	if succeeded != 2 {
+		t.Fatalf("bad: %d requests succeeded", succeeded)
+	}
+
+	te, err := c.tokenStore.Lookup(token)
+	if err != nil {
+		t.Fatalf("err: %v", err)
+	}
+	if te != nil {
+		t.Fatalf("token was not revoked: %#v", te)
+	}
+}
+
 func TestCore_Standby_Seal(t *testing.T) {
 	// Create the first core and initialize it
 	logger = logformat.NewVaultLogger(log.LevelTrace)
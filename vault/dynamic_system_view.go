@@ -26,6 +26,12 @@ func (d dynamicSystemView) MaxLeaseTTL() time.Duration {
 }
 
 func (d dynamicSystemView) SudoPrivilege(path string, token string) bool {
+	// Standbys have no token store, and leave such requests to the active
+	// node
+	if d.core.tokenStore == nil {
+		return false
+	}
+
 	// Resolve the token policy
 	te, err := d.core.tokenStore.Lookup(token)
 	if err != nil {
@@ -57,7 +63,8 @@ func (d dynamicSystemView) SudoPrivilege(path string, token string) bool {
 }
 
 // TTLsByPath returns the default and max TTLs corresponding to a particular
-// mount point, or the system default
+// mount point, or the system default. A system default TTL beyond the max TTL
+// of the mount is capped to the latter.
 func (d dynamicSystemView) fetchTTLs() (def, max time.Duration) {
 	def = d.core.defaultLeaseTTL
 	max = d.core.maxLeaseTTL
@@ -68,6 +75,9 @@ func (d dynamicSystemView) fetchTTLs() (def, max time.Duration) {
 	if d.mountEntry.Config.MaxLeaseTTL != 0 {
 		max = d.mountEntry.Config.MaxLeaseTTL
 	}
+	if def > max {
+		def = max
+	}
 
 	return
 }
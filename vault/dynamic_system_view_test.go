@@ -0,0 +1,38 @@
+package vault
+
+import (
+	"testing"
+	"time"
+)
+
+func TestDynamicSystemView_fetchTTLs(t *testing.T) {
+	c, _, _ := TestCoreUnsealed(t)
+	c.defaultLeaseTTL = 24 * time.Hour
+	c.maxLeaseTTL = 48 * time.Hour
+
+	cases := map[string]struct {
+		Default, Max                 time.Duration
+		ExpectedDefault, ExpectedMax time.Duration
+	}{
+		"system":       {0, 0, 24 * time.Hour, 48 * time.Hour},
+		"override":     {time.Hour, 2 * time.Hour, time.Hour, 2 * time.Hour},
+		"default only": {time.Hour, 0, time.Hour, 48 * time.Hour},
+		"max above":    {0, 72 * time.Hour, 24 * time.Hour, 72 * time.Hour},
+		"max below":    {0, 15 * time.Minute, 15 * time.Minute, 15 * time.Minute},
+	}
+
+	for name, tc := range cases {
+		d := dynamicSystemView{
+			core: c,
+			mountEntry: &MountEntry{
+				Config: MountConfig{
+					DefaultLeaseTTL: tc.Default,
+					MaxLeaseTTL:     tc.Max,
+				},
+			},
+		}
+		if def, max := d.DefaultLeaseTTL(), d.MaxLeaseTTL(); def != tc.ExpectedDefault || max != tc.ExpectedMax {
+			t.Fatalf("bad: %s: expected: %s %s, got: %s %s", name, tc.ExpectedDefault, tc.ExpectedMax, def, max)
+		}
+	}
+}
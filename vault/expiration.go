@@ -41,6 +41,10 @@ const (
 	// minRevokeDelay is used to prevent an instant revoke on restore
 	minRevokeDelay = 5 * time.Second
 
+	// revokePrefixBatchSize is the most leases of a listing revoked before
+	// listing them again when revoking a prefix
+	revokePrefixBatchSize = 1000
+
 	// maxLeaseDuration is the default maximum lease duration
 	maxLeaseTTL = 32 * 24 * time.Hour
 
@@ -396,7 +400,7 @@ func (m *ExpirationManager) revokeCommon(leaseID string, force, skipToken bool)
 				return err
 			} else {
 				if m.logger.IsWarn() {
-					m.logger.Warn("revocation from the backend failed, but in force mode so ignoring", "error", err)
+					m.logger.Warn("expire: revocation from the backend failed, but in force mode so ignoring", "lease_id", leaseID, "error", err)
 				}
 			}
 		}
@@ -441,6 +445,109 @@ func (m *ExpirationManager) RevokePrefix(prefix string) error {
 	return m.revokePrefixCommon(prefix, false)
 }
 
+// MovePrefix is used to move all secrets with a given prefix to another, as
+// when the mount they were issued by is moved. Their lease IDs and paths
+// keep the part following the prefix, so "ssh/creds/web/<uuid>" moved to
+// "infra/ssh/" becomes "infra/ssh/creds/web/<uuid>".
+func (m *ExpirationManager) MovePrefix(src, dst string) error {
+	defer metrics.MeasureSince([]string{"expire", "move-prefix"}, time.Now())
+
+	// Ensure there are trailing slashes
+	if !strings.HasSuffix(src, "/") {
+		src = src + "/"
+	}
+	if !strings.HasSuffix(dst, "/") {
+		dst = dst + "/"
+	}
+
+	// Accumulate existing leases
+	sub := m.idView.SubView(src)
+	existing, err := logical.CollectKeys(sub)
+	if err != nil {
+		return fmt.Errorf("failed to scan for leases: %v", err)
+	}
+
+	// Move all the keys, moving them back should any fail
+	if idx, err := m.moveEntries(existing, src, dst); err != nil {
+		err = fmt.Errorf("failed to move '%s' (%d / %d): %v",
+			src+existing[idx], idx+1, len(existing), err)
+
+		// The failed lease may have been moved in part, so it is moved back
+		// as well
+		if _, undoErr := m.moveEntries(existing[:idx+1], dst, src); undoErr != nil {
+			return multierror.Append(err, fmt.Errorf("failed to move leases back: %v", undoErr))
+		}
+		return err
+	}
+	return nil
+}
+
+// moveEntries is used to move the leases with the given suffixes from one
+// prefix to another. On failure it returns the index of the failed lease.
+func (m *ExpirationManager) moveEntries(suffixes []string, src, dst string) (int, error) {
+	for idx, suffix := range suffixes {
+		if err := m.moveEntry(src+suffix, src, dst); err != nil {
+			return idx, err
+		}
+	}
+	return 0, nil
+}
+
+// moveEntry is used to move a lease from one prefix to another. Moving back a
+// lease whose move failed part way restores it.
+func (m *ExpirationManager) moveEntry(leaseID, src, dst string) error {
+	// Hold the pending lock throughout, so that the lease cannot expire
+	// between being persisted under its new ID and its timer being moved
+	m.pendingLock.Lock()
+	defer m.pendingLock.Unlock()
+
+	le, err := m.loadEntry(leaseID)
+	if err != nil {
+		return err
+	}
+	if le == nil {
+		return nil
+	}
+
+	// Persist the entry under its new ID before removing the old one
+	le.LeaseID = dst + strings.TrimPrefix(leaseID, src)
+	le.Path = dst + strings.TrimPrefix(le.Path, src)
+	if le.Secret != nil {
+		le.Secret.LeaseID = le.LeaseID
+	}
+	if err := m.persistEntry(le); err != nil {
+		return err
+	}
+	if le.Secret != nil {
+		if err := m.createIndexByToken(le.ClientToken, le.LeaseID); err != nil {
+			return err
+		}
+		if err := m.removeIndexByToken(le.ClientToken, leaseID); err != nil {
+			return err
+		}
+	}
+	if err := m.deleteEntry(leaseID); err != nil {
+		return err
+	}
+
+	// Move the expiration handler
+	timer, ok := m.pending[leaseID]
+	if !ok {
+		return nil
+	}
+	timer.Stop()
+	delete(m.pending, leaseID)
+
+	remaining := le.ExpireTime.Sub(time.Now())
+	if remaining < minRevokeDelay {
+		remaining = minRevokeDelay
+	}
+	m.pending[le.LeaseID] = time.AfterFunc(remaining, func() {
+		m.expireID(le.LeaseID)
+	})
+	return nil
+}
+
 // RevokeByToken is used to revoke all the secrets issued with a given token.
 // This is done by using the secondary index. It also removes the lease entry
 // for the token itself. As a result it should *ONLY* ever be called from the
@@ -487,22 +594,71 @@ func (m *ExpirationManager) revokePrefixCommon(prefix string, force bool) error
 		prefix = prefix + "/"
 	}
 
-	// Accumulate existing leases
+	// Revoke the leases as they are listed, one level of the prefix at a
+	// time, rather than collecting all of them first. Each level is revoked
+	// in batches, listing it again after each, so that no more than a batch
+	// of lease IDs is held while revoking.
 	sub := m.idView.SubView(prefix)
-	existing, err := logical.CollectKeys(sub)
+	revoked := 0
+	frontier := []string{""}
+	for len(frontier) > 0 {
+		n := len(frontier)
+		current := frontier[n-1]
+		frontier = frontier[:n-1]
+
+		for listed := false; ; listed = true {
+			batch, more, err := m.listPrefixBatch(sub, current, !listed, &frontier)
+			if err != nil {
+				return fmt.Errorf("failed to scan for leases: %v", err)
+			}
+
+			for _, c := range batch {
+				leaseID := prefix + current + c
+				if err := m.revokeCommon(leaseID, force, false); err != nil {
+					return fmt.Errorf("failed to revoke '%s' (%d revoked): %v",
+						leaseID, revoked, err)
+				}
+				revoked++
+				if revoked%1000 == 0 && m.logger.IsInfo() {
+					m.logger.Info("expire: revoking leases", "prefix", prefix, "progress", revoked)
+				}
+			}
+			if !more {
+				break
+			}
+		}
+	}
+
+	if m.logger.IsInfo() {
+		m.logger.Info("expire: revoked leases", "prefix", prefix, "count", revoked)
+	}
+	return nil
+}
+
+// listPrefixBatch returns up to revokePrefixBatchSize leases listed in the
+// view at the given level, and whether there are more. When addLevels is set
+// the sub-levels of the level are appended to the frontier.
+func (m *ExpirationManager) listPrefixBatch(view *BarrierView, level string, addLevels bool, frontier *[]string) ([]string, bool, error) {
+	contents, err := view.List(level)
 	if err != nil {
-		return fmt.Errorf("failed to scan for leases: %v", err)
+		return nil, false, err
 	}
 
-	// Revoke all the keys
-	for idx, suffix := range existing {
-		leaseID := prefix + suffix
-		if err := m.revokeCommon(leaseID, force, false); err != nil {
-			return fmt.Errorf("failed to revoke '%s' (%d / %d): %v",
-				leaseID, idx+1, len(existing), err)
+	var batch []string
+	more := false
+	for _, c := range contents {
+		switch {
+		case strings.HasSuffix(c, "/"):
+			if addLevels {
+				*frontier = append(*frontier, level+c)
+			}
+		case len(batch) < revokePrefixBatchSize:
+			batch = append(batch, c)
+		default:
+			more = true
 		}
 	}
-	return nil
+	return batch, more, nil
 }
 
 // Renew is used to renew a secret using the given leaseID
@@ -580,8 +736,16 @@ func (m *ExpirationManager) RenewToken(req *logical.Request, source string, toke
 		return nil, err
 	}
 
-	// Check if the lease is renewable. Note that this also checks for a nil
-	// lease and errors in that case as well.
+	// Tokens without a TTL, such as root tokens, have no lease to renew, and
+	// others may have been created non-renewable
+	switch {
+	case le == nil || le.ExpireTime.IsZero():
+		return logical.ErrorResponse("token has no TTL and cannot be renewed"), logical.ErrInvalidRequest
+	case le.Auth != nil && !le.Auth.Renewable:
+		return logical.ErrorResponse("token is not renewable"), logical.ErrInvalidRequest
+	}
+
+	// Check if the lease is renewable
 	if _, err := le.renewable(); err != nil {
 		return logical.ErrorResponse(err.Error()), logical.ErrInvalidRequest
 	}
@@ -496,7 +496,7 @@ func TestExpiration_RegisterAuth_NoLease(t *testing.T) {
 
 	// Should not be able to renew, no expiration
 	resp, err := exp.RenewToken(&logical.Request{}, "auth/github/login", root.ID, 0)
-	if err != nil && (err != logical.ErrInvalidRequest || (resp != nil && resp.IsError() && resp.Error().Error() != "lease not found or lease is not renewable")) {
+	if err != nil && (err != logical.ErrInvalidRequest || (resp != nil && resp.IsError() && resp.Error().Error() != "token has no TTL and cannot be renewed")) {
 		t.Fatalf("bad: err:%v resp:%#v", err, resp)
 	}
 	if resp == nil {
@@ -687,6 +687,143 @@ func TestExpiration_RevokePrefix(t *testing.T) {
 	}
 }
 
+func TestExpiration_RevokePrefix_large(t *testing.T) {
+	exp := mockExpiration(t)
+	noop := &NoopBackend{}
+	_, barrier, _ := mockBarrier(t)
+	view := NewBarrierView(barrier, "logical/")
+	meUUID, err := uuid.GenerateUUID()
+	if err != nil {
+		t.Fatal(err)
+	}
+	err = exp.router.Mount(noop, "prod/ssh/", &MountEntry{Path: "prod/ssh/", Type: "noop", UUID: meUUID, Accessor: "noop-accessor"}, view)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// Several batches of leases at a single level, next to a sub-level and
+	// a sibling which is not revoked
+	register := func(path string, count int) {
+		for i := 0; i < count; i++ {
+			req := &logical.Request{
+				Operation:   logical.ReadOperation,
+				Path:        path,
+				ClientToken: "foobar",
+			}
+			resp := &logical.Response{
+				Secret: &logical.Secret{
+					LeaseOptions: logical.LeaseOptions{
+						TTL: time.Hour,
+					},
+				},
+			}
+			if _, err := exp.Register(req, resp); err != nil {
+				t.Fatalf("err: %v", err)
+			}
+		}
+	}
+	count := 2*revokePrefixBatchSize + revokePrefixBatchSize/2
+	register("prod/ssh/creds/web", count)
+	register("prod/ssh/creds/web/admin", 3)
+	register("prod/ssh/creds/webby", 2)
+
+	if err := exp.RevokePrefix("prod/ssh/creds/web"); err != nil {
+		t.Fatalf("err: %v", err)
+	}
+
+	// Every lease is revoked once
+	if len(noop.Requests) != count+3 {
+		t.Fatalf("bad: %d", len(noop.Requests))
+	}
+	revoked := make(map[string]int)
+	for _, req := range noop.Requests {
+		if req.Operation != logical.RevokeOperation {
+			t.Fatalf("bad: %v", req)
+		}
+		revoked[req.Path]++
+	}
+	expected := map[string]int{
+		"creds/web":       count,
+		"creds/web/admin": 3,
+	}
+	if !reflect.DeepEqual(revoked, expected) {
+		t.Fatalf("bad: %v", revoked)
+	}
+
+	existing, err := logical.CollectKeys(exp.idView.SubView("prod/ssh/"))
+	if err != nil {
+		t.Fatalf("err: %v", err)
+	}
+	if len(existing) != 2 || !strings.HasPrefix(existing[0], "creds/webby/") {
+		t.Fatalf("bad: %v", existing)
+	}
+}
+
+func TestExpiration_RevokeForce_failingBackend(t *testing.T) {
+	exp := mockExpiration(t)
+	noop := &NoopBackend{}
+	_, barrier, _ := mockBarrier(t)
+	view := NewBarrierView(barrier, "logical/")
+	meUUID, err := uuid.GenerateUUID()
+	if err != nil {
+		t.Fatal(err)
+	}
+	err = exp.router.Mount(noop, "prod/ssh/", &MountEntry{Path: "prod/ssh/", Type: "noop", UUID: meUUID, Accessor: "noop-accessor"}, view)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// Enough leases over several levels to be revoked in batches
+	for i := 0; i < 1500; i++ {
+		req := &logical.Request{
+			Operation:   logical.ReadOperation,
+			Path:        fmt.Sprintf("prod/ssh/creds/web%d", i%3),
+			ClientToken: "foobar",
+		}
+		resp := &logical.Response{
+			Secret: &logical.Secret{
+				LeaseOptions: logical.LeaseOptions{
+					TTL: time.Hour,
+				},
+			},
+		}
+		if _, err := exp.Register(req, resp); err != nil {
+			t.Fatalf("err: %v", err)
+		}
+	}
+
+	// The backend fails to revoke, as when the target host is gone
+	noop.Response = logical.ErrorResponse("host unreachable")
+	if err := exp.RevokePrefix("prod/ssh/creds"); err == nil {
+		t.Fatalf("expected error")
+	}
+	existing, err := logical.CollectKeys(exp.idView.SubView("prod/ssh/"))
+	if err != nil {
+		t.Fatalf("err: %v", err)
+	}
+	if len(existing) != 1500 {
+		t.Fatalf("bad: %d", len(existing))
+	}
+
+	// Forcing removes the lease state regardless
+	if err := exp.RevokeForce("prod/ssh/creds"); err != nil {
+		t.Fatalf("err: %v", err)
+	}
+	existing, err = logical.CollectKeys(exp.idView.SubView("prod/ssh/"))
+	if err != nil {
+		t.Fatalf("err: %v", err)
+	}
+	if len(existing) != 0 {
+		t.Fatalf("bad: %d", len(existing))
+	}
+	exp.pendingLock.Lock()
+	pending := len(exp.pending)
+	exp.pendingLock.Unlock()
+	if pending != 0 {
+		t.Fatalf("bad: %d", pending)
+	}
+}
+
 func TestExpiration_RevokeByToken(t *testing.T) {
 	exp := mockExpiration(t)
 	noop := &NoopBackend{}
@@ -811,7 +948,7 @@ func TestExpiration_RenewToken_NotRenewable(t *testing.T) {
 
 	// Attempt to renew the token
 	resp, err := exp.RenewToken(&logical.Request{}, "auth/github/login", root.ID, 0)
-	if err != nil && (err != logical.ErrInvalidRequest || (resp != nil && resp.IsError() && resp.Error().Error() != "lease is not renewable")) {
+	if err != nil && (err != logical.ErrInvalidRequest || (resp != nil && resp.IsError() && resp.Error().Error() != "token is not renewable")) {
 		t.Fatalf("bad: err:%v resp:%#v", err, resp)
 	}
 	if resp == nil {
@@ -63,6 +63,7 @@ func NewSystemBackend(core *Core) *SystemBackend {
 				"replication/primary/secondary-token",
 				"replication/reindex",
 				"rotate",
+				"rollback/*",
 				"config/cors",
 				"config/auditing/*",
 				"plugins/catalog/*",
@@ -230,6 +231,10 @@ func NewSystemBackend(core *Core) *SystemBackend {
 						Type:        framework.TypeString,
 						Description: strings.TrimSpace(sysHelp["tune_max_lease_ttl"][0]),
 					},
+					"wal_rollback_min_age": &framework.FieldSchema{
+						Type:        framework.TypeString,
+						Description: strings.TrimSpace(sysHelp["tune_wal_rollback_min_age"][0]),
+					},
 				},
 				Callbacks: map[logical.Operation]framework.OperationFunc{
 					logical.ReadOperation:   b.handleAuthTuneRead,
@@ -255,6 +260,10 @@ func NewSystemBackend(core *Core) *SystemBackend {
 						Type:        framework.TypeString,
 						Description: strings.TrimSpace(sysHelp["tune_max_lease_ttl"][0]),
 					},
+					"wal_rollback_min_age": &framework.FieldSchema{
+						Type:        framework.TypeString,
+						Description: strings.TrimSpace(sysHelp["tune_wal_rollback_min_age"][0]),
+					},
 				},
 
 				Callbacks: map[logical.Operation]framework.OperationFunc{
@@ -335,6 +344,24 @@ func NewSystemBackend(core *Core) *SystemBackend {
 				HelpDescription: strings.TrimSpace(sysHelp["remount"][1]),
 			},
 
+			&framework.Path{
+				Pattern: "rollback/(?P<path>.+)",
+
+				Fields: map[string]*framework.FieldSchema{
+					"path": &framework.FieldSchema{
+						Type:        framework.TypeString,
+						Description: strings.TrimSpace(sysHelp["rollback_path"][0]),
+					},
+				},
+
+				Callbacks: map[logical.Operation]framework.OperationFunc{
+					logical.UpdateOperation: b.handleRollback,
+				},
+
+				HelpSynopsis:    strings.TrimSpace(sysHelp["rollback"][0]),
+				HelpDescription: strings.TrimSpace(sysHelp["rollback"][1]),
+			},
+
 			&framework.Path{
 				Pattern: "leases/lookup/(?P<prefix>.+?)?",
 
@@ -1227,6 +1254,9 @@ func (b *SystemBackend) handleMountTable(
 		structConfig := structs.New(entry.Config).Map()
 		structConfig["default_lease_ttl"] = int64(structConfig["default_lease_ttl"].(time.Duration).Seconds())
 		structConfig["max_lease_ttl"] = int64(structConfig["max_lease_ttl"].(time.Duration).Seconds())
+		if minAge, ok := structConfig["wal_rollback_min_age"].(time.Duration); ok {
+			structConfig["wal_rollback_min_age"] = int64(minAge.Seconds())
+		}
 		info := map[string]interface{}{
 			"type":        entry.Type,
 			"description": entry.Description,
@@ -1417,6 +1447,50 @@ func (b *SystemBackend) handleRemount(
 	return nil, nil
 }
 
+// handleRollback rolls back the mount at the given path now, rather than at
+// the next tick of the rollback manager, joining the rollback in progress if
+// there is one. It reports how many WAL entries the backend processed and how
+// many of their rollbacks failed.
+func (b *SystemBackend) handleRollback(
+	req *logical.Request, data *framework.FieldData) (*logical.Response, error) {
+	path := data.Get("path").(string)
+	if path == "" {
+		return logical.ErrorResponse("path must be specified as a string"),
+			logical.ErrInvalidRequest
+	}
+	path = sanitizeMountPath(path)
+
+	if b.Core.router.MatchingMount(path) != path {
+		return logical.ErrorResponse(fmt.Sprintf("no matching mount at '%s'", path)),
+			logical.ErrInvalidRequest
+	}
+
+	backendResp, err := b.Core.rollback.rollback(path)
+	if err != nil {
+		b.Backend.Logger().Error("sys: rollback failed", "path", path, "error", err)
+		return handleError(err)
+	}
+	if backendResp.IsError() {
+		return backendResp, nil
+	}
+
+	// Backends which do not roll back WAL entries have none to process
+	resp := &logical.Response{
+		Data: map[string]interface{}{
+			"processed": 0,
+			"failed":    0,
+		},
+	}
+	if backendResp != nil {
+		for _, k := range []string{"processed", "failed", "errors"} {
+			if v, ok := backendResp.Data[k]; ok {
+				resp.Data[k] = v
+			}
+		}
+	}
+	return resp, nil
+}
+
 // handleAuthTuneRead is used to get config settings on a auth path
 func (b *SystemBackend) handleAuthTuneRead(
 	req *logical.Request, data *framework.FieldData) (*logical.Response, error) {
@@ -1463,9 +1537,10 @@ func (b *SystemBackend) handleTuneReadCommon(path string) (*logical.Response, er
 
 	resp := &logical.Response{
 		Data: map[string]interface{}{
-			"default_lease_ttl": int(sysView.DefaultLeaseTTL().Seconds()),
-			"max_lease_ttl":     int(sysView.MaxLeaseTTL().Seconds()),
-			"force_no_cache":    mountEntry.Config.ForceNoCache,
+			"default_lease_ttl":    int(sysView.DefaultLeaseTTL().Seconds()),
+			"max_lease_ttl":        int(sysView.MaxLeaseTTL().Seconds()),
+			"force_no_cache":       mountEntry.Config.ForceNoCache,
+			"wal_rollback_min_age": int(mountEntry.Config.WALRollbackMinAge.Seconds()),
 		},
 	}
 
@@ -1531,6 +1606,23 @@ func (b *SystemBackend) handleTuneWriteCommon(
 		lock = &b.Core.mountsLock
 	}
 
+	// Minimum age of the WAL entries rolled back by the backend, which is
+	// left to the backend if zero
+	if minAgeRaw := data.Get("wal_rollback_min_age").(string); minAgeRaw != "" {
+		minAge, err := parseutil.ParseDurationSecond(minAgeRaw)
+		if err != nil {
+			return handleError(err)
+		}
+
+		lock.Lock()
+		err = b.tuneMountWALRollbackMinAge(path, mountEntry, minAge)
+		lock.Unlock()
+		if err != nil {
+			b.Backend.Logger().Error("sys: tuning failed", "path", path, "error", err)
+			return handleError(err)
+		}
+	}
+
 	// Timing configuration parameters
 	{
 		var newDefault, newMax *time.Duration
@@ -2049,6 +2141,9 @@ func (b *SystemBackend) handleRawWrite(
 	if err := b.Core.barrier.Put(entry); err != nil {
 		return logical.ErrorResponse(err.Error()), logical.ErrInvalidRequest
 	}
+
+	// Let the backend owning the key drop the state it caches for it
+	b.Core.router.Invalidate(path)
 	return nil, nil
 }
 
@@ -2068,6 +2163,7 @@ func (b *SystemBackend) handleRawDelete(
 	if err := b.Core.barrier.Delete(path); err != nil {
 		return handleError(err)
 	}
+	b.Core.router.Invalidate(path)
 	return nil, nil
 }
 
@@ -2565,6 +2661,27 @@ This path responds to the following HTTP methods.
 the auth path.`,
 	},
 
+	"tune_wal_rollback_min_age": {
+		`The minimum age of the WAL entries rolled back by the backend of this
+mount, overriding the one of the backend. Zero restores the latter.`,
+	},
+
+	"rollback": {
+		"Roll back the partial operations of a mount now.",
+		`
+This path responds to the following HTTP methods.
+
+    POST /sys/rollback/<mount point>
+        Rolls back the WAL entries of the mount which are old enough,
+        rather than waiting for the periodic rollback. Reports how many
+        entries were processed and how many rollbacks failed.
+		`,
+	},
+
+	"rollback_path": {
+		`The path of the mount to roll back.`,
+	},
+
 	"mount_tune": {
 		"Tune backend configuration parameters for this mount.",
 		`Read and write the 'default-lease-ttl' and 'max-lease-ttl' values of
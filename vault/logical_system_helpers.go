@@ -83,3 +83,36 @@ func (b *SystemBackend) tuneMountTTLs(path string, me *MountEntry, newDefault, n
 
 	return nil
 }
+
+// tuneMountWALRollbackMinAge is used to set the minimum age of the WAL
+// entries rolled back by the backend of the mount
+func (b *SystemBackend) tuneMountWALRollbackMinAge(path string, me *MountEntry, minAge time.Duration) error {
+	if minAge < 0 {
+		return fmt.Errorf("wal_rollback_min_age cannot be negative")
+	}
+	if minAge == me.Config.WALRollbackMinAge {
+		return nil
+	}
+
+	origMinAge := me.Config.WALRollbackMinAge
+	me.Config.WALRollbackMinAge = minAge
+
+	// Update the mount table
+	var err error
+	switch {
+	case strings.HasPrefix(path, "auth/"):
+		err = b.Core.persistAuth(b.Core.auth, me.Local)
+	default:
+		err = b.Core.persistMounts(b.Core.mounts, me.Local)
+	}
+	if err != nil {
+		me.Config.WALRollbackMinAge = origMinAge
+		return fmt.Errorf("failed to update mount table, rolling back WAL rollback minimum age change")
+	}
+
+	if b.Core.logger.IsInfo() {
+		b.Core.logger.Info("core: mount tuning successful", "path", path)
+	}
+
+	return nil
+}
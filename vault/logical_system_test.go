@@ -2,6 +2,7 @@ package vault
 
 import (
 	"crypto/sha256"
+	"encoding/binary"
 	"encoding/hex"
 	"fmt"
 	"io/ioutil"
@@ -31,6 +32,7 @@ func TestSystemBackend_RootPaths(t *testing.T) {
 		"replication/primary/secondary-token",
 		"replication/reindex",
 		"rotate",
+		"rollback/*",
 		"config/cors",
 		"config/auditing/*",
 		"plugins/catalog/*",
@@ -373,6 +375,73 @@ func TestSystemBackend_remount_invalid(t *testing.T) {
 	}
 }
 
+func TestSystemBackend_rollback(t *testing.T) {
+	noop := &NoopBackend{}
+	c, b, _ := testCoreSystemBackend(t)
+	c.logicalBackends["noop"] = func(*logical.BackendConfig) (logical.Backend, error) {
+		return noop, nil
+	}
+
+	me := &MountEntry{
+		Table: mountTableType,
+		Path:  "foo/",
+		Type:  "noop",
+	}
+	if err := c.mount(me); err != nil {
+		t.Fatalf("err: %v", err)
+	}
+
+	// Backends without WAL entries report none
+	req := logical.TestRequest(t, logical.UpdateOperation, "rollback/foo")
+	resp, err := b.HandleRequest(req)
+	if err != nil {
+		t.Fatalf("err: %v", err)
+	}
+	exp := map[string]interface{}{
+		"processed": 0,
+		"failed":    0,
+	}
+	if !reflect.DeepEqual(resp.Data, exp) {
+		t.Fatalf("got: %#v expect: %#v", resp.Data, exp)
+	}
+
+	// The minimum age tuned for the mount is passed to the backend, and its
+	// report is passed on
+	req = logical.TestRequest(t, logical.UpdateOperation, "mounts/foo/tune")
+	req.Data["wal_rollback_min_age"] = "2m"
+	if _, err := b.HandleRequest(req); err != nil {
+		t.Fatalf("err: %v", err)
+	}
+	noop.Response = &logical.Response{
+		Data: map[string]interface{}{
+			"processed": 3,
+			"failed":    1,
+			"errors":    []string{"target unreachable"},
+		},
+	}
+	req = logical.TestRequest(t, logical.UpdateOperation, "rollback/foo")
+	resp, err = b.HandleRequest(req)
+	if err != nil {
+		t.Fatalf("err: %v", err)
+	}
+	if !reflect.DeepEqual(resp.Data, noop.Response.Data) {
+		t.Fatalf("got: %#v expect: %#v", resp.Data, noop.Response.Data)
+	}
+	last := noop.Requests[len(noop.Requests)-1]
+	if last.Operation != logical.RollbackOperation || last.Data["min_age"] != int64(120) {
+		t.Fatalf("bad: %#v", last)
+	}
+
+	// Only mounts are rolled back
+	for _, path := range []string{"rollback/unknown", "rollback/foo/bar"} {
+		req = logical.TestRequest(t, logical.UpdateOperation, path)
+		resp, err = b.HandleRequest(req)
+		if err != logical.ErrInvalidRequest || !strings.HasPrefix(resp.Data["error"].(string), "no matching mount") {
+			t.Fatalf("bad: %s: err: %v, resp: %#v", path, err, resp)
+		}
+	}
+}
+
 func TestSystemBackend_remount_system(t *testing.T) {
 	b := testSystemBackend(t)
 
@@ -1543,6 +1612,39 @@ func TestSystemBackend_rawDelete(t *testing.T) {
 	}
 }
 
+func TestSystemBackend_rawInvalidate(t *testing.T) {
+	c, b, root := testCoreSystemBackend(t)
+	noop := &NoopBackend{}
+	c.logicalBackends["noop"] = func(*logical.BackendConfig) (logical.Backend, error) {
+		return noop, nil
+	}
+
+	req := logical.TestRequest(t, logical.UpdateOperation, "sys/mounts/foo")
+	req.Data["type"] = "noop"
+	req.ClientToken = root
+	if _, err := c.HandleRequest(req); err != nil {
+		t.Fatalf("err: %v", err)
+	}
+	prefix := c.router.MatchingStorageView("foo/").prefix
+
+	// Writes and deletes of the keys of the mount are passed on relative to
+	// its storage view
+	req = logical.TestRequest(t, logical.UpdateOperation, "raw/"+prefix+"config")
+	req.Data["value"] = "{}"
+	if _, err := b.HandleRequest(req); err != nil {
+		t.Fatalf("err: %v", err)
+	}
+	req = logical.TestRequest(t, logical.DeleteOperation, "raw/"+prefix+"roles/bar")
+	if _, err := b.HandleRequest(req); err != nil {
+		t.Fatalf("err: %v", err)
+	}
+
+	expected := []string{"config", "roles/bar"}
+	if !reflect.DeepEqual(noop.Invalidations, expected) {
+		t.Fatalf("bad: expected: %#v, got: %#v", expected, noop.Invalidations)
+	}
+}
+
 func TestSystemBackend_keyStatus(t *testing.T) {
 	b := testSystemBackend(t)
 	req := logical.TestRequest(t, logical.ReadOperation, "key-status")
@@ -1587,6 +1689,50 @@ func TestSystemBackend_rotate(t *testing.T) {
 	}
 }
 
+func TestSystemBackend_rotateKeyring(t *testing.T) {
+	c, b, _ := testCoreSystemBackend(t)
+
+	req := logical.TestRequest(t, logical.UpdateOperation, "raw/foo")
+	req.Data["value"] = "old"
+	if _, err := b.HandleRequest(req); err != nil {
+		t.Fatalf("err: %v", err)
+	}
+
+	req = logical.TestRequest(t, logical.UpdateOperation, "rotate")
+	if _, err := b.HandleRequest(req); err != nil {
+		t.Fatalf("err: %v", err)
+	}
+
+	req = logical.TestRequest(t, logical.UpdateOperation, "raw/bar")
+	req.Data["value"] = "new"
+	if _, err := b.HandleRequest(req); err != nil {
+		t.Fatalf("err: %v", err)
+	}
+
+	// Ciphertexts are tagged with the term they were written under
+	for key, term := range map[string]uint32{"foo": 1, "bar": 2} {
+		pe, err := c.physical.Get(key)
+		if err != nil {
+			t.Fatalf("err: %v", err)
+		}
+		if pe == nil || binary.BigEndian.Uint32(pe.Value[:4]) != term {
+			t.Fatalf("bad: %s: %#v", key, pe)
+		}
+	}
+
+	// Entries written under the previous term remain readable
+	for key, value := range map[string]string{"foo": "old", "bar": "new"} {
+		req = logical.TestRequest(t, logical.ReadOperation, "raw/"+key)
+		resp, err := b.HandleRequest(req)
+		if err != nil {
+			t.Fatalf("err: %v", err)
+		}
+		if resp == nil || resp.Data["value"] != value {
+			t.Fatalf("bad: %s: %#v", key, resp)
+		}
+	}
+}
+
 func testSystemBackend(t *testing.T) logical.Backend {
 	c, _, _ := TestCoreUnsealed(t)
 	bc := &logical.BackendConfig{
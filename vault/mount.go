@@ -168,6 +168,11 @@ type MountConfig struct {
 	MaxLeaseTTL     time.Duration `json:"max_lease_ttl" structs:"max_lease_ttl" mapstructure:"max_lease_ttl"`             // Override for global default
 	ForceNoCache    bool          `json:"force_no_cache" structs:"force_no_cache" mapstructure:"force_no_cache"`          // Override for global default
 	PluginName      string        `json:"plugin_name,omitempty" structs:"plugin_name,omitempty" mapstructure:"plugin_name"`
+
+	// WALRollbackMinAge overrides the minimum age of the WAL entries the
+	// backend rolls back, for backends doing rollbacks. Zero leaves the
+	// minimum age to the backend.
+	WALRollbackMinAge time.Duration `json:"wal_rollback_min_age,omitempty" structs:"wal_rollback_min_age,omitempty" mapstructure:"wal_rollback_min_age"`
 }
 
 // APIMountConfig is an embedded struct of api.MountConfigInput
@@ -390,6 +395,26 @@ func (c *Core) taintMountEntry(path string) error {
 	return nil
 }
 
+// untaintMountEntry is used to unmark an entry in the mount table as tainted
+func (c *Core) untaintMountEntry(path string) error {
+	c.mountsLock.Lock()
+	defer c.mountsLock.Unlock()
+
+	entry := c.mounts.setTaint(path, false)
+	if entry == nil {
+		c.logger.Error("core: nil entry found untainting entry in mounts table", "path", path)
+		return logical.CodedError(500, "failed to untaint entry in mounts table")
+	}
+
+	// Update the mount table
+	if err := c.persistMounts(c.mounts, entry.Local); err != nil {
+		c.logger.Error("core: failed to untaint entry in mounts table", "error", err)
+		return logical.CodedError(500, "failed to untaint entry in mounts table")
+	}
+
+	return nil
+}
+
 // Remount is used to remount a path at a new mount point.
 func (c *Core) remount(src, dst string) error {
 	// Ensure we end the path in a slash
@@ -413,34 +438,53 @@ func (c *Core) remount(src, dst string) error {
 		return fmt.Errorf("no matching mount at '%s'", src)
 	}
 
+	for _, p := range protectedMounts {
+		if strings.HasPrefix(dst, p) {
+			return fmt.Errorf("cannot remount to '%s'", dst)
+		}
+	}
+
 	if match := c.router.MatchingMount(dst); match != "" {
 		return fmt.Errorf("existing mount at '%s'", match)
 	}
 
+	// Nor may the mount be moved above another
+	c.mountsLock.RLock()
+	for _, entry := range c.mounts.Entries {
+		if strings.HasPrefix(entry.Path, dst) {
+			c.mountsLock.RUnlock()
+			return fmt.Errorf("existing mount at '%s'", entry.Path)
+		}
+	}
+	c.mountsLock.RUnlock()
+
 	// Mark the entry as tainted
 	if err := c.taintMountEntry(src); err != nil {
 		return err
 	}
 
-	// Taint the router path to prevent routing
-	if err := c.router.Taint(src); err != nil {
-		return err
+	// Taint the router path to prevent routing, telling requests to retry
+	// at the new path
+	if err := c.router.TaintMove(src, dst); err != nil {
+		return c.abortRemount(src, err)
 	}
 
 	// Invoke the rollback manager a final time
 	if err := c.rollback.Rollback(src); err != nil {
-		return err
+		return c.abortRemount(src, err)
 	}
 
-	// Revoke all the dynamic keys
-	if err := c.expiration.RevokePrefix(src); err != nil {
-		return err
+	// Move the dynamic keys along with the mount, which keeps its storage.
+	// Should this fail, the keys already moved are moved back.
+	if err := c.expiration.MovePrefix(src, dst); err != nil {
+		return c.abortRemount(src, err)
 	}
 
 	c.mountsLock.Lock()
 	var ent *MountEntry
-	for _, ent = range c.mounts.Entries {
-		if ent.Path == src {
+	for _, entry := range c.mounts.Entries {
+		if entry.Path == src {
+			ent = entry
 			ent.Path = dst
 			ent.Tainted = false
 			break
@@ -448,6 +492,7 @@ func (c *Core) remount(src, dst string) error {
 	}
 
 	if ent == nil {
+		c.mountsLock.Unlock()
 		c.logger.Error("core: failed to find entry in mounts table")
 		return logical.CodedError(500, "failed to find entry in mounts table")
 	}
@@ -458,7 +503,12 @@ func (c *Core) remount(src, dst string) error {
 		ent.Tainted = true
 		c.mountsLock.Unlock()
 		c.logger.Error("core: failed to update mounts table", "error", err)
-		return logical.CodedError(500, "failed to update mounts table")
+
+		// Move the dynamic keys back to the mount left in place
+		if err := c.expiration.MovePrefix(dst, src); err != nil {
+			c.logger.Error("core: failed to move leases back", "old_path", src, "new_path", dst, "error", err)
+		}
+		return c.abortRemount(src, logical.CodedError(500, "failed to update mounts table"))
 	}
 	c.mountsLock.Unlock()
 
@@ -478,6 +528,18 @@ func (c *Core) remount(src, dst string) error {
 	return nil
 }
 
+// abortRemount is used to leave a mount in place when moving it failed. It
+// returns the error of the move.
+func (c *Core) abortRemount(src string, err error) error {
+	if err := c.untaintMountEntry(src); err != nil {
+		c.logger.Error("core: failed to untaint mount after failed remount", "path", src, "error", err)
+	}
+	if err := c.router.Untaint(src); err != nil {
+		c.logger.Error("core: failed to untaint route after failed remount", "path", src, "error", err)
+	}
+	return err
+}
+
 // loadMounts is invoked as part of postUnseal to load the mount table
 func (c *Core) loadMounts() error {
 	mountTable := &MountTable{}
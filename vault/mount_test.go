@@ -2,8 +2,11 @@ package vault
 
 import (
 	"encoding/json"
+	"fmt"
 	"reflect"
+	"sort"
 	"strings"
+	"sync"
 	"testing"
 	"time"
 
@@ -11,6 +14,8 @@ import (
 	"github.com/hashicorp/vault/helper/compressutil"
 	"github.com/hashicorp/vault/helper/jsonutil"
 	"github.com/hashicorp/vault/logical"
+	"github.com/hashicorp/vault/physical"
+	"github.com/hashicorp/vault/physical/inmem"
 )
 
 func TestCore_DefaultMountTable(t *testing.T) {
@@ -368,7 +373,8 @@ func TestCore_Remount_Cleanup(t *testing.T) {
 	resp := &logical.Response{
 		Secret: &logical.Secret{
 			LeaseOptions: logical.LeaseOptions{
-				TTL: time.Hour,
+				TTL:       time.Hour,
+				Renewable: true,
 			},
 		},
 		Data: map[string]interface{}{
@@ -391,7 +397,7 @@ func TestCore_Remount_Cleanup(t *testing.T) {
 		t.Fatalf("bad: %#v", resp)
 	}
 
-	// Remount, this should cleanup
+	// Remount, this should move the lease along
 	if err := c.remount("test/", "new/"); err != nil {
 		t.Fatalf("err: %v", err)
 	}
@@ -401,11 +407,43 @@ func TestCore_Remount_Cleanup(t *testing.T) {
 		t.Fatalf("bad: %#v", noop.Requests)
 	}
 
-	// Revoke should be invoked
-	if noop.Requests[2].Operation != logical.RevokeOperation {
+	// Revoke should not be invoked
+	if len(noop.Requests) != 2 {
 		t.Fatalf("bad: %#v", noop.Requests)
 	}
-	if noop.Requests[2].Path != "foo" {
+
+	// The lease is moved, and renewals are routed to the new path
+	leaseID := "new/" + strings.TrimPrefix(resp.Secret.LeaseID, "test/")
+	if le, err := c.expiration.loadEntry(resp.Secret.LeaseID); err != nil || le != nil {
+		t.Fatalf("bad: %#v %v", le, err)
+	}
+	le, err := c.expiration.loadEntry(leaseID)
+	if err != nil {
+		t.Fatalf("err: %v", err)
+	}
+	if le == nil || le.Path != "new/foo" || le.Secret.LeaseID != leaseID {
+		t.Fatalf("bad: %#v", le)
+	}
+	noop.Response = &logical.Response{
+		Secret: &logical.Secret{
+			LeaseOptions: logical.LeaseOptions{
+				TTL:       time.Hour,
+				Renewable: true,
+			},
+		},
+	}
+	if _, err := c.expiration.Renew(leaseID, 0); err != nil {
+		t.Fatalf("err: %v", err)
+	}
+	if noop.Requests[2].Operation != logical.RenewOperation || noop.Requests[2].Path != "foo" {
+		t.Fatalf("bad: %#v", noop.Requests)
+	}
+
+	// The lease is still revoked along with its token
+	if err := c.expiration.RevokeByToken(&TokenEntry{ID: root}); err != nil {
+		t.Fatalf("err: %v", err)
+	}
+	if noop.Requests[3].Operation != logical.RevokeOperation || noop.Requests[3].Path != "foo" {
 		t.Fatalf("bad: %#v", noop.Requests)
 	}
 
@@ -419,6 +457,233 @@ func TestCore_Remount_Cleanup(t *testing.T) {
 	}
 }
 
+func TestCore_Remount_Existing(t *testing.T) {
+	c, _, _ := TestCoreUnsealed(t)
+	me := &MountEntry{
+		Table: mountTableType,
+		Path:  "infra/ssh/",
+		Type:  "generic",
+	}
+	if err := c.mount(me); err != nil {
+		t.Fatalf("err: %v", err)
+	}
+
+	cases := map[string]string{
+		"infra/ssh":      "existing mount at 'infra/ssh/'",
+		"infra/ssh/keys": "existing mount at 'infra/ssh/'",
+		"infra":          "existing mount at 'infra/ssh/'",
+		"auth/secret":    "cannot remount to 'auth/secret/'",
+		"sys/secret":     "cannot remount to 'sys/secret/'",
+	}
+	for dst, expected := range cases {
+		err := c.remount("secret", dst)
+		if err == nil || err.Error() != expected {
+			t.Fatalf("bad: %s: %v", dst, err)
+		}
+	}
+
+	// The mount is left in place
+	if match := c.router.MatchingMount("secret/foo"); match != "secret/" {
+		t.Fatalf("bad: %s", match)
+	}
+}
+
+func TestCore_Remount_InFlight(t *testing.T) {
+	c, _, root := TestCoreUnsealed(t)
+
+	// Requests during the move are told to retry at the new path
+	if err := c.router.TaintMove("secret/", "new/"); err != nil {
+		t.Fatalf("err: %v", err)
+	}
+	req := &logical.Request{
+		Operation:   logical.ReadOperation,
+		Path:        "secret/foo",
+		ClientToken: root,
+	}
+	_, err := c.HandleRequest(req)
+	if err == nil || !strings.Contains(err.Error(), "is being moved to 'new/'") {
+		t.Fatalf("bad: %v", err)
+	}
+	status, _ := logical.RespondErrorCommon(req, nil, err)
+	logical.AdjustErrorStatusCode(&status, err)
+	if status != 503 {
+		t.Fatalf("bad: %d", status)
+	}
+
+	if err := c.router.Untaint("secret/"); err != nil {
+		t.Fatalf("err: %v", err)
+	}
+	if _, err := c.HandleRequest(req); err != nil {
+		t.Fatalf("err: %v", err)
+	}
+}
+
+// failingBackend is a physical backend whose writes fail for the keys
+// selected by failPut
+type failingBackend struct {
+	physical.Backend
+
+	l       sync.Mutex
+	failPut func(key string) bool
+}
+
+func (b *failingBackend) Put(entry *physical.Entry) error {
+	b.l.Lock()
+	failPut := b.failPut
+	b.l.Unlock()
+	if failPut != nil && failPut(entry.Key) {
+		return fmt.Errorf("failed to put %s", entry.Key)
+	}
+	return b.Backend.Put(entry)
+}
+
+func (b *failingBackend) setFailPut(failPut func(key string) bool) {
+	b.l.Lock()
+	defer b.l.Unlock()
+	b.failPut = failPut
+}
+
+func TestCore_Remount_Failure(t *testing.T) {
+	inm, err := inmem.NewInmem(nil, logger)
+	if err != nil {
+		t.Fatalf("err: %v", err)
+	}
+	backend := &failingBackend{Backend: inm}
+	c, _, root := TestCoreUnsealedBackend(t, backend)
+
+	noop := &NoopBackend{}
+	c.logicalBackends["noop"] = func(*logical.BackendConfig) (logical.Backend, error) {
+		return noop, nil
+	}
+	me := &MountEntry{
+		Table: mountTableType,
+		Path:  "test/",
+		Type:  "noop",
+	}
+	if err := c.mount(me); err != nil {
+		t.Fatalf("err: %v", err)
+	}
+
+	// Generate leased secrets
+	var leaseIDs []string
+	for i := 0; i < 3; i++ {
+		noop.Response = &logical.Response{
+			Secret: &logical.Secret{
+				LeaseOptions: logical.LeaseOptions{
+					TTL: time.Hour,
+				},
+			},
+		}
+		resp, err := c.HandleRequest(&logical.Request{
+			Operation:   logical.ReadOperation,
+			Path:        "test/foo",
+			ClientToken: root,
+		})
+		if err != nil {
+			t.Fatalf("err: %v", err)
+		}
+		leaseIDs = append(leaseIDs, resp.Secret.LeaseID)
+	}
+	noop.Response = nil
+
+	checkInPlace := func() {
+		if match := c.router.MatchingMount("test/foo"); match != "test/" {
+			t.Fatalf("bad: %s", match)
+		}
+		for _, entry := range c.mounts.Entries {
+			if entry.Path == "test/" && entry.Tainted {
+				t.Fatalf("mount is tainted")
+			}
+		}
+
+		for _, leaseID := range leaseIDs {
+			le, err := c.expiration.loadEntry(leaseID)
+			if err != nil {
+				t.Fatalf("err: %v", err)
+			}
+			if le == nil || le.Path != "test/foo" {
+				t.Fatalf("bad: %#v", le)
+			}
+			c.expiration.pendingLock.Lock()
+			_, ok := c.expiration.pending[leaseID]
+			c.expiration.pendingLock.Unlock()
+			if !ok {
+				t.Fatalf("lease %s is not pending", leaseID)
+			}
+		}
+		moved, err := logical.CollectKeys(c.expiration.idView.SubView("new/"))
+		if err != nil {
+			t.Fatalf("err: %v", err)
+		}
+		if len(moved) != 0 {
+			t.Fatalf("bad: %#v", moved)
+		}
+		tokenLeases, err := c.expiration.lookupByToken(root)
+		if err != nil {
+			t.Fatalf("err: %v", err)
+		}
+		sort.Strings(tokenLeases)
+		expected := append([]string(nil), leaseIDs...)
+		sort.Strings(expected)
+		if !reflect.DeepEqual(tokenLeases, expected) {
+			t.Fatalf("bad: got\n%#v\nexpected\n%#v\n", tokenLeases, expected)
+		}
+
+		if _, err := c.HandleRequest(&logical.Request{
+			Operation:   logical.ReadOperation,
+			Path:        "test/foo",
+			ClientToken: root,
+		}); err != nil {
+			t.Fatalf("err: %v", err)
+		}
+	}
+
+	// Fail to move the second lease, after the first has been moved
+	var puts int
+	backend.setFailPut(func(key string) bool {
+		if !strings.HasPrefix(key, "sys/expire/id/new/") {
+			return false
+		}
+		puts++
+		return puts == 2
+	})
+	if err := c.remount("test/", "new/"); err == nil {
+		t.Fatalf("expected error")
+	}
+	backend.setFailPut(nil)
+	checkInPlace()
+
+	// Fail to update the mount table after moving the leases, the first
+	// update having tainted the mount
+	puts = 0
+	backend.setFailPut(func(key string) bool {
+		if key != coreMountConfigPath {
+			return false
+		}
+		puts++
+		return puts == 2
+	})
+	if err := c.remount("test/", "new/"); err == nil {
+		t.Fatalf("expected error")
+	}
+	backend.setFailPut(nil)
+	checkInPlace()
+
+	// The remount succeeds once the storage does
+	if err := c.remount("test/", "new/"); err != nil {
+		t.Fatalf("err: %v", err)
+	}
+	for _, leaseID := range leaseIDs {
+		le, err := c.expiration.loadEntry("new/" + strings.TrimPrefix(leaseID, "test/"))
+		if err != nil {
+			t.Fatalf("err: %v", err)
+		}
+		if le == nil || le.Path != "new/foo" {
+			t.Fatalf("bad: %#v", le)
+		}
+	}
+}
+
 func TestCore_Remount_Protected(t *testing.T) {
 	c, _, _ := TestCoreUnsealed(t)
 	err := c.remount("sys", "foo")
@@ -69,6 +69,10 @@ type PathCapabilities struct {
 	Glob         bool
 	Capabilities []string
 
+	// SegmentWildcards is set if a segment of the prefix other than the
+	// trailing glob is "*", which matches any single segment of a path.
+	SegmentWildcards bool
+
 	// These keys are used at the top level to make the HCL nicer; we store in
 	// the Permissions object though
 	MinWrappingTTLHCL    interface{}              `hcl:"min_wrapping_ttl"`
@@ -199,6 +203,7 @@ func parsePaths(result *Policy, list *ast.ObjectList) error {
 			pc.Prefix = strings.TrimSuffix(pc.Prefix, "*")
 			pc.Glob = true
 		}
+		pc.SegmentWildcards = hasSegmentWildcard(pc.Prefix)
 
 		// Map old-style policies into capabilities
 		if len(pc.Policy) > 0 {
@@ -273,6 +278,16 @@ func parsePaths(result *Policy, list *ast.ObjectList) error {
 	return nil
 }
 
+// hasSegmentWildcard returns whether any segment of the prefix is "*".
+func hasSegmentWildcard(prefix string) bool {
+	for _, segment := range strings.Split(prefix, "/") {
+		if segment == "*" {
+			return true
+		}
+	}
+	return false
+}
+
 func checkHCLKeys(node ast.Node, valid []string) error {
 	var list *ast.ObjectList
 	switch n := node.(type) {
@@ -85,6 +85,11 @@ path "test/types" {
 		"bool" = [false]
 	}
 }
+
+# Read access to the CI secrets of all teams
+path "teams/*/ci/*" {
+	capabilities = ["read"]
+}
 `)
 
 func TestPolicy_Parse(t *testing.T) {
@@ -225,6 +230,16 @@ func TestPolicy_Parse(t *testing.T) {
 			},
 			Glob: false,
 		},
+		&PathCapabilities{
+			Prefix: "teams/*/ci/",
+			Policy: "",
+			Capabilities: []string{
+				"read",
+			},
+			Permissions:      &Permissions{CapabilitiesBitmap: ReadCapabilityInt},
+			Glob:             true,
+			SegmentWildcards: true,
+		},
 	}
 	if !reflect.DeepEqual(p.Paths, expect) {
 		t.Errorf("expected \n\n%#v\n\n to be \n\n%#v\n\n", p.Paths, expect)
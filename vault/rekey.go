@@ -158,7 +158,7 @@ func (c *Core) BarrierRekeyInit(config *SealConfig) error {
 
 	// Prevent multiple concurrent re-keys
 	if c.barrierRekeyConfig != nil {
-		return fmt.Errorf("rekey already in progress")
+		return fmt.Errorf("rekey already in progress; nonce for the in-flight rekey operation is %s", c.barrierRekeyConfig.Nonce)
 	}
 
 	// Copy the configuration
@@ -208,7 +208,7 @@ func (c *Core) RecoveryRekeyInit(config *SealConfig) error {
 
 	// Prevent multiple concurrent re-keys
 	if c.recoveryRekeyConfig != nil {
-		return fmt.Errorf("rekey already in progress")
+		return fmt.Errorf("rekey already in progress; nonce for the in-flight rekey operation is %s", c.recoveryRekeyConfig.Nonce)
 	}
 
 	// Copy the configuration
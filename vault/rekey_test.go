@@ -3,6 +3,7 @@ package vault
 import (
 	"fmt"
 	"reflect"
+	"strings"
 	"testing"
 
 	log "github.com/mgutz/logxi/v1"
@@ -129,11 +130,61 @@ func testCore_Rekey_Init_Common(t *testing.T, c *Core, recovery bool) {
 		t.Fatalf("err: %v", err)
 	}
 
-	// Second should fail
+	// Second should fail, naming the operation in flight
 	err = c.RekeyInit(newConf, recovery)
 	if err == nil {
 		t.Fatalf("should fail")
 	}
+	rkconf, confErr := c.RekeyConfig(recovery)
+	if confErr != nil {
+		t.Fatalf("err: %v", confErr)
+	}
+	if !strings.Contains(err.Error(), rkconf.Nonce) {
+		t.Fatalf("bad: %v", err)
+	}
+}
+
+func TestCore_Rekey_Seal(t *testing.T) {
+	c, keys, root := TestCoreUnsealed(t)
+
+	// Start a rekey and make some progress
+	newConf := &SealConfig{
+		SecretThreshold: 1,
+		SecretShares:    1,
+	}
+	if err := c.RekeyInit(newConf, false); err != nil {
+		t.Fatalf("err: %v", err)
+	}
+	rkconf, err := c.RekeyConfig(false)
+	if err != nil {
+		t.Fatalf("err: %v", err)
+	}
+	nonce := rkconf.Nonce
+
+	// Sealing cancels the rekey, as does a restart
+	if err := c.Seal(root); err != nil {
+		t.Fatalf("err: %v", err)
+	}
+	for i, key := range keys {
+		unseal, err := TestCoreUnseal(c, key)
+		if err != nil {
+			t.Fatalf("err: %v", err)
+		}
+		if i+1 == len(keys) && !unseal {
+			t.Fatalf("should be unsealed")
+		}
+	}
+
+	rkconf, err = c.RekeyConfig(false)
+	if err != nil {
+		t.Fatalf("err: %v", err)
+	}
+	if rkconf != nil {
+		t.Fatalf("bad: %#v", rkconf)
+	}
+	if _, err := c.RekeyUpdate(keys[0], nonce, false); err == nil {
+		t.Fatalf("should fail")
+	}
 }
 
 func TestCore_Rekey_Update(t *testing.T) {
@@ -23,7 +23,7 @@ func (c *Core) HandleRequest(req *logical.Request) (resp *logical.Response, err
 		return nil, consts.ErrSealed
 	}
 	if c.standby {
-		return nil, consts.ErrStandby
+		return c.handleStandbyRequest(req)
 	}
 
 	// Allowing writing to a path ending in / makes it extremely difficult to
@@ -177,8 +177,9 @@ func (c *Core) handleRequest(req *logical.Request) (retResp *logical.Response, r
 		return logical.ErrorResponse(ctErr.Error()), auth, retErr
 	}
 
-	// Attach the display name
+	// Attach the display name and metadata of the token
 	req.DisplayName = auth.DisplayName
+	req.TokenMetadata = auth.Metadata
 
 	// Create an audit trail of the request
 	if err := c.auditBroker.LogRequest(auth, req, c.auditedHeaders, nil); err != nil {
@@ -51,7 +51,8 @@ type RollbackManager struct {
 
 // rollbackState is used to track the state of a single rollback attempt
 type rollbackState struct {
-	lastError error
+	lastError    error
+	lastResponse *logical.Response
 	sync.WaitGroup
 }
 
@@ -151,8 +152,10 @@ func (m *RollbackManager) attemptRollback(path string, rs *rollbackState) (err e
 		m.logger.Trace("rollback: attempting rollback", "path", path)
 	}
 
+	var resp *logical.Response
 	defer func() {
 		rs.lastError = err
+		rs.lastResponse = resp
 		rs.Done()
 		m.inflightAll.Done()
 		m.inflightLock.Lock()
@@ -160,12 +163,18 @@ func (m *RollbackManager) attemptRollback(path string, rs *rollbackState) (err e
 		m.inflightLock.Unlock()
 	}()
 
-	// Invoke a RollbackOperation
+	// Invoke a RollbackOperation, passing on the minimum age of the WAL
+	// entries to roll back if it is tuned for the mount
 	req := &logical.Request{
 		Operation: logical.RollbackOperation,
 		Path:      path,
 	}
-	_, err = m.router.Route(req)
+	if me := m.router.MatchingMountEntry(path); me != nil && me.Config.WALRollbackMinAge != 0 {
+		req.Data = map[string]interface{}{
+			"min_age": int64(me.Config.WALRollbackMinAge.Seconds()),
+		}
+	}
+	resp, err = m.router.Route(req)
 
 	// If the error is an unsupported operation, then it doesn't
 	// matter, the backend doesn't support it.
@@ -175,12 +184,22 @@ func (m *RollbackManager) attemptRollback(path string, rs *rollbackState) (err e
 	if err != nil {
 		m.logger.Error("rollback: error rolling back", "path", path, "error", err)
 	}
+	if resp != nil && resp.Data["errors"] != nil {
+		m.logger.Error("rollback: failed to roll back WAL entries", "path", path, "errors", resp.Data["errors"])
+	}
 	return
 }
 
 // Rollback is used to trigger an immediate rollback of the path,
 // or to join an existing rollback operation if in flight.
 func (m *RollbackManager) Rollback(path string) error {
+	_, err := m.rollback(path)
+	return err
+}
+
+// rollback is Rollback which also returns the response of the backend,
+// reporting the WAL entries it processed.
+func (m *RollbackManager) rollback(path string) (*logical.Response, error) {
 	// Check for an existing attempt and start one if none
 	m.inflightLock.RLock()
 	rs, ok := m.inflight[path]
@@ -192,8 +211,8 @@ func (m *RollbackManager) Rollback(path string) error {
 	// Wait for the attempt to finish
 	rs.Wait()
 
-	// Return the last error
-	return rs.lastError
+	// Return the last response and error
+	return rs.lastResponse, rs.lastError
 }
 
 // The methods below are the hooks from core that are called pre/post seal.
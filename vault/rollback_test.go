@@ -9,6 +9,7 @@ import (
 
 	"github.com/hashicorp/go-uuid"
 	"github.com/hashicorp/vault/helper/logformat"
+	"github.com/hashicorp/vault/logical"
 )
 
 // mockRollback returns a mock rollback manager
@@ -106,3 +107,33 @@ func TestRollbackManager_Join(t *testing.T) {
 	}()
 	wg.Wait()
 }
+
+func TestRollbackManager_minAge(t *testing.T) {
+	m, backend := mockRollback(t)
+	backend.Response = &logical.Response{
+		Data: map[string]interface{}{
+			"processed": 1,
+			"failed":    0,
+		},
+	}
+
+	// Without a tuned minimum age, the backend applies its own
+	resp, err := m.rollback("foo")
+	if err != nil {
+		t.Fatalf("err: %v", err)
+	}
+	if resp != backend.Response {
+		t.Fatalf("bad: %#v", resp)
+	}
+	if backend.Requests[0].Data != nil {
+		t.Fatalf("bad: %#v", backend.Requests[0])
+	}
+
+	m.router.MatchingMountEntry("foo").Config.WALRollbackMinAge = 2 * time.Minute
+	if _, err := m.rollback("foo"); err != nil {
+		t.Fatalf("err: %v", err)
+	}
+	if backend.Requests[1].Data["min_age"] != int64(120) {
+		t.Fatalf("bad: %#v", backend.Requests[1])
+	}
+}
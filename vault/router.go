@@ -40,6 +40,7 @@ func NewRouter() *Router {
 // routeEntry is used to represent a mount point in the router
 type routeEntry struct {
 	tainted     bool
+	movingTo    string
 	backend     logical.Backend
 	mountEntry  *MountEntry
 	storageView *BarrierView
@@ -151,6 +152,20 @@ func (r *Router) Taint(path string) error {
 	return nil
 }
 
+// TaintMove is used to mark a path as tainted while it is moved to another.
+// Requests other than those allowed by Taint are told to retry at the new
+// path.
+func (r *Router) TaintMove(path, dst string) error {
+	r.l.Lock()
+	defer r.l.Unlock()
+	_, raw, ok := r.root.LongestPrefix(path)
+	if ok {
+		raw.(*routeEntry).tainted = true
+		raw.(*routeEntry).movingTo = dst
+	}
+	return nil
+}
+
 // Untaint is used to unmark a path as tainted.
 func (r *Router) Untaint(path string) error {
 	r.l.Lock()
@@ -158,6 +173,7 @@ func (r *Router) Untaint(path string) error {
 	_, raw, ok := r.root.LongestPrefix(path)
 	if ok {
 		raw.(*routeEntry).tainted = false
+		raw.(*routeEntry).movingTo = ""
 	}
 	return nil
 }
@@ -273,6 +289,21 @@ func (r *Router) MatchingStoragePrefix(path string) (string, string, bool) {
 	return mountPath, prefix, true
 }
 
+// Invalidate is used to notify the backend whose storage view holds the
+// given barrier key that the key was modified outside of the backend. The
+// backend is passed the key relative to its view.
+func (r *Router) Invalidate(path string) {
+	r.l.RLock()
+	_, raw, ok := r.storagePrefix.LongestPrefix(path)
+	r.l.RUnlock()
+	if !ok {
+		return
+	}
+
+	re := raw.(*routeEntry)
+	re.backend.InvalidateKey(strings.TrimPrefix(path, re.storageView.prefix))
+}
+
 // Route is used to route a given request
 func (r *Router) Route(req *logical.Request) (*logical.Response, error) {
 	resp, _, _, err := r.routeCommon(req, false)
@@ -309,6 +340,10 @@ func (r *Router) routeCommon(req *logical.Request, existenceCheck bool) (*logica
 		switch req.Operation {
 		case logical.RevokeOperation, logical.RollbackOperation:
 		default:
+			if re.movingTo != "" {
+				return nil, false, false, logical.CodedError(503,
+					fmt.Sprintf("mount '%s' is being moved to '%s'; retry the request there", mount, re.movingTo))
+			}
 			return logical.ErrorResponse(fmt.Sprintf("no handler for route '%s'", req.Path)), false, false, logical.ErrUnsupportedPath
 		}
 	}
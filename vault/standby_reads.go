@@ -0,0 +1,413 @@
+package vault
+
+import (
+	"bytes"
+	"fmt"
+	"strings"
+	"time"
+
+	"github.com/armon/go-metrics"
+	"github.com/hashicorp/go-multierror"
+	"github.com/hashicorp/vault/helper/consts"
+	"github.com/hashicorp/vault/helper/jsonutil"
+	"github.com/hashicorp/vault/helper/locksutil"
+	"github.com/hashicorp/vault/logical"
+	"github.com/hashicorp/vault/physical"
+)
+
+// standbyReadsTables are the keys of the tables the standby reads are built
+// from; they are rebuilt whenever one of them changes.
+var standbyReadsTables = []string{
+	coreMountConfigPath,
+	coreLocalMountConfigPath,
+	coreAuditConfigPath,
+	coreLocalAuditConfigPath,
+	systemBarrierPrefix + auditedHeadersSubPath + auditedHeadersEntry,
+}
+
+// standbyReads is what a standby sets up to serve the requests which the
+// backends of its mounts declare safe on a standby, as they only read storage
+// and create no leases. Everything in it uses read-only views of the barrier,
+// so that the standby cannot write to the storage shared with the active
+// node.
+type standbyReads struct {
+	// tables are the values of standbyReadsTables the rest was built from
+	tables [][]byte
+
+	router         *Router
+	backends       []logical.Backend
+	tokenStore     *TokenStore
+	policyStore    *PolicyStore
+	auditBroker    *AuditBroker
+	audits         *MountTable
+	auditedHeaders *AuditedHeadersConfig
+}
+
+// current returns whether the standby reads were built from the given tables
+func (sr *standbyReads) current(tables [][]byte) bool {
+	if sr == nil {
+		return false
+	}
+	for i := range tables {
+		if !bytes.Equal(sr.tables[i], tables[i]) {
+			return false
+		}
+	}
+	return true
+}
+
+// standbySafe returns whether the backend of the mount of the request declares
+// it safe on a standby.
+func (sr *standbyReads) standbySafe(req *logical.Request) bool {
+	// Wrapping the response creates a token, which only the active node can
+	// do
+	if req.WrapInfo != nil && req.WrapInfo.TTL != 0 {
+		return false
+	}
+	if sr.router.LoginPath(req.Path) {
+		return false
+	}
+
+	backend, ok := sr.router.MatchingBackend(req.Path).(logical.StandbySafeBackend)
+	if !ok {
+		return false
+	}
+
+	// The backend sees the path relative to its mount
+	path := req.Path
+	req.Path = strings.TrimPrefix(path, sr.router.MatchingMount(path))
+	defer func() {
+		req.Path = path
+	}()
+	return backend.StandbySafe(req)
+}
+
+// cleanup is used to clean up the backends of the standby reads. The audit
+// lock must not be held.
+func (sr *standbyReads) cleanup(c *Core) {
+	if sr == nil {
+		return
+	}
+
+	for _, backend := range sr.backends {
+		backend.Cleanup()
+	}
+
+	c.auditLock.Lock()
+	for _, entry := range sr.audits.Entries {
+		c.removeAuditReloadFunc(entry)
+	}
+	c.auditLock.Unlock()
+}
+
+// StandbySafe returns whether this node is a standby which serves the request
+// itself, as the backend of its mount declares it safe on a standby. The
+// request is then not forwarded to the active node, though it is redirected
+// there if the standby finds that its token is not allowed to make it, or
+// that it has limited uses.
+func (c *Core) StandbySafe(req *logical.Request) bool {
+	c.stateLock.RLock()
+	defer c.stateLock.RUnlock()
+	if c.sealed || !c.standby {
+		return false
+	}
+
+	sr, err := c.acquireStandbyReads()
+	if err != nil {
+		c.logger.Error("core: failed to set up standby reads", "error", err)
+		return false
+	}
+	defer c.standbyReadsLock.RUnlock()
+
+	return sr.standbySafe(req)
+}
+
+// handleStandbyRequest serves a request made to a standby, if the backend of
+// its mount declares it safe on a standby and its token is valid, has
+// unlimited uses and is allowed to make it. Otherwise it returns ErrStandby,
+// leaving the request to the active node. The state lock must be held.
+func (c *Core) handleStandbyRequest(req *logical.Request) (retResp *logical.Response, retErr error) {
+	defer metrics.MeasureSince([]string{"core", "handle_standby_request"}, time.Now())
+
+	if req.ClientToken == "" {
+		return nil, consts.ErrStandby
+	}
+
+	// The active node does not invalidate the cache of the standbys, so purge
+	// it; reads made for the request are then at least as recent as it.
+	if purgable, ok := c.physical.(physical.Purgable); ok {
+		purgable.Purge()
+	}
+
+	sr, err := c.acquireStandbyReads()
+	if err != nil {
+		c.logger.Error("core: failed to set up standby reads", "error", err)
+		return nil, consts.ErrStandby
+	}
+	defer c.standbyReadsLock.RUnlock()
+
+	auth, ok := c.checkStandbyToken(sr, req)
+	if !ok {
+		return nil, consts.ErrStandby
+	}
+
+	// Attach the display name and metadata of the token
+	req.DisplayName = auth.DisplayName
+	req.TokenMetadata = auth.Metadata
+
+	// Create an audit trail of the request
+	if err := sr.auditBroker.LogRequest(auth, req, sr.auditedHeaders, nil); err != nil {
+		c.logger.Error("core: failed to audit request", "path", req.Path, "error", err)
+		return nil, ErrInternalError
+	}
+
+	resp, routeErr := sr.router.Route(req)
+	if routeErr != nil {
+		retErr = multierror.Append(retErr, routeErr)
+	}
+
+	// Only the active node can register leases and wrap responses
+	if resp != nil && (resp.Secret != nil || resp.Auth != nil ||
+		(resp.WrapInfo != nil && resp.WrapInfo.TTL != 0)) {
+		c.logger.Error("core: request served on a standby created a lease or wrapped its response", "request_path", req.Path)
+		resp = nil
+		retErr = multierror.Append(retErr, ErrInternalError)
+	}
+
+	// Create an audit trail of the response
+	if auditErr := sr.auditBroker.LogResponse(auth, req, resp, sr.auditedHeaders, retErr); auditErr != nil {
+		c.logger.Error("core: failed to audit response", "request_path", req.Path, "error", auditErr)
+		return nil, ErrInternalError
+	}
+
+	return resp, retErr
+}
+
+// checkStandbyToken checks the token of a request made to a standby like
+// checkToken does, and whether the request is safe on a standby. It returns
+// false whenever the active node must handle the request instead, including
+// when the token is not allowed to make it, so that the active node denies it
+// as usual, and when the token has limited uses, which the active node counts.
+func (c *Core) checkStandbyToken(sr *standbyReads, req *logical.Request) (*logical.Auth, bool) {
+	te, err := sr.tokenStore.Lookup(req.ClientToken)
+	if err != nil {
+		c.logger.Error("core: failed to lookup token", "error", err)
+		return nil, false
+	}
+	if te == nil || te.NumUses != 0 {
+		return nil, false
+	}
+
+	acl, err := sr.policyStore.ACL(te.Policies...)
+	if err != nil {
+		c.logger.Error("core: failed to construct ACL", "error", err)
+		return nil, false
+	}
+
+	// Tell creations from updates as checkToken does
+	if req.Operation == logical.CreateOperation || req.Operation == logical.UpdateOperation {
+		checkExists, resourceExists, err := sr.router.RouteExistenceCheck(req)
+		switch {
+		case err != nil && err != logical.ErrUnsupportedPath:
+			return nil, false
+		case err == nil && checkExists && !resourceExists:
+			req.Operation = logical.CreateOperation
+		default:
+			req.Operation = logical.UpdateOperation
+		}
+	}
+
+	if !sr.standbySafe(req) {
+		return nil, false
+	}
+
+	allowed, rootPrivs := acl.AllowOperation(req)
+	if !allowed || (sr.router.RootPath(req.Path) && !rootPrivs) {
+		return nil, false
+	}
+
+	req.ClientTokenAccessor = te.Accessor
+	return &logical.Auth{
+		ClientToken: req.ClientToken,
+		Accessor:    te.Accessor,
+		Policies:    te.Policies,
+		Metadata:    te.Meta,
+		DisplayName: te.DisplayName,
+	}, true
+}
+
+// acquireStandbyReads returns the standby reads, rebuilding them first if the
+// tables they are built from changed. It returns with the standby reads lock
+// held for reading. The state lock must be held.
+func (c *Core) acquireStandbyReads() (*standbyReads, error) {
+	tables := make([][]byte, len(standbyReadsTables))
+	for i, key := range standbyReadsTables {
+		entry, err := c.barrier.Get(key)
+		if err != nil {
+			return nil, err
+		}
+		if entry != nil {
+			tables[i] = entry.Value
+		}
+	}
+
+	c.standbyReadsLock.RLock()
+	if c.standbyReads.current(tables) {
+		return c.standbyReads, nil
+	}
+	c.standbyReadsLock.RUnlock()
+
+	if err := c.refreshStandbyReads(tables); err != nil {
+		return nil, err
+	}
+
+	// Concurrent requests may have rebuilt the standby reads again since,
+	// from tables they read at about the same time, which serve as well
+	c.standbyReadsLock.RLock()
+	if c.standbyReads == nil {
+		c.standbyReadsLock.RUnlock()
+		return nil, fmt.Errorf("standby reads were torn down")
+	}
+	return c.standbyReads, nil
+}
+
+// refreshStandbyReads rebuilds the standby reads from the given tables,
+// unless they already were.
+func (c *Core) refreshStandbyReads(tables [][]byte) error {
+	c.standbyReadsLock.Lock()
+	defer c.standbyReadsLock.Unlock()
+
+	if c.standbyReads.current(tables) {
+		return nil
+	}
+
+	c.standbyReads.cleanup(c)
+	c.standbyReads = nil
+
+	sr, err := c.setupStandbyReads(tables)
+	if err != nil {
+		return err
+	}
+	c.standbyReads = sr
+	return nil
+}
+
+// teardownStandbyReads is used when a standby becomes active or is sealed, to
+// clean up the standby reads.
+func (c *Core) teardownStandbyReads() {
+	c.standbyReadsLock.Lock()
+	defer c.standbyReadsLock.Unlock()
+
+	c.standbyReads.cleanup(c)
+	c.standbyReads = nil
+}
+
+// setupStandbyReads builds the standby reads from the given tables. The
+// system and cubbyhole backends are not set up, as they write on reads, and
+// neither are plugins, which only run on the active node; requests to mounts
+// which are not set up are forwarded to the active node.
+func (c *Core) setupStandbyReads(tables [][]byte) (*standbyReads, error) {
+	mounts, err := decodeStandbyTable(tables[0], tables[1])
+	if err != nil {
+		return nil, fmt.Errorf("failed to decode the mount table: %v", err)
+	}
+	audits, err := decodeStandbyTable(tables[2], tables[3])
+	if err != nil {
+		return nil, fmt.Errorf("failed to decode the audit table: %v", err)
+	}
+
+	systemView := c.standbyBarrierView(systemBarrierPrefix)
+	auditedHeaders, err := loadAuditedHeadersConfig(systemView.SubView(auditedHeadersSubPath))
+	if err != nil {
+		return nil, err
+	}
+
+	sr := &standbyReads{
+		tables: tables,
+		router: NewRouter(),
+		tokenStore: &TokenStore{
+			view:       systemView.SubView(tokenSubPath),
+			logger:     c.logger,
+			tokenLocks: locksutil.CreateLocks(),
+		},
+		// Without a cache, policies are read at every request, so that
+		// their updates on the active node apply at once
+		policyStore: &PolicyStore{
+			view: systemView.SubView(policySubPath),
+		},
+		auditBroker:    NewAuditBroker(c.logger),
+		audits:         audits,
+		auditedHeaders: auditedHeaders,
+	}
+	if err := sr.tokenStore.Initialize(); err != nil {
+		return nil, err
+	}
+
+	var auditCount int
+	for _, entry := range audits.Entries {
+		view := c.standbyBarrierView(auditBarrierPrefix + entry.UUID + "/")
+		backend, err := c.newAuditBackend(entry, view, entry.Options)
+		if err != nil {
+			c.logger.Error("core: failed to create audit entry on standby", "path", entry.Path, "error", err)
+			continue
+		}
+		sr.auditBroker.Register(entry.Path, backend, view)
+		auditCount++
+	}
+	if len(audits.Entries) > 0 && auditCount == 0 {
+		sr.cleanup(c)
+		return nil, errLoadAuditFailed
+	}
+
+	for _, entry := range mounts.Entries {
+		switch {
+		case entry.Type == "system", entry.Type == "cubbyhole", entry.Type == "plugin", entry.Tainted:
+			continue
+		}
+
+		view := c.standbyBarrierView(backendBarrierPrefix + entry.UUID + "/")
+		backend, err := c.newLogicalBackend(entry.Type, c.mountEntrySysView(entry), view, nil)
+		if err != nil {
+			c.logger.Warn("core: failed to create mount entry on standby", "path", entry.Path, "error", err)
+			continue
+		}
+		err = backend.Initialize()
+		if err == nil {
+			err = sr.router.Mount(backend, entry.Path, entry, view)
+		}
+		if err != nil {
+			c.logger.Warn("core: failed to mount entry on standby", "path", entry.Path, "error", err)
+			backend.Cleanup()
+			continue
+		}
+		sr.backends = append(sr.backends, backend)
+	}
+
+	return sr, nil
+}
+
+// standbyBarrierView returns a read-only view of the barrier at the prefix
+func (c *Core) standbyBarrierView(prefix string) *BarrierView {
+	view := NewBarrierView(c.barrier, prefix)
+	view.readonly = true
+	return view
+}
+
+// decodeStandbyTable decodes a mount or audit table and its local entries as
+// persisted by the active node.
+func decodeStandbyTable(raw, rawLocal []byte) (*MountTable, error) {
+	table := &MountTable{}
+	if raw != nil {
+		if err := jsonutil.DecodeJSON(raw, table); err != nil {
+			return nil, err
+		}
+	}
+	if rawLocal != nil {
+		localTable := &MountTable{}
+		if err := jsonutil.DecodeJSON(rawLocal, localTable); err != nil {
+			return nil, err
+		}
+		table.Entries = append(table.Entries, localTable.Entries...)
+	}
+	return table, nil
+}
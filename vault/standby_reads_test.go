@@ -0,0 +1,249 @@
+package vault
+
+import (
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/hashicorp/vault/audit"
+	"github.com/hashicorp/vault/helper/consts"
+	"github.com/hashicorp/vault/helper/logformat"
+	"github.com/hashicorp/vault/logical"
+	"github.com/hashicorp/vault/logical/framework"
+	"github.com/hashicorp/vault/physical"
+	"github.com/hashicorp/vault/physical/inmem"
+	log "github.com/mgutz/logxi/v1"
+)
+
+// standbyTestBackend stores values, which it declares safe to read on a
+// standby. Reading "touch" is declared safe as well, though it writes.
+func standbyTestBackend(conf *logical.BackendConfig) (logical.Backend, error) {
+	b := &framework.Backend{
+		BackendType: logical.TypeLogical,
+		Paths: []*framework.Path{
+			&framework.Path{
+				Pattern: "values/" + framework.GenericNameRegex("name"),
+				Fields: map[string]*framework.FieldSchema{
+					"name":  &framework.FieldSchema{Type: framework.TypeString},
+					"value": &framework.FieldSchema{Type: framework.TypeString},
+				},
+				Operations: map[logical.Operation]*framework.PathOperation{
+					logical.ReadOperation: &framework.PathOperation{
+						Callback: func(req *logical.Request, data *framework.FieldData) (*logical.Response, error) {
+							entry, err := req.Storage.Get(data.Get("name").(string))
+							if err != nil || entry == nil {
+								return nil, err
+							}
+							return &logical.Response{
+								Data: map[string]interface{}{"value": string(entry.Value)},
+							}, nil
+						},
+						StandbySafe: true,
+					},
+					logical.UpdateOperation: &framework.PathOperation{
+						Callback: func(req *logical.Request, data *framework.FieldData) (*logical.Response, error) {
+							return nil, req.Storage.Put(&logical.StorageEntry{
+								Key:   data.Get("name").(string),
+								Value: []byte(data.Get("value").(string)),
+							})
+						},
+					},
+				},
+			},
+			&framework.Path{
+				Pattern: "touch",
+				Operations: map[logical.Operation]*framework.PathOperation{
+					logical.ReadOperation: &framework.PathOperation{
+						Callback: func(req *logical.Request, data *framework.FieldData) (*logical.Response, error) {
+							return nil, req.Storage.Put(&logical.StorageEntry{Key: "touched"})
+						},
+						StandbySafe: true,
+					},
+				},
+			},
+		},
+	}
+	if err := b.Setup(conf); err != nil {
+		return nil, err
+	}
+	return b, nil
+}
+
+func TestCore_StandbyReads(t *testing.T) {
+	logger := logformat.NewVaultLogger(log.LevelTrace)
+	inmha, err := inmem.NewInmemHA(nil, logger)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	var standbyAudit *NoopAudit
+	newCore := func(redirectAddr string, standby bool) *Core {
+		core, err := NewCore(&CoreConfig{
+			Physical:     inmha,
+			HAPhysical:   inmha.(physical.HABackend),
+			RedirectAddr: redirectAddr,
+			DisableMlock: true,
+			LogicalBackends: map[string]logical.Factory{
+				"standbytest": standbyTestBackend,
+			},
+			AuditBackends: map[string]audit.Factory{
+				"noop": func(config *audit.BackendConfig) (audit.Backend, error) {
+					backend := &NoopAudit{Config: config}
+					if standby {
+						standbyAudit = backend
+					}
+					return backend, nil
+				},
+			},
+		})
+		if err != nil {
+			t.Fatalf("err: %v", err)
+		}
+		return core
+	}
+
+	core := newCore("http://127.0.0.1:8200", false)
+	keys, root := TestCoreInit(t, core)
+	for _, key := range keys {
+		if _, err := TestCoreUnseal(core, TestKeyCopy(key)); err != nil {
+			t.Fatalf("unseal err: %s", err)
+		}
+	}
+	TestWaitActive(t, core)
+
+	request := func(core *Core, op logical.Operation, path, token string, data map[string]interface{}) (*logical.Response, error) {
+		return core.HandleRequest(&logical.Request{
+			Operation:   op,
+			Path:        path,
+			ClientToken: token,
+			Data:        data,
+		})
+	}
+	mustRequest := func(op logical.Operation, path string, data map[string]interface{}) *logical.Response {
+		resp, err := request(core, op, path, root, data)
+		if err != nil || (resp != nil && resp.IsError()) {
+			t.Fatalf("bad: %s %s: %#v, %v", op, path, resp, err)
+		}
+		return resp
+	}
+
+	mustRequest(logical.UpdateOperation, "sys/audit/noop", map[string]interface{}{"type": "noop"})
+	mustRequest(logical.UpdateOperation, "sys/mounts/standbytest", map[string]interface{}{"type": "standbytest"})
+	mustRequest(logical.UpdateOperation, "standbytest/values/foo", map[string]interface{}{"value": "bar"})
+	mustRequest(logical.UpdateOperation, "sys/policy/reader", map[string]interface{}{
+		"rules": `path "standbytest/values/*" { capabilities = ["read"] }`,
+	})
+	readerToken := mustRequest(logical.UpdateOperation, "auth/token/create", map[string]interface{}{
+		"policies": []string{"reader"},
+	}).Auth.ClientToken
+	limitedToken := mustRequest(logical.UpdateOperation, "auth/token/create", map[string]interface{}{
+		"num_uses": 10,
+	}).Auth.ClientToken
+
+	core2 := newCore("http://127.0.0.1:8500", true)
+	for _, key := range keys {
+		if _, err := TestCoreUnseal(core2, TestKeyCopy(key)); err != nil {
+			t.Fatalf("unseal err: %s", err)
+		}
+	}
+	if standby, _ := core2.Standby(); !standby {
+		t.Fatal("should be standby")
+	}
+
+	// Only the operations declared safe on a standby are served there
+	cases := []struct {
+		Operation logical.Operation
+		Path      string
+		Safe      bool
+	}{
+		{logical.ReadOperation, "standbytest/values/foo", true},
+		{logical.UpdateOperation, "standbytest/values/foo", false},
+		{logical.ReadOperation, "standbytest/touch", true},
+		{logical.ReadOperation, "secret/foo", false},
+		{logical.ReadOperation, "sys/mounts", false},
+	}
+	for _, tc := range cases {
+		req := &logical.Request{Operation: tc.Operation, Path: tc.Path}
+		if safe := core2.StandbySafe(req); safe != tc.Safe {
+			t.Fatalf("bad: %s %s: %v", tc.Operation, tc.Path, safe)
+		}
+	}
+	if core.StandbySafe(&logical.Request{Operation: logical.ReadOperation, Path: "standbytest/values/foo"}) {
+		t.Fatal("the active node should not report requests as safe on a standby")
+	}
+
+	// Safe reads are served and audited by the standby, and see updates
+	// made on the active node
+	for _, value := range []string{"bar", "baz"} {
+		mustRequest(logical.UpdateOperation, "standbytest/values/foo", map[string]interface{}{"value": value})
+		for _, token := range []string{root, readerToken} {
+			resp, err := request(core2, logical.ReadOperation, "standbytest/values/foo", token, nil)
+			if err != nil || resp == nil || resp.Data["value"] != value {
+				t.Fatalf("bad: %#v, %v", resp, err)
+			}
+		}
+	}
+	if standbyAudit == nil || len(standbyAudit.Req) != 4 || len(standbyAudit.Resp) != 4 {
+		t.Fatal("the requests served on the standby should be audited there")
+	}
+	if standbyAudit.Req[1].ClientTokenAccessor == "" {
+		t.Fatalf("bad: %#v", standbyAudit.Req[1])
+	}
+
+	// Writes, tokens which are denied or have limited uses, and wrapped
+	// responses are left to the active node
+	if _, err := request(core2, logical.UpdateOperation, "standbytest/values/foo", root, map[string]interface{}{"value": "qux"}); err != consts.ErrStandby {
+		t.Fatalf("err: %v", err)
+	}
+	for _, token := range []string{"", "invalid", limitedToken} {
+		if _, err := request(core2, logical.ReadOperation, "standbytest/values/foo", token, nil); err != consts.ErrStandby {
+			t.Fatalf("err: %s: %v", token, err)
+		}
+	}
+	if _, err := request(core2, logical.ReadOperation, "standbytest/touch", readerToken, nil); err != consts.ErrStandby {
+		t.Fatalf("err: %v", err)
+	}
+	_, err = core2.HandleRequest(&logical.Request{
+		Operation:   logical.ReadOperation,
+		Path:        "standbytest/values/foo",
+		ClientToken: root,
+		WrapInfo:    &logical.RequestWrapInfo{TTL: time.Minute},
+	})
+	if err != consts.ErrStandby {
+		t.Fatalf("err: %v", err)
+	}
+
+	// Operations declared safe which write fail without writing
+	if _, err := request(core2, logical.ReadOperation, "standbytest/touch", root, nil); err == nil || !strings.Contains(err.Error(), "declared safe on a standby") {
+		t.Fatalf("err: %v", err)
+	}
+	if entry, _ := core.router.MatchingStorageView("standbytest/").Get("touched"); entry != nil {
+		t.Fatalf("bad: %#v", entry)
+	}
+
+	// Mounts made on the active node are served once the standby sees them
+	mustRequest(logical.UpdateOperation, "sys/mounts/other", map[string]interface{}{"type": "standbytest"})
+	mustRequest(logical.UpdateOperation, "other/values/foo", map[string]interface{}{"value": "other"})
+	resp, err := request(core2, logical.ReadOperation, "other/values/foo", root, nil)
+	if err != nil || resp == nil || resp.Data["value"] != "other" {
+		t.Fatalf("bad: %#v, %v", resp, err)
+	}
+
+	// The standby takes over as usual once the active node steps down
+	if err := core.Seal(root); err != nil {
+		t.Fatalf("err: %v", err)
+	}
+	TestWaitActive(t, core2)
+	if _, err := request(core2, logical.UpdateOperation, "standbytest/values/foo", root, map[string]interface{}{"value": "qux"}); err != nil {
+		t.Fatalf("err: %v", err)
+	}
+	resp, err = request(core2, logical.ReadOperation, "standbytest/values/foo", root, nil)
+	if err != nil || resp == nil || resp.Data["value"] != "qux" {
+		t.Fatalf("bad: %#v, %v", resp, err)
+	}
+	core2.standbyReadsLock.RLock()
+	defer core2.standbyReadsLock.RUnlock()
+	if core2.standbyReads != nil {
+		t.Fatal("the standby reads should be torn down")
+	}
+}
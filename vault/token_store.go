@@ -60,6 +60,12 @@ const (
 	// again (or when the revocation function is run again), but all other uses
 	// will report the token invalid
 	tokenRevocationFailed = -3
+
+	// maxTokenMetaPairs, maxTokenMetaKeyLength and maxTokenMetaValueLength
+	// limit the metadata that can be attached to a token on creation
+	maxTokenMetaPairs       = 64
+	maxTokenMetaKeyLength   = 128
+	maxTokenMetaValueLength = 512
 )
 
 var (
@@ -69,6 +75,11 @@ var (
 	// pathSuffixSanitize is used to ensure a path suffix in a role is valid.
 	pathSuffixSanitize = regexp.MustCompile("\\w[\\w-.]+\\w")
 
+	// reservedTokenMetaKeys are metadata keys set by credential backends to
+	// identify the authenticated user or role. Backends such as SSH template
+	// usernames from them, so only sudo may set them on created tokens.
+	reservedTokenMetaKeys = []string{"username", "role", "role_name"}
+
 	destroyCubbyhole = func(ts *TokenStore, saltedID string) error {
 		if ts.cubbyholeBackend == nil {
 			// Should only ever happen in testing
@@ -838,9 +849,10 @@ func (ts *TokenStore) UseToken(te *TokenEntry) (*TokenEntry, error) {
 	}
 	// If it can't be found we shouldn't be trying to use it, so if we get nil
 	// back, it is because it has been revoked in the interim or will be
-	// revoked (NumUses is -1)
+	// revoked (NumUses is -1), e.g. by a concurrent request that took the
+	// last use
 	if te == nil {
-		return nil, fmt.Errorf("token not found or fully used already")
+		return nil, nil
 	}
 
 	// Decrement the count. If this is our last use count, we need to indicate
@@ -870,7 +882,15 @@ func (ts *TokenStore) UseTokenByID(id string) (*TokenEntry, error) {
 		return te, err
 	}
 
-	return ts.UseToken(te)
+	te, err = ts.UseToken(te)
+	if err != nil {
+		return nil, err
+	}
+	if te == nil {
+		return nil, fmt.Errorf("token not found or fully used already")
+	}
+
+	return te, nil
 }
 
 // Lookup is used to find a token given its ID. It acquires a read lock, then calls lookupSalted.
@@ -1063,6 +1083,11 @@ func (ts *TokenStore) revokeSalted(saltedId string) (ret error) {
 		return err
 	}
 
+	// Orphan any remaining children so they no longer reference this token
+	if err := ts.orphanChildren(saltedId); err != nil {
+		return err
+	}
+
 	// Clear the secondary index if any
 	if entry.Parent != "" {
 		parentSaltedID, err := ts.SaltID(entry.Parent)
@@ -1098,6 +1123,48 @@ func (ts *TokenStore) revokeSalted(saltedId string) (ret error) {
 	return nil
 }
 
+// orphanChildren clears the parent of every child of the given salted token
+// and removes them from its secondary index. Each child is rewritten before
+// its index entry is deleted, so an interrupted run leaves the remaining
+// children reachable from the parent for a later retry or tidy.
+func (ts *TokenStore) orphanChildren(saltedId string) error {
+	path := parentPrefix + saltedId + "/"
+	children, err := ts.view.List(path)
+	if err != nil {
+		return fmt.Errorf("failed to scan for children: %v", err)
+	}
+
+	for _, child := range children {
+		te, err := ts.lookupSalted(child, true)
+		if err != nil {
+			return fmt.Errorf("failed to lookup child token: %v", err)
+		}
+
+		if te != nil {
+			lock := locksutil.LockForKey(ts.tokenLocks, te.ID)
+			lock.Lock()
+
+			// Look it up again under the lock. Children that are being
+			// revoked themselves are left alone; they are going away.
+			te, err = ts.lookupSalted(child, true)
+			if err == nil && te != nil && te.NumUses != tokenRevocationInProgress {
+				te.Parent = ""
+				err = ts.storeCommon(te, false)
+			}
+			lock.Unlock()
+			if err != nil {
+				return fmt.Errorf("failed to orphan child token: %v", err)
+			}
+		}
+
+		if err := ts.view.Delete(path + child); err != nil {
+			return fmt.Errorf("failed to delete entry: %v", err)
+		}
+	}
+
+	return nil
+}
+
 // RevokeTree is used to invalide a given token and all
 // child tokens.
 func (ts *TokenStore) RevokeTree(id string) error {
@@ -1146,6 +1213,31 @@ func (ts *TokenStore) revokeTreeSalted(saltedId string) error {
 	return nil
 }
 
+// validateTokenMeta checks the size of the metadata given to a new token and
+// that reserved keys are only set by sudo callers.
+func validateTokenMeta(meta map[string]string, isSudo bool) error {
+	if len(meta) > maxTokenMetaPairs {
+		return fmt.Errorf("metadata cannot contain more than %d key/value pairs", maxTokenMetaPairs)
+	}
+
+	for key, value := range meta {
+		if key == "" {
+			return fmt.Errorf("metadata keys cannot be empty")
+		}
+		if len(key) > maxTokenMetaKeyLength {
+			return fmt.Errorf("metadata key %q is longer than %d characters", key, maxTokenMetaKeyLength)
+		}
+		if len(value) > maxTokenMetaValueLength {
+			return fmt.Errorf("value of metadata key %q is longer than %d characters", key, maxTokenMetaValueLength)
+		}
+		if !isSudo && strutil.StrListContains(reservedTokenMetaKeys, key) {
+			return fmt.Errorf("root or sudo privileges required to set reserved metadata key %q", key)
+		}
+	}
+
+	return nil
+}
+
 // handleCreateAgainstRole handles the auth/token/create path for a role
 func (ts *TokenStore) handleCreateAgainstRole(
 	req *logical.Request, d *framework.FieldData) (*logical.Response, error) {
@@ -1513,6 +1605,10 @@ func (ts *TokenStore) handleCreateCommon(
 			logical.ErrInvalidRequest
 	}
 
+	if err := validateTokenMeta(data.Metadata, isSudo); err != nil {
+		return logical.ErrorResponse(err.Error()), logical.ErrInvalidRequest
+	}
+
 	// Setup the token entry
 	te := TokenEntry{
 		Parent: req.ClientToken,
@@ -2102,7 +2198,7 @@ func (ts *TokenStore) handleRenew(
 	// Renew the token and its children
 	resp, err := ts.expiration.RenewToken(req, te.Path, te.ID, increment)
 
-	if urltoken {
+	if urltoken && resp != nil {
 		resp.AddWarning(`Using a token in the path is unsafe as the token can be logged in many places. Please use POST or PUT with the token passed in via the "token" parameter.`)
 	}
 
@@ -2131,68 +2227,46 @@ func (ts *TokenStore) authRenew(
 		return nil, fmt.Errorf("no token entry found during lookup")
 	}
 
-	f := framework.LeaseExtend(req.Auth.Increment, te.ExplicitMaxTTL, ts.System())
-
 	// If (te/role).Period is not zero, this is a periodic token. The TTL for a
 	// periodic token is always the same (the period value). It is not subject
 	// to normal maximum TTL checks that would come from calling LeaseExtend,
-	// so we fast path it.
+	// so we fast path it. If the token was created with a period and against a
+	// role with a period, the lesser of the two is used, as on creation.
 	//
 	// The one wrinkle here is if the token has an explicit max TTL. If both
-	// are set, we treat it as a regular token and use the periodic value as
-	// the increment.
+	// are set, the stricter one wins: the period is used as the TTL, but never
+	// past the explicit max TTL.
+	periodToUse := te.Period
+	if te.Role != "" {
+		role, err := ts.tokenStoreRole(te.Role)
+		if err != nil {
+			return nil, fmt.Errorf("error looking up role %s: %s", te.Role, err)
+		}
 
-	// No role? Use normal LeaseExtend semantics, taking into account
-	// TokenEntry properties
-	if te.Role == "" {
-		//Explicit max TTL overrides the period, if both are set
-		if te.Period != 0 {
-			if te.ExplicitMaxTTL == 0 {
-				req.Auth.TTL = te.Period
-				return &logical.Response{Auth: req.Auth}, nil
-			} else {
-				maxTime := time.Unix(te.CreationTime, 0).Add(te.ExplicitMaxTTL)
-				if time.Now().Add(te.Period).After(maxTime) {
-					req.Auth.TTL = maxTime.Sub(time.Now())
-				} else {
-					req.Auth.TTL = te.Period
-				}
-				return &logical.Response{Auth: req.Auth}, nil
-			}
+		if role == nil {
+			return nil, fmt.Errorf("original token role (%s) could not be found, not renewing", te.Role)
 		}
-		return f(req, d)
-	}
 
-	role, err := ts.tokenStoreRole(te.Role)
-	if err != nil {
-		return nil, fmt.Errorf("error looking up role %s: %s", te.Role, err)
+		if role.Period != 0 && (periodToUse == 0 || role.Period < periodToUse) {
+			periodToUse = role.Period
+		}
 	}
 
-	if role == nil {
-		return nil, fmt.Errorf("original token role (%s) could not be found, not renewing", te.Role)
+	// No period? Use normal LeaseExtend semantics, taking into account
+	// TokenEntry properties
+	if periodToUse == 0 {
+		f := framework.LeaseExtend(req.Auth.Increment, te.ExplicitMaxTTL, ts.System())
+		return f(req, d)
 	}
 
-	// Same deal here, but using the role period
-	if role.Period != 0 {
-		periodToUse := role.Period
-		if te.Period > 0 && te.Period < role.Period {
-			periodToUse = te.Period
-		}
-		if te.ExplicitMaxTTL == 0 {
-			req.Auth.TTL = periodToUse
-			return &logical.Response{Auth: req.Auth}, nil
-		} else {
-			maxTime := time.Unix(te.CreationTime, 0).Add(te.ExplicitMaxTTL)
-			if time.Now().Add(periodToUse).After(maxTime) {
-				req.Auth.TTL = maxTime.Sub(time.Now())
-			} else {
-				req.Auth.TTL = periodToUse
-			}
-			return &logical.Response{Auth: req.Auth}, nil
+	req.Auth.TTL = periodToUse
+	if te.ExplicitMaxTTL != 0 {
+		maxTime := time.Unix(te.CreationTime, 0).Add(te.ExplicitMaxTTL)
+		if time.Now().Add(periodToUse).After(maxTime) {
+			req.Auth.TTL = maxTime.Sub(time.Now())
 		}
 	}
-
-	return f(req, d)
+	return &logical.Response{Auth: req.Auth}, nil
 }
 
 func (ts *TokenStore) tokenStoreRole(name string) (*tsRoleEntry, error) {
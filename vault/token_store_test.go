@@ -689,9 +689,25 @@ func TestTokenStore_Revoke_Orphan(t *testing.T) {
 	if err != nil {
 		t.Fatalf("err: %v", err)
 	}
+
+	// The child is now an orphan
+	ent2.Parent = ""
 	if !reflect.DeepEqual(out, ent2) {
 		t.Fatalf("bad: expected:%#v\nactual:%#v", ent2, out)
 	}
+
+	// The parent index is cleaned up
+	saltedID, err := ts.SaltID(ent.ID)
+	if err != nil {
+		t.Fatal(err)
+	}
+	children, err := ts.view.List(parentPrefix + saltedID + "/")
+	if err != nil {
+		t.Fatalf("err: %v", err)
+	}
+	if len(children) != 0 {
+		t.Fatalf("bad: %v", children)
+	}
 }
 
 func TestTokenStore_RevokeTree(t *testing.T) {
@@ -1200,6 +1216,52 @@ func TestTokenStore_HandleRequest_CreateToken_Metadata(t *testing.T) {
 	}
 }
 
+func TestTokenStore_HandleRequest_CreateToken_MetadataLimits(t *testing.T) {
+	_, ts, _, root := TestCoreWithTokenStore(t)
+	testMakeToken(t, ts, root, "client", "", []string{"foo"})
+
+	tooMany := map[string]string{}
+	for i := 0; i <= maxTokenMetaPairs; i++ {
+		tooMany[fmt.Sprintf("key%d", i)] = "value"
+	}
+
+	cases := []struct {
+		Name     string
+		Token    string
+		Meta     map[string]string
+		Expected string
+	}{
+		{"valid", "client", map[string]string{"pipeline": "deploy"}, ""},
+		{"too many", "client", tooMany, "metadata cannot contain more than 64 key/value pairs"},
+		{"empty key", "client", map[string]string{"": "value"}, "metadata keys cannot be empty"},
+		{"long key", "client", map[string]string{strings.Repeat("k", 129): "value"}, "is longer than 128 characters"},
+		{"long value", "client", map[string]string{"key": strings.Repeat("v", 513)}, "is longer than 512 characters"},
+		{"reserved", "client", map[string]string{"username": "root"}, `reserved metadata key "username"`},
+		{"reserved sudo", root, map[string]string{"username": "armon"}, ""},
+	}
+
+	for _, tc := range cases {
+		req := logical.TestRequest(t, logical.UpdateOperation, "create")
+		req.ClientToken = tc.Token
+		req.Data["meta"] = tc.Meta
+
+		resp, err := ts.HandleRequest(req)
+		if tc.Expected == "" {
+			if err != nil || resp == nil || resp.IsError() {
+				t.Fatalf("bad: %s: err: %v resp: %#v", tc.Name, err, resp)
+			}
+			out, _ := ts.Lookup(resp.Auth.ClientToken)
+			if !reflect.DeepEqual(out.Meta, tc.Meta) {
+				t.Fatalf("bad: %s: expected:%#v\nactual:%#v", tc.Name, tc.Meta, out.Meta)
+			}
+			continue
+		}
+		if err != logical.ErrInvalidRequest || resp == nil || !strings.Contains(resp.Data["error"].(string), tc.Expected) {
+			t.Fatalf("bad: %s: err: %v resp: %#v", tc.Name, err, resp)
+		}
+	}
+}
+
 func TestTokenStore_HandleRequest_CreateToken_Lease(t *testing.T) {
 	_, ts, _, root := TestCoreWithTokenStore(t)
 
@@ -1315,6 +1377,20 @@ func TestTokenStore_HandleRequest_RevokeOrphan(t *testing.T) {
 	if out == nil {
 		t.Fatalf("bad: %v", out)
 	}
+
+	// And should be reported as an orphan
+	req = logical.TestRequest(t, logical.UpdateOperation, "lookup")
+	req.Data = map[string]interface{}{
+		"token": "sub-child",
+	}
+	req.ClientToken = root
+	resp, err = ts.HandleRequest(req)
+	if err != nil {
+		t.Fatalf("err: %v %v", err, resp)
+	}
+	if resp == nil || resp.Data["orphan"] != true {
+		t.Fatalf("bad: %#v", resp)
+	}
 }
 
 func TestTokenStore_HandleRequest_RevokeOrphan_NonRoot(t *testing.T) {
@@ -1665,6 +1741,40 @@ func TestTokenStore_HandleRequest_RenewSelf(t *testing.T) {
 	}
 }
 
+func TestTokenStore_HandleRequest_RenewSelf_notRenewable(t *testing.T) {
+	exp := mockExpiration(t)
+	ts := exp.tokenStore
+
+	root, err := ts.rootToken()
+	if err != nil {
+		t.Fatalf("err: %v", err)
+	}
+
+	// Tokens without a lease cannot be renewed
+	req := logical.TestRequest(t, logical.UpdateOperation, "renew-self")
+	req.ClientToken = root.ID
+	resp, err := ts.HandleRequest(req)
+	if err != logical.ErrInvalidRequest || resp == nil || resp.Data["error"] != "token has no TTL and cannot be renewed" {
+		t.Fatalf("bad: err: %v resp: %#v", err, resp)
+	}
+
+	// Nor can those created non-renewable
+	auth := &logical.Auth{
+		ClientToken: root.ID,
+		LeaseOptions: logical.LeaseOptions{
+			TTL:       time.Hour,
+			Renewable: false,
+		},
+	}
+	if err := exp.RegisterAuth("auth/token/root", auth); err != nil {
+		t.Fatalf("err: %v", err)
+	}
+	resp, err = ts.HandleRequest(req)
+	if err != logical.ErrInvalidRequest || resp == nil || resp.Data["error"] != "token is not renewable" {
+		t.Fatalf("bad: err: %v resp: %#v", err, resp)
+	}
+}
+
 func TestTokenStore_RoleCRUD(t *testing.T) {
 	core, _, _, root := TestCoreWithTokenStore(t)
 
@@ -2807,6 +2917,71 @@ func TestTokenStore_Periodic(t *testing.T) {
 	}
 }
 
+func TestTokenStore_Periodic_RoleWithoutPeriod(t *testing.T) {
+	core, _, _, root := TestCoreWithTokenStore(t)
+
+	core.defaultLeaseTTL = 10 * time.Second
+	core.maxLeaseTTL = 10 * time.Second
+
+	req := logical.TestRequest(t, logical.UpdateOperation, "auth/token/roles/test")
+	req.ClientToken = root
+	resp, err := core.HandleRequest(req)
+	if err != nil {
+		t.Fatalf("err: %v %v", err, resp)
+	}
+
+	cases := []struct {
+		Data   map[string]interface{}
+		MinTTL int64
+		MaxTTL int64
+	}{
+		// The period of the token overrides the max TTL on renewal
+		{map[string]interface{}{"period": 300}, 299, 300},
+
+		// The explicit max TTL is stricter than the period
+		{map[string]interface{}{"period": 300, "explicit_max_ttl": 150}, 140, 150},
+	}
+
+	for _, tc := range cases {
+		req.ClientToken = root
+		req.Operation = logical.UpdateOperation
+		req.Path = "auth/token/create/test"
+		req.Data = tc.Data
+		resp, err = core.HandleRequest(req)
+		if err != nil {
+			t.Fatalf("err: %v %v", err, resp)
+		}
+		if resp == nil || resp.Auth == nil || resp.Auth.ClientToken == "" {
+			t.Fatalf("bad: %#v", resp)
+		}
+
+		req.ClientToken = resp.Auth.ClientToken
+		req.Path = "auth/token/renew-self"
+		req.Data = map[string]interface{}{
+			"increment": 1,
+		}
+		resp, err = core.HandleRequest(req)
+		if err != nil {
+			t.Fatalf("err: %v %v", err, resp)
+		}
+
+		req.Operation = logical.ReadOperation
+		req.Path = "auth/token/lookup-self"
+		req.Data = nil
+		resp, err = core.HandleRequest(req)
+		if err != nil {
+			t.Fatalf("err: %v", err)
+		}
+		if resp.Data["period"].(int64) != 300 {
+			t.Fatalf("bad: %#v: period: %v", tc.Data, resp.Data["period"])
+		}
+		ttl := resp.Data["ttl"].(int64)
+		if ttl < tc.MinTTL || ttl > tc.MaxTTL {
+			t.Fatalf("bad: %#v: TTL bad (expected between %d and %d, got %d)", tc.Data, tc.MinTTL, tc.MaxTTL, ttl)
+		}
+	}
+}
+
 func TestTokenStore_NoDefaultPolicy(t *testing.T) {
 	var resp *logical.Response
 	var err error
@@ -3255,6 +3430,39 @@ func TestTokenStore_RevokeUseCountToken(t *testing.T) {
 
 // Create a token, delete the token entry while leaking accessors, invoke tidy
 // and check if the dangling accessor entry is getting removed
+func TestTokenStore_UseToken_lastUseRace(t *testing.T) {
+	_, ts, _, root := TestCoreWithTokenStore(t)
+
+	ent := &TokenEntry{Path: "test", Policies: []string{"dev"}, NumUses: 1, Parent: root}
+	if err := ts.create(ent); err != nil {
+		t.Fatalf("err: %v", err)
+	}
+
+	// Two requests looked up the entry before either used it
+	first, second := *ent, *ent
+
+	te, err := ts.UseToken(&first)
+	if err != nil {
+		t.Fatalf("err: %v", err)
+	}
+	if te == nil || te.NumUses != tokenRevocationDeferred {
+		t.Fatalf("bad: %#v", te)
+	}
+
+	// The loser of the race is told the token is gone rather than failing
+	te, err = ts.UseToken(&second)
+	if err != nil {
+		t.Fatalf("err: %v", err)
+	}
+	if te != nil {
+		t.Fatalf("bad: %#v", te)
+	}
+
+	if _, err := ts.UseTokenByID(ent.ID); err == nil {
+		t.Fatal("expected error using a fully used token by ID")
+	}
+}
+
 func TestTokenStore_HandleTidyCase1(t *testing.T) {
 	var resp *logical.Response
 	var err error